@@ -1,45 +1,131 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-var jwtSecret = []byte("change-me")
+const defaultSecret = "change-me"
+
+var jwtSecret = []byte(defaultSecret)
+
+// keys signs and verifies tokens; HMAC with jwtSecret unless ConfigureFromEnv selects otherwise.
+var keys *KeyManager
+
+var (
+	// AccessTokenTTL is the lifetime of JWT access tokens.
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is the lifetime of opaque refresh tokens.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
 
 func init() {
 	if s := os.Getenv("JWT_SECRET"); s != "" {
 		jwtSecret = []byte(s)
 	}
+	if d, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && d > 0 {
+		AccessTokenTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && d > 0 {
+		RefreshTokenTTL = d
+	}
+	keys = NewHMACKeyManager(jwtSecret)
+}
+
+// ConfigureFromEnv selects the signing algorithm (JWT_ALG: HS256, RS256 or EdDSA),
+// key directory (JWT_KEYS_DIR) and rotation period (JWT_KEY_ROTATION). With
+// APP_ENV=production it refuses the default or a short HMAC secret.
+func ConfigureFromEnv() (*KeyManager, error) {
+	production := os.Getenv("APP_ENV") == "production"
+	alg := normalizeAlg(os.Getenv("JWT_ALG"))
+	if alg == AlgHS256 {
+		if production && (string(jwtSecret) == defaultSecret || len(jwtSecret) < 32) {
+			return nil, errors.New("JWT_SECRET must be set to at least 32 random bytes in production")
+		}
+		return keys, nil
+	}
+	// retired keys must outlive every token they signed
+	retention := AccessTokenTTL
+	if d, err := time.ParseDuration(os.Getenv("JWT_KEY_RETENTION")); err == nil && d > retention {
+		retention = d
+	}
+	km, err := NewKeyManager(alg, os.Getenv("JWT_KEYS_DIR"), retention)
+	if err != nil {
+		return nil, err
+	}
+	SetKeyManager(km)
+	return km, nil
+}
+
+// SetKeyManager replaces the key manager used by GenerateToken and ParseToken.
+func SetKeyManager(km *KeyManager) {
+	keys = km
+}
+
+// Keys returns the current key manager.
+func Keys() *KeyManager {
+	return keys
 }
 
 type Claims struct {
 	UserID int    `json:"user_id"`
 	Role   string `json:"role"`
+	// IssuedAtMs is iat in milliseconds, so a logout-all can tell tokens
+	// issued just before it from those issued just after in the same second.
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
 	jwt.StandardClaims
 }
 
+// Issued returns when the token was issued, to the millisecond when it says.
+func (c *Claims) Issued() time.Time {
+	if c.IssuedAtMs != 0 {
+		return time.UnixMilli(c.IssuedAtMs)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
 func GenerateToken(userID int, role string, ttl time.Duration) (string, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := Claims{
-		UserID: userID,
-		Role:   role,
+		UserID:     userID,
+		Role:       role,
+		IssuedAtMs: now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(ttl).Unix(),
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	k := keys.Active()
+	token := jwt.NewWithClaims(k.Method, claims)
+	token.Header["kid"] = k.ID
+	return token.SignedString(k.private)
 }
 
 func ParseToken(tok string) (*Claims, error) {
 	parsed, err := jwt.ParseWithClaims(tok, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		k, ok := keys.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		// the key decides the algorithm, never the token header
+		if token.Method.Alg() != k.Method.Alg() {
 			return nil, errors.New("invalid signing method")
 		}
-		return jwtSecret, nil
+		return k.public, nil
 	})
 	if err != nil {
 		return nil, err
@@ -49,3 +135,27 @@ func ParseToken(tok string) (*Claims, error) {
 	}
 	return nil, errors.New("invalid token")
 }
+
+// NewRefreshToken returns an opaque refresh token and the hash to persist.
+// Only the hash is stored so a database leak does not expose live tokens.
+func NewRefreshToken() (raw string, hash string, err error) {
+	raw, err = randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return raw, HashRefreshToken(raw), nil
+}
+
+// HashRefreshToken returns the storage hash of a raw refresh token.
+func HashRefreshToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
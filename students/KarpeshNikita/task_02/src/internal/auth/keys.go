@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Supported signing algorithms.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is a signing key identified by its kid.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	CreatedAt time.Time
+	private   interface{}
+	public    interface{}
+	retiredAt time.Time
+}
+
+// KeyManager holds the active signing key plus recently retired keys that are
+// still accepted for verification until tokens signed with them expire.
+type KeyManager struct {
+	mu        sync.RWMutex
+	alg       string
+	keys      []*Key // keys[0] signs new tokens
+	dir       string
+	retention time.Duration
+}
+
+// NewHMACKeyManager returns a manager with a single shared-secret key.
+// HMAC keys are not rotated or published in the JWKS.
+func NewHMACKeyManager(secret []byte) *KeyManager {
+	k := &Key{ID: "hs256", Method: jwt.SigningMethodHS256, CreatedAt: time.Now(), private: secret, public: secret}
+	return &KeyManager{alg: AlgHS256, keys: []*Key{k}}
+}
+
+// NewKeyManager creates an RS256 or EdDSA manager. When dir is set, keys are
+// loaded from and persisted to PEM files there so replicas and restarts share them;
+// otherwise a fresh in-memory key is generated. Retired keys are kept for retention.
+func NewKeyManager(alg, dir string, retention time.Duration) (*KeyManager, error) {
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported key algorithm %q", alg)
+	}
+	km := &KeyManager{alg: alg, dir: dir, retention: retention}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := km.load(); err != nil {
+			return nil, err
+		}
+	}
+	if km.empty() {
+		if err := km.rotateLocked(km.empty); err != nil {
+			return nil, err
+		}
+	}
+	return km, nil
+}
+
+// Alg returns the algorithm used for new tokens.
+func (km *KeyManager) Alg() string {
+	return km.alg
+}
+
+// Active returns the key used to sign new tokens.
+func (km *KeyManager) Active() *Key {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.keys[0]
+}
+
+// Lookup returns the verification key for kid. Tokens issued before kids were
+// introduced carry none and are matched against the HMAC key, if any.
+func (km *KeyManager) Lookup(kid string) (*Key, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	for _, k := range km.keys {
+		if k.ID == kid || (kid == "" && k.Method == jwt.SigningMethodHS256) {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Rotate generates a new active key. The previous key keeps verifying tokens
+// for the retention period.
+func (km *KeyManager) Rotate() error {
+	if km.alg == AlgHS256 {
+		return errors.New("HMAC keys cannot be rotated")
+	}
+	k, err := generateKey(km.alg)
+	if err != nil {
+		return err
+	}
+	if km.dir != "" {
+		if err := writeKey(km.dir, k); err != nil {
+			return err
+		}
+	}
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if len(km.keys) > 0 {
+		km.keys[0].retiredAt = k.CreatedAt
+	}
+	km.keys = append([]*Key{k}, km.keys...)
+	km.prune(k.CreatedAt)
+	return nil
+}
+
+// RotateIfDue picks up keys written by other replicas and rotates when the
+// active key is older than every.
+func (km *KeyManager) RotateIfDue(every time.Duration) error {
+	if km.dir != "" {
+		if err := km.load(); err != nil {
+			return err
+		}
+	}
+	due := func() bool { return time.Since(km.Active().CreatedAt) >= every }
+	if !due() {
+		return nil
+	}
+	return km.rotateLocked(due)
+}
+
+// rotateLocked rotates while holding the key directory lock, so replicas
+// sharing dir do not all rotate at once. Once the lock is held the keys are
+// reloaded and due is asked again: another replica may have rotated already.
+func (km *KeyManager) rotateLocked(due func() bool) error {
+	if km.dir == "" {
+		return km.Rotate()
+	}
+	unlock, err := lockKeyDir(km.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := km.load(); err != nil {
+		return err
+	}
+	if !due() {
+		return nil
+	}
+	return km.Rotate()
+}
+
+func (km *KeyManager) empty() bool {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return len(km.keys) == 0
+}
+
+// The rotation lock is a file created exclusively in the key directory. A
+// replica that dies holding it leaves it behind, so a lock older than
+// keyLockStale is taken over.
+const (
+	keyLockFile  = ".rotate.lock"
+	keyLockStale = time.Minute
+	keyLockWait  = 30 * time.Second
+)
+
+func lockKeyDir(dir string) (unlock func(), err error) {
+	path := filepath.Join(dir, keyLockFile)
+	deadline := time.Now().Add(keyLockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > keyLockStale {
+			_ = os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("key directory is locked by another rotation")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// StartRotation rotates keys in the background until stop is closed.
+func (km *KeyManager) StartRotation(every time.Duration, stop <-chan struct{}, onErr func(error)) {
+	tick := time.Minute
+	if every < tick {
+		tick = every
+	}
+	go func() {
+		t := time.NewTicker(tick)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if err := km.RotateIfDue(every); err != nil && onErr != nil {
+					onErr(err)
+				}
+			}
+		}
+	}()
+}
+
+// prune drops retired keys past retention. Caller holds km.mu.
+func (km *KeyManager) prune(now time.Time) {
+	kept := km.keys[:0]
+	for i, k := range km.keys {
+		if i > 0 && !k.retiredAt.IsZero() && now.Sub(k.retiredAt) > km.retention {
+			if km.dir != "" {
+				_ = os.Remove(filepath.Join(km.dir, k.ID+".pem"))
+			}
+			continue
+		}
+		kept = append(kept, k)
+	}
+	km.keys = kept
+}
+
+// load reads every PEM key in dir; the newest file becomes the active key.
+func (km *KeyManager) load() error {
+	files, err := filepath.Glob(filepath.Join(km.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	var keys []*Key
+	for _, f := range files {
+		k, err := readKey(f)
+		if err != nil {
+			return fmt.Errorf("load key %s: %w", filepath.Base(f), err)
+		}
+		if k.Method.Alg() != km.alg {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	for i := 1; i < len(keys); i++ {
+		keys[i].retiredAt = keys[i-1].CreatedAt
+	}
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	km.keys = keys
+	km.prune(time.Now())
+	return nil
+}
+
+func generateKey(alg string) (*Key, error) {
+	switch alg {
+	case AlgRS256:
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(priv, time.Now())
+	case AlgEdDSA:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(priv, time.Now())
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q", alg)
+}
+
+// newKey wraps a private key and derives its kid from the public key.
+func newKey(priv interface{}, created time.Time) (*Key, error) {
+	k := &Key{CreatedAt: created, private: priv}
+	switch p := priv.(type) {
+	case *rsa.PrivateKey:
+		k.Method = jwt.SigningMethodRS256
+		k.public = &p.PublicKey
+	case ed25519.PrivateKey:
+		k.Method = SigningMethodEdDSA
+		k.public = p.Public()
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+	der, err := x509.MarshalPKIXPublicKey(k.public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	k.ID = base64.RawURLEncoding.EncodeToString(sum[:12])
+	return k, nil
+}
+
+// createdHeader is the PEM header holding a key's creation time. File times
+// are not used: copies, restores and image builds change them.
+const createdHeader = "Created"
+
+// writeKey stores k under a temporary name and renames it into place, so other
+// replicas loading dir never read half a key.
+func writeKey(dir string, k *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{createdHeader: k.CreatedAt.UTC().Format(time.RFC3339Nano)},
+		Bytes:   der,
+	})
+	path := filepath.Join(dir, k.ID+".pem")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func readKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	created, err := keyCreated(path, block)
+	if err != nil {
+		return nil, err
+	}
+	var priv interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newKey(priv, created)
+}
+
+// keyCreated reads the creation time from the PEM header. Keys written before
+// the header existed, or placed in dir by hand, fall back to the file time.
+func keyCreated(path string, block *pem.Block) (time.Time, error) {
+	if v, ok := block.Headers[createdHeader]; ok {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad %s header: %w", createdHeader, err)
+		}
+		return t, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS returns the public halves of all keys still accepted for verification.
+// Shared HMAC secrets are never published.
+func (km *KeyManager) JWKS() []JWK {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	out := []JWK{}
+	for _, k := range km.keys {
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			out = append(out, JWK{
+				Kty: "RSA", Kid: k.ID, Use: "sig", Alg: AlgRS256,
+				N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			out = append(out, JWK{
+				Kty: "OKP", Kid: k.ID, Use: "sig", Alg: AlgEdDSA, Crv: "Ed25519",
+				X: base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return out
+}
+
+// normalizeAlg accepts algorithm names case-insensitively.
+func normalizeAlg(alg string) string {
+	switch strings.ToUpper(alg) {
+	case "", AlgHS256:
+		return AlgHS256
+	case AlgRS256:
+		return AlgRS256
+	case "EDDSA", "ED25519":
+		return AlgEdDSA
+	}
+	return alg
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withKeys swaps the package key manager for the duration of a test
+func withKeys(t *testing.T, km *KeyManager) {
+	t.Helper()
+	prev := keys
+	SetKeyManager(km)
+	t.Cleanup(func() { SetKeyManager(prev) })
+}
+
+func TestSignAndVerifyAsymmetric(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		km, err := NewKeyManager(alg, "", time.Hour)
+		if err != nil {
+			t.Fatalf("%s: new manager: %v", alg, err)
+		}
+		withKeys(t, km)
+		tok, err := GenerateToken(7, "user", time.Minute)
+		if err != nil {
+			t.Fatalf("%s: generate: %v", alg, err)
+		}
+		claims, err := ParseToken(tok)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", alg, err)
+		}
+		if claims.UserID != 7 || claims.Id == "" {
+			t.Fatalf("%s: unexpected claims %+v", alg, claims)
+		}
+	}
+}
+
+func TestRotationKeepsPreviousKey(t *testing.T) {
+	km, err := NewKeyManager(AlgEdDSA, "", time.Hour)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	withKeys(t, km)
+	old, err := GenerateToken(1, "user", time.Minute)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	oldKid := km.Active().ID
+	if err := km.Rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if km.Active().ID == oldKid {
+		t.Fatalf("active key not replaced")
+	}
+	if _, err := ParseToken(old); err != nil {
+		t.Fatalf("token signed by previous key rejected: %v", err)
+	}
+	if len(km.JWKS()) != 2 {
+		t.Fatalf("expected both keys in JWKS, got %d", len(km.JWKS()))
+	}
+}
+
+func TestRetiredKeyPruned(t *testing.T) {
+	km, err := NewKeyManager(AlgEdDSA, "", 0)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	withKeys(t, km)
+	old, err := GenerateToken(1, "user", time.Minute)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err := km.Rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	// zero retention: the next rotation drops the first key
+	time.Sleep(time.Millisecond)
+	if err := km.Rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if _, err := ParseToken(old); err == nil {
+		t.Fatalf("token signed by pruned key accepted")
+	}
+}
+
+func TestKeysPersistedInDir(t *testing.T) {
+	dir := t.TempDir()
+	km, err := NewKeyManager(AlgRS256, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	withKeys(t, km)
+	tok, err := GenerateToken(3, "admin", time.Minute)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	// a second replica (or a restart) loads the same key
+	other, err := NewKeyManager(AlgRS256, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if other.Active().ID != km.Active().ID {
+		t.Fatalf("reloaded key differs: %s != %s", other.Active().ID, km.Active().ID)
+	}
+	SetKeyManager(other)
+	if _, err := ParseToken(tok); err != nil {
+		t.Fatalf("token rejected by reloaded manager: %v", err)
+	}
+}
+
+func TestKeyAgeIgnoresFileTimes(t *testing.T) {
+	dir := t.TempDir()
+	km, err := NewKeyManager(AlgEdDSA, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	old := km.Active().ID
+	if err := km.Rotate(); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	active := km.Active().ID
+	// a copy or restore gives the older key the newest file time
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, old+".pem"), future, future); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	other, err := NewKeyManager(AlgEdDSA, dir, time.Hour)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if other.Active().ID != active {
+		t.Fatalf("active key after reload = %s, want %s", other.Active().ID, active)
+	}
+}
+
+func TestReplicasRotateOnce(t *testing.T) {
+	dir := t.TempDir()
+	var replicas []*KeyManager
+	for i := 0; i < 4; i++ {
+		km, err := NewKeyManager(AlgRS256, dir, time.Hour)
+		if err != nil {
+			t.Fatalf("new manager: %v", err)
+		}
+		replicas = append(replicas, km)
+	}
+	// RSA key generation is slow enough for unlocked replicas to race
+	every := 300 * time.Millisecond
+	time.Sleep(every + 50*time.Millisecond)
+	var wg sync.WaitGroup
+	for _, km := range replicas {
+		wg.Add(1)
+		go func(km *KeyManager) {
+			defer wg.Done()
+			if err := km.RotateIfDue(every); err != nil {
+				t.Errorf("rotate: %v", err)
+			}
+		}(km)
+	}
+	wg.Wait()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if len(files) != 2 {
+		t.Fatalf("got %d key files, want the first key and one rotation", len(files))
+	}
+	for _, km := range replicas[1:] {
+		if err := km.RotateIfDue(every); err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if km.Active().ID != replicas[0].Active().ID {
+			t.Fatalf("replicas disagree on the active key")
+		}
+	}
+}
+
+func TestAlgorithmConfusionRejected(t *testing.T) {
+	hm := NewHMACKeyManager([]byte("secret"))
+	withKeys(t, hm)
+	tok, err := GenerateToken(1, "admin", time.Minute)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	km, err := NewKeyManager(AlgEdDSA, "", time.Hour)
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	SetKeyManager(km)
+	if _, err := ParseToken(tok); err == nil {
+		t.Fatalf("HMAC token accepted by EdDSA manager")
+	}
+	if len(hm.JWKS()) != 0 {
+		t.Fatalf("HMAC secret published in JWKS")
+	}
+}
+
+func TestProductionRefusesDefaultSecret(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("JWT_ALG", "")
+	prev := jwtSecret
+	jwtSecret = []byte(defaultSecret)
+	t.Cleanup(func() { jwtSecret = prev })
+	if _, err := ConfigureFromEnv(); err == nil {
+		t.Fatalf("expected default secret to be refused in production")
+	}
+	t.Setenv("APP_ENV", "")
+	if _, err := ConfigureFromEnv(); err != nil {
+		t.Fatalf("default secret should be allowed outside production: %v", err)
+	}
+}
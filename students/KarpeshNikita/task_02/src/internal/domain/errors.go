@@ -0,0 +1,65 @@
+// Package domain defines the error kinds shared by the repository, service and
+// handler layers. Handlers map the kinds to HTTP status codes; the messages are
+// safe to show to clients and never contain SQL.
+package domain
+
+import "errors"
+
+// Kinds of domain errors. Match them with errors.Is.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+	ErrForbidden  = errors.New("forbidden")
+)
+
+// Error is a domain error of a given kind. Err keeps the underlying cause for
+// logging and errors.Is / errors.As; it is never shown to clients.
+type Error struct {
+	Kind    error
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the error's kind as well as the error itself.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func NotFound(msg string) *Error {
+	return &Error{Kind: ErrNotFound, Message: msg}
+}
+
+func Conflict(msg string) *Error {
+	return &Error{Kind: ErrConflict, Message: msg}
+}
+
+func Validation(msg string) *Error {
+	return &Error{Kind: ErrValidation, Message: msg}
+}
+
+func Forbidden(msg string) *Error {
+	return &Error{Kind: ErrForbidden, Message: msg}
+}
+
+// Wrap attaches a cause to a new error of the given kind.
+func Wrap(kind error, msg string, cause error) *Error {
+	return &Error{Kind: kind, Message: msg, Err: cause}
+}
+
+// Message returns the client-safe message of a domain error, or "" for any other error.
+func Message(err error) string {
+	var de *Error
+	if errors.As(err, &de) {
+		return de.Message
+	}
+	return ""
+}
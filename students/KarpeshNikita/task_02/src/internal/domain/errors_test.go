@@ -0,0 +1,29 @@
+package domain
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	err := fmt.Errorf("load: %w", Wrap(ErrNotFound, "book not found", sql.ErrNoRows))
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) {
+		t.Fatalf("kind not matched: %v", err)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("cause not reachable")
+	}
+	if Message(err) != "book not found" {
+		t.Fatalf("unexpected message %q", Message(err))
+	}
+	if Message(errors.New("pq: syntax error")) != "" {
+		t.Fatalf("plain errors must not expose a message")
+	}
+
+	sentinel := Conflict("email is already registered")
+	if !errors.Is(fmt.Errorf("register: %w", sentinel), sentinel) {
+		t.Fatalf("sentinel identity lost")
+	}
+}
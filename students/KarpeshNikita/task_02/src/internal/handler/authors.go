@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ListAuthors godoc
+// @Summary List authors
+// @Description Get all authors ordered by name
+// @Tags Authors
+// @Produce json
+// @Success 200 {array} models.Author
+// @Failure 500 {object} map[string]string
+// @Router /api/authors [get]
+func (h *Handler) ListAuthors(c *gin.Context) {
+	as, err := h.svc.ListAuthors(c.Request.Context())
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, as)
+}
+
+// GetAuthor godoc
+// @Summary Get author by id
+// @Tags Authors
+// @Produce json
+// @Param id path int true "Author ID"
+// @Success 200 {object} models.Author
+// @Failure 404 {object} map[string]string
+// @Router /api/authors/{id} [get]
+func (h *Handler) GetAuthor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+	a, err := h.svc.GetAuthor(c.Request.Context(), id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// CreateAuthor godoc
+// @Summary Create an author
+// @Description Create an author (admin only)
+// @Tags Authors
+// @Accept json
+// @Produce json
+// @Param payload body models.Author true "Author payload"
+// @Success 201 {object} models.Author
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/authors [post]
+func (h *Handler) CreateAuthor(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	a := &service.AuthorModel{Name: req.Name}
+	if err := h.svc.CreateAuthor(c.Request.Context(), a); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, a)
+}
+
+// UpdateAuthor godoc
+// @Summary Update an author
+// @Description Rename an author (admin only)
+// @Tags Authors
+// @Accept json
+// @Produce json
+// @Param id path int true "Author ID"
+// @Param payload body models.Author true "Author payload"
+// @Success 200 {object} models.Author
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/authors/{id} [put]
+func (h *Handler) UpdateAuthor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	a := &service.AuthorModel{ID: id, Name: req.Name}
+	if err := h.svc.UpdateAuthor(c.Request.Context(), a); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// DeleteAuthor godoc
+// @Summary Delete an author
+// @Description Delete an author without books (admin only)
+// @Tags Authors
+// @Param id path int true "Author ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/authors/{id} [delete]
+func (h *Handler) DeleteAuthor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if err := h.svc.DeleteAuthor(c.Request.Context(), id); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListAuthorBooks godoc
+// @Summary List books of an author
+// @Description Books the author contributed to in any role
+// @Tags Authors
+// @Produce json
+// @Param id path int true "Author ID"
+// @Success 200 {array} models.Book
+// @Failure 404 {object} map[string]string
+// @Router /api/authors/{id}/books [get]
+func (h *Handler) ListAuthorBooks(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+	if _, err := h.svc.GetAuthor(c.Request.Context(), id); err != nil {
+		renderError(c, err)
+		return
+	}
+	bs, err := h.svc.ListBooksByAuthor(c.Request.Context(), id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, bs)
+}
+
+// AuthorPage UI: show author and their books
+func (h *Handler) AuthorPage(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid author id")
+		return
+	}
+	a, err := h.svc.GetAuthor(c.Request.Context(), id)
+	if err != nil {
+		c.String(http.StatusNotFound, "author not found")
+		return
+	}
+	books, err := h.svc.ListBooksByAuthor(c.Request.Context(), id)
+	if err != nil {
+		books = []service.BookModel{}
+	}
+	c.HTML(http.StatusOK, "author.html", gin.H{"author": a, "books": books})
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// restoreTimeout bounds a restore in place of DB_TIMEOUT. A client that goes
+// away does not stop it: the restore either commits or leaves nothing behind.
+const restoreTimeout = 30 * time.Minute
+
+// Backup godoc
+// @Summary Back up the whole catalog
+// @Description Streams a zip archive of the users, authors, books, shelves, shelf entries, reviews and cover images, for POST /api/admin/restore on another instance. Password hashes are left out unless password_hashes=true.
+// @Tags Admin
+// @Produce application/zip
+// @Param password_hashes query bool false "include password hashes, so users can sign in after a restore"
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/admin/backup [get]
+func (h *Handler) Backup(c *gin.Context) {
+	hashes := false
+	if v := c.Query("password_hashes"); v != "" {
+		var err error
+		if hashes, err = strconv.ParseBool(v); err != nil {
+			writeProblem(c, http.StatusBadRequest, "password_hashes must be true or false")
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), exportTimeout)
+	defer cancel()
+	name := "books-backup-" + time.Now().UTC().Format("20060102-150405") + ".zip"
+	w := &exportWriter{c: c, contentType: "application/zip", filename: name}
+	err := h.svc.Backup(ctx, w, hashes)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		return
+	}
+	if !w.started {
+		renderError(c, err)
+		return
+	}
+	// the status is already sent; the archive simply ends early
+	log.Printf("%s %s: backup failed after the response started: %v", c.Request.Method, c.Request.URL.Path, err)
+}
+
+// Restore godoc
+// @Summary Restore a backup into an empty catalog
+// @Description Multipart upload in the "file" field of an archive from GET /api/admin/backup. The archive is checked first and then loaded in one transaction, so either everything is restored or nothing is. Records get new IDs and their references follow. Backed-up users whose email already has an account are attached to it. The catalog must be empty apart from the sample books.
+// @Tags Admin
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "backup archive"
+// @Success 200 {object} service.RestoreReport
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/admin/restore [post]
+func (h *Handler) Restore(c *gin.Context) {
+	// leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, service.MaxRestoreBytes+64<<10)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			renderError(c, service.ErrBackupTooLarge)
+			return
+		}
+		writeProblem(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), restoreTimeout)
+	defer cancel()
+	// the multipart file is already on disk or in memory; read it in place
+	report, err := h.svc.Restore(ctx, f, fh.Size)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
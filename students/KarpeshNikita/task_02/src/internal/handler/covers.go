@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// coverCacheControl lets browsers and proxies keep cover images for a year:
+// a new upload gets a new version and therefore a new URL.
+const coverCacheControl = "public, max-age=31536000, immutable"
+
+// UploadCover godoc
+// @Summary Upload a book cover
+// @Description Multipart upload in the "cover" field. JPEG, PNG or GIF up to 5 MB; small, medium and large JPEG thumbnails are generated. Replaces any previous cover.
+// @Tags Books
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param cover formData file true "Cover image"
+// @Success 200 {object} models.Book
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 415 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/books/{id}/cover [post]
+func (h *Handler) UploadCover(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	// leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, service.MaxCoverBytes+64<<10)
+	fh, err := c.FormFile("cover")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			renderError(c, service.ErrCoverTooLarge)
+			return
+		}
+		writeProblem(c, http.StatusBadRequest, `multipart file field "cover" is required`)
+		return
+	}
+	if fh.Size > service.MaxCoverBytes {
+		renderError(c, service.ErrCoverTooLarge)
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	book, err := h.svc.UploadCover(c.Request.Context(), actor, id, data)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, book)
+}
+
+// DeleteCover godoc
+// @Summary Remove a book cover
+// @Tags Books
+// @Param id path int true "Book ID"
+// @Success 204
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/books/{id}/cover [delete]
+func (h *Handler) DeleteCover(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.svc.DeleteCover(c.Request.Context(), actor, id); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ServeCover streams a cover thumbnail, /covers/<version>/<size>.jpg, as
+// linked from a book's cover field. Versions never change, so responses are
+// cacheable indefinitely and revalidate by ETag.
+func (h *Handler) ServeCover(c *gin.Context) {
+	cover := c.Param("cover")
+	size, ok := strings.CutSuffix(c.Param("file"), ".jpg")
+	if !ok {
+		writeProblem(c, http.StatusNotFound, "cover not found")
+		return
+	}
+	etag := `"` + cover + "-" + size + `"`
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+		c.Header("Cache-Control", coverCacheControl)
+		c.Header("ETag", etag)
+		c.Status(http.StatusNotModified)
+		return
+	}
+	rc, info, err := h.svc.OpenCover(c.Request.Context(), cover, size)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	defer rc.Close()
+	c.DataFromReader(http.StatusOK, info.Size, info.ContentType, rc, map[string]string{
+		"Cache-Control": coverCacheControl,
+		"ETag":          etag,
+	})
+}
@@ -1,12 +1,16 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/repository"
 	"github.com/example/books/internal/service"
 	"github.com/example/books/pkg/models"
 	"github.com/gin-gonic/gin"
@@ -15,26 +19,170 @@ import (
 // minimal in-memory repo implementing repository.Repository methods used by service
 type tinyRepo struct{}
 
-func (r *tinyRepo) CreateUser(u *models.User) error                   { u.ID = 1; return nil }
-func (r *tinyRepo) GetUserByEmail(email string) (*models.User, error) { return nil, nil }
-func (r *tinyRepo) CreateAuthor(a *models.Author) error               { return nil }
-func (r *tinyRepo) ListBooks() ([]models.Book, error)                 { return []models.Book{}, nil }
-func (r *tinyRepo) CreateBook(b *models.Book) error                   { return nil }
-func (r *tinyRepo) GetBook(id int) (*models.Book, error)              { return nil, nil }
-func (r *tinyRepo) UpdateBook(b *models.Book) error                   { return nil }
-func (r *tinyRepo) DeleteBook(id int) error                           { return nil }
-func (r *tinyRepo) CreateShelf(s *models.Shelf) error                 { return nil }
-func (r *tinyRepo) ListShelves() ([]models.Shelf, error)              { return []models.Shelf{}, nil }
-func (r *tinyRepo) CreateReview(rw *models.Review) error              { return nil }
-func (r *tinyRepo) ListReviewsByBook(bookID int) ([]models.Review, error) {
+func (r *tinyRepo) CreateUser(ctx context.Context, u *models.User) error { u.ID = 1; return nil }
+func (r *tinyRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) CreateAuthor(ctx context.Context, a *models.Author) error { return nil }
+func (r *tinyRepo) ListAuthors(ctx context.Context) ([]models.Author, error) {
+	return []models.Author{}, nil
+}
+func (r *tinyRepo) GetAuthor(ctx context.Context, id int) (*models.Author, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) FindAuthorByName(ctx context.Context, name string) (*models.Author, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) UpdateAuthor(ctx context.Context, a *models.Author) error { return nil }
+func (r *tinyRepo) DeleteAuthor(ctx context.Context, id int) error           { return nil }
+func (r *tinyRepo) ListBooksByAuthor(ctx context.Context, authorID int) ([]models.Book, error) {
+	return []models.Book{}, nil
+}
+func (r *tinyRepo) ListBooks(ctx context.Context) ([]models.Book, error) { return []models.Book{}, nil }
+func (r *tinyRepo) CreateBook(ctx context.Context, b *models.Book) error { return nil }
+func (r *tinyRepo) GetBook(ctx context.Context, id int) (*models.Book, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) UpdateBook(ctx context.Context, b *models.Book) error             { return nil }
+func (r *tinyRepo) DeleteBook(ctx context.Context, id int) error                     { return nil }
+func (r *tinyRepo) SetBookCover(ctx context.Context, bookID int, cover string) error { return nil }
+func (r *tinyRepo) SaveBooks(ctx context.Context, books []*models.Book) (int, error) {
+	return len(books), nil
+}
+func (r *tinyRepo) FindBookByKey(ctx context.Context, isbn, title string, authorID int) (*models.Book, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) CreateImportJob(ctx context.Context, j *models.ImportJob) error { return nil }
+func (r *tinyRepo) GetImportJob(ctx context.Context, id int) (*models.ImportJob, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) UpdateImportJob(ctx context.Context, j *models.ImportJob) error { return nil }
+func (r *tinyRepo) CancelImportJob(ctx context.Context, id int) (bool, error) {
+	return false, nil
+}
+func (r *tinyRepo) InterruptImportJobs(ctx context.Context, reason string) error { return nil }
+func (r *tinyRepo) ListGenres(ctx context.Context) ([]models.LabelCount, error) {
+	return []models.LabelCount{}, nil
+}
+func (r *tinyRepo) ListTags(ctx context.Context) ([]models.LabelCount, error) {
+	return []models.LabelCount{}, nil
+}
+func (r *tinyRepo) CreateShelf(ctx context.Context, s *models.Shelf) error { return nil }
+func (r *tinyRepo) QueryBooks(ctx context.Context, q repository.BookQuery) (*repository.BookPage, error) {
+	return &repository.BookPage{Books: []models.Book{}}, nil
+}
+func (r *tinyRepo) StreamBooks(ctx context.Context, q repository.BookQuery, fn func(*models.Book) error) error {
+	return nil
+}
+func (r *tinyRepo) CountBooks(ctx context.Context, q repository.BookQuery) (int, error) {
+	return 0, nil
+}
+func (r *tinyRepo) SearchBooks(ctx context.Context, q repository.SearchQuery) (*repository.SearchPage, error) {
+	return &repository.SearchPage{Hits: []models.BookSearchHit{}}, nil
+}
+func (r *tinyRepo) QueryShelves(ctx context.Context, q repository.ShelfQuery) ([]models.Shelf, error) {
+	return []models.Shelf{}, nil
+}
+func (r *tinyRepo) CountShelves(ctx context.Context, q repository.ShelfQuery) (int, error) {
+	return 0, nil
+}
+func (r *tinyRepo) CreateReview(ctx context.Context, rw *models.Review) error { return nil }
+func (r *tinyRepo) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) UpdateReview(ctx context.Context, rw *models.Review) error { return nil }
+func (r *tinyRepo) DeleteReview(ctx context.Context, id int) error            { return nil }
+func (r *tinyRepo) ListReviewsByBook(ctx context.Context, bookID int, sort string) ([]models.Review, error) {
 	return []models.Review{}, nil
 }
+func (r *tinyRepo) AddReviewVote(ctx context.Context, reviewID, userID int) error    { return nil }
+func (r *tinyRepo) RemoveReviewVote(ctx context.Context, reviewID, userID int) error { return nil }
+func (r *tinyRepo) SetReviewStatus(ctx context.Context, id int, status string, moderatorID *int, note string) error {
+	return nil
+}
+func (r *tinyRepo) QueryReviews(ctx context.Context, q repository.ReviewQuery) ([]models.Review, error) {
+	return []models.Review{}, nil
+}
+func (r *tinyRepo) CountReviews(ctx context.Context, q repository.ReviewQuery) (int, error) {
+	return 0, nil
+}
+func (r *tinyRepo) ReportReview(ctx context.Context, rep *models.ReviewReport) (int, error) {
+	return 0, nil
+}
+func (r *tinyRepo) ListReviewReports(ctx context.Context, reviewID int) ([]models.ReviewReport, error) {
+	return []models.ReviewReport{}, nil
+}
 
-func (r *tinyRepo) GetShelf(id int) (*models.Shelf, error)            { return nil, nil }
-func (r *tinyRepo) ListBooksByShelf(shelfID int) ([]models.Book, error) { return []models.Book{}, nil }
-func (r *tinyRepo) AddBookToShelf(shelfID int, bookID int) error        { return nil }
-func (r *tinyRepo) GetUserByID(id int) (*models.User, error)           { return nil, nil }
-func (r *tinyRepo) UpdateUserRole(userID int, role string) error       { return nil }
+func (r *tinyRepo) GetShelf(ctx context.Context, id int) (*models.Shelf, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) ListBooksByShelf(ctx context.Context, shelfID int) ([]models.Book, error) {
+	return []models.Book{}, nil
+}
+func (r *tinyRepo) AddBookToShelf(ctx context.Context, shelfID int, bookID int) error  { return nil }
+func (r *tinyRepo) UpdateShelf(ctx context.Context, s *models.Shelf) error             { return nil }
+func (r *tinyRepo) DeleteShelf(ctx context.Context, id int) error                      { return nil }
+func (r *tinyRepo) RemoveBookFromShelf(ctx context.Context, shelfID, bookID int) error { return nil }
+func (r *tinyRepo) ReorderShelf(ctx context.Context, shelfID int, bookIDs []int) error { return nil }
+func (r *tinyRepo) MoveBook(ctx context.Context, fromShelfID, toShelfID, bookID int) error {
+	return nil
+}
+func (r *tinyRepo) GetShelfByShareToken(ctx context.Context, token string) (*models.Shelf, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) ListShelfCollaborators(ctx context.Context, shelfID int) ([]models.ShelfCollaborator, error) {
+	return []models.ShelfCollaborator{}, nil
+}
+func (r *tinyRepo) GetShelfCollaborator(ctx context.Context, shelfID, userID int) (*models.ShelfCollaborator, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) SaveShelfCollaborator(ctx context.Context, c *models.ShelfCollaborator) error {
+	return nil
+}
+func (r *tinyRepo) RemoveShelfCollaborator(ctx context.Context, shelfID, userID int) error {
+	return nil
+}
+func (r *tinyRepo) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) UpdateUserRole(ctx context.Context, userID int, role string) error { return nil }
+func (r *tinyRepo) GetReadingStatus(ctx context.Context, userID, bookID int) (*models.ReadingStatus, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) ListReadingStatuses(ctx context.Context, userID int, status string) ([]models.ReadingStatus, error) {
+	return []models.ReadingStatus{}, nil
+}
+func (r *tinyRepo) SaveReadingStatus(ctx context.Context, rs *models.ReadingStatus, statusChanged bool) error {
+	return nil
+}
+func (r *tinyRepo) DeleteReadingStatus(ctx context.Context, userID, bookID int) error { return nil }
+func (r *tinyRepo) ListReadingHistory(ctx context.Context, userID, bookID int) ([]models.ReadingEvent, error) {
+	return []models.ReadingEvent{}, nil
+}
+func (r *tinyRepo) TopRatedBooks(ctx context.Context, q repository.TopRatedQuery) ([]models.RatedBook, error) {
+	return []models.RatedBook{}, nil
+}
+func (r *tinyRepo) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error { return nil }
+func (r *tinyRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	return nil, domain.NotFound("not found")
+}
+func (r *tinyRepo) RevokeRefreshToken(ctx context.Context, id int) (bool, error)  { return true, nil }
+func (r *tinyRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error { return nil }
+func (r *tinyRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return nil
+}
+func (r *tinyRepo) SetUserTokenCutoff(ctx context.Context, userID int, notBefore time.Time) error {
+	return nil
+}
+func (r *tinyRepo) IsAccessTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	return false, nil
+}
+func (r *tinyRepo) Backup(ctx context.Context, fn func(table string, row interface{}) error) error {
+	return nil
+}
+func (r *tinyRepo) Restore(ctx context.Context, fn func(repository.Restorer) error) error {
+	return domain.Conflict("a backup can only be restored into an empty catalog")
+}
 
 func TestDocsPage(t *testing.T) {
 	r := &tinyRepo{}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+const problemContentType = "application/problem+json"
+
+// problem is an RFC 7807 problem details body.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func newProblem(c *gin.Context, status int, detail string) problem {
+	return problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: c.Request.URL.Path,
+	}
+}
+
+// writeProblem responds with a problem+json body.
+func writeProblem(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", problemContentType)
+	c.JSON(status, newProblem(c, status, detail))
+}
+
+// abortProblem is writeProblem for middleware that stops the chain.
+func abortProblem(c *gin.Context, status int, detail string) {
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(status, newProblem(c, status, detail))
+}
+
+// statusForError maps domain and service errors to HTTP status codes
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, domain.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrImportsBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, domain.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrCoverTooLarge), errors.Is(err, service.ErrImportTooLarge), errors.Is(err, service.ErrBackupTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrUnsupportedCover):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, domain.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrInvalidQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrInvalidToken), errors.Is(err, service.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// renderError writes err as a problem. Only domain messages and our own
+// sentinel texts reach the client; anything else is logged and reported as
+// an internal error so driver and SQL details never leak.
+func renderError(c *gin.Context, err error) {
+	status := statusForError(err)
+	detail := domain.Message(err)
+	switch {
+	case status == http.StatusInternalServerError:
+		log.Printf("%s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		detail = "internal server error"
+	case status == http.StatusGatewayTimeout:
+		detail = "request timed out"
+	case detail == "":
+		detail = err.Error()
+	}
+	writeProblem(c, status, detail)
+}
@@ -1,11 +1,12 @@
 package handler
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/example/books/internal/auth"
@@ -15,68 +16,152 @@ import (
 )
 
 type Handler struct {
-	svc *service.Service
+	svc          *service.Service
+	opdsFailures *failureLimiter
 }
 
 func NewHandler(s *service.Service) *Handler {
-	return &Handler{svc: s}
+	return &Handler{svc: s, opdsFailures: newFailureLimiter(opdsMaxFailures, opdsFailureWindow)}
 }
 
 // RegisterRoutes registers all HTTP routes on the provided Gin engine.
 func (h *Handler) RegisterRoutes(r *gin.Engine) {
 	// instrumentation middleware (Prometheus)
 	r.Use(metrics.GinMiddleware())
+	// bound the database work of every request (DB_TIMEOUT, default 5s)
+	r.Use(DBTimeout(durationEnv("DB_TIMEOUT", 5*time.Second)))
 
 	// static assets & templates
 	r.Static("/assets", "./web/static")
 	r.LoadHTMLGlob("web/templates/*")
+	r.GET("/covers/:cover/:file", h.ServeCover)
 
 	api := r.Group("/api")
 	{
 		api.POST("/register", h.Register)
 		api.POST("/login", h.Login)
+		api.POST("/token/refresh", h.RefreshToken)
+		api.POST("/logout", h.AuthMiddleware(), h.Logout)
+		api.POST("/logout/all", h.AuthMiddleware(), h.LogoutAll)
 		api.GET("/me", h.AuthMiddleware(), h.Me)
+		api.GET("/me/shelves", h.AuthMiddleware(), h.MyShelves)
+
+		reading := api.Group("/me/reading", h.AuthMiddleware())
+		{
+			reading.GET("", h.ListReading)
+			reading.GET(":book_id", h.GetReading)
+			reading.PUT(":book_id", h.UpdateReading)
+			reading.DELETE(":book_id", h.DeleteReading)
+		}
 
 		// admin: update user role
 		api.PUT("/users/:id/role", h.AuthMiddleware(), h.RequireRole("admin"), h.UpdateUserRole)
+		// admin: end every session of a user
+		api.POST("/users/:id/logout", h.AuthMiddleware(), h.RequireRole("admin"), h.LogoutUser)
 
 		books := api.Group("/books")
 		{
 			books.GET("", h.ListBooks)
+			books.GET("/search", h.SearchBooks)
+			books.GET("/top-rated", h.TopRatedBooks)
 			books.POST("", h.AuthMiddleware(), h.RequireRole("admin"), h.CreateBook)
 			books.GET(":id", h.GetBook)
 			books.PUT(":id", h.AuthMiddleware(), h.UpdateBook)
 			books.DELETE(":id", h.AuthMiddleware(), h.DeleteBook)
+			books.GET(":id/reviews", h.ListBookReviews)
+			books.POST(":id/cover", h.AuthMiddleware(), h.UploadCover)
+			books.DELETE(":id/cover", h.AuthMiddleware(), h.DeleteCover)
 
 			// Import/Export
 			books.GET("/export/json", h.AuthMiddleware(), h.ExportBooksJSON)
 			books.GET("/export/csv", h.AuthMiddleware(), h.ExportBooksCSV)
+			books.GET("/export/ndjson", h.AuthMiddleware(), h.ExportBooksNDJSON)
 			books.POST("/import/json", h.AuthMiddleware(), h.RequireRole("admin"), h.ImportBooksJSON)
 			books.POST("/import/csv", h.AuthMiddleware(), h.RequireRole("admin"), h.ImportBooksCSV)
 		}
 
+		// every user may import their own library; jobs are visible to their owner and admins
+		imports := api.Group("/imports", h.AuthMiddleware())
+		{
+			imports.POST("goodreads", h.ImportGoodreads)
+			imports.POST("librarything", h.ImportLibraryThing)
+			imports.GET(":id", h.GetImport)
+			imports.GET(":id/errors", h.ImportErrorReport)
+			imports.GET(":id/changes", h.ImportChanges)
+			imports.POST(":id/cancel", h.CancelImport)
+		}
+
+		api.GET("/genres", h.ListGenres)
+		api.GET("/tags", h.ListTags)
+
+		authors := api.Group("/authors")
+		{
+			authors.GET("", h.ListAuthors)
+			authors.POST("", h.AuthMiddleware(), h.RequireRole("admin"), h.CreateAuthor)
+			authors.GET(":id", h.GetAuthor)
+			authors.PUT(":id", h.AuthMiddleware(), h.RequireRole("admin"), h.UpdateAuthor)
+			authors.DELETE(":id", h.AuthMiddleware(), h.RequireRole("admin"), h.DeleteAuthor)
+			authors.GET(":id/books", h.ListAuthorBooks)
+		}
+
 		shelves := api.Group("/shelves")
 		{
 			shelves.GET("", h.ListShelves)
 			shelves.POST("", h.AuthMiddleware(), h.CreateShelf)
+			shelves.GET(":id", h.OptionalAuth(), h.GetShelf)
+			shelves.PUT(":id", h.AuthMiddleware(), h.UpdateShelf)
+			shelves.DELETE(":id", h.AuthMiddleware(), h.DeleteShelf)
+			shelves.POST(":id/share-token", h.AuthMiddleware(), h.RotateShareToken)
+			shelves.GET(":id/collaborators", h.OptionalAuth(), h.ListCollaborators)
+			shelves.POST(":id/collaborators", h.AuthMiddleware(), h.AddCollaborator)
+			shelves.DELETE(":id/collaborators/:user_id", h.AuthMiddleware(), h.RemoveCollaborator)
 			shelves.POST(":id/books", h.AuthMiddleware(), h.AddBookToShelf)
+			shelves.PUT(":id/books", h.AuthMiddleware(), h.ReorderShelf)
+			shelves.DELETE(":id/books/:book_id", h.AuthMiddleware(), h.RemoveBookFromShelf)
+			shelves.POST(":id/books/:book_id/move", h.AuthMiddleware(), h.MoveShelfBook)
 		}
 
+		api.GET("/shared-shelves/:token", h.GetSharedShelf)
+
 		reviews := api.Group("/reviews")
 		{
 			reviews.POST("", h.AuthMiddleware(), h.CreateReview)
+			reviews.PUT(":id", h.AuthMiddleware(), h.UpdateReview)
+			reviews.DELETE(":id", h.AuthMiddleware(), h.DeleteReview)
+			reviews.POST(":id/helpful", h.AuthMiddleware(), h.VoteHelpful)
+			reviews.DELETE(":id/helpful", h.AuthMiddleware(), h.UnvoteHelpful)
+			reviews.POST(":id/report", h.AuthMiddleware(), h.ReportReview)
+		}
+
+		moderation := api.Group("/admin/reviews", h.AuthMiddleware(), h.RequireRole("admin"))
+		{
+			moderation.GET("", h.ReviewQueue)
+			moderation.PUT(":id", h.ModerateReview)
+			moderation.GET(":id/reports", h.ReviewReports)
 		}
+
+		// admin: move a whole instance between environments
+		api.GET("/admin/backup", h.AuthMiddleware(), h.RequireRole("admin"), h.Backup)
+		api.POST("/admin/restore", h.AuthMiddleware(), h.RequireRole("admin"), h.Restore)
 	}
 
+	// public signing keys for services verifying our tokens
+	r.GET("/.well-known/jwks.json", h.JWKS)
+	// OPDS catalog for e-reader apps
+	h.OPDSRoutes(r)
+
 	// UI pages
 	r.GET("/", h.Index)
 	r.GET("/books/new", h.NewBookPage)
 	r.GET("/books/:id", h.BookPage)
+	r.GET("/authors/:id", h.AuthorPage)
 	r.GET("/shelves", h.ShelvesPage)
-	r.GET("/shelves/:id", h.ShelfPage)
+	r.GET("/shelves/:id", h.OptionalAuth(), h.ShelfPage)
+	r.GET("/s/:token", h.SharedShelfPage)
 	r.GET("/login", h.LoginPage)
 	r.GET("/register", h.RegisterPage)
 	r.GET("/profile", h.ProfilePage)
+	r.GET("/admin/reviews", h.ModerationPage)
 
 	// docs
 	r.GET("/docs", h.SwaggerUI)
@@ -87,33 +172,58 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 }
 
 func (h *Handler) Index(c *gin.Context) {
-	books, err := h.svc.ListBooks()
+	page, size := pageParams(c, 12)
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		h.searchPage(c, q, page, size)
+		return
+	}
+	q := service.BookQuery{Limit: size, Offset: (page - 1) * size,
+		Genre: c.Query("genre"), Tag: c.Query("tag"), Language: c.Query("lang")}
+	q.Year, _ = strconv.Atoi(c.Query("year"))
+	res, err := h.svc.QueryBooks(c.Request.Context(), q)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		renderError(c, err)
+		return
+	}
+	total, err := h.svc.CountBooks(c.Request.Context(), q)
+	if err != nil {
+		renderError(c, err)
 		return
 	}
 	// prevent caching of the main page which may show auth-dependent content
 	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
 	c.Header("Pragma", "no-cache")
-	c.HTML(http.StatusOK, "index.html", gin.H{"books": books})
+	genres, err := h.svc.ListGenres(c.Request.Context())
+	if err != nil {
+		genres = nil // the filter still works by link
+	}
+	data := paginationData(page, size, total)
+	data["books"] = res.Books
+	data["genres"] = genres
+	data["genre"], data["tag"], data["lang"] = q.Genre, q.Tag, q.Language
+	if q.Year != 0 {
+		data["year"] = q.Year
+	}
+	c.HTML(http.StatusOK, "index.html", data)
 }
 
 func (h *Handler) BookPage(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		writeProblem(c, http.StatusBadRequest, "invalid id")
 		return
 	}
-	b, err := h.svc.GetBook(id)
+	b, err := h.svc.GetBook(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+		renderError(c, err)
 		return
 	}
-	reviews, err := h.svc.ListReviews(id)
+	reviewSort := c.DefaultQuery("sort", service.ReviewSortNewest)
+	reviews, err := h.svc.ListReviews(c.Request.Context(), id, reviewSort)
 	if err != nil {
 		reviews = []service.ReviewModel{} // allow page to render even if reviews fail, but handle error
 	}
-	c.HTML(http.StatusOK, "book.html", gin.H{"book": b, "reviews": reviews})
+	c.HTML(http.StatusOK, "book.html", gin.H{"book": b, "reviews": reviews, "reviewSort": reviewSort, "ratingBars": ratingBars(b)})
 }
 
 // Swagger UI page; loads OpenAPI from /docs/openapi.yaml
@@ -157,61 +267,24 @@ func (h *Handler) ShelvesPage(c *gin.Context) {
 	// prevent caching because shelves content is auth-dependent
 	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
 	c.Header("Pragma", "no-cache")
-	// pagination params
-	pageStr := c.DefaultQuery("page", "1")
-	sizeStr := c.DefaultQuery("size", "10")
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
-	}
-	size, err := strconv.Atoi(sizeStr)
-	if err != nil || size < 1 {
-		size = 10
-	}
-
-	shelves, err := h.svc.ListShelves()
+	page, size := pageParams(c, 10)
+	q := service.ShelfQuery{Limit: size, Offset: (page - 1) * size, Visibility: service.ShelfPublic}
+	shelves, total, err := h.svc.QueryShelves(c.Request.Context(), q)
 	if err != nil {
 		c.HTML(http.StatusOK, "shelves.html", gin.H{"shelves": []interface{}{}, "page": page, "size": size, "total": 0, "totalPages": 0})
 		return
 	}
-	total := len(shelves)
-	start := (page - 1) * size
-	if start > total {
-		start = total
-	}
-	end := start + size
-	if end > total {
-		end = total
-	}
-	pageShelves := shelves[start:end]
-	totalPages := 0
-	if size > 0 {
-		totalPages = (total + size - 1) / size
-	}
-	prevPage := page - 1
-	if prevPage < 1 {
-		prevPage = 1
-	}
-	nextPage := page + 1
-	if nextPage > totalPages {
-		nextPage = totalPages
-	}
-
-	c.HTML(http.StatusOK, "shelves.html", gin.H{
-		"shelves":    pageShelves,
-		"page":       page,
-		"size":       size,
-		"total":      total,
-		"totalPages": totalPages,
-		"prevPage":   prevPage,
-		"nextPage":   nextPage,
-		"hasPrev":    page > 1,
-		"hasNext":    page < totalPages,
-	})
+	data := paginationData(page, size, total)
+	data["shelves"] = shelves
+	c.HTML(http.StatusOK, "shelves.html", data)
 }
 
 func (h *Handler) NewBookPage(c *gin.Context) {
-	c.HTML(http.StatusOK, "new_book.html", nil)
+	authors, err := h.svc.ListAuthors(c.Request.Context())
+	if err != nil {
+		authors = []service.AuthorModel{}
+	}
+	c.HTML(http.StatusOK, "new_book.html", gin.H{"authors": authors})
 }
 
 // API handlers
@@ -232,12 +305,12 @@ func (h *Handler) Register(c *gin.Context) {
 		Name     string `json:"name"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
 		return
 	}
-	u, err := h.svc.RegisterUser(req.Email, req.Password, req.Name)
+	u, err := h.svc.RegisterUser(c.Request.Context(), req.Email, req.Password, req.Name)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, u)
@@ -259,44 +332,174 @@ func (h *Handler) Login(c *gin.Context) {
 		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	u, err := h.svc.Authenticate(req.Email, req.Password)
+	u, err := h.svc.Authenticate(c.Request.Context(), req.Email, req.Password)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	pair, err := h.svc.IssueTokens(c.Request.Context(), u)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(pair))
+}
+
+// tokenResponse keeps "token" for clients written against the single-token login
+func tokenResponse(p *service.TokenPair) gin.H {
+	return gin.H{
+		"token":         p.AccessToken,
+		"access_token":  p.AccessToken,
+		"refresh_token": p.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_at":    p.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// RefreshToken godoc
+// @Summary Rotate a refresh token
+// @Description Exchange a refresh token for a new access/refresh token pair. The presented refresh token is revoked.
+// @Tags Auth
+// @Accept json
+// @Produce json
+// @Param payload body map[string]string true "Refresh payload"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /api/token/refresh [post]
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	pair, err := h.svc.RefreshTokens(c.Request.Context(), req.RefreshToken)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokenResponse(pair))
+}
+
+// Logout godoc
+// @Summary Log out the current session
+// @Description Revoke the current access token and, if provided, the refresh token
+// @Tags Auth
+// @Accept json
+// @Param payload body map[string]string false "Logout payload"
+// @Success 204
+// @Failure 401 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/logout [post]
+func (h *Handler) Logout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	// body is optional
+	_ = c.ShouldBindJSON(&req)
+	claimsVal, ok := c.Get("claims")
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	// generate JWT
-	tok, err := auth.GenerateToken(u.ID, u.Role, 24*time.Hour)
+	if err := h.svc.Logout(c.Request.Context(), claimsVal.(*auth.Claims), req.RefreshToken); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// LogoutAll godoc
+// @Summary Log out all sessions
+// @Description Revoke every refresh token and access token of the current user
+// @Tags Auth
+// @Success 204
+// @Failure 401 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/logout/all [post]
+func (h *Handler) LogoutAll(c *gin.Context) {
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.svc.LogoutAll(c.Request.Context(), actor.UserID); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// JWKS godoc
+// @Summary JSON Web Key Set
+// @Description Public keys currently accepted for verifying access tokens (empty when HS256 is used)
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKS(c *gin.Context) {
+	// short cache so verifiers pick up rotated keys quickly
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": auth.Keys().JWKS()})
+}
+
+// LogoutUser API handler (admin only): end every session of a user
+func (h *Handler) LogoutUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "token generation failed"})
+		writeProblem(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	if err := h.svc.LogoutAll(c.Request.Context(), id); err != nil {
+		renderError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": tok})
+	c.Status(http.StatusNoContent)
 }
 
 // ListBooks godoc
 // @Summary List books
-// @Description Get list of books
+// @Description Get a page of books. Pass next_cursor from the previous response as cursor to continue.
 // @Tags Books
 // @Produce json
-// @Success 200 {array} models.Book
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param offset query int false "Rows to skip (ignored when cursor is set)"
+// @Param sort query string false "created_at, title, id, rating or year; prefix with - for descending (default -created_at)"
+// @Param author_id query int false "Only books by this author"
+// @Param genre query string false "Only books in this genre"
+// @Param tag query string false "Only books with this tag"
+// @Param lang query string false "ISO 639 language code"
+// @Param year query int false "Publication year"
+// @Param isbn query string false "ISBN-10 or ISBN-13"
+// @Param created_after query string false "RFC 3339 timestamp or date"
+// @Param created_before query string false "RFC 3339 timestamp or date"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/books [get]
 func (h *Handler) ListBooks(c *gin.Context) {
-	bs, err := h.svc.ListBooks()
+	q, err := parseBookQuery(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, bs)
+	res, err := h.svc.QueryBooks(c.Request.Context(), q)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": res.Books, "next_cursor": res.NextCursor})
 }
 
 // CreateBook godoc
 // @Summary Create a new book
-// @Description Create a book (authenticated)
+// @Description Create a book (authenticated). Credit authors, editors, translators, illustrators and narrators in order with contributors; author_id alone is deprecated and credits one author.
 // @Tags Books
 // @Accept json
 // @Produce json
@@ -304,21 +507,28 @@ func (h *Handler) ListBooks(c *gin.Context) {
 // @Success 201 {object} models.Book
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 422 {object} map[string]string
 // @Security bearerAuth
 // @Router /api/books [post]
 func (h *Handler) CreateBook(c *gin.Context) {
-	var b struct {
-		Title       string `json:"title" binding:"required"`
-		Description string `json:"description"`
-		AuthorID    int    `json:"author_id"`
-	}
+	var b bookRequest
 	if err := c.ShouldBindJSON(&b); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if b.Title == "" {
+		writeProblem(c, http.StatusBadRequest, "title is required")
 		return
 	}
-	bk := &service.BookModel{Title: b.Title, Description: b.Description, AuthorID: b.AuthorID}
-	if err := h.svc.CreateBookFromModel(bk); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	bk := b.model()
+	bk.CreatedBy = &actor.UserID
+	if err := h.svc.CreateBookFromModel(c.Request.Context(), bk); err != nil {
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, bk)
@@ -328,18 +538,18 @@ func (h *Handler) CreateBook(c *gin.Context) {
 func (h *Handler) UpdateUserRole(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		writeProblem(c, http.StatusBadRequest, "invalid user id")
 		return
 	}
 	var req struct {
 		Role string `json:"role" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
 		return
 	}
-	if err := h.svc.UpdateUserRole(id, req.Role); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc.UpdateUserRole(c.Request.Context(), id, req.Role); err != nil {
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"id": id, "role": req.Role})
@@ -357,12 +567,12 @@ func (h *Handler) UpdateUserRole(c *gin.Context) {
 func (h *Handler) GetBook(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		writeProblem(c, http.StatusBadRequest, "invalid id")
 		return
 	}
-	b, err := h.svc.GetBook(id)
+	b, err := h.svc.GetBook(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, b)
@@ -379,26 +589,31 @@ func (h *Handler) GetBook(c *gin.Context) {
 // @Success 200 {object} models.Book
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} map[string]string
 // @Security bearerAuth
 // @Router /api/books/{id} [put]
 func (h *Handler) UpdateBook(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		writeProblem(c, http.StatusBadRequest, "invalid id")
 		return
 	}
-	var b struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
-		AuthorID    int    `json:"author_id"`
-	}
+	var b bookRequest
 	if err := c.ShouldBindJSON(&b); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	bk := &service.BookModel{ID: id, Title: b.Title, Description: b.Description, AuthorID: b.AuthorID}
-	if err := h.svc.UpdateBookFromModel(bk); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	bk := b.model()
+	bk.ID = id
+	if err := h.svc.UpdateBookFromModel(c.Request.Context(), actor, bk); err != nil {
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, bk)
@@ -411,16 +626,23 @@ func (h *Handler) UpdateBook(c *gin.Context) {
 // @Param id path int true "Book ID"
 // @Success 204
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Security bearerAuth
 // @Router /api/books/{id} [delete]
 func (h *Handler) DeleteBook(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	if err := h.svc.DeleteBook(id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc.DeleteBook(c.Request.Context(), actor, id); err != nil {
+		renderError(c, err)
 		return
 	}
 	c.Status(http.StatusNoContent)
@@ -428,23 +650,31 @@ func (h *Handler) DeleteBook(c *gin.Context) {
 
 // ListShelves godoc
 // @Summary List shelves
-// @Description List all shelves
+// @Description One page of the public shelves.
 // @Tags Shelves
 // @Produce json
-// @Success 200 {array} models.Shelf
+// @Param limit query int false "Page size"
+// @Param offset query int false "Rows to skip"
+// @Success 200 {object} map[string]interface{}
 // @Router /api/shelves [get]
 func (h *Handler) ListShelves(c *gin.Context) {
-	s, err := h.svc.ListShelves()
+	q, err := shelfPageQuery(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, s)
+	q.Visibility = service.ShelfPublic
+	shelves, total, err := h.svc.QueryShelves(c.Request.Context(), q)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": shelves, "total": total})
 }
 
 // CreateShelf godoc
 // @Summary Create a shelf
-// @Description Create a shelf for authenticated user
+// @Description Create a shelf for authenticated user. Shelves are private unless visibility is public or unlisted.
 // @Tags Shelves
 // @Accept json
 // @Produce json
@@ -452,29 +682,31 @@ func (h *Handler) ListShelves(c *gin.Context) {
 // @Success 201 {object} models.Shelf
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 422 {object} map[string]string
 // @Security bearerAuth
 // @Router /api/shelves [post]
 func (h *Handler) CreateShelf(c *gin.Context) {
 	var sh struct {
-		Name string `json:"name" binding:"required"`
+		Name       string `json:"name" binding:"required"`
+		Visibility string `json:"visibility"`
 	}
 	if err := c.ShouldBindJSON(&sh); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
 		return
 	}
 	uidVal, ok := c.Get("user_id")
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 	uid, ok := uidVal.(int)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid user id"})
+		writeProblem(c, http.StatusInternalServerError, "invalid user id")
 		return
 	}
-	shelf := &service.ShelfModel{UserID: uid, Name: sh.Name}
-	if err := h.svc.CreateShelfFromModel(shelf); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	shelf := &service.ShelfModel{UserID: uid, Name: sh.Name, Visibility: sh.Visibility}
+	if err := h.svc.CreateShelfFromModel(c.Request.Context(), shelf); err != nil {
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, shelf)
@@ -490,6 +722,7 @@ func (h *Handler) CreateShelf(c *gin.Context) {
 // @Success 201 {object} models.Review
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 409 {object} map[string]string
 // @Security bearerAuth
 // @Router /api/reviews [post]
 func (h *Handler) CreateReview(c *gin.Context) {
@@ -499,22 +732,22 @@ func (h *Handler) CreateReview(c *gin.Context) {
 		Rating int    `json:"rating" binding:"required,min=1,max=5"`
 	}
 	if err := c.ShouldBindJSON(&r); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
 		return
 	}
 	uidVal, ok := c.Get("user_id")
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 	uid, ok := uidVal.(int)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid user id"})
+		writeProblem(c, http.StatusInternalServerError, "invalid user id")
 		return
 	}
 	rev := &service.ReviewModel{UserID: uid, BookID: r.BookID, Text: r.Text, Rating: r.Rating}
-	if err := h.svc.CreateReviewFromModel(rev); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc.CreateReviewFromModel(c.Request.Context(), rev); err != nil {
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, rev)
@@ -524,61 +757,84 @@ func (h *Handler) CreateReview(c *gin.Context) {
 func (h *Handler) AddBookToShelf(c *gin.Context) {
 	sid, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid shelf id"})
+		writeProblem(c, http.StatusBadRequest, "invalid shelf id")
 		return
 	}
 	var req struct {
 		BookID int `json:"book_id" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	if err := h.svc.AddBookToShelf(sid, req.BookID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.svc.AddBookToShelf(c.Request.Context(), actor, sid, req.BookID); err != nil {
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusCreated, gin.H{"shelf_id": sid, "book_id": req.BookID})
 }
 
-// ShelfPage UI: show shelf and its books
+// ShelfPage UI: show shelf and its books to those who may see it, as
+// GetShelf does. Browsers keep the token in local storage and send no
+// Authorization header with a page load, so for them only public shelves
+// are rendered here; the page script loads restricted shelves with the token.
 func (h *Handler) ShelfPage(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.String(http.StatusBadRequest, "invalid shelf id")
 		return
 	}
-	shelf, err := h.svc.GetShelf(id)
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	// the picker starts with the newest books; the page searches for the rest
+	var allBooks []service.BookModel
+	if page, err := h.svc.QueryBooks(c.Request.Context(), service.BookQuery{}); err == nil {
+		allBooks = page.Books
+	}
+	actor, _ := actorFromContext(c)
+	shelf, err := h.svc.GetShelf(c.Request.Context(), actor, id)
 	if err != nil {
-		c.String(http.StatusNotFound, "shelf not found")
+		c.HTML(http.StatusOK, "shelf_detail.html", gin.H{"shelfID": id, "books": []service.BookModel{}, "allBooks": allBooks})
 		return
 	}
-	books, err := h.svc.ListBooksByShelf(id)
+	books, err := h.svc.ListBooksByShelf(c.Request.Context(), actor, id)
 	if err != nil {
 		books = []service.BookModel{}
 	}
-	allBooks, err := h.svc.ListBooks()
+	c.HTML(http.StatusOK, "shelf_detail.html", gin.H{"shelfID": id, "shelf": shelf, "books": books, "allBooks": allBooks})
+}
+
+// SharedShelfPage UI: read-only view of a shelf opened through its share link
+func (h *Handler) SharedShelfPage(c *gin.Context) {
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	c.Header("Referrer-Policy", "no-referrer")
+	shelf, books, err := h.svc.SharedShelf(c.Request.Context(), c.Param("token"))
 	if err != nil {
-		allBooks = []service.BookModel{}
+		c.String(http.StatusNotFound, "shelf not found")
+		return
 	}
-	c.HTML(http.StatusOK, "shelf_detail.html", gin.H{"shelf": shelf, "books": books, "allBooks": allBooks})
+	c.HTML(http.StatusOK, "shelf_detail.html", gin.H{"shelfID": shelf.ID, "shelf": shelf, "books": books, "shared": true})
 }
 
 // Me returns current authenticated user (API)
 func (h *Handler) Me(c *gin.Context) {
 	uidVal, ok := c.Get("user_id")
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 	uid, ok := uidVal.(int)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid user id"})
+		writeProblem(c, http.StatusInternalServerError, "invalid user id")
 		return
 	}
-	u, err := h.svc.GetUserByID(uid)
+	u, err := h.svc.GetUserByID(c.Request.Context(), uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		renderError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, u)
@@ -592,114 +848,125 @@ func (h *Handler) ProfilePage(c *gin.Context) {
 // Auth middleware using real JWT
 func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokh := c.GetHeader("Authorization")
-		if tokh == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			return
-		}
-		var tok string
-		_, err := fmt.Sscanf(tokh, "Bearer %s", &tok)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		if c.GetHeader("Authorization") == "" {
+			abortProblem(c, http.StatusUnauthorized, "unauthorized")
 			return
 		}
-		claims, err := auth.ParseToken(tok)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			return
+		if h.authenticate(c) {
+			c.Next()
 		}
-		c.Set("user_id", claims.UserID)
-		c.Set("role", claims.Role)
-		c.Next()
 	}
 }
 
-// RequireRole middleware checks user role
-func (h *Handler) RequireRole(role string) gin.HandlerFunc {
+// OptionalAuth identifies the caller when an Authorization header is sent and
+// lets anonymous requests through. A bad token is still rejected.
+func (h *Handler) OptionalAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		r, ok := c.Get("role")
-		if !ok || r.(string) != role {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		if c.GetHeader("Authorization") != "" && !h.authenticate(c) {
 			return
 		}
 		c.Next()
 	}
 }
 
-func envOrDefault(k, def string) string {
-	v := os.Getenv(k)
-	if v == "" {
-		return def
+// authenticate verifies the bearer token and stores its claims on the
+// context, aborting the request when it is not acceptable.
+func (h *Handler) authenticate(c *gin.Context) bool {
+	var tok string
+	_, err := fmt.Sscanf(c.GetHeader("Authorization"), "Bearer %s", &tok)
+	if err != nil {
+		abortProblem(c, http.StatusUnauthorized, "unauthorized")
+		return false
 	}
-	return v
-}
-
-func (h *Handler) ExportBooksJSON(c *gin.Context) {
-	data, err := h.svc.ExportBooksJSON()
+	claims, err := auth.ParseToken(tok)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		abortProblem(c, http.StatusUnauthorized, "unauthorized")
+		return false
 	}
-	c.Header("Content-Disposition", "attachment; filename=books.json")
-	c.Data(http.StatusOK, "application/json", data)
-}
-
-func (h *Handler) ExportBooksCSV(c *gin.Context) {
-	data, err := h.svc.ExportBooksCSV()
+	revoked, err := h.svc.IsTokenRevoked(c.Request.Context(), claims)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		renderError(c, err)
+		c.Abort()
+		return false
+	}
+	if revoked {
+		abortProblem(c, http.StatusUnauthorized, "token revoked")
+		return false
 	}
-	c.Header("Content-Disposition", "attachment; filename=books.csv")
-	c.Data(http.StatusOK, "text/csv", data)
+	c.Set("claims", claims)
+	c.Set("user_id", claims.UserID)
+	c.Set("role", claims.Role)
+	return true
 }
 
-func (h *Handler) ImportBooksJSON(c *gin.Context) {
-	file, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
-		return
-	}
-	f, err := file.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// actorFromContext builds the service actor from claims set by AuthMiddleware
+func actorFromContext(c *gin.Context) (service.Actor, bool) {
+	uid, ok := c.Get("user_id")
+	if !ok {
+		return service.Actor{}, false
 	}
-	defer f.Close()
-	content, err := io.ReadAll(f)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	id, ok := uid.(int)
+	if !ok {
+		return service.Actor{}, false
 	}
+	role, _ := c.Get("role")
+	r, _ := role.(string)
+	return service.Actor{UserID: id, Role: r}, true
+}
 
-	if err := h.svc.ImportBooksJSON(content); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// RequireRole middleware checks user role
+func (h *Handler) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, ok := c.Get("role")
+		if !ok || r.(string) != role {
+			abortProblem(c, http.StatusForbidden, "forbidden")
+			return
+		}
+		c.Next()
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "imported successfully"})
 }
 
-func (h *Handler) ImportBooksCSV(c *gin.Context) {
-	file, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
-		return
+// DBTimeout attaches a deadline to the request context. Every service call
+// receives that context, so queries still running when it expires are cancelled.
+// A zero or negative d disables the deadline.
+func DBTimeout(d time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if d <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
 	}
-	f, err := file.Open()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+}
+
+// TrustProxies makes the engine take the client address from X-Forwarded-For
+// only on requests from the given comma-separated addresses or CIDR ranges.
+// With none, the header is ignored and the peer address is the client.
+func TrustProxies(r *gin.Engine, list string) error {
+	var proxies []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
 	}
-	defer f.Close()
-	content, err := io.ReadAll(f)
+	return r.SetTrustedProxies(proxies)
+}
+
+func durationEnv(k string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(k))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return def
 	}
+	return d
+}
 
-	if err := h.svc.ImportBooksCSV(content); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+func envOrDefault(k, def string) string {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "imported successfully"})
+	return v
 }
@@ -2,56 +2,959 @@ package handler
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
-	"errors"
+	"encoding/xml"
+	"fmt"
+	"html"
 	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/example/books/internal/auth"
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/moderation"
+	"github.com/example/books/internal/repository"
 	"github.com/example/books/internal/service"
+	"github.com/example/books/internal/storage"
 	"github.com/example/books/pkg/models"
 	"github.com/gin-gonic/gin"
 )
 
 // in-memory repo implementing repository.Repository for handler tests
 type memRepo struct {
-	users map[string]*models.User
-	next  int
+	users   map[string]*models.User
+	authors map[int]*models.Author
+	books   map[int]*models.Book
+	shelves map[int]*models.Shelf
+	entries map[int][]int // shelf id -> book ids in shelf order
+	collabs map[[2]int]*models.ShelfCollaborator
+	reviews map[int]*models.Review
+	votes   map[[2]int]bool
+	reports map[[2]int]*models.ReviewReport
+	refresh map[int]*models.RefreshToken
+	revoked map[string]bool
+	cutoffs map[int]time.Time
+	reading map[[2]int]*models.ReadingStatus
+	history []models.ReadingEvent
+	next    int
+
+	// import jobs are written by the import goroutine while requests read them
+	jobsMu sync.Mutex
+	jobs   map[int]models.ImportJob
+
+	lastBookQuery     repository.BookQuery
+	lastTopRatedQuery repository.TopRatedQuery
 }
 
-func newMemRepo() *memRepo { return &memRepo{users: make(map[string]*models.User), next: 1} }
-func (r *memRepo) CreateUser(u *models.User) error {
+func newMemRepo() *memRepo {
+	return &memRepo{
+		users:   make(map[string]*models.User),
+		authors: make(map[int]*models.Author),
+		books:   make(map[int]*models.Book),
+		shelves: make(map[int]*models.Shelf),
+		entries: make(map[int][]int),
+		collabs: make(map[[2]int]*models.ShelfCollaborator),
+		reviews: make(map[int]*models.Review),
+		votes:   make(map[[2]int]bool),
+		reports: make(map[[2]int]*models.ReviewReport),
+		refresh: make(map[int]*models.RefreshToken),
+		revoked: make(map[string]bool),
+		cutoffs: make(map[int]time.Time),
+		reading: make(map[[2]int]*models.ReadingStatus),
+		jobs:    make(map[int]models.ImportJob),
+		next:    1,
+	}
+}
+func (r *memRepo) CreateUser(ctx context.Context, u *models.User) error {
 	u.ID = r.next
 	r.next++
 	r.users[u.Email] = u
 	return nil
 }
-func (r *memRepo) GetUserByEmail(email string) (*models.User, error) {
+func (r *memRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	if u, ok := r.users[email]; ok {
 		return u, nil
 	}
-	return nil, errors.New("not found")
+	return nil, domain.NotFound("not found")
+}
+func (r *memRepo) CreateAuthor(ctx context.Context, a *models.Author) error {
+	a.ID = r.next
+	r.next++
+	r.authors[a.ID] = a
+	return nil
+}
+func (r *memRepo) ListAuthors(ctx context.Context) ([]models.Author, error) {
+	out := []models.Author{}
+	for _, a := range r.authors {
+		out = append(out, *a)
+	}
+	return out, nil
+}
+func (r *memRepo) GetAuthor(ctx context.Context, id int) (*models.Author, error) {
+	if a, ok := r.authors[id]; ok {
+		return a, nil
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *memRepo) FindAuthorByName(ctx context.Context, name string) (*models.Author, error) {
+	var match *models.Author
+	for _, a := range r.authors {
+		if strings.EqualFold(a.Name, name) && (match == nil || a.ID < match.ID) {
+			match = a
+		}
+	}
+	if match == nil {
+		return nil, domain.NotFound("author not found")
+	}
+	return match, nil
+}
+func (r *memRepo) UpdateAuthor(ctx context.Context, a *models.Author) error {
+	if _, ok := r.authors[a.ID]; !ok {
+		return domain.NotFound("author not found")
+	}
+	r.authors[a.ID] = a
+	return nil
+}
+func (r *memRepo) DeleteAuthor(ctx context.Context, id int) error { delete(r.authors, id); return nil }
+func (r *memRepo) ListBooksByAuthor(ctx context.Context, authorID int) ([]models.Book, error) {
+	out := []models.Book{}
+	for _, b := range r.books {
+		if credits(b, authorID) {
+			out = append(out, *b)
+		}
+	}
+	return out, nil
+}
+
+// credits mimics book_contributors; books seeded without contributors credit their author_id
+func credits(b *models.Book, authorID int) bool {
+	if len(b.Contributors) == 0 {
+		return b.AuthorID == authorID
+	}
+	for _, c := range b.Contributors {
+		if c.AuthorID == authorID {
+			return true
+		}
+	}
+	return false
+}
+func (r *memRepo) ListBooks(ctx context.Context) ([]models.Book, error) { return []models.Book{}, nil }
+
+// isbnTaken mimics the unique index on books.isbn
+func (r *memRepo) isbnTaken(b *models.Book) bool {
+	for _, other := range r.books {
+		if b.ISBN != "" && other.ISBN == b.ISBN && other.ID != b.ID {
+			return true
+		}
+	}
+	return false
+}
+func (r *memRepo) CreateBook(ctx context.Context, b *models.Book) error {
+	if r.isbnTaken(b) {
+		return domain.Conflict("book already exists")
+	}
+	b.ID = r.next
+	r.next++
+	r.books[b.ID] = b
+	return nil
+}
+func (r *memRepo) GetBook(ctx context.Context, id int) (*models.Book, error) {
+	if b, ok := r.books[id]; ok {
+		if a, ok := r.authors[b.AuthorID]; ok {
+			b.AuthorName = a.Name
+		}
+		return b, nil
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *memRepo) UpdateBook(ctx context.Context, b *models.Book) error {
+	if r.isbnTaken(b) {
+		return domain.Conflict("book already exists")
+	}
+	if old, ok := r.books[b.ID]; ok {
+		b.CreatedBy = old.CreatedBy
+	}
+	r.books[b.ID] = b
+	return nil
+}
+func (r *memRepo) DeleteBook(ctx context.Context, id int) error { delete(r.books, id); return nil }
+func (r *memRepo) SetBookCover(ctx context.Context, bookID int, cover string) error {
+	b, ok := r.books[bookID]
+	if !ok {
+		return domain.NotFound("book not found")
+	}
+	b.Cover = models.Cover(cover)
+	return nil
+}
+func (r *memRepo) SaveBooks(ctx context.Context, books []*models.Book) (int, error) {
+	saved := maps.Clone(r.books)
+	for i, b := range books {
+		var err error
+		if b.ID == 0 {
+			err = r.CreateBook(ctx, b)
+		} else {
+			err = r.UpdateBook(ctx, b)
+		}
+		if err != nil {
+			r.books = saved
+			return i, err
+		}
+	}
+	return len(books), nil
+}
+func (r *memRepo) FindBookByKey(ctx context.Context, isbn, title string, authorID int) (*models.Book, error) {
+	var match *models.Book
+	for _, b := range r.books {
+		switch {
+		case isbn != "" && b.ISBN == isbn:
+			return b, nil
+		case title != "" && strings.EqualFold(b.Title, title) && b.AuthorID == authorID && (isbn == "" || b.ISBN == ""):
+			if match == nil || b.ID < match.ID {
+				match = b
+			}
+		}
+	}
+	if match == nil {
+		return nil, domain.NotFound("book not found")
+	}
+	return match, nil
+}
+func (r *memRepo) CreateImportJob(ctx context.Context, j *models.ImportJob) error {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	j.ID, j.CreatedAt = len(r.jobs)+1, time.Now()
+	r.jobs[j.ID] = *j
+	return nil
+}
+func (r *memRepo) GetImportJob(ctx context.Context, id int) (*models.ImportJob, error) {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return nil, domain.NotFound("import not found")
+	}
+	return &j, nil
+}
+func (r *memRepo) UpdateImportJob(ctx context.Context, j *models.ImportJob) error {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	saved := *j
+	saved.Errors = append(models.ImportErrors(nil), j.Errors...)
+	r.jobs[j.ID] = saved
+	return nil
+}
+func (r *memRepo) CancelImportJob(ctx context.Context, id int) (bool, error) {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok || j.Status != "running" {
+		return false, nil
+	}
+	now := time.Now()
+	j.Status, j.FinishedAt = "cancelled", &now
+	r.jobs[id] = j
+	return true, nil
+}
+func (r *memRepo) InterruptImportJobs(ctx context.Context, reason string) error { return nil }
+func hasLabel(labels []string, name string) bool {
+	for _, l := range labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+func countLabels(books map[int]*models.Book, labels func(*models.Book) []string) []models.LabelCount {
+	counts := map[string]int{}
+	for _, b := range books {
+		for _, l := range labels(b) {
+			counts[l]++
+		}
+	}
+	out := []models.LabelCount{}
+	for name, n := range counts {
+		out = append(out, models.LabelCount{Name: name, BookCount: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+func (r *memRepo) ListGenres(ctx context.Context) ([]models.LabelCount, error) {
+	return countLabels(r.books, func(b *models.Book) []string { return b.Genres }), nil
+}
+func (r *memRepo) ListTags(ctx context.Context) ([]models.LabelCount, error) {
+	return countLabels(r.books, func(b *models.Book) []string { return b.Tags }), nil
+}
+func (r *memRepo) CreateShelf(ctx context.Context, s *models.Shelf) error {
+	if s.Visibility == "" {
+		s.Visibility = repository.ShelfPrivate
+	}
+	s.ID = r.next
+	r.next++
+	r.shelves[s.ID] = s
+	return nil
+}
+
+// QueryBooks filters by author and pages by offset; ordering is by id to keep tests deterministic
+func (r *memRepo) QueryBooks(ctx context.Context, q repository.BookQuery) (*repository.BookPage, error) {
+	// behave like a database driver that observes cancellation
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.lastBookQuery = q
+	all := r.filterBooks(q)
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	if strings.HasPrefix(q.Sort, "-") {
+		sort.Slice(all, func(i, j int) bool { return all[i].ID > all[j].ID })
+	}
+	page := &repository.BookPage{Books: []models.Book{}}
+	for i := q.Offset; i < len(all) && len(page.Books) < q.Limit; i++ {
+		page.Books = append(page.Books, all[i])
+	}
+	if q.Offset+q.Limit < len(all) {
+		page.NextCursor = "next"
+	}
+	return page, nil
+}
+func (r *memRepo) StreamBooks(ctx context.Context, q repository.BookQuery, fn func(*models.Book) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	all := r.filterBooks(q)
+	sort.Slice(all, func(i, j int) bool { return (all[i].ID < all[j].ID) != strings.HasPrefix(q.Sort, "-") })
+	for i := range all {
+		if err := fn(&all[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (r *memRepo) CountBooks(ctx context.Context, q repository.BookQuery) (int, error) {
+	return len(r.filterBooks(q)), nil
+}
+func (r *memRepo) filterBooks(q repository.BookQuery) []models.Book {
+	out := []models.Book{}
+	for _, b := range r.books {
+		if q.AuthorID != 0 && !credits(b, q.AuthorID) {
+			continue
+		}
+		if (q.Genre != "" && !hasLabel(b.Genres, q.Genre)) || (q.Tag != "" && !hasLabel(b.Tags, q.Tag)) {
+			continue
+		}
+		if (q.Language != "" && b.Language != q.Language) || (q.ISBN != "" && b.ISBN != q.ISBN) || (q.Year != 0 && b.PublishedYear != q.Year) {
+			continue
+		}
+		out = append(out, *b)
+	}
+	return out
+}
+
+// SearchBooks is a simple stand-in for Postgres full-text search: every term must
+// prefix-match a word of the title, contributor names or description; title matches rank highest.
+func (r *memRepo) SearchBooks(ctx context.Context, q repository.SearchQuery) (*repository.SearchPage, error) {
+	terms := repository.SearchTerms(q.Text)
+	hits := []models.BookSearchHit{}
+	for _, b := range r.books {
+		book := *b
+		if a, ok := r.authors[b.AuthorID]; ok {
+			book.AuthorName = a.Name
+		}
+		names := book.AuthorName
+		for _, c := range book.Contributors {
+			names += " " + c.Name
+		}
+		fields := []struct {
+			text   string
+			weight float64
+		}{{book.Title, 1}, {names, 0.4}, {book.Description, 0.2}}
+		rank := 0.0
+		for _, t := range terms {
+			found := false
+			for _, f := range fields {
+				for _, w := range repository.SearchTerms(f.text) {
+					if strings.HasPrefix(w, t) {
+						rank += f.weight
+						found = true
+					}
+				}
+			}
+			if !found {
+				rank = 0
+				break
+			}
+		}
+		if len(terms) == 0 || rank == 0 {
+			continue
+		}
+		hits = append(hits, models.BookSearchHit{Book: book, Rank: rank, Snippet: memHighlight(book.Description, terms), HighlightedTitle: memHighlight(book.Title, terms)})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	page := &repository.SearchPage{Hits: []models.BookSearchHit{}}
+	for i := q.Offset; i < len(hits) && len(page.Hits) < q.Limit; i++ {
+		page.Hits = append(page.Hits, hits[i])
+	}
+	page.HasMore = q.Offset+q.Limit < len(hits)
+	return page, nil
+}
+
+// memHighlight escapes text and marks words starting with any of the terms
+func memHighlight(text string, terms []string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		esc := html.EscapeString(w)
+		for _, t := range terms {
+			if strings.HasPrefix(strings.ToLower(w), t) {
+				esc = "<mark>" + esc + "</mark>"
+				break
+			}
+		}
+		words[i] = esc
+	}
+	return strings.Join(words, " ")
+}
+
+func (r *memRepo) shelfMatches(s *models.Shelf, q repository.ShelfQuery) bool {
+	if q.UserID != 0 && s.UserID != q.UserID {
+		return false
+	}
+	if q.MemberID != 0 && s.UserID != q.MemberID && r.collabs[[2]int{s.ID, q.MemberID}] == nil {
+		return false
+	}
+	return q.Visibility == "" || s.Visibility == q.Visibility
+}
+func (r *memRepo) QueryShelves(ctx context.Context, q repository.ShelfQuery) ([]models.Shelf, error) {
+	out := []models.Shelf{}
+	for _, s := range r.shelves {
+		if r.shelfMatches(s, q) {
+			out = append(out, *s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	if q.Offset >= len(out) {
+		return []models.Shelf{}, nil
+	}
+	out = out[q.Offset:]
+	if len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+func (r *memRepo) CountShelves(ctx context.Context, q repository.ShelfQuery) (int, error) {
+	n := 0
+	for _, s := range r.shelves {
+		if r.shelfMatches(s, q) {
+			n++
+		}
+	}
+	return n, nil
+}
+func (r *memRepo) CreateReview(ctx context.Context, rw *models.Review) error {
+	b, ok := r.books[rw.BookID]
+	if !ok {
+		return domain.Validation("review references a record that does not exist")
+	}
+	for _, other := range r.reviews {
+		if other.UserID == rw.UserID && other.BookID == rw.BookID {
+			return domain.Conflict("review already exists")
+		}
+	}
+	rw.ID = r.next
+	r.next++
+	if rw.Status == "" {
+		rw.Status = repository.ReviewApproved
+	}
+	r.reviews[rw.ID] = rw
+	r.moveRating(b, "", 0, rw.Status, rw.Rating)
+	return nil
+}
+
+// moveRating counts only approved reviews, like the Postgres repository
+func (r *memRepo) moveRating(b *models.Book, oldStatus string, oldRating int, newStatus string, newRating int) {
+	if oldStatus == repository.ReviewApproved {
+		r.adjustRating(b, oldRating, -1)
+	}
+	if newStatus == repository.ReviewApproved {
+		r.adjustRating(b, newRating, 1)
+	}
+}
+
+// adjustRating mirrors the aggregate bookkeeping of the Postgres repository
+func (r *memRepo) adjustRating(b *models.Book, rating, delta int) {
+	if len(b.RatingHistogram) != 5 {
+		b.RatingHistogram = make([]int, 5)
+	}
+	b.RatingHistogram[rating-1] += delta
+	sum := 0
+	b.RatingCount = 0
+	for i, n := range b.RatingHistogram {
+		sum += (i + 1) * n
+		b.RatingCount += n
+	}
+	b.RatingAvg = 0
+	if b.RatingCount > 0 {
+		b.RatingAvg = float64(sum) / float64(b.RatingCount)
+	}
+}
+func (r *memRepo) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	rw, ok := r.reviews[id]
+	if !ok {
+		return nil, domain.NotFound("review not found")
+	}
+	cp := *rw
+	for _, u := range r.users {
+		if u.ID == cp.UserID {
+			cp.UserName = u.Name
+		}
+	}
+	if b, ok := r.books[cp.BookID]; ok {
+		cp.BookTitle = b.Title
+	}
+	return &cp, nil
+}
+func (r *memRepo) UpdateReview(ctx context.Context, rw *models.Review) error {
+	old, ok := r.reviews[rw.ID]
+	if !ok {
+		return domain.NotFound("review not found")
+	}
+	if rw.Status == "" {
+		rw.Status = old.Status
+	}
+	r.moveRating(r.books[old.BookID], old.Status, old.Rating, rw.Status, rw.Rating)
+	if rw.Text != old.Text {
+		old.ModeratedBy, old.ModeratedAt, old.ModerationNote = nil, nil, ""
+	}
+	rw.ModeratedBy, rw.ModeratedAt, rw.ModerationNote = old.ModeratedBy, old.ModeratedAt, old.ModerationNote
+	now := time.Now()
+	old.Text, old.Rating, old.Status, old.UpdatedAt = rw.Text, rw.Rating, rw.Status, &now
+	rw.UpdatedAt = &now
+	return nil
+}
+func (r *memRepo) DeleteReview(ctx context.Context, id int) error {
+	rw, ok := r.reviews[id]
+	if !ok {
+		return domain.NotFound("review not found")
+	}
+	r.moveRating(r.books[rw.BookID], rw.Status, rw.Rating, "", 0)
+	delete(r.reviews, id)
+	return nil
+}
+func (r *memRepo) ListReviewsByBook(ctx context.Context, bookID int, order string) ([]models.Review, error) {
+	out := []models.Review{}
+	for id, rw := range r.reviews {
+		if rw.BookID == bookID && rw.Status == repository.ReviewApproved {
+			cp, _ := r.GetReview(ctx, id)
+			out = append(out, *cp)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if order == repository.ReviewSortHelpful && out[i].HelpfulCount != out[j].HelpfulCount {
+			return out[i].HelpfulCount > out[j].HelpfulCount
+		}
+		return out[i].ID > out[j].ID
+	})
+	return out, nil
+}
+func (r *memRepo) SetReviewStatus(ctx context.Context, id int, status string, moderatorID *int, note string) error {
+	rw, ok := r.reviews[id]
+	if !ok {
+		return domain.NotFound("review not found")
+	}
+	r.moveRating(r.books[rw.BookID], rw.Status, rw.Rating, status, rw.Rating)
+	now := time.Now()
+	rw.Status, rw.ModeratedBy, rw.ModerationNote, rw.ModeratedAt = status, moderatorID, note, &now
+	return nil
+}
+func (r *memRepo) QueryReviews(ctx context.Context, q repository.ReviewQuery) ([]models.Review, error) {
+	q.Normalize()
+	out := []models.Review{}
+	for id, rw := range r.reviews {
+		if rw.Status == q.Status {
+			cp, _ := r.GetReview(ctx, id)
+			out = append(out, *cp)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ReportCount != out[j].ReportCount {
+			return out[i].ReportCount > out[j].ReportCount
+		}
+		return out[i].ID < out[j].ID
+	})
+	if q.Offset > len(out) {
+		q.Offset = len(out)
+	}
+	out = out[q.Offset:]
+	if len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+func (r *memRepo) CountReviews(ctx context.Context, q repository.ReviewQuery) (int, error) {
+	q.Normalize()
+	n := 0
+	for _, rw := range r.reviews {
+		if rw.Status == q.Status {
+			n++
+		}
+	}
+	return n, nil
+}
+func (r *memRepo) ReportReview(ctx context.Context, rep *models.ReviewReport) (int, error) {
+	key := [2]int{rep.ReviewID, rep.UserID}
+	if _, ok := r.reports[key]; !ok {
+		rep.CreatedAt = time.Now()
+		r.reports[key] = rep
+		r.reviews[rep.ReviewID].ReportCount++
+	}
+	return r.reviews[rep.ReviewID].ReportCount, nil
+}
+func (r *memRepo) ListReviewReports(ctx context.Context, reviewID int) ([]models.ReviewReport, error) {
+	out := []models.ReviewReport{}
+	for key, rep := range r.reports {
+		if key[0] == reviewID {
+			out = append(out, *rep)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserID < out[j].UserID })
+	return out, nil
+}
+func (r *memRepo) AddReviewVote(ctx context.Context, reviewID, userID int) error {
+	if !r.votes[[2]int{reviewID, userID}] {
+		r.votes[[2]int{reviewID, userID}] = true
+		r.reviews[reviewID].HelpfulCount++
+	}
+	return nil
+}
+func (r *memRepo) RemoveReviewVote(ctx context.Context, reviewID, userID int) error {
+	if r.votes[[2]int{reviewID, userID}] {
+		delete(r.votes, [2]int{reviewID, userID})
+		r.reviews[reviewID].HelpfulCount--
+	}
+	return nil
+}
+func (r *memRepo) TopRatedBooks(ctx context.Context, q repository.TopRatedQuery) ([]models.RatedBook, error) {
+	r.lastTopRatedQuery = q
+	out := []models.RatedBook{}
+	for _, b := range r.books {
+		if b.RatingCount >= q.MinCount {
+			out = append(out, models.RatedBook{Book: *b, Score: b.RatingAvg})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out, nil
+}
+
+func (r *memRepo) GetShelf(ctx context.Context, id int) (*models.Shelf, error) {
+	if s, ok := r.shelves[id]; ok {
+		cp := *s
+		return &cp, nil
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *memRepo) ListBooksByShelf(ctx context.Context, shelfID int) ([]models.Book, error) {
+	out := []models.Book{}
+	for _, id := range r.entries[shelfID] {
+		if b, ok := r.books[id]; ok {
+			out = append(out, *b)
+		}
+	}
+	return out, nil
+}
+func (r *memRepo) AddBookToShelf(ctx context.Context, shelfID int, bookID int) error {
+	for _, id := range r.entries[shelfID] {
+		if id == bookID {
+			return nil
+		}
+	}
+	r.entries[shelfID] = append(r.entries[shelfID], bookID)
+	return nil
+}
+func (r *memRepo) UpdateShelf(ctx context.Context, s *models.Shelf) error {
+	if _, ok := r.shelves[s.ID]; !ok {
+		return domain.NotFound("shelf not found")
+	}
+	r.shelves[s.ID] = s
+	return nil
+}
+func (r *memRepo) DeleteShelf(ctx context.Context, id int) error {
+	if _, ok := r.shelves[id]; !ok {
+		return domain.NotFound("shelf not found")
+	}
+	delete(r.shelves, id)
+	delete(r.entries, id)
+	return nil
+}
+func (r *memRepo) RemoveBookFromShelf(ctx context.Context, shelfID, bookID int) error {
+	ids := r.entries[shelfID]
+	for i, id := range ids {
+		if id == bookID {
+			r.entries[shelfID] = append(ids[:i:i], ids[i+1:]...)
+			return nil
+		}
+	}
+	return domain.NotFound("shelf entry not found")
+}
+func (r *memRepo) ReorderShelf(ctx context.Context, shelfID int, bookIDs []int) error {
+	r.entries[shelfID] = append([]int(nil), bookIDs...)
+	return nil
+}
+func (r *memRepo) MoveBook(ctx context.Context, fromShelfID, toShelfID, bookID int) error {
+	if err := r.RemoveBookFromShelf(ctx, fromShelfID, bookID); err != nil {
+		return err
+	}
+	return r.AddBookToShelf(ctx, toShelfID, bookID)
+}
+func (r *memRepo) GetShelfByShareToken(ctx context.Context, token string) (*models.Shelf, error) {
+	for _, s := range r.shelves {
+		if s.ShareToken != nil && *s.ShareToken == token {
+			cp := *s
+			return &cp, nil
+		}
+	}
+	return nil, domain.NotFound("shelf not found")
+}
+func (r *memRepo) ListShelfCollaborators(ctx context.Context, shelfID int) ([]models.ShelfCollaborator, error) {
+	out := []models.ShelfCollaborator{}
+	for k, c := range r.collabs {
+		if k[0] == shelfID {
+			out = append(out, *c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserID < out[j].UserID })
+	return out, nil
+}
+func (r *memRepo) GetShelfCollaborator(ctx context.Context, shelfID, userID int) (*models.ShelfCollaborator, error) {
+	if c, ok := r.collabs[[2]int{shelfID, userID}]; ok {
+		return c, nil
+	}
+	return nil, domain.NotFound("collaborator not found")
+}
+func (r *memRepo) SaveShelfCollaborator(ctx context.Context, c *models.ShelfCollaborator) error {
+	c.AddedAt = time.Now()
+	r.collabs[[2]int{c.ShelfID, c.UserID}] = c
+	return nil
+}
+func (r *memRepo) RemoveShelfCollaborator(ctx context.Context, shelfID, userID int) error {
+	if _, ok := r.collabs[[2]int{shelfID, userID}]; !ok {
+		return domain.NotFound("collaborator not found")
+	}
+	delete(r.collabs, [2]int{shelfID, userID})
+	return nil
+}
+func (r *memRepo) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	for _, u := range r.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *memRepo) UpdateUserRole(ctx context.Context, userID int, role string) error { return nil }
+
+func (r *memRepo) GetReadingStatus(ctx context.Context, userID, bookID int) (*models.ReadingStatus, error) {
+	if rs, ok := r.reading[[2]int{userID, bookID}]; ok {
+		cp := *rs
+		return &cp, nil
+	}
+	return nil, domain.NotFound("reading status not found")
+}
+func (r *memRepo) ListReadingStatuses(ctx context.Context, userID int, status string) ([]models.ReadingStatus, error) {
+	out := []models.ReadingStatus{}
+	for k, rs := range r.reading {
+		if k[0] == userID && (status == "" || rs.Status == status) {
+			out = append(out, *rs)
+		}
+	}
+	return out, nil
+}
+func (r *memRepo) SaveReadingStatus(ctx context.Context, rs *models.ReadingStatus, statusChanged bool) error {
+	rs.UpdatedAt = time.Now()
+	cp := *rs
+	r.reading[[2]int{rs.UserID, rs.BookID}] = &cp
+	if statusChanged {
+		r.history = append(r.history, models.ReadingEvent{ID: len(r.history) + 1, UserID: rs.UserID, BookID: rs.BookID, Status: rs.Status, ChangedAt: rs.UpdatedAt})
+	}
+	return nil
+}
+func (r *memRepo) DeleteReadingStatus(ctx context.Context, userID, bookID int) error {
+	if _, ok := r.reading[[2]int{userID, bookID}]; !ok {
+		return domain.NotFound("reading status not found")
+	}
+	delete(r.reading, [2]int{userID, bookID})
+	return nil
+}
+func (r *memRepo) ListReadingHistory(ctx context.Context, userID, bookID int) ([]models.ReadingEvent, error) {
+	out := []models.ReadingEvent{}
+	for _, e := range r.history {
+		if e.UserID == userID && e.BookID == bookID {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+func (r *memRepo) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	t.ID = r.next
+	r.next++
+	t.CreatedAt = time.Now()
+	r.refresh[t.ID] = t
+	return nil
 }
-func (r *memRepo) CreateAuthor(a *models.Author) error  { a.ID = r.next; r.next++; return nil }
-func (r *memRepo) ListBooks() ([]models.Book, error)    { return []models.Book{}, nil }
-func (r *memRepo) CreateBook(b *models.Book) error      { b.ID = r.next; r.next++; return nil }
-func (r *memRepo) GetBook(id int) (*models.Book, error) { return nil, errors.New("not found") }
-func (r *memRepo) UpdateBook(b *models.Book) error      { return nil }
-func (r *memRepo) DeleteBook(id int) error              { return nil }
-func (r *memRepo) CreateShelf(s *models.Shelf) error    { s.ID = r.next; r.next++; return nil }
-func (r *memRepo) ListShelves() ([]models.Shelf, error) { return []models.Shelf{}, nil }
-func (r *memRepo) CreateReview(rw *models.Review) error { rw.ID = r.next; r.next++; return nil }
-func (r *memRepo) ListReviewsByBook(bookID int) ([]models.Review, error) {
-	return []models.Review{}, nil
+func (r *memRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	for _, t := range r.refresh {
+		if t.TokenHash == hash {
+			return t, nil
+		}
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *memRepo) RevokeRefreshToken(ctx context.Context, id int) (bool, error) {
+	t, ok := r.refresh[id]
+	if !ok || t.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	t.RevokedAt = &now
+	return true, nil
+}
+func (r *memRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	for _, t := range r.refresh {
+		if t.UserID == userID {
+			_, _ = r.RevokeRefreshToken(ctx, t.ID)
+		}
+	}
+	return nil
+}
+func (r *memRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	r.revoked[jti] = true
+	return nil
+}
+func (r *memRepo) SetUserTokenCutoff(ctx context.Context, userID int, notBefore time.Time) error {
+	r.cutoffs[userID] = notBefore
+	return nil
+}
+func (r *memRepo) IsAccessTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	if r.revoked[jti] {
+		return true, nil
+	}
+	cut, ok := r.cutoffs[userID]
+	return ok && cut.After(issuedAt), nil
+}
+
+func (r *memRepo) Backup(ctx context.Context, fn func(table string, row interface{}) error) error {
+	users := make([]*models.User, 0, len(r.users))
+	for _, u := range r.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	for _, u := range users {
+		if err := fn(repository.BackupUsers, u); err != nil {
+			return err
+		}
+	}
+	for _, a := range r.authors {
+		if err := fn(repository.BackupAuthors, a); err != nil {
+			return err
+		}
+	}
+	for _, b := range r.books {
+		if err := fn(repository.BackupBooks, b); err != nil {
+			return err
+		}
+	}
+	for _, s := range r.shelves {
+		if err := fn(repository.BackupShelves, s); err != nil {
+			return err
+		}
+	}
+	for shelfID, ids := range r.entries {
+		for i, id := range ids {
+			if err := fn(repository.BackupShelfBooks, &models.ShelfBook{ShelfID: shelfID, BookID: id, Position: i + 1}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rw := range r.reviews {
+		if err := fn(repository.BackupReviews, rw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (r *memRepo) Restore(ctx context.Context, fn func(repository.Restorer) error) error {
+	if len(r.authors) > 0 || len(r.books) > 0 || len(r.shelves) > 0 || len(r.reviews) > 0 {
+		return domain.Conflict("a backup can only be restored into an empty catalog")
+	}
+	return fn(memRestorer{r})
+}
+
+type memRestorer struct{ *memRepo }
+
+func (r memRestorer) InsertUser(ctx context.Context, u *models.User) error {
+	return r.CreateUser(ctx, u)
+}
+func (r memRestorer) InsertAuthor(ctx context.Context, a *models.Author) error {
+	return r.CreateAuthor(ctx, a)
+}
+func (r memRestorer) InsertBook(ctx context.Context, b *models.Book) error {
+	return r.CreateBook(ctx, b)
+}
+func (r memRestorer) InsertShelf(ctx context.Context, s *models.Shelf) error {
+	return r.CreateShelf(ctx, s)
+}
+func (r memRestorer) InsertShelfBook(ctx context.Context, sb *models.ShelfBook) error {
+	return r.AddBookToShelf(ctx, sb.ShelfID, sb.BookID)
+}
+func (r memRestorer) InsertReview(ctx context.Context, rv *models.Review) error {
+	return r.CreateReview(ctx, rv)
 }
 
-func (r *memRepo) GetShelf(id int) (*models.Shelf, error) { return nil, nil }
-func (r *memRepo) ListBooksByShelf(shelfID int) ([]models.Book, error) { return []models.Book{}, nil }
-func (r *memRepo) AddBookToShelf(shelfID int, bookID int) error { return nil }
-func (r *memRepo) GetUserByID(id int) (*models.User, error) { return nil, nil }
-func (r *memRepo) UpdateUserRole(userID int, role string) error { return nil }
+// bearer issues a token for the given user to use in test requests
+func bearer(t *testing.T, userID int, role string) string {
+	t.Helper()
+	tok, err := auth.GenerateToken(userID, role, time.Hour)
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	return "Bearer " + tok
+}
+
+// doJSON performs a request against the router with an optional auth header
+func doJSON(router *gin.Engine, method, path, authz string, body interface{}) *httptest.ResponseRecorder {
+	var rd *bytes.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	} else {
+		rd = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, rd)
+	req.Header.Set("Content-Type", "application/json")
+	if authz != "" {
+		req.Header.Set("Authorization", authz)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
 
 func TestRegisterLoginProtected(t *testing.T) {
 	r := newMemRepo()
@@ -146,3 +1049,1808 @@ func TestLoginRegisterPages(t *testing.T) {
 		t.Fatalf("register page content missing")
 	}
 }
+
+func ownershipRouter(h *Handler) *gin.Engine {
+	router := gin.New()
+	router.PUT("/api/books/:id", h.AuthMiddleware(), h.UpdateBook)
+	router.DELETE("/api/books/:id", h.AuthMiddleware(), h.DeleteBook)
+	router.POST("/api/shelves/:id/books", h.AuthMiddleware(), h.AddBookToShelf)
+	return router
+}
+
+func TestBookOwnership(t *testing.T) {
+	r := newMemRepo()
+	svc := service.NewService(r)
+	h := NewHandler(svc)
+	router := ownershipRouter(h)
+
+	owner := 10
+	author := &models.Author{Name: "A"}
+	if err := r.CreateAuthor(context.Background(), author); err != nil {
+		t.Fatalf("seed author: %v", err)
+	}
+	book := &models.Book{Title: "Mine", AuthorID: author.ID, CreatedBy: &owner}
+	if err := r.CreateBook(context.Background(), book); err != nil {
+		t.Fatalf("seed book: %v", err)
+	}
+	path := fmt.Sprintf("/api/books/%d", book.ID)
+	upd := map[string]interface{}{"title": "Changed", "author_id": author.ID}
+
+	if w := doJSON(router, "PUT", path, bearer(t, 11, "user"), upd); w.Code != http.StatusForbidden {
+		t.Fatalf("stranger update: expected 403, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "DELETE", path, bearer(t, 11, "user"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("stranger delete: expected 403, got %d", w.Code)
+	}
+	if r.books[book.ID].Title != "Mine" {
+		t.Fatalf("book modified by stranger")
+	}
+	if w := doJSON(router, "PUT", path, bearer(t, owner, "user"), upd); w.Code != http.StatusOK {
+		t.Fatalf("owner update: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "PUT", path, bearer(t, 99, "admin"), upd); w.Code != http.StatusOK {
+		t.Fatalf("admin update: expected 200, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "DELETE", "/api/books/12345", bearer(t, owner, "user"), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("missing book: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", path, bearer(t, owner, "user"), nil); w.Code != http.StatusNoContent {
+		t.Fatalf("owner delete: expected 204, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBookWithoutCreatorIsAdminOnly(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := ownershipRouter(h)
+
+	book := &models.Book{Title: "Seeded"}
+	if err := r.CreateBook(context.Background(), book); err != nil {
+		t.Fatalf("seed book: %v", err)
+	}
+	path := fmt.Sprintf("/api/books/%d", book.ID)
+	if w := doJSON(router, "DELETE", path, bearer(t, 1, "user"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("user delete: expected 403, got %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", path, bearer(t, 2, "admin"), nil); w.Code != http.StatusNoContent {
+		t.Fatalf("admin delete: expected 204, got %d", w.Code)
+	}
+}
+
+func TestAddBookToForeignShelf(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := ownershipRouter(h)
+
+	shelf := &models.Shelf{UserID: 5, Name: "Owned"}
+	if err := r.CreateShelf(context.Background(), shelf); err != nil {
+		t.Fatalf("seed shelf: %v", err)
+	}
+	path := fmt.Sprintf("/api/shelves/%d/books", shelf.ID)
+	body := map[string]int{"book_id": 1}
+
+	// a private shelf is missing to strangers; a public one is visible but not theirs
+	if w := doJSON(router, "POST", path, bearer(t, 6, "user"), body); w.Code != http.StatusNotFound {
+		t.Fatalf("stranger add: expected 404, got %d %s", w.Code, w.Body.String())
+	}
+	public := &models.Shelf{UserID: 5, Name: "Shown", Visibility: repository.ShelfPublic}
+	if err := r.CreateShelf(context.Background(), public); err != nil {
+		t.Fatalf("seed shelf: %v", err)
+	}
+	if w := doJSON(router, "POST", fmt.Sprintf("/api/shelves/%d/books", public.ID), bearer(t, 6, "user"), body); w.Code != http.StatusForbidden {
+		t.Fatalf("stranger add to public shelf: expected 403, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "POST", path, bearer(t, 5, "user"), body); w.Code != http.StatusCreated {
+		t.Fatalf("owner add: expected 201, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "POST", path, bearer(t, 7, "admin"), body); w.Code != http.StatusCreated {
+		t.Fatalf("admin add: expected 201, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	r := newMemRepo()
+	svc := service.NewService(r)
+	h := NewHandler(svc)
+	router := gin.New()
+	router.POST("/api/token/refresh", h.RefreshToken)
+	router.POST("/api/logout", h.AuthMiddleware(), h.Logout)
+	router.POST("/api/logout/all", h.AuthMiddleware(), h.LogoutAll)
+	router.GET("/api/me", h.AuthMiddleware(), h.Me)
+
+	u, err := svc.RegisterUser(context.Background(), "out@test.com", "secret", "Out")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	pair, err := svc.IssueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	authz := "Bearer " + pair.AccessToken
+	if w := doJSON(router, "GET", "/api/me", authz, nil); w.Code != http.StatusOK {
+		t.Fatalf("me before logout: %d %s", w.Code, w.Body.String())
+	}
+	w := doJSON(router, "POST", "/api/logout", authz, map[string]string{"refresh_token": pair.RefreshToken})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", "/api/me", authz, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked access token accepted: %d", w.Code)
+	}
+	if w := doJSON(router, "POST", "/api/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked refresh token accepted: %d", w.Code)
+	}
+
+	// log out all sessions: a second session issued earlier stops working
+	old, err := svc.IssueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	r.cutoffs[u.ID] = time.Now().Add(time.Hour) // pretend the old session predates the cutoff
+	if w := doJSON(router, "GET", "/api/me", "Bearer "+old.AccessToken, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("token issued before cutoff accepted: %d", w.Code)
+	}
+	delete(r.cutoffs, u.ID)
+	if w := doJSON(router, "POST", "/api/logout/all", "Bearer "+old.AccessToken, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("logout all: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "POST", "/api/token/refresh", "", map[string]string{"refresh_token": old.RefreshToken}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh after logout all accepted: %d", w.Code)
+	}
+}
+
+func TestRefreshEndpointRotates(t *testing.T) {
+	r := newMemRepo()
+	svc := service.NewService(r)
+	h := NewHandler(svc)
+	router := gin.New()
+	router.POST("/api/token/refresh", h.RefreshToken)
+
+	u, err := svc.RegisterUser(context.Background(), "rot@test.com", "secret", "Rot")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	pair, err := svc.IssueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	w := doJSON(router, "POST", "/api/token/refresh", "", map[string]string{"refresh_token": pair.RefreshToken})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: %d %s", w.Code, w.Body.String())
+	}
+	var res map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal refresh response: %v", err)
+	}
+	if res["access_token"] == "" || res["refresh_token"] == "" || res["refresh_token"] == pair.RefreshToken {
+		t.Fatalf("unexpected refresh response: %v", res)
+	}
+}
+
+func TestAuthorsAPI(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.GET("/api/authors", h.ListAuthors)
+	router.POST("/api/authors", h.AuthMiddleware(), h.RequireRole("admin"), h.CreateAuthor)
+	router.GET("/api/authors/:id", h.GetAuthor)
+	router.PUT("/api/authors/:id", h.AuthMiddleware(), h.RequireRole("admin"), h.UpdateAuthor)
+	router.DELETE("/api/authors/:id", h.AuthMiddleware(), h.RequireRole("admin"), h.DeleteAuthor)
+	router.GET("/api/authors/:id/books", h.ListAuthorBooks)
+	router.POST("/api/books", h.AuthMiddleware(), h.RequireRole("admin"), h.CreateBook)
+	router.GET("/api/books/:id", h.GetBook)
+	admin := bearer(t, 1, "admin")
+
+	if w := doJSON(router, "POST", "/api/authors", bearer(t, 2, "user"), map[string]string{"name": "X"}); w.Code != http.StatusForbidden {
+		t.Fatalf("user create author: expected 403, got %d", w.Code)
+	}
+	w := doJSON(router, "POST", "/api/authors", admin, map[string]string{"name": "Leo Tolstoy"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create author: %d %s", w.Code, w.Body.String())
+	}
+	var author models.Author
+	if err := json.Unmarshal(w.Body.Bytes(), &author); err != nil {
+		t.Fatalf("unmarshal author: %v", err)
+	}
+	authorPath := fmt.Sprintf("/api/authors/%d", author.ID)
+
+	// books must point at an existing author
+	if w := doJSON(router, "POST", "/api/books", admin, map[string]interface{}{"title": "Orphan", "author_id": 999}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("book with unknown author: expected 422, got %d %s", w.Code, w.Body.String())
+	}
+	w = doJSON(router, "POST", "/api/books", admin, map[string]interface{}{"title": "War and Peace", "author_id": author.ID})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create book: %d %s", w.Code, w.Body.String())
+	}
+	var book models.Book
+	if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil {
+		t.Fatalf("unmarshal book: %v", err)
+	}
+	if book.AuthorName != "Leo Tolstoy" {
+		t.Fatalf("author name not embedded in create response: %+v", book)
+	}
+	w = doJSON(router, "GET", fmt.Sprintf("/api/books/%d", book.ID), "", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"author_name":"Leo Tolstoy"`) {
+		t.Fatalf("author name not embedded in get response: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(router, "GET", authorPath+"/books", "", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "War and Peace") {
+		t.Fatalf("author books: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", "/api/authors/999/books", "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("books of unknown author: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(router, "PUT", authorPath, admin, map[string]string{"name": "Lev Tolstoy"}); w.Code != http.StatusOK {
+		t.Fatalf("update author: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", authorPath, "", nil); !strings.Contains(w.Body.String(), "Lev Tolstoy") {
+		t.Fatalf("author not renamed: %s", w.Body.String())
+	}
+	if w := doJSON(router, "DELETE", authorPath, admin, nil); w.Code != http.StatusConflict {
+		t.Fatalf("delete author with books: expected 409, got %d", w.Code)
+	}
+	delete(r.books, book.ID)
+	if w := doJSON(router, "DELETE", authorPath, admin, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete author: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", authorPath, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("deleted author still served: %d", w.Code)
+	}
+}
+
+func TestListBooksPaging(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.GET("/api/books", h.ListBooks)
+	for i := 0; i < 5; i++ {
+		_ = r.CreateBook(context.Background(), &models.Book{Title: fmt.Sprintf("B%d", i), AuthorID: 1 + i%2})
+	}
+
+	w := doJSON(router, "GET", "/api/books?limit=2&sort=-id&author_id=1&created_after=2020-01-01", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body.String())
+	}
+	var res struct {
+		Items      []models.Book `json:"items"`
+		NextCursor string        `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(res.Items) != 2 || res.NextCursor == "" {
+		t.Fatalf("expected 2 items and a next cursor, got %d %q", len(res.Items), res.NextCursor)
+	}
+	q := r.lastBookQuery
+	if q.Limit != 2 || q.Sort != "-id" || q.AuthorID != 1 || q.CreatedAfter == nil {
+		t.Fatalf("query not passed through: %+v", q)
+	}
+
+	for _, bad := range []string{"?sort=password", "?limit=x", "?created_before=yesterday"} {
+		if w := doJSON(router, "GET", "/api/books"+bad, "", nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", bad, w.Code)
+		}
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.ParseFiles("../../web/templates/index.html")))
+	router.GET("/", h.Index)
+	router.GET("/api/books/search", h.SearchBooks)
+
+	tolstoy := &models.Author{Name: "Leo Tolstoy"}
+	_ = r.CreateAuthor(context.Background(), tolstoy)
+	other := &models.Author{Name: "Someone Else"}
+	_ = r.CreateAuthor(context.Background(), other)
+	war := &models.Book{Title: "War and Peace", Description: "Napoleon invades <Russia>", AuthorID: tolstoy.ID}
+	_ = r.CreateBook(context.Background(), war)
+	_ = r.CreateBook(context.Background(), &models.Book{Title: "Essays", Description: "On war and politics", AuthorID: other.ID})
+	_ = r.CreateBook(context.Background(), &models.Book{Title: "Cooking", Description: "Recipes", AuthorID: other.ID})
+
+	w := doJSON(router, "GET", "/api/books/search?q=war", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("search: %d %s", w.Code, w.Body.String())
+	}
+	var res struct {
+		Items []models.BookSearchHit `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(res.Items) != 2 || res.Items[0].ID != war.ID {
+		t.Fatalf("expected title match ranked first, got %+v", res.Items)
+	}
+	if res.Items[0].HighlightedTitle != "<mark>War</mark> and Peace" {
+		t.Fatalf("unexpected highlight: %q", res.Items[0].HighlightedTitle)
+	}
+
+	// author name is searchable and snippets are escaped
+	w = doJSON(router, "GET", "/api/books/search?q=tolst+nap", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(res.Items) != 1 || !strings.Contains(res.Items[0].Snippet, "&lt;Russia&gt;") {
+		t.Fatalf("unexpected author search result: %+v", res.Items)
+	}
+
+	if w := doJSON(router, "GET", "/api/books/search?q=+", "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("blank query: expected 400, got %d", w.Code)
+	}
+
+	w = doJSON(router, "GET", "/?q=peace", "", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<mark>Peace</mark>") || strings.Contains(w.Body.String(), "Cooking") {
+		t.Fatalf("search page: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDBTimeoutCancelsQueries(t *testing.T) {
+	svc := service.NewService(newMemRepo())
+	h := NewHandler(svc)
+	router := gin.New()
+	router.Use(DBTimeout(time.Nanosecond))
+	router.GET("/api/books", h.ListBooks)
+
+	w := doJSON(router, "GET", "/api/books", "", nil)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504 after the deadline, got %d %s", w.Code, w.Body.String())
+	}
+
+	router = gin.New()
+	router.Use(DBTimeout(time.Minute))
+	router.GET("/api/books", h.ListBooks)
+	if w := doJSON(router, "GET", "/api/books", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 within the deadline, got %d", w.Code)
+	}
+}
+
+// brokenRepo fails book listings the way a misconfigured database would
+type brokenRepo struct{ *memRepo }
+
+func (r brokenRepo) QueryBooks(ctx context.Context, q repository.BookQuery) (*repository.BookPage, error) {
+	return nil, fmt.Errorf(`pq: relation "books" does not exist`)
+}
+
+func TestErrorsRenderAsProblems(t *testing.T) {
+	svc := service.NewService(newMemRepo())
+	h := NewHandler(svc)
+	router := gin.New()
+	router.POST("/api/register", h.Register)
+	router.GET("/api/books/:id", h.GetBook)
+
+	decode := func(w *httptest.ResponseRecorder) problem {
+		t.Helper()
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, problemContentType) {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		var p problem
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if p.Status != w.Code {
+			t.Fatalf("status mismatch: body %d, header %d", p.Status, w.Code)
+		}
+		return p
+	}
+
+	w := doJSON(router, "GET", "/api/books/42", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("missing book: expected 404, got %d", w.Code)
+	}
+	if p := decode(w); p.Instance != "/api/books/42" || p.Title != "Not Found" {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+
+	body := map[string]string{"email": "dup@test.com", "password": "secret1"}
+	if w := doJSON(router, "POST", "/api/register", "", body); w.Code != http.StatusCreated {
+		t.Fatalf("register: %d", w.Code)
+	}
+	w = doJSON(router, "POST", "/api/register", "", body)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("duplicate register: expected 409, got %d", w.Code)
+	}
+	if p := decode(w); p.Detail != service.ErrUserExists.Error() {
+		t.Fatalf("unexpected detail %q", p.Detail)
+	}
+
+	broken := NewHandler(service.NewService(brokenRepo{newMemRepo()}))
+	router = gin.New()
+	router.GET("/api/books", broken.ListBooks)
+	w = doJSON(router, "GET", "/api/books", "", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if p := decode(w); strings.Contains(p.Detail, "pq") || strings.Contains(w.Body.String(), "relation") {
+		t.Fatalf("internal error leaked: %s", w.Body.String())
+	}
+}
+
+func TestReadingEndpoints(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	reading := router.Group("/api/me/reading", h.AuthMiddleware())
+	reading.GET("", h.ListReading)
+	reading.GET(":book_id", h.GetReading)
+	reading.PUT(":book_id", h.UpdateReading)
+	reading.DELETE(":book_id", h.DeleteReading)
+
+	book := &models.Book{Title: "Dune"}
+	_ = r.CreateBook(context.Background(), book)
+	path := fmt.Sprintf("/api/me/reading/%d", book.ID)
+	alice := bearer(t, 1, "user")
+	bob := bearer(t, 2, "user")
+
+	if w := doJSON(router, "GET", path, "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: expected 401, got %d", w.Code)
+	}
+	if w := doJSON(router, "GET", path, alice, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("no status yet: expected 404, got %d", w.Code)
+	}
+
+	w := doJSON(router, "PUT", path, alice, map[string]interface{}{"status": "reading", "percent": 30, "started_at": "2024-03-01"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body.String())
+	}
+	var rs models.ReadingStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &rs); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if rs.Status != "reading" || *rs.Percent != 30 || rs.StartedAt.Format("2006-01-02") != "2024-03-01" {
+		t.Fatalf("unexpected status: %+v", rs)
+	}
+
+	if w := doJSON(router, "PUT", path, alice, map[string]interface{}{"status": "skimmed"}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("bad status: expected 422, got %d", w.Code)
+	}
+	if w := doJSON(router, "PUT", path, alice, map[string]interface{}{"started_at": "yesterday"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad date: expected 400, got %d", w.Code)
+	}
+	if w := doJSON(router, "PUT", "/api/me/reading/999", alice, map[string]interface{}{"status": "reading"}); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown book: expected 404, got %d", w.Code)
+	}
+
+	w = doJSON(router, "GET", path, alice, nil)
+	var detail struct {
+		Status  models.ReadingStatus  `json:"status"`
+		History []models.ReadingEvent `json:"history"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil || len(detail.History) != 1 {
+		t.Fatalf("detail: %v %s", err, w.Body.String())
+	}
+
+	// statuses are private to each user
+	w = doJSON(router, "GET", "/api/me/reading", bob, nil)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("other user's list: %d %s", w.Code, w.Body.String())
+	}
+	w = doJSON(router, "GET", "/api/me/reading?status=reading", alice, nil)
+	if !strings.Contains(w.Body.String(), `"book_id":`+strconv.Itoa(book.ID)) {
+		t.Fatalf("own list: %s", w.Body.String())
+	}
+
+	if w := doJSON(router, "DELETE", path, alice, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", path, alice, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("delete again: expected 404, got %d", w.Code)
+	}
+}
+
+func TestRatingAggregates(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.ParseFiles("../../web/templates/book.html")))
+	router.POST("/api/reviews", h.AuthMiddleware(), h.CreateReview)
+	router.GET("/api/books", h.ListBooks)
+	router.GET("/api/books/top-rated", h.TopRatedBooks)
+	router.GET("/api/books/:id", h.GetBook)
+	router.GET("/books/:id", h.BookPage)
+
+	book := &models.Book{Title: "Dune"}
+	_ = r.CreateBook(context.Background(), book)
+	for i, rating := range []int{5, 4, 4} {
+		w := doJSON(router, "POST", "/api/reviews", bearer(t, i+1, "user"), map[string]interface{}{"book_id": book.ID, "rating": rating})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("review: %d %s", w.Code, w.Body.String())
+		}
+	}
+	if w := doJSON(router, "POST", "/api/reviews", bearer(t, 9, "user"), map[string]interface{}{"book_id": 999, "rating": 3}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("review of unknown book: expected 422, got %d", w.Code)
+	}
+
+	w := doJSON(router, "GET", fmt.Sprintf("/api/books/%d", book.ID), "", nil)
+	var got models.Book
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.RatingCount != 3 || fmt.Sprintf("%.2f", got.RatingAvg) != "4.33" || fmt.Sprint(got.RatingHistogram) != "[0 0 0 2 1]" {
+		t.Fatalf("unexpected aggregates: %+v", got)
+	}
+
+	w = doJSON(router, "GET", "/api/books?sort=-rating", "", nil)
+	if w.Code != http.StatusOK || r.lastBookQuery.Sort != "-rating" || !strings.Contains(w.Body.String(), `"rating_count":3`) {
+		t.Fatalf("listing: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(router, "GET", "/api/books/top-rated?limit=5&min_count=2&weight=3", "", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"score"`) {
+		t.Fatalf("top rated: %d %s", w.Code, w.Body.String())
+	}
+	if q := r.lastTopRatedQuery; q.Limit != 5 || q.MinCount != 2 || q.Weight != 3 {
+		t.Fatalf("query params not passed: %+v", q)
+	}
+	if w := doJSON(router, "GET", "/api/books/top-rated?limit=x", "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad limit: expected 400, got %d", w.Code)
+	}
+
+	w = doJSON(router, "GET", fmt.Sprintf("/books/%d", book.ID), "", nil)
+	if !strings.Contains(w.Body.String(), "★ 4.3") || !strings.Contains(w.Body.String(), "width: 66%") {
+		t.Fatalf("book page lacks rating summary: %s", w.Body.String())
+	}
+}
+
+func TestReviewLifecycle(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.ParseFiles("../../web/templates/book.html")))
+	router.POST("/api/reviews", h.AuthMiddleware(), h.CreateReview)
+	router.PUT("/api/reviews/:id", h.AuthMiddleware(), h.UpdateReview)
+	router.DELETE("/api/reviews/:id", h.AuthMiddleware(), h.DeleteReview)
+	router.POST("/api/reviews/:id/helpful", h.AuthMiddleware(), h.VoteHelpful)
+	router.DELETE("/api/reviews/:id/helpful", h.AuthMiddleware(), h.UnvoteHelpful)
+	router.GET("/api/books/:id/reviews", h.ListBookReviews)
+	router.GET("/books/:id", h.BookPage)
+
+	ctx := context.Background()
+	alice := &models.User{Email: "alice@example.com", Name: "Alice"}
+	bob := &models.User{Email: "bob@example.com", Name: "Bob"}
+	_ = r.CreateUser(ctx, alice)
+	_ = r.CreateUser(ctx, bob)
+	book := &models.Book{Title: "Dune"}
+	_ = r.CreateBook(ctx, book)
+
+	review := func(uid, rating int) models.Review {
+		w := doJSON(router, "POST", "/api/reviews", bearer(t, uid, "user"), map[string]interface{}{"book_id": book.ID, "rating": rating, "text": "ok"})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("review: %d %s", w.Code, w.Body.String())
+		}
+		var rv models.Review
+		_ = json.Unmarshal(w.Body.Bytes(), &rv)
+		return rv
+	}
+	first := review(alice.ID, 3)
+	second := review(bob.ID, 5)
+	if w := doJSON(router, "POST", "/api/reviews", bearer(t, alice.ID, "user"), map[string]interface{}{"book_id": book.ID, "rating": 4}); w.Code != http.StatusConflict {
+		t.Fatalf("duplicate review: expected 409, got %d", w.Code)
+	}
+
+	path := fmt.Sprintf("/api/reviews/%d", first.ID)
+	if w := doJSON(router, "PUT", path, bearer(t, bob.ID, "user"), map[string]interface{}{"rating": 1}); w.Code != http.StatusForbidden {
+		t.Fatalf("edit by other user: expected 403, got %d", w.Code)
+	}
+	w := doJSON(router, "PUT", path, bearer(t, alice.ID, "user"), map[string]interface{}{"rating": 4, "text": "better on reread"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"updated_at"`) {
+		t.Fatalf("edit: %d %s", w.Code, w.Body.String())
+	}
+	if b := r.books[book.ID]; b.RatingCount != 2 || b.RatingAvg != 4.5 {
+		t.Fatalf("aggregates not moved to new rating: %+v", b)
+	}
+	if w := doJSON(router, "PUT", "/api/reviews/999", bearer(t, alice.ID, "user"), map[string]interface{}{"rating": 4}); w.Code != http.StatusNotFound {
+		t.Fatalf("edit unknown review: expected 404, got %d", w.Code)
+	}
+
+	if w := doJSON(router, "POST", path+"/helpful", bearer(t, alice.ID, "user"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("self vote: expected 403, got %d", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		w = doJSON(router, "POST", path+"/helpful", bearer(t, bob.ID, "user"), nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"helpful_count":1`) {
+			t.Fatalf("vote %d: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+
+	w = doJSON(router, "GET", fmt.Sprintf("/api/books/%d/reviews?sort=helpful", book.ID), "", nil)
+	var list []models.Review
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 2 {
+		t.Fatalf("list: %d %s", w.Code, w.Body.String())
+	}
+	if list[0].ID != first.ID || list[0].UserName != "Alice" || list[1].UserName != "Bob" {
+		t.Fatalf("expected most helpful first with reviewer names: %+v", list)
+	}
+	w = doJSON(router, "GET", fmt.Sprintf("/api/books/%d/reviews", book.ID), "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || list[0].ID != second.ID {
+		t.Fatalf("expected newest first: %s", w.Body.String())
+	}
+	if w := doJSON(router, "GET", fmt.Sprintf("/api/books/%d/reviews?sort=stars", book.ID), "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown sort: expected 400, got %d", w.Code)
+	}
+	w = doJSON(router, "GET", fmt.Sprintf("/books/%d?sort=helpful", book.ID), "", nil)
+	if !strings.Contains(w.Body.String(), "by Alice") || !strings.Contains(w.Body.String(), "(edited)") {
+		t.Fatalf("book page lacks reviewer details: %s", w.Body.String())
+	}
+
+	w = doJSON(router, "DELETE", path+"/helpful", bearer(t, bob.ID, "user"), nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"helpful_count":0`) {
+		t.Fatalf("unvote: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := doJSON(router, "DELETE", path, bearer(t, bob.ID, "user"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("delete by other user: expected 403, got %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", path, bearer(t, 99, "admin"), nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete by admin: expected 204, got %d", w.Code)
+	}
+	if b := r.books[book.ID]; b.RatingCount != 1 || b.RatingAvg != 5 {
+		t.Fatalf("aggregates still count deleted review: %+v", b)
+	}
+	review(alice.ID, 2)
+}
+
+func TestReviewModerationEndpoints(t *testing.T) {
+	r := newMemRepo()
+	svc := service.NewService(r)
+	svc.SetModeration(service.ModerationConfig{Filter: moderation.MustParse("spoiler"), ReportThreshold: 1})
+	h := NewHandler(svc)
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.ParseFiles("../../web/templates/moderation.html")))
+	router.POST("/api/reviews", h.AuthMiddleware(), h.CreateReview)
+	router.POST("/api/reviews/:id/report", h.AuthMiddleware(), h.ReportReview)
+	router.GET("/api/books/:id/reviews", h.ListBookReviews)
+	admin := router.Group("/api/admin/reviews", h.AuthMiddleware(), h.RequireRole("admin"))
+	admin.GET("", h.ReviewQueue)
+	admin.PUT(":id", h.ModerateReview)
+	admin.GET(":id/reports", h.ReviewReports)
+	router.GET("/admin/reviews", h.ModerationPage)
+
+	book := &models.Book{Title: "Dune"}
+	_ = r.CreateBook(context.Background(), book)
+	post := func(uid int, text string) models.Review {
+		w := doJSON(router, "POST", "/api/reviews", bearer(t, uid, "user"), map[string]interface{}{"book_id": book.ID, "rating": 4, "text": text})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("review: %d %s", w.Code, w.Body.String())
+		}
+		var rv models.Review
+		_ = json.Unmarshal(w.Body.Bytes(), &rv)
+		return rv
+	}
+	held := post(1, "spoiler: the worm did it")
+	shown := post(2, "great")
+	if held.Status != "pending" || shown.Status != "approved" {
+		t.Fatalf("unexpected statuses %q %q", held.Status, shown.Status)
+	}
+	if b := r.books[book.ID]; b.RatingCount != 1 {
+		t.Fatalf("pending review must not count: %+v", b)
+	}
+	listed := func() string {
+		return doJSON(router, "GET", fmt.Sprintf("/api/books/%d/reviews", book.ID), "", nil).Body.String()
+	}
+	if strings.Contains(listed(), "worm") {
+		t.Fatalf("pending review listed: %s", listed())
+	}
+
+	if w := doJSON(router, "GET", "/api/admin/reviews", bearer(t, 3, "user"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("queue as user: expected 403, got %d", w.Code)
+	}
+	w := doJSON(router, "POST", fmt.Sprintf("/api/reviews/%d/report", shown.ID), bearer(t, 3, "user"), map[string]string{"reason": "off topic"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"pending"`) {
+		t.Fatalf("report: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "POST", fmt.Sprintf("/api/reviews/%d/report", held.ID), bearer(t, 3, "user"), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("report of hidden review: expected 404, got %d", w.Code)
+	}
+
+	w = doJSON(router, "GET", "/api/admin/reviews?limit=1", bearer(t, 9, "admin"), nil)
+	var page struct {
+		Items []models.Review `json:"items"`
+		Total int             `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || page.Total != 2 || len(page.Items) != 1 {
+		t.Fatalf("queue: %d %s", w.Code, w.Body.String())
+	}
+	if page.Items[0].ID != shown.ID || page.Items[0].BookTitle != "Dune" {
+		t.Fatalf("expected the reported review first: %+v", page.Items[0])
+	}
+	w = doJSON(router, "GET", fmt.Sprintf("/api/admin/reviews/%d/reports", shown.ID), bearer(t, 9, "admin"), nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "off topic") {
+		t.Fatalf("reports: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := doJSON(router, "PUT", fmt.Sprintf("/api/admin/reviews/%d", held.ID), bearer(t, 9, "admin"), map[string]string{"status": "deleted"}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("bad status: expected 422, got %d", w.Code)
+	}
+	for _, id := range []int{held.ID, shown.ID} {
+		if w := doJSON(router, "PUT", fmt.Sprintf("/api/admin/reviews/%d", id), bearer(t, 9, "admin"), map[string]string{"status": "approved"}); w.Code != http.StatusOK {
+			t.Fatalf("approve: %d %s", w.Code, w.Body.String())
+		}
+	}
+	if !strings.Contains(listed(), "worm") || r.books[book.ID].RatingCount != 2 {
+		t.Fatalf("approved reviews not public: %s", listed())
+	}
+	if w := doJSON(router, "GET", "/api/admin/reviews?status=spam", bearer(t, 9, "admin"), nil); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unknown status: expected 422, got %d", w.Code)
+	}
+
+	if w := doJSON(router, "GET", "/admin/reviews", "", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/api/admin/reviews") {
+		t.Fatalf("moderation page: %d", w.Code)
+	}
+}
+
+func TestShelfManagement(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.GET("/api/me/shelves", h.AuthMiddleware(), h.MyShelves)
+	router.GET("/api/shelves/:id", h.OptionalAuth(), h.GetShelf)
+	router.PUT("/api/shelves/:id", h.AuthMiddleware(), h.UpdateShelf)
+	router.DELETE("/api/shelves/:id", h.AuthMiddleware(), h.DeleteShelf)
+	router.POST("/api/shelves/:id/books", h.AuthMiddleware(), h.AddBookToShelf)
+	router.PUT("/api/shelves/:id/books", h.AuthMiddleware(), h.ReorderShelf)
+	router.DELETE("/api/shelves/:id/books/:book_id", h.AuthMiddleware(), h.RemoveBookFromShelf)
+	router.POST("/api/shelves/:id/books/:book_id/move", h.AuthMiddleware(), h.MoveShelfBook)
+
+	ctx := context.Background()
+	owner := bearer(t, 5, "user")
+	stranger := bearer(t, 6, "user")
+	var ids []int
+	for _, title := range []string{"A", "B", "C"} {
+		b := &models.Book{Title: title}
+		_ = r.CreateBook(ctx, b)
+		ids = append(ids, b.ID)
+	}
+	shelf := &models.Shelf{UserID: 5, Name: "Favourites"}
+	other := &models.Shelf{UserID: 5, Name: "Later"}
+	foreign := &models.Shelf{UserID: 6, Name: "Theirs"}
+	for _, sh := range []*models.Shelf{shelf, other, foreign} {
+		_ = r.CreateShelf(ctx, sh)
+	}
+	base := fmt.Sprintf("/api/shelves/%d", shelf.ID)
+	for _, id := range ids {
+		if w := doJSON(router, "POST", base+"/books", owner, map[string]int{"book_id": id}); w.Code != http.StatusCreated {
+			t.Fatalf("add: %d %s", w.Code, w.Body.String())
+		}
+	}
+	titles := func(path string) string {
+		var res struct {
+			Books []models.Book `json:"books"`
+		}
+		w := doJSON(router, "GET", path, owner, nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("get shelf: %d %s", w.Code, w.Body.String())
+		}
+		out := ""
+		for _, b := range res.Books {
+			out += b.Title
+		}
+		return out
+	}
+	if got := titles(base); got != "ABC" {
+		t.Fatalf("expected insertion order, got %q", got)
+	}
+
+	w := doJSON(router, "PUT", base+"/books", owner, map[string][]int{"book_ids": {ids[2], ids[0], ids[1]}})
+	if w.Code != http.StatusOK || titles(base) != "CAB" {
+		t.Fatalf("reorder: %d %s", w.Code, w.Body.String())
+	}
+	for _, bad := range [][]int{{ids[0], ids[1]}, {ids[0], ids[0], ids[1]}, {ids[0], ids[1], 999}} {
+		if w := doJSON(router, "PUT", base+"/books", owner, map[string][]int{"book_ids": bad}); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("reorder %v: expected 422, got %d", bad, w.Code)
+		}
+	}
+	if w := doJSON(router, "PUT", base+"/books", stranger, map[string][]int{"book_ids": ids}); w.Code != http.StatusNotFound {
+		t.Fatalf("stranger reorder: expected 404, got %d", w.Code)
+	}
+
+	move := fmt.Sprintf("%s/books/%d/move", base, ids[0])
+	if w := doJSON(router, "POST", move, owner, map[string]int{"shelf_id": foreign.ID}); w.Code != http.StatusNotFound {
+		t.Fatalf("move to foreign private shelf: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(router, "POST", move, owner, map[string]int{"shelf_id": other.ID}); w.Code != http.StatusNoContent {
+		t.Fatalf("move: %d %s", w.Code, w.Body.String())
+	}
+	if titles(base) != "CB" || titles(fmt.Sprintf("/api/shelves/%d", other.ID)) != "A" {
+		t.Fatalf("move did not take effect: %q %q", titles(base), titles(fmt.Sprintf("/api/shelves/%d", other.ID)))
+	}
+	if w := doJSON(router, "POST", move, owner, map[string]int{"shelf_id": other.ID}); w.Code != http.StatusNotFound {
+		t.Fatalf("move of a book not on the shelf: expected 404, got %d", w.Code)
+	}
+
+	remove := fmt.Sprintf("%s/books/%d", base, ids[1])
+	if w := doJSON(router, "DELETE", remove, stranger, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("stranger remove: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", remove, owner, nil); w.Code != http.StatusNoContent || titles(base) != "C" {
+		t.Fatalf("remove: %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", remove, owner, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("second remove: expected 404, got %d", w.Code)
+	}
+
+	if w := doJSON(router, "PUT", base, owner, map[string]string{"name": "   "}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("blank rename: expected 422, got %d", w.Code)
+	}
+	w = doJSON(router, "PUT", base, owner, map[string]string{"name": " Best "})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"Best"`) {
+		t.Fatalf("rename: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(router, "GET", "/api/me/shelves", owner, nil)
+	var mine struct {
+		Items []models.Shelf `json:"items"`
+		Total int            `json:"total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &mine); err != nil || mine.Total != 2 || len(mine.Items) != 2 {
+		t.Fatalf("my shelves: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := doJSON(router, "DELETE", base, stranger, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("stranger delete: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", base, owner, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if w := doJSON(router, "GET", base, owner, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("deleted shelf: expected 404, got %d", w.Code)
+	}
+}
+
+func TestListPublicShelves(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.GET("/api/shelves", h.ListShelves)
+	ctx := context.Background()
+	token := "secret"
+	for i, vis := range []string{repository.ShelfPublic, repository.ShelfPrivate, repository.ShelfPublic, repository.ShelfUnlisted, repository.ShelfPublic} {
+		_ = r.CreateShelf(ctx, &models.Shelf{UserID: 1, Name: fmt.Sprintf("Shelf %d", i), Visibility: vis, ShareToken: &token})
+	}
+
+	var page struct {
+		Items []models.Shelf `json:"items"`
+		Total int            `json:"total"`
+	}
+	w := doJSON(router, "GET", "/api/shelves?limit=2&offset=1", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body.String())
+	}
+	if page.Total != 3 || len(page.Items) != 2 || page.Items[0].Name != "Shelf 2" || page.Items[1].Name != "Shelf 4" {
+		t.Fatalf("expected the second page of public shelves, got %+v", page)
+	}
+	for _, sh := range page.Items {
+		if sh.ShareToken != nil {
+			t.Fatalf("share token listed: %+v", sh)
+		}
+	}
+	if w := doJSON(router, "GET", "/api/shelves?limit=many", "", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad limit: %d", w.Code)
+	}
+}
+
+func TestShelfSharing(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.ParseFiles("../../web/templates/shelf_detail.html")))
+	router.GET("/api/me/shelves", h.AuthMiddleware(), h.MyShelves)
+	router.POST("/api/shelves", h.AuthMiddleware(), h.CreateShelf)
+	router.GET("/api/shelves/:id", h.OptionalAuth(), h.GetShelf)
+	router.PUT("/api/shelves/:id", h.AuthMiddleware(), h.UpdateShelf)
+	router.POST("/api/shelves/:id/books", h.AuthMiddleware(), h.AddBookToShelf)
+	router.POST("/api/shelves/:id/share-token", h.AuthMiddleware(), h.RotateShareToken)
+	router.GET("/api/shelves/:id/collaborators", h.OptionalAuth(), h.ListCollaborators)
+	router.POST("/api/shelves/:id/collaborators", h.AuthMiddleware(), h.AddCollaborator)
+	router.DELETE("/api/shelves/:id/collaborators/:user_id", h.AuthMiddleware(), h.RemoveCollaborator)
+	router.GET("/api/shared-shelves/:token", h.GetSharedShelf)
+	router.GET("/shelves/:id", h.OptionalAuth(), h.ShelfPage)
+	router.GET("/s/:token", h.SharedShelfPage)
+
+	ctx := context.Background()
+	ann := &models.User{Email: "ann@example.com", Name: "Ann"}
+	bob := &models.User{Email: "bob@example.com", Name: "Bob"}
+	eve := &models.User{Email: "eve@example.com", Name: "Eve"}
+	for _, u := range []*models.User{ann, bob, eve} {
+		_ = r.CreateUser(ctx, u)
+	}
+	owner, friend, stranger := bearer(t, ann.ID, "user"), bearer(t, bob.ID, "user"), bearer(t, eve.ID, "user")
+	book := &models.Book{Title: "Dune"}
+	_ = r.CreateBook(ctx, book)
+
+	w := doJSON(router, "POST", "/api/shelves", owner, map[string]string{"name": "Secret", "visibility": "hidden"})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("bad visibility: expected 422, got %d", w.Code)
+	}
+	w = doJSON(router, "POST", "/api/shelves", owner, map[string]string{"name": "Secret"})
+	var shelf models.Shelf
+	if err := json.Unmarshal(w.Body.Bytes(), &shelf); err != nil || w.Code != http.StatusCreated || shelf.Visibility != "private" {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	base := fmt.Sprintf("/api/shelves/%d", shelf.ID)
+	if w := doJSON(router, "POST", base+"/books", owner, map[string]int{"book_id": book.ID}); w.Code != http.StatusCreated {
+		t.Fatalf("add: %d", w.Code)
+	}
+
+	// private: hidden from everyone but the owner, and not leaked by the page
+	for _, who := range []string{"", stranger, friend} {
+		if w := doJSON(router, "GET", base, who, nil); w.Code != http.StatusNotFound {
+			t.Fatalf("private shelf visible to %q: %d", who, w.Code)
+		}
+	}
+	if w := doJSON(router, "GET", base, owner, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Dune") {
+		t.Fatalf("owner view: %d %s", w.Code, w.Body.String())
+	}
+	// the add-book picker lists catalog books; the shelf's own list must stay empty
+	if w := doJSON(router, "GET", fmt.Sprintf("/shelves/%d", shelf.ID), "", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "No books in this shelf") {
+		t.Fatalf("page leaked private shelf: %d", w.Code)
+	}
+	if w := doJSON(router, "GET", fmt.Sprintf("/shelves/%d", shelf.ID), owner, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<h1 id="shelf-name">Secret</h1>`) {
+		t.Fatalf("owner's page: %d %s", w.Code, w.Body.String())
+	}
+
+	// read collaborator can see but not edit; write collaborator can add books
+	if w := doJSON(router, "POST", base+"/collaborators", stranger, map[string]string{"email": bob.Email}); w.Code != http.StatusNotFound {
+		t.Fatalf("stranger invite to a private shelf: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(router, "POST", base+"/collaborators", owner, map[string]string{"email": ann.Email}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("owner as collaborator: expected 422, got %d", w.Code)
+	}
+	if w := doJSON(router, "POST", base+"/collaborators", owner, map[string]string{"email": "nobody@example.com"}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unknown email: expected 422, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "POST", base+"/collaborators", owner, map[string]string{"email": bob.Email}); w.Code != http.StatusOK {
+		t.Fatalf("invite: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", base, friend, nil); w.Code != http.StatusOK {
+		t.Fatalf("reader view: %d", w.Code)
+	}
+	if w := doJSON(router, "POST", base+"/books", friend, map[string]int{"book_id": book.ID}); w.Code != http.StatusForbidden {
+		t.Fatalf("reader add: expected 403, got %d", w.Code)
+	}
+	if w := doJSON(router, "POST", base+"/collaborators", owner, map[string]string{"email": bob.Email, "role": "write"}); w.Code != http.StatusOK {
+		t.Fatalf("promote: %d", w.Code)
+	}
+	other := &models.Book{Title: "Emma"}
+	_ = r.CreateBook(ctx, other)
+	if w := doJSON(router, "POST", base+"/books", friend, map[string]int{"book_id": other.ID}); w.Code != http.StatusCreated {
+		t.Fatalf("writer add: %d", w.Code)
+	}
+	if w := doJSON(router, "PUT", base, friend, map[string]string{"name": "Mine now"}); w.Code != http.StatusForbidden {
+		t.Fatalf("writer rename: expected 403, got %d", w.Code)
+	}
+	w = doJSON(router, "GET", base, friend, nil)
+	if strings.Contains(w.Body.String(), "share_token") {
+		t.Fatalf("share token shown to collaborator: %s", w.Body.String())
+	}
+	w = doJSON(router, "GET", "/api/me/shelves", friend, nil)
+	if !strings.Contains(w.Body.String(), `"total":1`) {
+		t.Fatalf("shared shelf missing from my shelves: %s", w.Body.String())
+	}
+
+	// unlisted: reachable through the share link only
+	w = doJSON(router, "PUT", base, owner, map[string]string{"visibility": "unlisted"})
+	if err := json.Unmarshal(w.Body.Bytes(), &shelf); err != nil || shelf.ShareToken == nil || len(*shelf.ShareToken) < 20 {
+		t.Fatalf("unlisted: %d %s", w.Code, w.Body.String())
+	}
+	link := *shelf.ShareToken
+	if w := doJSON(router, "GET", base, stranger, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("unlisted shelf by id: expected 404, got %d", w.Code)
+	}
+	w = doJSON(router, "GET", "/api/shared-shelves/"+link, "", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Emma") || strings.Contains(w.Body.String(), link) {
+		t.Fatalf("shared shelf: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", "/s/"+link, "", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Dune") {
+		t.Fatalf("shared page: %d", w.Code)
+	}
+	w = doJSON(router, "POST", base+"/share-token", owner, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &shelf); err != nil || *shelf.ShareToken == link {
+		t.Fatalf("rotate: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", "/api/shared-shelves/"+link, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("old link: expected 404, got %d", w.Code)
+	}
+	if w := doJSON(router, "PUT", base, owner, map[string]string{"visibility": "private"}); w.Code != http.StatusOK {
+		t.Fatalf("make private: %d", w.Code)
+	}
+	if w := doJSON(router, "GET", "/api/shared-shelves/"+*shelf.ShareToken, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("link to private shelf: expected 404, got %d", w.Code)
+	}
+
+	// public: open to anonymous visitors
+	if w := doJSON(router, "PUT", base, owner, map[string]string{"visibility": "public"}); w.Code != http.StatusOK {
+		t.Fatalf("make public: %d", w.Code)
+	}
+	w = doJSON(router, "GET", base, "", nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "share_token") {
+		t.Fatalf("public view: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", base, "Bearer junk", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("bad token on public shelf: expected 401, got %d", w.Code)
+	}
+
+	// collaborators may leave; strangers may not remove them
+	leave := fmt.Sprintf("%s/collaborators/%d", base, bob.ID)
+	if w := doJSON(router, "DELETE", leave, stranger, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("stranger remove: expected 403, got %d", w.Code)
+	}
+	if w := doJSON(router, "DELETE", leave, friend, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("leave: %d", w.Code)
+	}
+	w = doJSON(router, "GET", base+"/collaborators", "", nil)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("collaborators after leave: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBookMetadata(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.POST("/api/books", h.AuthMiddleware(), h.CreateBook)
+	router.PUT("/api/books/:id", h.AuthMiddleware(), h.UpdateBook)
+	router.GET("/api/books", h.ListBooks)
+	router.GET("/api/genres", h.ListGenres)
+	author := &models.Author{Name: "A"}
+	_ = r.CreateAuthor(context.Background(), author)
+	user := bearer(t, 1, "user")
+
+	w := doJSON(router, "POST", "/api/books", user, map[string]interface{}{
+		"title": "Dune", "author_id": author.ID, "isbn": "0-441-17271-7", "language": "EN",
+		"published_year": 1965, "genres": []string{" Sci-Fi", "classic", "sci-fi"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	var dune models.Book
+	if err := json.Unmarshal(w.Body.Bytes(), &dune); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if dune.ISBN != "9780441172719" || dune.Language != "en" || strings.Join(dune.Genres, ",") != "classic,sci-fi" {
+		t.Fatalf("metadata not normalized: %+v", dune)
+	}
+
+	if w := doJSON(router, "POST", "/api/books", user, map[string]interface{}{
+		"title": "Dune again", "author_id": author.ID, "isbn": "9780441172719"}); w.Code != http.StatusConflict {
+		t.Fatalf("duplicate isbn: expected 409, got %d", w.Code)
+	}
+	for _, bad := range []map[string]interface{}{
+		{"isbn": "9780441172718"}, {"language": "english"}, {"page_count": -1}, {"published_year": 3000},
+	} {
+		bad["title"], bad["author_id"] = "Bad", author.ID
+		if w := doJSON(router, "POST", "/api/books", user, bad); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%v: expected 422, got %d %s", bad, w.Code, w.Body.String())
+		}
+	}
+	_ = doJSON(router, "POST", "/api/books", user, map[string]interface{}{
+		"title": "Emma", "author_id": author.ID, "language": "en", "genres": []string{"classic"}})
+
+	var page struct {
+		Items []models.Book `json:"items"`
+	}
+	w = doJSON(router, "GET", "/api/books?genre=Sci-Fi&lang=en", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || len(page.Items) != 1 || page.Items[0].ID != dune.ID {
+		t.Fatalf("genre filter: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", "/api/books?lang=english", "", nil); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("bad lang filter: expected 422, got %d", w.Code)
+	}
+	var genres []models.LabelCount
+	w = doJSON(router, "GET", "/api/genres", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &genres); err != nil || len(genres) != 2 ||
+		genres[0] != (models.LabelCount{Name: "classic", BookCount: 2}) {
+		t.Fatalf("genres: %d %s", w.Code, w.Body.String())
+	}
+
+	// PUT replaces the whole record, so omitted genres are cleared
+	path := fmt.Sprintf("/api/books/%d", dune.ID)
+	w = doJSON(router, "PUT", path, user, map[string]interface{}{"title": "Dune", "author_id": author.ID, "tags": []string{"Desert"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body.String())
+	}
+	if b := r.books[dune.ID]; len(b.Genres) != 0 || b.ISBN != "" || strings.Join(b.Tags, ",") != "desert" {
+		t.Fatalf("labels not replaced: %+v", b)
+	}
+}
+
+func TestBookContributors(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.POST("/api/books", h.AuthMiddleware(), h.CreateBook)
+	router.PUT("/api/books/:id", h.AuthMiddleware(), h.UpdateBook)
+	router.GET("/api/books", h.ListBooks)
+	router.GET("/api/authors/:id/books", h.ListAuthorBooks)
+	router.DELETE("/api/authors/:id", h.DeleteAuthor)
+	ctx := context.Background()
+	tolstoy, maude := &models.Author{Name: "Leo Tolstoy"}, &models.Author{Name: "Aylmer Maude"}
+	_ = r.CreateAuthor(ctx, tolstoy)
+	_ = r.CreateAuthor(ctx, maude)
+	user := bearer(t, 1, "user")
+
+	// the translator is credited first, but the primary author is the first one with the author role
+	w := doJSON(router, "POST", "/api/books", user, map[string]interface{}{"title": "War and Peace",
+		"contributors": []map[string]interface{}{{"author_id": maude.ID, "role": "Translator"}, {"author_id": tolstoy.ID}}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+	var book models.Book
+	if err := json.Unmarshal(w.Body.Bytes(), &book); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := models.Contributors{{AuthorID: maude.ID, Name: "Aylmer Maude", Role: "translator"}, {AuthorID: tolstoy.ID, Name: "Leo Tolstoy", Role: "author"}}
+	if book.AuthorID != tolstoy.ID || book.AuthorName != "Leo Tolstoy" || fmt.Sprint(book.Contributors) != fmt.Sprint(want) {
+		t.Fatalf("unexpected contributors: %+v", book)
+	}
+
+	// the deprecated author_id alone still credits one author
+	w = doJSON(router, "POST", "/api/books", user, map[string]interface{}{"title": "Anna Karenina", "author_id": tolstoy.ID})
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"contributors":[{"author_id":`+fmt.Sprint(tolstoy.ID)+`,"name":"Leo Tolstoy","role":"author"}]`) {
+		t.Fatalf("legacy create: %d %s", w.Code, w.Body.String())
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"unknown role":           {"contributors": []map[string]interface{}{{"author_id": tolstoy.ID, "role": "ghost"}}},
+		"unknown author":         {"contributors": []map[string]interface{}{{"author_id": 999}}},
+		"listed twice":           {"contributors": []map[string]interface{}{{"author_id": tolstoy.ID}, {"author_id": tolstoy.ID, "role": "author"}}},
+		"author_id not credited": {"author_id": maude.ID, "contributors": []map[string]interface{}{{"author_id": tolstoy.ID}}},
+	} {
+		body["title"] = "Bad"
+		if w := doJSON(router, "POST", "/api/books", user, body); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: expected 422, got %d %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	// translations are listed under the translator and block deleting them
+	var page struct {
+		Items []models.Book `json:"items"`
+	}
+	w = doJSON(router, "GET", fmt.Sprintf("/api/books?author_id=%d", maude.ID), "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || len(page.Items) != 1 || page.Items[0].ID != book.ID {
+		t.Fatalf("author filter: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", fmt.Sprintf("/api/authors/%d/books", maude.ID), "", nil); !strings.Contains(w.Body.String(), "War and Peace") {
+		t.Fatalf("author books: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "DELETE", fmt.Sprintf("/api/authors/%d", maude.ID), "", nil); w.Code != http.StatusConflict {
+		t.Fatalf("delete credited translator: expected 409, got %d", w.Code)
+	}
+
+	// PUT with only author_id replaces the contributor list
+	path := fmt.Sprintf("/api/books/%d", book.ID)
+	if w := doJSON(router, "PUT", path, user, map[string]interface{}{"title": "War and Peace", "author_id": tolstoy.ID}); w.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", w.Code, w.Body.String())
+	}
+	if cs := r.books[book.ID].Contributors; len(cs) != 1 || cs[0].AuthorID != tolstoy.ID {
+		t.Fatalf("contributors not replaced: %+v", cs)
+	}
+}
+
+// uploadCover posts data as the multipart "cover" field.
+func uploadCover(router *gin.Engine, path, authz string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("cover", "cover.png")
+	part.Write(data)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", authz)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func pngBytes(t *testing.T, w, h int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBookCover(t *testing.T) {
+	r := newMemRepo()
+	svc := service.NewService(r)
+	svc.SetStorage(storage.NewLocal(t.TempDir()))
+	h := NewHandler(svc)
+	router := gin.New()
+	router.POST("/api/books/:id/cover", h.AuthMiddleware(), h.UploadCover)
+	router.DELETE("/api/books/:id/cover", h.AuthMiddleware(), h.DeleteCover)
+	router.GET("/covers/:cover/:file", h.ServeCover)
+
+	owner := 5
+	book := &models.Book{Title: "Dune", CreatedBy: &owner}
+	_ = r.CreateBook(context.Background(), book)
+	path := fmt.Sprintf("/api/books/%d/cover", book.ID)
+	red := pngBytes(t, 900, 1200, color.RGBA{R: 255, A: 255})
+
+	if w := uploadCover(router, path, bearer(t, 6, "user"), red); w.Code != http.StatusForbidden {
+		t.Fatalf("stranger upload: expected 403, got %d", w.Code)
+	}
+	if w := uploadCover(router, path, bearer(t, owner, "user"), []byte("plain text")); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text upload: expected 415, got %d %s", w.Code, w.Body.String())
+	}
+	if w := uploadCover(router, path, bearer(t, owner, "user"), make([]byte, service.MaxCoverBytes+1)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("huge upload: expected 413, got %d", w.Code)
+	}
+
+	w := uploadCover(router, path, bearer(t, owner, "user"), red)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	var res struct {
+		Cover map[string]string `json:"cover"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res.Cover) != 3 {
+		t.Fatalf("cover urls: %s", w.Body.String())
+	}
+	large := res.Cover["large"]
+	w = doJSON(router, "GET", large, "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/jpeg" ||
+		!strings.Contains(w.Header().Get("Cache-Control"), "immutable") || w.Header().Get("ETag") == "" {
+		t.Fatalf("serve: %d %v", w.Code, w.Header())
+	}
+	if cfg, err := jpeg.DecodeConfig(w.Body); err != nil || cfg.Width != 600 || cfg.Height != 800 {
+		t.Fatalf("large thumbnail: %v %+v", err, cfg)
+	}
+	req := httptest.NewRequest(http.MethodGet, large, nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	cached := httptest.NewRecorder()
+	router.ServeHTTP(cached, req)
+	if cached.Code != http.StatusNotModified {
+		t.Fatalf("revalidate: expected 304, got %d", cached.Code)
+	}
+
+	// a new image gets new URLs and the old files go away
+	w = uploadCover(router, path, bearer(t, 99, "admin"), pngBytes(t, 300, 450, color.White))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), large) {
+		t.Fatalf("replace: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "GET", large, "", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("old cover: expected 404, got %d", w.Code)
+	}
+	for _, bad := range []string{"/covers/../secret/large.jpg", "/covers/1-0123456789ab/huge.jpg", "/covers/x/large.png"} {
+		if w := doJSON(router, "GET", bad, "", nil); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", bad, w.Code)
+		}
+	}
+
+	if w := doJSON(router, "DELETE", path, bearer(t, owner, "user"), nil); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(router, "DELETE", path, bearer(t, owner, "user"), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("delete again: expected 404, got %d", w.Code)
+	}
+}
+
+func uploadImport(router *gin.Engine, path, authz, data string, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "books")
+	part.Write([]byte(data))
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, path, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", authz)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestImportJobs(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	router.POST("/api/books/import/csv", h.AuthMiddleware(), h.RequireRole("admin"), h.ImportBooksCSV)
+	imports := router.Group("/api/imports", h.AuthMiddleware(), h.RequireRole("admin"))
+	imports.GET(":id", h.GetImport)
+	imports.GET(":id/errors", h.ImportErrorReport)
+	imports.POST(":id/cancel", h.CancelImport)
+	imports.GET(":id/changes", h.ImportChanges)
+	admin := bearer(t, 1, "admin")
+	author := &models.Author{Name: "Frank Herbert"}
+	_ = r.CreateAuthor(context.Background(), author)
+	data := fmt.Sprintf("ID,Title,Description,AuthorID\n1,Dune,,%d\n2,Dune Messiah,,nobody\n", author.ID)
+
+	if w := uploadImport(router, "/api/books/import/csv", bearer(t, 2, "user"), data, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("user import: expected 403, got %d", w.Code)
+	}
+	if w := uploadImport(router, "/api/books/import/csv", admin, data, map[string]string{"on_error": "sometimes"}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("bad on_error: expected 422, got %d", w.Code)
+	}
+
+	w := uploadImport(router, "/api/books/import/csv", admin, data, map[string]string{"on_error": "skip"})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("import: %d %s", w.Code, w.Body.String())
+	}
+	var job models.ImportJob
+	_ = json.Unmarshal(w.Body.Bytes(), &job)
+	location := w.Header().Get("Location")
+	if location != fmt.Sprintf("/api/imports/%d", job.ID) || job.Status != "running" || job.OnError != "skip" {
+		t.Fatalf("unexpected job %s at %q", w.Body.String(), location)
+	}
+	for deadline := time.Now().Add(5 * time.Second); job.Status == "running"; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("import did not finish")
+		}
+		w = doJSON(router, http.MethodGet, location, admin, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("get import: %d %s", w.Code, w.Body.String())
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &job)
+	}
+	if job.Status != "completed" || job.Total != 2 || job.Processed != 2 || job.Imported != 1 || job.Failed != 1 || job.FinishedAt == nil {
+		t.Fatalf("finished import: %s", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), `"errors"`) {
+		t.Fatalf("progress should not carry the error report: %s", w.Body.String())
+	}
+
+	w = doJSON(router, http.MethodGet, location+"/errors", admin, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" ||
+		!strings.Contains(w.Header().Get("Content-Disposition"), "attachment") ||
+		w.Body.String() != "Line,Title,Error\n3,Dune Messiah,\"invalid author id \"\"nobody\"\"\"\n" {
+		t.Fatalf("error report: %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+	if w = doJSON(router, http.MethodPost, location+"/cancel", admin, nil); w.Code != http.StatusConflict {
+		t.Fatalf("cancel finished import: expected 409, got %d", w.Code)
+	}
+	if w = doJSON(router, http.MethodGet, "/api/imports/999", admin, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("missing import: expected 404, got %d", w.Code)
+	}
+
+	if w = uploadImport(router, "/api/books/import/csv", admin, data, map[string]string{"mapping": "Name=title"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad mapping: expected 400, got %d", w.Code)
+	}
+	if w = uploadImport(router, "/api/books/import/csv", admin, data, map[string]string{"mapping": `{"Name":"title"}`}); w.Code != http.StatusUnprocessableEntity ||
+		!strings.Contains(w.Body.String(), `no \"Name\" column`) {
+		t.Fatalf("mapping a missing column: expected 422, got %d %s", w.Code, w.Body.String())
+	}
+	if w = uploadImport(router, "/api/books/import/csv", admin, data, map[string]string{"dry_run": "maybe"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad dry_run: expected 400, got %d", w.Code)
+	}
+
+	// importing the same rows again as an upsert dry run changes nothing
+	books := len(r.books)
+	w = uploadImport(router, "/api/books/import/csv?mode=upsert&dry_run=true", admin,
+		data+fmt.Sprintf("3,Children of Dune,,%d\n", author.ID), map[string]string{"on_error": "skip"})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("dry run: %d %s", w.Code, w.Body.String())
+	}
+	location = w.Header().Get("Location")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if w = doJSON(router, http.MethodGet, location, admin, nil); !strings.Contains(w.Body.String(), `"status":"running"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dry run did not finish")
+		}
+	}
+	w = doJSON(router, http.MethodGet, location+"/changes", admin, nil)
+	var diff struct {
+		DryRun  bool                  `json:"dry_run"`
+		Created int                   `json:"created"`
+		Skipped int                   `json:"skipped"`
+		Changes []models.ImportChange `json:"changes"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &diff)
+	if w.Code != http.StatusOK || !diff.DryRun || diff.Created != 1 || diff.Skipped != 1 || len(diff.Changes) != 2 ||
+		diff.Changes[0].Reason != "unchanged" || diff.Changes[1].Action != "create" || len(r.books) != books {
+		t.Fatalf("changes: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestExportBooks(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	// exports outlive the per-request database deadline
+	router.Use(DBTimeout(time.Nanosecond))
+	router.GET("/api/books/export/json", h.AuthMiddleware(), h.ExportBooksJSON)
+	router.GET("/api/books/export/csv", h.AuthMiddleware(), h.ExportBooksCSV)
+	router.GET("/api/books/export/ndjson", h.AuthMiddleware(), h.ExportBooksNDJSON)
+	user := bearer(t, 1, "user")
+	for _, b := range []*models.Book{{Title: "Dune", Genres: []string{"sci-fi"}}, {Title: "Emma"}, {Title: "Hyperion", Genres: []string{"sci-fi"}}} {
+		_ = r.CreateBook(context.Background(), b)
+	}
+
+	if w := doJSON(router, "GET", "/api/books/export/json", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous export: expected 401, got %d", w.Code)
+	}
+	if w := doJSON(router, "GET", "/api/books/export/csv?sort=pages", user, nil); w.Code != http.StatusBadRequest || w.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("bad sort: expected a plain 400, got %d %v", w.Code, w.Header())
+	}
+
+	w := doJSON(router, "GET", "/api/books/export/ndjson?genre=Sci-Fi&sort=-id&limit=1", user, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" || w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("ndjson export: %d %v", w.Code, w.Header())
+	}
+	var titles []string
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var b models.Book
+		if err := json.Unmarshal([]byte(line), &b); err != nil {
+			t.Fatalf("ndjson line %q: %v", line, err)
+		}
+		titles = append(titles, b.Title)
+	}
+	if strings.Join(titles, ",") != "Hyperion,Dune" {
+		t.Fatalf("expected the filtered books in sort order without paging, got %v", titles)
+	}
+
+	req := httptest.NewRequest("GET", "/api/books/export/csv", nil)
+	req.Header.Set("Authorization", user)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("gzip export: %d %v", w.Code, w.Header())
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || !strings.HasPrefix(string(data), "ID,Title,") || strings.Count(string(data), "\n") != 4 {
+		t.Fatalf("gzip body: %v %q", err, data)
+	}
+
+	for header, want := range map[string]bool{"": false, "gzip": true, "GZIP ; q=0.5": true, "gzip;q=0": false, "deflate, gzip; q=0.0": false, "x-gzip": false} {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestLibraryImport(t *testing.T) {
+	r := newMemRepo()
+	h := NewHandler(service.NewService(r))
+	router := gin.New()
+	imports := router.Group("/api/imports", h.AuthMiddleware())
+	imports.POST("goodreads", h.ImportGoodreads)
+	imports.POST("librarything", h.ImportLibraryThing)
+	imports.GET(":id", h.GetImport)
+	imports.GET(":id/changes", h.ImportChanges)
+	reader := bearer(t, 2, "user")
+	data := "Title,Author,ISBN13,My Rating,Exclusive Shelf,Bookshelves\n" +
+		"Dune,Frank Herbert,,4,currently-reading,\n" +
+		"Untitled,,,,,\n"
+
+	if w := uploadImport(router, "/api/imports/goodreads", "", data, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous import: expected 401, got %d", w.Code)
+	}
+	if w := uploadImport(router, "/api/imports/librarything", reader, data, map[string]string{"on_error": "rollback"}); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("on_error on a library import: expected 422, got %d", w.Code)
+	}
+	w := uploadImport(router, "/api/imports/goodreads", reader, data, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("import: %d %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	var job models.ImportJob
+	for deadline := time.Now().Add(5 * time.Second); job.Status != "completed"; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("import did not complete: %+v", job)
+		}
+		w = doJSON(router, http.MethodGet, location, reader, nil)
+		_ = json.Unmarshal(w.Body.Bytes(), &job)
+	}
+	if job.Format != "goodreads" || job.Created != 1 || job.Imported != 1 || job.Failed != 1 {
+		t.Fatalf("finished import: %s", w.Body.String())
+	}
+	if w = doJSON(router, http.MethodGet, location, bearer(t, 3, "user"), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("someone else's import: expected 404, got %d", w.Code)
+	}
+	w = doJSON(router, http.MethodGet, location+"/changes", reader, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"action":"create"`) || !strings.Contains(w.Body.String(), `"matched":0`) {
+		t.Fatalf("changes: %d %s", w.Code, w.Body.String())
+	}
+	var dune int
+	for id, b := range r.books {
+		if b.Title == "Dune" && b.AuthorName == "Frank Herbert" {
+			dune = id
+		}
+	}
+	if rs, err := r.GetReadingStatus(context.Background(), 2, dune); err != nil || rs.Status != "reading" {
+		t.Fatalf("reading status of the added book: %+v %v", rs, err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	src := newMemRepo()
+	h := NewHandler(service.NewService(src))
+	router := gin.New()
+	router.GET("/api/admin/backup", h.AuthMiddleware(), h.RequireRole("admin"), h.Backup)
+	router.POST("/api/admin/restore", h.AuthMiddleware(), h.RequireRole("admin"), h.Restore)
+	admin := bearer(t, 1, "admin")
+	ctx := context.Background()
+	_ = src.CreateUser(ctx, &models.User{Email: "reader@example.com", PasswordHash: "hash", Role: "user"})
+	author := &models.Author{Name: "Frank Herbert"}
+	_ = src.CreateAuthor(ctx, author)
+	_ = src.CreateBook(ctx, &models.Book{Title: "Dune", AuthorID: author.ID})
+
+	if w := doJSON(router, http.MethodGet, "/api/admin/backup", bearer(t, 1, "user"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("backup by a user: %d", w.Code)
+	}
+	if w := doJSON(router, http.MethodGet, "/api/admin/backup?password_hashes=maybe", admin, nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad password_hashes: %d", w.Code)
+	}
+	w := doJSON(router, http.MethodGet, "/api/admin/backup", admin, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" ||
+		!strings.Contains(w.Header().Get("Content-Disposition"), "books-backup-") {
+		t.Fatalf("backup: %d %v", w.Code, w.Header())
+	}
+	archive := w.Body.String()
+
+	// the source catalog is not empty
+	if w := uploadImport(router, "/api/admin/restore", admin, archive, nil); w.Code != http.StatusConflict {
+		t.Fatalf("restore into a used catalog: %d %s", w.Code, w.Body.String())
+	}
+
+	dst := newMemRepo()
+	h = NewHandler(service.NewService(dst))
+	router = gin.New()
+	router.POST("/api/admin/restore", h.AuthMiddleware(), h.RequireRole("admin"), h.Restore)
+	if w := uploadImport(router, "/api/admin/restore", admin, "not a zip", nil); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("restore of a non-archive: %d %s", w.Code, w.Body.String())
+	}
+	w = uploadImport(router, "/api/admin/restore", admin, archive, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", w.Code, w.Body.String())
+	}
+	var report service.RestoreReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Counts["books"] != 1 || report.Counts["users"] != 1 || report.UsersWithoutPassword != 1 {
+		t.Fatalf("report: %+v", report)
+	}
+	if len(dst.books) != 1 || dst.users["reader@example.com"] == nil {
+		t.Fatalf("restored: %d books, users %v", len(dst.books), dst.users)
+	}
+}
+
+func TestOPDS(t *testing.T) {
+	r := newMemRepo()
+	svc := service.NewService(r)
+	h := NewHandler(svc)
+	router := gin.New()
+	h.OPDSRoutes(router)
+	ctx := context.Background()
+	owner, err := svc.RegisterUser(ctx, "reader@example.com", "secret", "Reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	author := &models.Author{Name: "Frank Herbert"}
+	_ = r.CreateAuthor(ctx, author)
+	var ids []int
+	for i := 1; i <= 25; i++ {
+		b := &models.Book{Title: fmt.Sprintf("Dune %d", i), AuthorID: author.ID, AuthorName: author.Name,
+			ISBN: fmt.Sprintf("97800000000%02d", i), Cover: "1-0123456789ab"}
+		_ = r.CreateBook(ctx, b)
+		ids = append(ids, b.ID)
+	}
+	private := &models.Shelf{UserID: owner.ID, Name: "Bedside", Visibility: repository.ShelfPrivate}
+	public := &models.Shelf{UserID: owner.ID, Name: "Classics", Visibility: repository.ShelfPublic}
+	_ = r.CreateShelf(ctx, private)
+	_ = r.CreateShelf(ctx, public)
+	_ = r.AddBookToShelf(ctx, private.ID, ids[0])
+
+	get := func(path, user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/opds", "", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), opdsNavigationType) {
+		t.Fatalf("root: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var root struct {
+		Links []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Entries []struct {
+			Title string `xml:"title"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &root); err != nil || len(root.Entries) != 4 {
+		t.Fatalf("root feed: %v %s", err, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `rel="search" href="/opds/opensearch.xml"`) {
+		t.Fatalf("root has no search link: %s", w.Body.String())
+	}
+
+	// newest first, paged
+	w = get("/opds/new?page=2", "", "")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), opdsAcquisitionType) {
+		t.Fatalf("newest: %d %s", w.Code, body)
+	}
+	for _, want := range []string{"<title>Dune 5</title>", "<opensearch:totalResults>25</opensearch:totalResults>",
+		`rel="previous" href="/opds/new"`, `rel="first" href="/opds/new"`, "<dc:identifier>urn:isbn:9780000000005</dc:identifier>",
+		`rel="http://opds-spec.org/image/thumbnail" href="/covers/1-0123456789ab/small.jpg"`, "<name>Frank Herbert</name>"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("newest page 2 lacks %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `rel="next"`) || strings.Contains(body, "<title>Dune 6</title>") {
+		t.Fatalf("newest page 2 should be the last: %s", body)
+	}
+
+	w = get(fmt.Sprintf("/opds/authors/%d", author.ID), "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `rel="next" href="/opds/authors/`+strconv.Itoa(author.ID)+`?page=2"`) {
+		t.Fatalf("author feed: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/opds/authors/nobody", "", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad author id: %d", w.Code)
+	}
+
+	// a page far past the end is empty rather than an overflowed offset
+	const far = "page=9223372036854775807&size=100"
+	for _, path := range []string{"/opds/new?" + far, "/opds/authors?" + far, fmt.Sprintf("/opds/shelves/%d?%s", public.ID, far), "/opds/search?q=dune&" + far} {
+		w := get(path, "", "")
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "<entry>") {
+			t.Fatalf("%s far past the end: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	// private shelves need the account
+	w = get(fmt.Sprintf("/opds/shelves/%d", private.ID), "", "")
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Fatalf("anonymous private shelf: %d %v", w.Code, w.Header())
+	}
+	if w := get(fmt.Sprintf("/opds/shelves/%d", private.ID), "reader@example.com", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: %d", w.Code)
+	}
+	w = get(fmt.Sprintf("/opds/shelves/%d", private.ID), "reader@example.com", "secret")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<title>Dune 1</title>") {
+		t.Fatalf("own private shelf: %d %s", w.Code, w.Body.String())
+	}
+	w = get("/opds/shelves", "", "")
+	if !strings.Contains(w.Body.String(), "Classics") || strings.Contains(w.Body.String(), "Bedside") {
+		t.Fatalf("public shelves: %s", w.Body.String())
+	}
+	if w := get("/opds/my-shelves", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous my shelves: %d", w.Code)
+	}
+	w = get("/opds/my-shelves", "reader@example.com", "secret")
+	if !strings.Contains(w.Body.String(), "Classics") || !strings.Contains(w.Body.String(), "Bedside") {
+		t.Fatalf("my shelves: %s", w.Body.String())
+	}
+
+	w = get("/opds/opensearch.xml", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `template="http://example.com/opds/search?q={searchTerms}"`) {
+		t.Fatalf("opensearch description: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/opds/search?q=dune", "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `rel="next" href="/opds/search?page=2&amp;q=dune"`) {
+		t.Fatalf("search: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("/opds/search", "", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("search without q: %d", w.Code)
+	}
+
+	// OPDS 2.0
+	w = get("/opds/v2/new?size=10", "", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != opds2Type {
+		t.Fatalf("v2 newest: %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var feed struct {
+		Metadata struct {
+			NumberOfItems int `json:"numberOfItems"`
+		} `json:"metadata"`
+		Links []struct {
+			Rel       string `json:"rel"`
+			Href      string `json:"href"`
+			Templated bool   `json:"templated"`
+		} `json:"links"`
+		Publications []struct {
+			Metadata struct {
+				Title      string `json:"title"`
+				Identifier string `json:"identifier"`
+				Author     []struct {
+					Name string `json:"name"`
+				} `json:"author"`
+			} `json:"metadata"`
+			Images []struct {
+				Href string `json:"href"`
+			} `json:"images"`
+		} `json:"publications"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Metadata.NumberOfItems != 25 || len(feed.Publications) != 10 || feed.Publications[0].Metadata.Title != "Dune 25" ||
+		feed.Publications[0].Metadata.Author[0].Name != "Frank Herbert" || len(feed.Publications[0].Images) != 2 {
+		t.Fatalf("v2 feed: %s", w.Body.String())
+	}
+	links := map[string]string{}
+	for _, l := range feed.Links {
+		links[l.Rel] = l.Href
+	}
+	if links["next"] != "/opds/v2/new?page=2&size=10" || links["last"] != "/opds/v2/new?page=3&size=10" || links["search"] != "/opds/v2/search{?query}" {
+		t.Fatalf("v2 links: %v", links)
+	}
+	if w := get("/opds/v2/search?query=dune", "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Dune 1"`) {
+		t.Fatalf("v2 search: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(fmt.Sprintf("/opds/v2/shelves/%d", private.ID), "reader@example.com", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("v2 private shelf: %d", w.Code)
+	}
+}
+
+func TestOPDSLimitsFailedSignIns(t *testing.T) {
+	r := newMemRepo()
+	svc := service.NewService(r)
+	h := NewHandler(svc)
+	now := time.Now()
+	h.opdsFailures.now = func() time.Time { return now }
+	router := gin.New()
+	if err := TrustProxies(router, ""); err != nil {
+		t.Fatal(err)
+	}
+	h.OPDSRoutes(router)
+	ctx := context.Background()
+	for _, email := range []string{"reader@example.com", "other@example.com"} {
+		if _, err := svc.RegisterUser(ctx, email, "secret", "Reader"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requests := 0
+	get := func(addr, user, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/opds/my-shelves", nil)
+		req.RemoteAddr = addr + ":4000"
+		// no proxy is trusted, so a forwarded address is never the client's
+		requests++
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", requests))
+		req.SetBasicAuth(user, password)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// one address trying many accounts
+	for i := 0; i < opdsMaxFailures; i++ {
+		if w := get("192.0.2.1", fmt.Sprintf("user%d@example.com", i), "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	w := get("192.0.2.1", "reader@example.com", "secret")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != strconv.Itoa(int(opdsFailureWindow/time.Second)) {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	if w := get("198.51.100.7", "reader@example.com", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("account from another address: expected 200, got %d", w.Code)
+	}
+
+	// many addresses trying one account
+	now = now.Add(opdsFailureWindow)
+	for i := 0; i < opdsMaxFailures; i++ {
+		if w := get(fmt.Sprintf("198.51.100.%d", i+10), "reader@example.com", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: expected 401, got %d", i+1, w.Code)
+		}
+	}
+	if w := get("192.0.2.1", "reader@example.com", "secret"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("account from a fresh address: expected 429, got %d", w.Code)
+	}
+	if w := get("192.0.2.1", "other@example.com", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("other account: expected 200, got %d", w.Code)
+	}
+
+	now = now.Add(opdsFailureWindow)
+	if w := get("192.0.2.1", "reader@example.com", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("after the window: expected 200, got %d", w.Code)
+	}
+}
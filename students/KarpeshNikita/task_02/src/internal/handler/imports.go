@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/example/books/internal/service"
+	"github.com/example/books/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ImportBooksJSON godoc
+// @Summary Import books from a JSON export
+// @Description Multipart upload in the "file" field, an array of books as exported. The import runs in the background; poll the returned job. Records match existing books by ISBN or by title and primary author: mode=create adds them all, mode=upsert updates matches, mode=skip_existing leaves matches alone. on_error=rollback (default) imports nothing if any record is refused, on_error=skip imports the valid records. dry_run=true only reports the changes.
+// @Tags Imports
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "JSON file"
+// @Param mode formData string false "create (default), upsert or skip_existing"
+// @Param on_error formData string false "rollback (default) or skip"
+// @Param dry_run formData bool false "report the changes without writing them"
+// @Success 202 {object} models.ImportJob
+// @Failure 400 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/books/import/json [post]
+func (h *Handler) ImportBooksJSON(c *gin.Context) {
+	h.startImport(c, service.ImportJSON)
+}
+
+// ImportBooksCSV godoc
+// @Summary Import books from a CSV export
+// @Description Multipart upload in the "file" field. Columns are found by their header, so the export layout needs no options; mapping names the book field of other columns, e.g. {"Название":"title","Год":"published_year"}. The delimiter (, ; tab or |) and the encoding (UTF-8 or Windows-1251) are detected unless given. Refused rows are reported by line number. The import runs in the background; poll the returned job. Rows match existing books by ISBN or by title and primary author: mode=create adds them all, mode=upsert updates matches, mode=skip_existing leaves matches alone. on_error=rollback (default) imports nothing if any row is refused, on_error=skip imports the valid rows. dry_run=true only reports the changes.
+// @Tags Imports
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV file"
+// @Param mode formData string false "create (default), upsert or skip_existing"
+// @Param on_error formData string false "rollback (default) or skip"
+// @Param dry_run formData bool false "report the changes without writing them"
+// @Param delimiter formData string false "field delimiter: a single character or tab; detected from the header when empty"
+// @Param encoding formData string false "utf-8 or windows-1251; detected when empty"
+// @Param mapping formData string false "JSON object mapping header columns to book fields: title, description, author_id, isbn, publisher, published_year, language, page_count, genres, tags, contributors"
+// @Success 202 {object} models.ImportJob
+// @Failure 400 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/books/import/csv [post]
+func (h *Handler) ImportBooksCSV(c *gin.Context) {
+	h.startImport(c, service.ImportCSV)
+}
+
+// ImportGoodreads godoc
+// @Summary Import your Goodreads library
+// @Description Multipart upload in the "file" field of the CSV from Goodreads' "Export Library". Each row is matched to a catalog book by ISBN or by title and author; missing books and authors are added. The exclusive shelf sets your reading status (read, currently-reading, to-read), other shelves become your shelves, and your rating and review become a review. Rows that match no book and cannot add one are reported. The import runs in the background; poll the returned job. dry_run=true only reports what would happen.
+// @Tags Imports
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Goodreads library export"
+// @Param dry_run formData bool false "report the changes without writing them"
+// @Success 202 {object} models.ImportJob
+// @Failure 400 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/imports/goodreads [post]
+func (h *Handler) ImportGoodreads(c *gin.Context) {
+	h.startImport(c, service.ImportGoodreads)
+}
+
+// ImportLibraryThing godoc
+// @Summary Import your LibraryThing library
+// @Description Multipart upload in the "file" field of LibraryThing's tab-separated export. Books are matched and added as for Goodreads. The Currently Reading and To Read collections and the start and read dates set your reading status, other collections except Your library become your shelves, and your rating (half stars round up) and review become a review.
+// @Tags Imports
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "LibraryThing export (TSV)"
+// @Param dry_run formData bool false "report the changes without writing them"
+// @Success 202 {object} models.ImportJob
+// @Failure 400 {object} map[string]string
+// @Failure 413 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/imports/librarything [post]
+func (h *Handler) ImportLibraryThing(c *gin.Context) {
+	h.startImport(c, service.ImportLibraryThing)
+}
+
+func (h *Handler) startImport(c *gin.Context, format string) {
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	// leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, service.MaxImportBytes+64<<10)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			renderError(c, service.ErrImportTooLarge)
+			return
+		}
+		writeProblem(c, http.StatusBadRequest, "file is required")
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	defer f.Close()
+	opts := service.ImportOptions{Format: format, Mode: formOrQuery(c, "mode"), OnError: formOrQuery(c, "on_error"),
+		Delimiter: formOrQuery(c, "delimiter"), Encoding: formOrQuery(c, "encoding")}
+	if v := formOrQuery(c, "mapping"); v != "" {
+		if err := json.Unmarshal([]byte(v), &opts.Mapping); err != nil {
+			writeProblem(c, http.StatusBadRequest, "mapping must be a JSON object of column names to book fields")
+			return
+		}
+	}
+	if v := formOrQuery(c, "dry_run"); v != "" {
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			writeProblem(c, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+	}
+	job, err := h.svc.StartImport(c.Request.Context(), actor, opts, f)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Header("Location", "/api/imports/"+strconv.Itoa(job.ID))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// formOrQuery reads an import option from the multipart form or else the query string.
+func formOrQuery(c *gin.Context, name string) string {
+	if v := c.PostForm(name); v != "" {
+		return v
+	}
+	return c.Query(name)
+}
+
+// importParams reads the import id and the caller.
+func importParams(c *gin.Context) (int, service.Actor, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return 0, service.Actor{}, false
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return 0, service.Actor{}, false
+	}
+	return id, actor, true
+}
+
+// GetImport godoc
+// @Summary Get the progress of an import
+// @Tags Imports
+// @Produce json
+// @Param id path int true "Import ID"
+// @Success 200 {object} models.ImportJob
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/imports/{id} [get]
+func (h *Handler) GetImport(c *gin.Context) {
+	id, actor, ok := importParams(c)
+	if !ok {
+		return
+	}
+	job, err := h.svc.GetImport(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// ImportErrorReport godoc
+// @Summary Download the records an import refused
+// @Description CSV with the record number (CSV rows count the header), title and reason of each refused record.
+// @Tags Imports
+// @Produce text/csv
+// @Param id path int true "Import ID"
+// @Success 200 {file} file
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/imports/{id}/errors [get]
+func (h *Handler) ImportErrorReport(c *gin.Context) {
+	id, actor, ok := importParams(c)
+	if !ok {
+		return
+	}
+	data, err := h.svc.ImportErrorReport(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=import-"+strconv.Itoa(id)+"-errors.csv")
+	c.Data(http.StatusOK, "text/csv", data)
+}
+
+// CancelImport godoc
+// @Summary Cancel a running import
+// @Description Stops the import. With on_error=rollback nothing is imported; with on_error=skip the books imported so far are kept.
+// @Tags Imports
+// @Produce json
+// @Param id path int true "Import ID"
+// @Success 202 {object} models.ImportJob
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/imports/{id}/cancel [post]
+func (h *Handler) CancelImport(c *gin.Context) {
+	id, actor, ok := importParams(c)
+	if !ok {
+		return
+	}
+	job, err := h.svc.CancelImport(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ImportChanges godoc
+// @Summary List what an import did or, in a dry run, would do
+// @Description The create, update or skip action of each record, or match for a library row naming a book already in the catalog; updates list the old and new value of every changed field. At most 1000 records are listed.
+// @Tags Imports
+// @Produce json
+// @Param id path int true "Import ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/imports/{id}/changes [get]
+func (h *Handler) ImportChanges(c *gin.Context) {
+	id, actor, ok := importParams(c)
+	if !ok {
+		return
+	}
+	job, err := h.svc.GetImport(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	changes := job.Changes
+	if changes == nil {
+		changes = models.ImportChanges{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run":   job.DryRun,
+		"created":   job.Created,
+		"updated":   job.Updated,
+		"skipped":   job.Skipped,
+		"matched":   job.Matched,
+		"changes":   changes,
+		"truncated": job.Created+job.Updated+job.Skipped+job.Matched > len(changes),
+	})
+}
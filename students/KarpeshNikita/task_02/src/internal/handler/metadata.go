@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/example/books/internal/service"
+	"github.com/example/books/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// bookRequest is the body of POST and PUT /api/books. PUT replaces every
+// field, so omitted metadata, genres, tags and contributors are cleared.
+type bookRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// AuthorID is deprecated: send Contributors. Alone it credits one author.
+	AuthorID      int                 `json:"author_id"`
+	Contributors  models.Contributors `json:"contributors"`
+	ISBN          string              `json:"isbn"`
+	Publisher     string              `json:"publisher"`
+	PublishedYear int                 `json:"published_year"`
+	Language      string              `json:"language"`
+	PageCount     int                 `json:"page_count"`
+	Genres        []string            `json:"genres"`
+	Tags          []string            `json:"tags"`
+}
+
+func (r bookRequest) model() *service.BookModel {
+	return &service.BookModel{
+		Title:         r.Title,
+		Description:   r.Description,
+		AuthorID:      r.AuthorID,
+		Contributors:  r.Contributors,
+		ISBN:          r.ISBN,
+		Publisher:     r.Publisher,
+		PublishedYear: r.PublishedYear,
+		Language:      r.Language,
+		PageCount:     r.PageCount,
+		Genres:        r.Genres,
+		Tags:          r.Tags,
+	}
+}
+
+// ListGenres godoc
+// @Summary List genres
+// @Description Genres in use, with the number of books in each. Filter books with /api/books?genre=.
+// @Tags Books
+// @Produce json
+// @Success 200 {array} models.LabelCount
+// @Router /api/genres [get]
+func (h *Handler) ListGenres(c *gin.Context) {
+	genres, err := h.svc.ListGenres(c.Request.Context())
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, genres)
+}
+
+// ListTags godoc
+// @Summary List tags
+// @Description Tags in use, with the number of books carrying each. Filter books with /api/books?tag=.
+// @Tags Books
+// @Produce json
+// @Success 200 {array} models.LabelCount
+// @Router /api/tags [get]
+func (h *Handler) ListTags(c *gin.Context) {
+	tags, err := h.svc.ListTags(c.Request.Context())
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
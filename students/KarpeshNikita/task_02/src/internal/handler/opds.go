@@ -0,0 +1,648 @@
+package handler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/internal/service"
+	"github.com/example/books/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// OPDS media types.
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opds2Type           = "application/opds+json"
+	openSearchType      = "application/opensearchdescription+xml"
+)
+
+// opdsPageSize is the default number of entries in a feed page.
+const opdsPageSize = 20
+
+// opdsVersion selects how a feed is written: OPDS 1.2 Atom under /opds, or
+// OPDS 2.0 JSON under /opds/v2.
+type opdsVersion int
+
+const (
+	opds1 opdsVersion = iota + 1
+	opds2
+)
+
+func (v opdsVersion) root() string {
+	if v == opds2 {
+		return "/opds/v2"
+	}
+	return "/opds"
+}
+
+// errOPDSSignIn asks the client for credentials: the feed is private and the
+// request is anonymous.
+var errOPDSSignIn = errors.New("sign in to see this feed")
+
+// OPDSRoutes registers the OPDS catalog for e-reader apps. Every feed is
+// served as OPDS 1.2 Atom under /opds and as OPDS 2.0 JSON under /opds/v2.
+func (h *Handler) OPDSRoutes(r gin.IRouter) {
+	g := r.Group("/opds", h.OPDSAuth())
+	g.GET("/opensearch.xml", h.OPDSSearchDescription)
+	for _, v := range []opdsVersion{opds1, opds2} {
+		vg := g
+		if v == opds2 {
+			vg = g.Group("/v2")
+		}
+		vg.GET("", h.serveOPDS(v, h.opdsRoot))
+		vg.GET("/new", h.serveOPDS(v, h.opdsNewest))
+		vg.GET("/authors", h.serveOPDS(v, h.opdsAuthors))
+		vg.GET("/authors/:id", h.serveOPDS(v, h.opdsAuthor))
+		vg.GET("/shelves", h.serveOPDS(v, h.opdsShelves))
+		vg.GET("/shelves/:id", h.serveOPDS(v, h.opdsShelf))
+		vg.GET("/my-shelves", h.serveOPDS(v, h.opdsMyShelves))
+		vg.GET("/search", h.serveOPDS(v, h.opdsSearch))
+	}
+}
+
+// OPDSAuth identifies OPDS clients. E-readers sign in with HTTP basic auth,
+// the user's email and password; a bearer token works as elsewhere.
+// Anonymous requests see the public catalog.
+func (h *Handler) OPDSAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, password, ok := c.Request.BasicAuth()
+		if !ok {
+			if c.GetHeader("Authorization") != "" && !h.authenticate(c) {
+				return
+			}
+			c.Next()
+			return
+		}
+		ipKey, accountKey := "ip:"+c.ClientIP(), "account:"+strings.ToLower(strings.TrimSpace(email))
+		if wait := h.opdsFailures.blocked(ipKey, accountKey); wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			abortProblem(c, http.StatusTooManyRequests, "too many failed sign-ins, try again later")
+			return
+		}
+		u, err := h.svc.Authenticate(c.Request.Context(), email, password)
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			h.opdsFailures.fail(ipKey, accountKey)
+			opdsChallenge(c, "invalid email or password")
+			return
+		}
+		if err != nil {
+			renderError(c, err)
+			c.Abort()
+			return
+		}
+		h.opdsFailures.reset(accountKey)
+		c.Set("user_id", u.ID)
+		c.Set("role", u.Role)
+		c.Next()
+	}
+}
+
+// opdsChallenge answers 401 with a basic auth challenge, which makes
+// e-readers prompt for the account.
+func opdsChallenge(c *gin.Context, detail string) {
+	c.Header("WWW-Authenticate", `Basic realm="Books", charset="UTF-8"`)
+	abortProblem(c, http.StatusUnauthorized, detail)
+}
+
+// opdsFeed is a feed before it is written in either OPDS version. A
+// navigation feed has nav entries, an acquisition feed has books.
+type opdsFeed struct {
+	path       string     // below the version root, e.g. "/new"; "" for the root
+	query      url.Values // kept in the paging links
+	title      string
+	navigation bool
+	nav        []opdsNav
+	books      []models.Book
+	page       int
+	size       int
+	total      int  // number of entries on all pages; -1 when unknown
+	hasNext    bool // used when total is unknown
+}
+
+type opdsNav struct {
+	path        string
+	title       string
+	summary     string
+	acquisition bool // leads to books rather than more navigation
+}
+
+type opdsLink struct {
+	rel  string
+	path string
+}
+
+// link is the feed's path with the query and, past the first, the page.
+func (f *opdsFeed) link(page int) string {
+	q := url.Values{}
+	for k, v := range f.query {
+		q[k] = v
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	if f.size != 0 && f.size != opdsPageSize {
+		q.Set("size", strconv.Itoa(f.size))
+	}
+	if len(q) == 0 {
+		return f.path
+	}
+	return f.path + "?" + q.Encode()
+}
+
+// pageLinks are the first, previous, next and last links of a paged feed.
+func (f *opdsFeed) pageLinks() []opdsLink {
+	if f.size == 0 {
+		return nil
+	}
+	last := 0
+	if f.total >= 0 {
+		last = max((f.total+f.size-1)/f.size, 1)
+	}
+	var links []opdsLink
+	if f.page > 1 {
+		links = append(links, opdsLink{"first", f.link(1)}, opdsLink{"previous", f.link(f.page - 1)})
+	}
+	if (last > 0 && f.page < last) || (last == 0 && f.hasNext) {
+		links = append(links, opdsLink{"next", f.link(f.page + 1)})
+	}
+	if last > 1 {
+		links = append(links, opdsLink{"last", f.link(last)})
+	}
+	return links
+}
+
+// pageOf returns the items on a 1-based page.
+func pageOf[T any](all []T, page, size int) []T {
+	from := min((page-1)*size, len(all))
+	return all[from:min(from+size, len(all))]
+}
+
+// serveOPDS writes the feed build returns in the given version. A nil feed
+// without an error means build has already answered.
+func (h *Handler) serveOPDS(v opdsVersion, build func(*gin.Context) (*opdsFeed, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f, err := build(c)
+		if errors.Is(err, errOPDSSignIn) {
+			opdsChallenge(c, err.Error())
+			return
+		}
+		if err != nil {
+			renderError(c, err)
+			return
+		}
+		if f == nil {
+			return
+		}
+		if v == opds2 {
+			writeOPDS2(c, f)
+			return
+		}
+		writeOPDS1(c, f)
+	}
+}
+
+func (h *Handler) opdsRoot(c *gin.Context) (*opdsFeed, error) {
+	return &opdsFeed{title: "Books", navigation: true, nav: []opdsNav{
+		{path: "/new", title: "Newest", summary: "Books most recently added to the catalog", acquisition: true},
+		{path: "/authors", title: "By author", summary: "Every author, by name"},
+		{path: "/shelves", title: "Shelves", summary: "Public reading lists"},
+		{path: "/my-shelves", title: "My shelves", summary: "Your shelves and those shared with you"},
+	}}, nil
+}
+
+func (h *Handler) opdsNewest(c *gin.Context) (*opdsFeed, error) {
+	page, size := pageParams(c, opdsPageSize)
+	f := &opdsFeed{path: "/new", title: "Newest", page: page, size: size}
+	return f, h.opdsBooks(c, f, service.BookQuery{Sort: "-created_at"})
+}
+
+// opdsBooks fills an acquisition feed with one page of q.
+func (h *Handler) opdsBooks(c *gin.Context, f *opdsFeed, q service.BookQuery) error {
+	q.Limit, q.Offset = f.size, (f.page-1)*f.size
+	res, err := h.svc.QueryBooks(c.Request.Context(), q)
+	if err != nil {
+		return err
+	}
+	f.books = res.Books
+	f.total, err = h.svc.CountBooks(c.Request.Context(), q)
+	return err
+}
+
+func (h *Handler) opdsAuthors(c *gin.Context) (*opdsFeed, error) {
+	authors, err := h.svc.ListAuthors(c.Request.Context())
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(authors, func(i, j int) bool {
+		return strings.ToLower(authors[i].Name) < strings.ToLower(authors[j].Name)
+	})
+	page, size := pageParams(c, opdsPageSize)
+	f := &opdsFeed{path: "/authors", title: "Authors", navigation: true, page: page, size: size, total: len(authors)}
+	for _, a := range pageOf(authors, page, size) {
+		f.nav = append(f.nav, opdsNav{path: "/authors/" + strconv.Itoa(a.ID), title: a.Name, acquisition: true})
+	}
+	return f, nil
+}
+
+func (h *Handler) opdsAuthor(c *gin.Context) (*opdsFeed, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid id")
+		return nil, nil
+	}
+	a, err := h.svc.GetAuthor(c.Request.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	page, size := pageParams(c, opdsPageSize)
+	f := &opdsFeed{path: "/authors/" + strconv.Itoa(id), title: a.Name, page: page, size: size}
+	return f, h.opdsBooks(c, f, service.BookQuery{AuthorID: id, Sort: "title"})
+}
+
+func (h *Handler) opdsShelves(c *gin.Context) (*opdsFeed, error) {
+	page, size := pageParams(c, opdsPageSize)
+	f := &opdsFeed{path: "/shelves", title: "Shelves", navigation: true, page: page, size: size}
+	return f, h.opdsShelfList(c, f, service.ShelfQuery{Visibility: repository.ShelfPublic})
+}
+
+// opdsMyShelves lists the shelves the signed-in user owns or collaborates
+// on, private ones included.
+func (h *Handler) opdsMyShelves(c *gin.Context) (*opdsFeed, error) {
+	actor, ok := actorFromContext(c)
+	if !ok {
+		return nil, errOPDSSignIn
+	}
+	page, size := pageParams(c, opdsPageSize)
+	f := &opdsFeed{path: "/my-shelves", title: "My shelves", navigation: true, page: page, size: size}
+	return f, h.opdsShelfList(c, f, service.ShelfQuery{MemberID: actor.UserID})
+}
+
+func (h *Handler) opdsShelfList(c *gin.Context, f *opdsFeed, q service.ShelfQuery) error {
+	q.Limit, q.Offset = f.size, (f.page-1)*f.size
+	shelves, total, err := h.svc.QueryShelves(c.Request.Context(), q)
+	if err != nil {
+		return err
+	}
+	f.total = total
+	for _, sh := range shelves {
+		f.nav = append(f.nav, opdsNav{path: "/shelves/" + strconv.Itoa(sh.ID), title: sh.Name, acquisition: true})
+	}
+	return nil
+}
+
+// opdsShelf lists a shelf's books in shelf order. A shelf an anonymous
+// client may not see asks for credentials, as a missing one does, so its
+// existence does not leak.
+func (h *Handler) opdsShelf(c *gin.Context) (*opdsFeed, error) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return nil, nil
+	}
+	actor, signedIn := actorFromContext(c)
+	sh, err := h.svc.GetShelf(c.Request.Context(), actor, id)
+	if errors.Is(err, domain.ErrNotFound) && !signedIn {
+		return nil, errOPDSSignIn
+	}
+	if err != nil {
+		return nil, err
+	}
+	books, err := h.svc.ListBooksByShelf(c.Request.Context(), actor, id)
+	if err != nil {
+		return nil, err
+	}
+	page, size := pageParams(c, opdsPageSize)
+	return &opdsFeed{path: "/shelves/" + strconv.Itoa(id), title: sh.Name, page: page, size: size,
+		total: len(books), books: pageOf(books, page, size)}, nil
+}
+
+// opdsSearch runs a full-text search. OPDS 1.2 clients fill in q from the
+// OpenSearch description, OPDS 2.0 clients fill in query from the templated
+// search link.
+func (h *Handler) opdsSearch(c *gin.Context) (*opdsFeed, error) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		text = strings.TrimSpace(c.Query("query"))
+	}
+	if text == "" {
+		writeProblem(c, http.StatusBadRequest, "q is required")
+		return nil, nil
+	}
+	page, size := pageParams(c, opdsPageSize)
+	res, err := h.svc.SearchBooks(c.Request.Context(), service.SearchQuery{Text: text, Limit: size, Offset: (page - 1) * size})
+	if err != nil {
+		return nil, err
+	}
+	f := &opdsFeed{path: "/search", query: url.Values{"q": {text}}, title: "Search: " + text,
+		page: page, size: size, total: -1, hasNext: res.HasMore}
+	for _, hit := range res.Hits {
+		f.books = append(f.books, hit.Book)
+	}
+	return f, nil
+}
+
+// OPDSSearchDescription serves the OpenSearch description OPDS 1.2 feeds
+// link to. Its template must be an absolute URL, so it is built from the
+// request.
+func (h *Handler) OPDSSearchDescription(c *gin.Context) {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	desc := struct {
+		XMLName     xml.Name `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+		ShortName   string   `xml:"ShortName"`
+		Description string   `xml:"Description"`
+		Encoding    string   `xml:"InputEncoding"`
+		URL         struct {
+			Type     string `xml:"type,attr"`
+			Template string `xml:"template,attr"`
+		} `xml:"Url"`
+	}{ShortName: "Books", Description: "Search the catalog by title, author or description", Encoding: "UTF-8"}
+	desc.URL.Type = opdsAcquisitionType
+	desc.URL.Template = scheme + "://" + c.Request.Host + "/opds/search?q={searchTerms}"
+	writeXML(c, openSearchType, desc)
+}
+
+func writeXML(c *gin.Context, contentType string, v interface{}) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, contentType+"; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+// bookAuthors names a book's authors, falling back to the primary author of
+// books without contributors.
+func bookAuthors(b *models.Book) []models.Contributor {
+	var out []models.Contributor
+	for _, c := range b.Contributors {
+		if c.Role == models.RoleAuthor {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 && b.AuthorName != "" {
+		out = append(out, models.Contributor{AuthorID: b.AuthorID, Name: b.AuthorName, Role: models.RoleAuthor})
+	}
+	return out
+}
+
+// OPDS 1.2 Atom
+
+type atomFeed struct {
+	XMLName    xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	DC         string      `xml:"xmlns:dc,attr"`
+	OpenSearch string      `xml:"xmlns:opensearch,attr"`
+	ID         string      `xml:"id"`
+	Title      string      `xml:"title"`
+	Updated    string      `xml:"updated"`
+	Links      []atomLink  `xml:"link"`
+	Total      int         `xml:"opensearch:totalResults,omitempty"`
+	PerPage    int         `xml:"opensearch:itemsPerPage,omitempty"`
+	Start      int         `xml:"opensearch:startIndex,omitempty"`
+	Entries    []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Identifier string         `xml:"dc:identifier,omitempty"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Content    *atomContent   `xml:"content"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+func writeOPDS1(c *gin.Context, f *opdsFeed) {
+	root := opds1.root()
+	kind := opdsAcquisitionType
+	if f.navigation {
+		kind = opdsNavigationType
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	out := atomFeed{
+		DC: "http://purl.org/dc/terms/", OpenSearch: "http://a9.com/-/spec/opensearch/1.1/",
+		ID: "urn:books:opds" + f.path, Title: f.title, Updated: now,
+		Links: []atomLink{
+			{Rel: "self", Href: root + f.link(f.page), Type: kind},
+			{Rel: "start", Href: root, Type: opdsNavigationType},
+			{Rel: "search", Href: root + "/opensearch.xml", Type: openSearchType},
+		},
+	}
+	for _, l := range f.pageLinks() {
+		out.Links = append(out.Links, atomLink{Rel: l.rel, Href: root + l.path, Type: kind})
+	}
+	if f.size > 0 {
+		out.Total, out.PerPage, out.Start = max(f.total, 0), f.size, (f.page-1)*f.size+1
+	}
+	for _, n := range f.nav {
+		typ := opdsNavigationType
+		if n.acquisition {
+			typ = opdsAcquisitionType
+		}
+		e := atomEntry{ID: "urn:books:opds" + n.path, Title: n.title, Updated: now,
+			Links: []atomLink{{Rel: "subsection", Href: root + n.path, Type: typ}}}
+		if n.summary != "" {
+			e.Content = &atomContent{Type: "text", Text: n.summary}
+		}
+		out.Entries = append(out.Entries, e)
+	}
+	for i := range f.books {
+		out.Entries = append(out.Entries, atomBook(&f.books[i]))
+	}
+	writeXML(c, kind, out)
+}
+
+func atomBook(b *models.Book) atomEntry {
+	root := opds1.root()
+	e := atomEntry{ID: "urn:books:book:" + strconv.Itoa(b.ID), Title: b.Title,
+		Updated: b.CreatedAt.UTC().Format(time.RFC3339), Language: b.Language, Publisher: b.Publisher,
+		Links: []atomLink{{Rel: "alternate", Href: "/books/" + strconv.Itoa(b.ID), Type: "text/html", Title: "Book page"}}}
+	for _, a := range bookAuthors(b) {
+		e.Authors = append(e.Authors, atomAuthor{Name: a.Name, URI: root + "/authors/" + strconv.Itoa(a.AuthorID)})
+		e.Links = append(e.Links, atomLink{Rel: "related", Href: root + "/authors/" + strconv.Itoa(a.AuthorID),
+			Type: opdsAcquisitionType, Title: "More by " + a.Name})
+	}
+	if b.ISBN != "" {
+		e.Identifier = "urn:isbn:" + b.ISBN
+	}
+	if b.PublishedYear != 0 {
+		e.Issued = strconv.Itoa(b.PublishedYear)
+	}
+	for _, g := range b.Genres {
+		e.Categories = append(e.Categories, atomCategory{Term: g, Label: g})
+	}
+	if b.Description != "" {
+		e.Content = &atomContent{Type: "text", Text: b.Description}
+	}
+	if b.Cover != "" {
+		e.Links = append(e.Links,
+			atomLink{Rel: "http://opds-spec.org/image", Href: b.Cover.URL("large"), Type: "image/jpeg"},
+			atomLink{Rel: "http://opds-spec.org/image/thumbnail", Href: b.Cover.URL("small"), Type: "image/jpeg"})
+	}
+	return e
+}
+
+// OPDS 2.0 JSON
+
+type opds2Link struct {
+	Rel        string           `json:"rel,omitempty"`
+	Href       string           `json:"href"`
+	Type       string           `json:"type,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Templated  bool             `json:"templated,omitempty"`
+	Properties *opds2Properties `json:"properties,omitempty"`
+}
+
+type opds2Properties struct {
+	NumberOfItems int `json:"numberOfItems"`
+}
+
+type opds2Feed struct {
+	Metadata struct {
+		Title         string `json:"title"`
+		NumberOfItems *int   `json:"numberOfItems,omitempty"`
+		ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+		CurrentPage   int    `json:"currentPage,omitempty"`
+	} `json:"metadata"`
+	Links        []opds2Link        `json:"links"`
+	Navigation   []opds2Link        `json:"navigation,omitempty"`
+	Publications []opds2Publication `json:"publications,omitempty"`
+}
+
+type opds2Publication struct {
+	Metadata opds2Metadata `json:"metadata"`
+	Links    []opds2Link   `json:"links"`
+	Images   []opds2Link   `json:"images,omitempty"`
+}
+
+type opds2Metadata struct {
+	Type          string             `json:"@type"`
+	Identifier    string             `json:"identifier"`
+	Title         string             `json:"title"`
+	Author        []opds2Contributor `json:"author,omitempty"`
+	Editor        []opds2Contributor `json:"editor,omitempty"`
+	Translator    []opds2Contributor `json:"translator,omitempty"`
+	Illustrator   []opds2Contributor `json:"illustrator,omitempty"`
+	Narrator      []opds2Contributor `json:"narrator,omitempty"`
+	Language      string             `json:"language,omitempty"`
+	Publisher     string             `json:"publisher,omitempty"`
+	Published     string             `json:"published,omitempty"`
+	Modified      string             `json:"modified"`
+	Description   string             `json:"description,omitempty"`
+	NumberOfPages int                `json:"numberOfPages,omitempty"`
+	Subject       []string           `json:"subject,omitempty"`
+}
+
+type opds2Contributor struct {
+	Name  string      `json:"name"`
+	Links []opds2Link `json:"links,omitempty"`
+}
+
+func writeOPDS2(c *gin.Context, f *opdsFeed) {
+	root := opds2.root()
+	var out opds2Feed
+	out.Metadata.Title = f.title
+	if f.size > 0 {
+		out.Metadata.ItemsPerPage, out.Metadata.CurrentPage = f.size, f.page
+		if f.total >= 0 {
+			out.Metadata.NumberOfItems = &f.total
+		}
+	}
+	out.Links = []opds2Link{
+		{Rel: "self", Href: root + f.link(f.page), Type: opds2Type},
+		{Rel: "start", Href: root, Type: opds2Type},
+		{Rel: "search", Href: root + "/search{?query}", Type: opds2Type, Templated: true},
+	}
+	for _, l := range f.pageLinks() {
+		out.Links = append(out.Links, opds2Link{Rel: l.rel, Href: root + l.path, Type: opds2Type})
+	}
+	for _, n := range f.nav {
+		out.Navigation = append(out.Navigation, opds2Link{Rel: "subsection", Href: root + n.path, Type: opds2Type, Title: n.title})
+	}
+	for i := range f.books {
+		out.Publications = append(out.Publications, opds2Book(&f.books[i]))
+	}
+	body, err := json.Marshal(out)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, opds2Type, body)
+}
+
+func opds2Book(b *models.Book) opds2Publication {
+	root := opds2.root()
+	m := opds2Metadata{Type: "http://schema.org/Book", Identifier: "urn:books:book:" + strconv.Itoa(b.ID),
+		Title: b.Title, Language: b.Language, Publisher: b.Publisher, Modified: b.CreatedAt.UTC().Format(time.RFC3339),
+		Description: b.Description, NumberOfPages: b.PageCount, Subject: b.Genres}
+	if b.ISBN != "" {
+		m.Identifier = "urn:isbn:" + b.ISBN
+	}
+	if b.PublishedYear != 0 {
+		m.Published = strconv.Itoa(b.PublishedYear)
+	}
+	credit := func(c models.Contributor) opds2Contributor {
+		return opds2Contributor{Name: c.Name,
+			Links: []opds2Link{{Href: root + "/authors/" + strconv.Itoa(c.AuthorID), Type: opds2Type}}}
+	}
+	for _, c := range bookAuthors(b) {
+		m.Author = append(m.Author, credit(c))
+	}
+	for _, c := range b.Contributors {
+		switch c.Role {
+		case models.RoleEditor:
+			m.Editor = append(m.Editor, credit(c))
+		case models.RoleTranslator:
+			m.Translator = append(m.Translator, credit(c))
+		case models.RoleIllustrator:
+			m.Illustrator = append(m.Illustrator, credit(c))
+		case models.RoleNarrator:
+			m.Narrator = append(m.Narrator, credit(c))
+		}
+	}
+	p := opds2Publication{Metadata: m,
+		Links: []opds2Link{{Rel: "alternate", Href: "/books/" + strconv.Itoa(b.ID), Type: "text/html", Title: "Book page"}}}
+	if b.Cover != "" {
+		for _, size := range []string{"large", "small"} {
+			p.Images = append(p.Images, opds2Link{Href: b.Cover.URL(size), Type: "image/jpeg"})
+		}
+	}
+	return p
+}
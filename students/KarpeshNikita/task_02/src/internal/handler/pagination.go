@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// parseBookQuery reads limit, offset, cursor, sort, author_id, created_after,
+// created_before, genre, tag, lang, year and isbn from the query string.
+func parseBookQuery(c *gin.Context) (service.BookQuery, error) {
+	q := service.BookQuery{Cursor: c.Query("cursor"), Sort: c.Query("sort"),
+		Genre: c.Query("genre"), Tag: c.Query("tag"), Language: c.Query("lang"), ISBN: c.Query("isbn")}
+	var err error
+	if q.Limit, err = intParam(c, "limit"); err != nil {
+		return q, err
+	}
+	if q.Offset, err = intParam(c, "offset"); err != nil {
+		return q, err
+	}
+	if q.AuthorID, err = intParam(c, "author_id"); err != nil {
+		return q, err
+	}
+	if q.CreatedAfter, err = timeParam(c, "created_after"); err != nil {
+		return q, err
+	}
+	if q.CreatedBefore, err = timeParam(c, "created_before"); err != nil {
+		return q, err
+	}
+	if q.Year, err = intParam(c, "year"); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func intParam(c *gin.Context, name string) (int, error) {
+	v := c.Query(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s", name)
+	}
+	return n, nil
+}
+
+// timeParam accepts RFC 3339 timestamps or plain dates
+func timeParam(c *gin.Context, name string) (*time.Time, error) {
+	return parseTime(name, c.Query(name))
+}
+
+// parseTime accepts RFC 3339 timestamps or plain dates; empty input yields nil
+func parseTime(name, v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s", name)
+}
+
+// maxPage keeps (page-1)*size, the offset every caller computes, well
+// inside an int.
+const maxPage = math.MaxInt32 / service.MaxPageSize
+
+// pageParams reads 1-based page and size used by the HTML pages
+func pageParams(c *gin.Context, defaultSize int) (int, int) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	if page > maxPage {
+		page = maxPage
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(defaultSize)))
+	if err != nil || size < 1 {
+		size = defaultSize
+	}
+	if size > service.MaxPageSize {
+		size = service.MaxPageSize
+	}
+	return page, size
+}
+
+// paginationData builds the template fields for Previous / Next navigation
+func paginationData(page, size, total int) gin.H {
+	totalPages := (total + size - 1) / size
+	prevPage := page - 1
+	if prevPage < 1 {
+		prevPage = 1
+	}
+	nextPage := page + 1
+	if nextPage > totalPages {
+		nextPage = totalPages
+	}
+	return gin.H{
+		"page":       page,
+		"size":       size,
+		"total":      total,
+		"totalPages": totalPages,
+		"prevPage":   prevPage,
+		"nextPage":   nextPage,
+		"hasPrev":    page > 1,
+		"hasNext":    page < totalPages,
+	}
+}
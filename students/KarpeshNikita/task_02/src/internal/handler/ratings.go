@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// TopRatedBooks godoc
+// @Summary Top rated books
+// @Description Books ranked by a Bayesian average: every book starts with `weight` virtual ratings equal to the catalog mean, so a single 5-star review does not outrank many good ones.
+// @Tags Books
+// @Produce json
+// @Param limit query int false "Number of books (default 20, max 100)"
+// @Param min_count query int false "Only books with at least this many ratings (default 1)"
+// @Param weight query int false "Virtual ratings added to every book (default 10)"
+// @Success 200 {array} models.RatedBook
+// @Failure 400 {object} map[string]string
+// @Router /api/books/top-rated [get]
+func (h *Handler) TopRatedBooks(c *gin.Context) {
+	var q service.TopRatedQuery
+	var err error
+	for name, dst := range map[string]*int{"limit": &q.Limit, "min_count": &q.MinCount, "weight": &q.Weight} {
+		if *dst, err = intParam(c, name); err != nil {
+			writeProblem(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	books, err := h.svc.TopRatedBooks(c.Request.Context(), q)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, books)
+}
+
+// ratingBar is one row of the rating histogram on the book page
+type ratingBar struct {
+	Stars   int
+	Count   int
+	Percent int
+}
+
+// ratingBars lists histogram rows from 5 stars down to 1
+func ratingBars(b *service.BookModel) []ratingBar {
+	if b.RatingCount == 0 || len(b.RatingHistogram) != 5 {
+		return nil
+	}
+	bars := make([]ratingBar, 0, 5)
+	for stars := 5; stars >= 1; stars-- {
+		n := b.RatingHistogram[stars-1]
+		bars = append(bars, ratingBar{Stars: stars, Count: n, Percent: n * 100 / b.RatingCount})
+	}
+	return bars
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// readingRequest is the body of PUT /api/me/reading/:book_id. Dates accept
+// RFC 3339 timestamps or plain dates.
+type readingRequest struct {
+	Status      string `json:"status"`
+	CurrentPage *int   `json:"current_page"`
+	Percent     *int   `json:"percent"`
+	StartedAt   string `json:"started_at"`
+	FinishedAt  string `json:"finished_at"`
+}
+
+func bookIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("book_id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid book id")
+		return 0, false
+	}
+	return id, true
+}
+
+// ListReading godoc
+// @Summary List my reading statuses
+// @Tags Reading
+// @Produce json
+// @Param status query string false "want_to_read, reading, finished or abandoned"
+// @Success 200 {array} models.ReadingStatus
+// @Failure 401 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/me/reading [get]
+func (h *Handler) ListReading(c *gin.Context) {
+	a, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	items, err := h.svc.ListReading(c.Request.Context(), a.UserID, c.Query("status"))
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// GetReading godoc
+// @Summary Get my reading status of a book with its history
+// @Tags Reading
+// @Produce json
+// @Param book_id path int true "Book ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/me/reading/{book_id} [get]
+func (h *Handler) GetReading(c *gin.Context) {
+	a, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	bookID, ok := bookIDParam(c)
+	if !ok {
+		return
+	}
+	rs, err := h.svc.GetReadingStatus(c.Request.Context(), a.UserID, bookID)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	history, err := h.svc.ReadingHistory(c.Request.Context(), a.UserID, bookID)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": rs, "history": history})
+}
+
+// UpdateReading godoc
+// @Summary Set my reading status or progress for a book
+// @Description Omitted fields keep their value. Moving to reading or finished fills in the start and finish dates.
+// @Tags Reading
+// @Accept json
+// @Produce json
+// @Param book_id path int true "Book ID"
+// @Param payload body map[string]interface{} true "status, current_page, percent, started_at, finished_at"
+// @Success 200 {object} models.ReadingStatus
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/me/reading/{book_id} [put]
+func (h *Handler) UpdateReading(c *gin.Context) {
+	a, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	bookID, ok := bookIDParam(c)
+	if !ok {
+		return
+	}
+	var req readingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	upd := service.ReadingUpdate{Status: req.Status, CurrentPage: req.CurrentPage, Percent: req.Percent}
+	var err error
+	if upd.StartedAt, err = parseTime("started_at", req.StartedAt); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if upd.FinishedAt, err = parseTime("finished_at", req.FinishedAt); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	rs, err := h.svc.SetReadingStatus(c.Request.Context(), a.UserID, bookID, upd)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rs)
+}
+
+// DeleteReading godoc
+// @Summary Remove a book from my reading list
+// @Tags Reading
+// @Param book_id path int true "Book ID"
+// @Success 204
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/me/reading/{book_id} [delete]
+func (h *Handler) DeleteReading(c *gin.Context) {
+	a, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	bookID, ok := bookIDParam(c)
+	if !ok {
+		return
+	}
+	if err := h.svc.ClearReadingStatus(c.Request.Context(), a.UserID, bookID); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
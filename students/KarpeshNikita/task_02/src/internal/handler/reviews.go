@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// reviewIDParam parses the :id path parameter, writing a 400 when it is malformed
+func reviewIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid review id")
+		return 0, false
+	}
+	return id, true
+}
+
+// ListBookReviews godoc
+// @Summary List reviews of a book
+// @Tags Reviews
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param sort query string false "newest (default) or helpful"
+// @Success 200 {array} models.Review
+// @Failure 400 {object} map[string]string
+// @Router /api/books/{id}/reviews [get]
+func (h *Handler) ListBookReviews(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid book id")
+		return
+	}
+	if _, err := h.svc.GetBook(c.Request.Context(), id); err != nil {
+		renderError(c, err)
+		return
+	}
+	reviews, err := h.svc.ListReviews(c.Request.Context(), id, c.Query("sort"))
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reviews)
+}
+
+// UpdateReview godoc
+// @Summary Edit a review
+// @Description Only the review's author or an admin may edit it.
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/reviews/{id} [put]
+func (h *Handler) UpdateReview(c *gin.Context) {
+	id, ok := reviewIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Text   string `json:"text"`
+		Rating int    `json:"rating" binding:"required,min=1,max=5"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	rv, err := h.svc.UpdateReview(c.Request.Context(), actor, id, req.Text, req.Rating)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rv)
+}
+
+// DeleteReview godoc
+// @Summary Delete a review
+// @Description Only the review's author or an admin may delete it.
+// @Tags Reviews
+// @Param id path int true "Review ID"
+// @Success 204
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/reviews/{id} [delete]
+func (h *Handler) DeleteReview(c *gin.Context) {
+	id, ok := reviewIDParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.svc.DeleteReview(c.Request.Context(), actor, id); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// VoteHelpful godoc
+// @Summary Mark a review as helpful
+// @Description Each user counts once; authors cannot vote for their own reviews.
+// @Tags Reviews
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/reviews/{id}/helpful [post]
+func (h *Handler) VoteHelpful(c *gin.Context) {
+	id, ok := reviewIDParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	rv, err := h.svc.VoteHelpful(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rv)
+}
+
+// UnvoteHelpful godoc
+// @Summary Withdraw a helpful vote
+// @Tags Reviews
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/reviews/{id}/helpful [delete]
+func (h *Handler) UnvoteHelpful(c *gin.Context) {
+	id, ok := reviewIDParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	rv, err := h.svc.UnvoteHelpful(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rv)
+}
+
+// ReportReview godoc
+// @Summary Report a review
+// @Description Flags a review for moderators. Enough reports hide it until a moderator decides.
+// @Tags Reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/reviews/{id}/report [post]
+func (h *Handler) ReportReview(c *gin.Context) {
+	id, ok := reviewIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"max=1000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	rv, err := h.svc.ReportReview(c.Request.Context(), actor, id, strings.TrimSpace(req.Reason))
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rv)
+}
+
+// ReviewQueue godoc
+// @Summary Review moderation queue
+// @Tags Moderation
+// @Produce json
+// @Param status query string false "pending (default), approved or rejected"
+// @Param limit query int false "Page size"
+// @Param offset query int false "Rows to skip"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/admin/reviews [get]
+func (h *Handler) ReviewQueue(c *gin.Context) {
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	q := service.ReviewQuery{Status: c.Query("status")}
+	var err error
+	if q.Limit, err = intParam(c, "limit"); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if q.Offset, err = intParam(c, "offset"); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	reviews, total, err := h.svc.ReviewQueue(c.Request.Context(), actor, q)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": reviews, "total": total})
+}
+
+// ReviewReports godoc
+// @Summary Reports filed against a review
+// @Tags Moderation
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {array} models.ReviewReport
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/admin/reviews/{id}/reports [get]
+func (h *Handler) ReviewReports(c *gin.Context) {
+	id, ok := reviewIDParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	reports, err := h.svc.ReviewReports(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, reports)
+}
+
+// ModerateReview godoc
+// @Summary Approve or reject a review
+// @Description Only approved reviews are listed and counted in the book's rating.
+// @Tags Moderation
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Success 200 {object} models.Review
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/admin/reviews/{id} [put]
+func (h *Handler) ModerateReview(c *gin.Context) {
+	id, ok := reviewIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	rv, err := h.svc.ModerateReview(c.Request.Context(), actor, id, req.Status, strings.TrimSpace(req.Note))
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rv)
+}
+
+// ModerationPage renders the review queue; its data comes from the admin API.
+// The page cannot sit behind RequireRole("admin"): browsers keep the token in
+// local storage and send no Authorization header when loading a page. The
+// queue endpoints are guarded instead, and the page script sends everyone but
+// admins away once /api/me answers.
+func (h *Handler) ModerationPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "moderation.html", nil)
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// SearchBooks godoc
+// @Summary Search books
+// @Description Ranked full-text search over title, description and author name. Every word is matched as a prefix. Snippets are HTML-escaped with matches wrapped in <mark>.
+// @Tags Books
+// @Produce json
+// @Param q query string true "Search text"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Rows to skip"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /api/books/search [get]
+func (h *Handler) SearchBooks(c *gin.Context) {
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		writeProblem(c, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit, err := intParam(c, "limit")
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	offset, err := intParam(c, "offset")
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := service.SearchQuery{Text: text, Limit: limit, Offset: offset}
+	q.Normalize()
+	res, err := h.svc.SearchBooks(c.Request.Context(), q)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	resp := gin.H{"items": res.Hits}
+	if res.HasMore {
+		resp["next_offset"] = q.Offset + q.Limit
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// searchHit carries pre-escaped highlight markup to the template
+type searchHit struct {
+	service.BookModel
+	Title   template.HTML
+	Snippet template.HTML
+}
+
+// searchPage renders index.html with search results instead of the latest books
+func (h *Handler) searchPage(c *gin.Context, text string, page, size int) {
+	res, err := h.svc.SearchBooks(c.Request.Context(), service.SearchQuery{Text: text, Limit: size, Offset: (page - 1) * size})
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	hits := make([]searchHit, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		// repository output is already escaped; only <mark> tags are markup
+		hits = append(hits, searchHit{BookModel: hit.Book, Title: template.HTML(hit.HighlightedTitle), Snippet: template.HTML(hit.Snippet)})
+	}
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	c.Header("Pragma", "no-cache")
+	c.HTML(http.StatusOK, "index.html", gin.H{
+		"query":    text,
+		"results":  hits,
+		"page":     page,
+		"size":     size,
+		"prevPage": page - 1,
+		"nextPage": page + 1,
+		"hasPrev":  page > 1,
+		"hasNext":  res.HasMore,
+	})
+}
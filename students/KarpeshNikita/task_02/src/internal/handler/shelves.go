@@ -0,0 +1,407 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/example/books/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// shelfIDParam parses the :id path parameter, writing a 400 when it is malformed
+func shelfIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid shelf id")
+		return 0, false
+	}
+	return id, true
+}
+
+// GetShelf godoc
+// @Summary Get a shelf with its books
+// @Description Public shelves are open to everyone; private and unlisted shelves need the owner, a collaborator or an admin.
+// @Tags Shelves
+// @Produce json
+// @Param id path int true "Shelf ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /api/shelves/{id} [get]
+func (h *Handler) GetShelf(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	actor, _ := actorFromContext(c)
+	shelf, err := h.svc.GetShelf(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	books, err := h.svc.ListBooksByShelf(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"shelf": shelf, "books": books})
+}
+
+// GetSharedShelf godoc
+// @Summary Open a shared shelf
+// @Description Resolves a share link token to the shelf and its books.
+// @Tags Shelves
+// @Produce json
+// @Param token path string true "Share token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /api/shared-shelves/{token} [get]
+func (h *Handler) GetSharedShelf(c *gin.Context) {
+	shelf, books, err := h.svc.SharedShelf(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"shelf": shelf, "books": books})
+}
+
+// shelfPageQuery reads limit and offset from the query string.
+func shelfPageQuery(c *gin.Context) (service.ShelfQuery, error) {
+	var q service.ShelfQuery
+	var err error
+	if q.Limit, err = intParam(c, "limit"); err != nil {
+		return q, err
+	}
+	q.Offset, err = intParam(c, "offset")
+	return q, err
+}
+
+// MyShelves godoc
+// @Summary List my shelves
+// @Description Shelves the caller owns or collaborates on.
+// @Tags Shelves
+// @Produce json
+// @Param limit query int false "Page size"
+// @Param offset query int false "Rows to skip"
+// @Success 200 {object} map[string]interface{}
+// @Security bearerAuth
+// @Router /api/me/shelves [get]
+func (h *Handler) MyShelves(c *gin.Context) {
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	q, err := shelfPageQuery(c)
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.MemberID = actor.UserID
+	shelves, total, err := h.svc.QueryShelves(c.Request.Context(), q)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": shelves, "total": total})
+}
+
+// UpdateShelf godoc
+// @Summary Rename a shelf or change its visibility
+// @Description Only the shelf's owner or an admin may change it. Making a shelf unlisted creates its share token.
+// @Tags Shelves
+// @Accept json
+// @Produce json
+// @Param id path int true "Shelf ID"
+// @Success 200 {object} models.Shelf
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id} [put]
+func (h *Handler) UpdateShelf(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Name       *string `json:"name"`
+		Visibility *string `json:"visibility"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Name == nil && req.Visibility == nil {
+		writeProblem(c, http.StatusBadRequest, "name or visibility is required")
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	shelf, err := h.svc.UpdateShelf(c.Request.Context(), actor, id, service.ShelfUpdate{Name: req.Name, Visibility: req.Visibility})
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, shelf)
+}
+
+// RotateShareToken godoc
+// @Summary Replace a shelf's share link
+// @Description Issues a new share token; links using the old one stop working.
+// @Tags Shelves
+// @Produce json
+// @Param id path int true "Shelf ID"
+// @Success 200 {object} models.Shelf
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id}/share-token [post]
+func (h *Handler) RotateShareToken(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	shelf, err := h.svc.RotateShareToken(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, shelf)
+}
+
+// ListCollaborators godoc
+// @Summary List a shelf's collaborators
+// @Tags Shelves
+// @Produce json
+// @Param id path int true "Shelf ID"
+// @Success 200 {array} models.ShelfCollaborator
+// @Failure 404 {object} map[string]string
+// @Router /api/shelves/{id}/collaborators [get]
+func (h *Handler) ListCollaborators(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	actor, _ := actorFromContext(c)
+	list, err := h.svc.ListCollaborators(c.Request.Context(), actor, id)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// AddCollaborator godoc
+// @Summary Add or update a shelf collaborator
+// @Description Readers can see a restricted shelf; writers can also add, remove, reorder and move its books.
+// @Tags Shelves
+// @Accept json
+// @Produce json
+// @Param id path int true "Shelf ID"
+// @Success 200 {object} models.ShelfCollaborator
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id}/collaborators [post]
+func (h *Handler) AddCollaborator(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Email string `json:"email" binding:"required"`
+		Role  string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	collab, err := h.svc.AddCollaborator(c.Request.Context(), actor, id, req.Email, req.Role)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, collab)
+}
+
+// RemoveCollaborator godoc
+// @Summary Remove a shelf collaborator
+// @Description The owner or an admin may remove anyone; collaborators may remove themselves.
+// @Tags Shelves
+// @Param id path int true "Shelf ID"
+// @Param user_id path int true "User ID"
+// @Success 204
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id}/collaborators/{user_id} [delete]
+func (h *Handler) RemoveCollaborator(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		writeProblem(c, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.svc.RemoveCollaborator(c.Request.Context(), actor, id, userID); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteShelf godoc
+// @Summary Delete a shelf
+// @Description Only the shelf's owner or an admin may delete it. The books stay in the catalog.
+// @Tags Shelves
+// @Param id path int true "Shelf ID"
+// @Success 204
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id} [delete]
+func (h *Handler) DeleteShelf(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.svc.DeleteShelf(c.Request.Context(), actor, id); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveBookFromShelf godoc
+// @Summary Remove a book from a shelf
+// @Tags Shelves
+// @Param id path int true "Shelf ID"
+// @Param book_id path int true "Book ID"
+// @Success 204
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id}/books/{book_id} [delete]
+func (h *Handler) RemoveBookFromShelf(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	bookID, ok := bookIDParam(c)
+	if !ok {
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.svc.RemoveBookFromShelf(c.Request.Context(), actor, id, bookID); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ReorderShelf godoc
+// @Summary Reorder the books on a shelf
+// @Description book_ids lists every book on the shelf once, in the new order.
+// @Tags Shelves
+// @Accept json
+// @Produce json
+// @Param id path int true "Shelf ID"
+// @Success 200 {array} models.Book
+// @Failure 403 {object} map[string]string
+// @Failure 422 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id}/books [put]
+func (h *Handler) ReorderShelf(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		BookIDs []int `json:"book_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	books, err := h.svc.ReorderShelf(c.Request.Context(), actor, id, req.BookIDs)
+	if err != nil {
+		renderError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, books)
+}
+
+// MoveShelfBook godoc
+// @Summary Move a book to another shelf
+// @Description Removes the book from this shelf and appends it to shelf_id in one transaction. The caller must be allowed to edit both shelves.
+// @Tags Shelves
+// @Accept json
+// @Param id path int true "Source shelf ID"
+// @Param book_id path int true "Book ID"
+// @Success 204
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Security bearerAuth
+// @Router /api/shelves/{id}/books/{book_id}/move [post]
+func (h *Handler) MoveShelfBook(c *gin.Context) {
+	id, ok := shelfIDParam(c)
+	if !ok {
+		return
+	}
+	bookID, ok := bookIDParam(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		ShelfID int `json:"shelf_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeProblem(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	actor, ok := actorFromContext(c)
+	if !ok {
+		writeProblem(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if err := h.svc.MoveBook(c.Request.Context(), actor, id, req.ShelfID, bookID); err != nil {
+		renderError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// OPDS readers sign in with basic auth on every request, so failed
+// passwords are limited per client address and per account: after
+// opdsMaxFailures within opdsFailureWindow the key is refused until the
+// window ends, without checking the password.
+const (
+	opdsMaxFailures   = 10
+	opdsFailureWindow = 15 * time.Minute
+	// failureKeys bounds the keys kept before expired ones are swept.
+	failureKeys = 10000
+)
+
+// failureLimiter counts failures per key within a fixed window.
+type failureLimiter struct {
+	mu     sync.Mutex
+	max    int
+	window time.Duration
+	keys   map[string]*failureCount
+	now    func() time.Time
+}
+
+type failureCount struct {
+	n     int
+	until time.Time // when the count resets
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{max: max, window: window, keys: make(map[string]*failureCount), now: time.Now}
+}
+
+// blocked returns how long the first refused key stays refused, or 0 when
+// none of the keys is.
+func (l *failureLimiter) blocked(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var wait time.Duration
+	for _, k := range keys {
+		if f, ok := l.keys[k]; ok && f.n >= l.max && now.Before(f.until) {
+			wait = max(wait, f.until.Sub(now))
+		}
+	}
+	return wait
+}
+
+// fail records a failure against every key.
+func (l *failureLimiter) fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if len(l.keys) >= failureKeys {
+		for k, f := range l.keys {
+			if !now.Before(f.until) {
+				delete(l.keys, k)
+			}
+		}
+	}
+	for _, k := range keys {
+		f, ok := l.keys[k]
+		if !ok || !now.Before(f.until) {
+			f = &failureCount{until: now.Add(l.window)}
+			l.keys[k] = f
+		}
+		f.n++
+	}
+}
+
+// reset forgets the failures of a key, e.g. once the account signed in.
+func (l *failureLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
@@ -0,0 +1,262 @@
+// Package migrate applies versioned SQL migrations and records them in the
+// schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// lockKey identifies the advisory lock held while migrating so that replicas
+// starting at the same time apply migrations one after another.
+const lockKey int64 = 0x626f6f6b73 // "books"
+
+var (
+	// ErrChecksum is returned when an applied migration file was edited afterwards.
+	ErrChecksum = errors.New("migration checksum mismatch")
+	// ErrUnknownVersion is returned when the database has a version this binary does not ship.
+	ErrUnknownVersion = errors.New("unknown applied migration")
+)
+
+// Migration is one numbered schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// AppliedMigration is a row of schema_migrations.
+type AppliedMigration struct {
+	Version   int       `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+?)(\.up|\.down)?\.sql$`)
+
+// Load reads NNN_name.sql (or NNN_name.up.sql) and optional NNN_name.down.sql
+// files from fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == ".down" {
+			mig.Down = string(body)
+			continue
+		}
+		if mig.Up != "" {
+			return nil, fmt.Errorf("migration %d has more than one up file", version)
+		}
+		mig.Up = string(body)
+		sum := sha256.Sum256(body)
+		mig.Checksum = hex.EncodeToString(sum[:])
+	}
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Pending verifies applied migrations against the files and returns the ones
+// still to run. Edited or unknown applied migrations are errors.
+func Pending(migrations []Migration, applied []AppliedMigration) ([]Migration, error) {
+	known := map[int]Migration{}
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+	done := map[int]bool{}
+	for _, a := range applied {
+		m, ok := known[a.Version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrUnknownVersion, a.Version, a.Name)
+		}
+		if m.Checksum != a.Checksum {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrChecksum, a.Version, a.Name)
+		}
+		done[a.Version] = true
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Runner applies migrations to a Postgres database.
+type Runner struct {
+	db         *sqlx.DB
+	migrations []Migration
+	logf       func(format string, args ...interface{})
+}
+
+// New loads migrations from fsys. logf may be nil.
+func New(db *sqlx.DB, fsys fs.FS, logf func(string, ...interface{})) (*Runner, error) {
+	ms, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	return &Runner{db: db, migrations: ms, logf: logf}, nil
+}
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INT PRIMARY KEY,
+    name TEXT NOT NULL,
+    checksum TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT now()
+)`
+
+// withLock runs fn on a single connection holding the migration advisory lock.
+func (r *Runner) withLock(ctx context.Context, fn func(conn *sqlx.Conn) error) error {
+	conn, err := r.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	// unlock on a fresh context so a cancelled ctx still releases the lock
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey) //nolint:errcheck
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func applied(ctx context.Context, conn *sqlx.Conn) ([]AppliedMigration, error) {
+	var rows []AppliedMigration
+	err := conn.SelectContext(ctx, &rows, "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	return rows, err
+}
+
+// Up applies every pending migration, each in its own transaction.
+func (r *Runner) Up(ctx context.Context) error {
+	return r.withLock(ctx, func(conn *sqlx.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		pending, err := Pending(r.migrations, done)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			if err := inTx(ctx, conn, func(tx *sqlx.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1,$2,$3)", m.Version, m.Name, m.Checksum)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %03d_%s: %w", m.Version, m.Name, err)
+			}
+			r.logf("applied migration %03d_%s", m.Version, m.Name)
+		}
+		return nil
+	})
+}
+
+// Down reverts the latest steps applied migrations using their down files.
+func (r *Runner) Down(ctx context.Context, steps int) error {
+	return r.withLock(ctx, func(conn *sqlx.Conn) error {
+		done, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if _, err := Pending(r.migrations, done); err != nil {
+			return err
+		}
+		known := map[int]Migration{}
+		for _, m := range r.migrations {
+			known[m.Version] = m
+		}
+		for i := len(done) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			m := known[done[i].Version]
+			if m.Down == "" {
+				return fmt.Errorf("migration %03d_%s has no down file", m.Version, m.Name)
+			}
+			if err := inTx(ctx, conn, func(tx *sqlx.Tx) error {
+				if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version=$1", m.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("revert %03d_%s: %w", m.Version, m.Name, err)
+			}
+			r.logf("reverted migration %03d_%s", m.Version, m.Name)
+		}
+		return nil
+	})
+}
+
+// Status returns the applied migrations and the ones still pending.
+func (r *Runner) Status(ctx context.Context) ([]AppliedMigration, []Migration, error) {
+	var done []AppliedMigration
+	var pending []Migration
+	err := r.withLock(ctx, func(conn *sqlx.Conn) error {
+		var err error
+		if done, err = applied(ctx, conn); err != nil {
+			return err
+		}
+		pending, err = Pending(r.migrations, done)
+		return err
+	})
+	return done, pending, err
+}
+
+func inTx(ctx context.Context, conn *sqlx.Conn, fn func(tx *sqlx.Tx) error) error {
+	tx, err := conn.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/example/books/migrations"
+)
+
+func TestLoadOrdersAndPairsFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_seed.sql":       {Data: []byte("INSERT 2")},
+		"001_init.up.sql":    {Data: []byte("CREATE 1")},
+		"001_init.down.sql":  {Data: []byte("DROP 1")},
+		"010_later.sql":      {Data: []byte("CREATE 10")},
+		"README.md":          {Data: []byte("ignored")},
+		"notes_001_init.sql": {Data: []byte("ignored")},
+	}
+	ms, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(ms) != 3 || ms[0].Version != 1 || ms[1].Version != 2 || ms[2].Version != 10 {
+		t.Fatalf("unexpected order: %+v", ms)
+	}
+	if ms[0].Name != "init" || ms[0].Up != "CREATE 1" || ms[0].Down != "DROP 1" || ms[0].Checksum == "" {
+		t.Fatalf("unexpected migration: %+v", ms[0])
+	}
+	if ms[1].Down != "" {
+		t.Fatalf("seed should have no down file")
+	}
+}
+
+func TestLoadRejectsBrokenSets(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"down without up": {"001_init.down.sql": {Data: []byte("DROP")}},
+		"duplicate up":    {"001_init.sql": {Data: []byte("A")}, "001_init.up.sql": {Data: []byte("B")}},
+		"name conflict":   {"001_init.sql": {Data: []byte("A")}, "001_other.down.sql": {Data: []byte("B")}},
+	}
+	for name, fsys := range cases {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	ms, err := Load(fstest.MapFS{
+		"001_a.sql": {Data: []byte("A")},
+		"002_b.sql": {Data: []byte("B")},
+		"003_c.sql": {Data: []byte("C")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := Pending(ms, []AppliedMigration{{Version: 1, Name: "a", Checksum: ms[0].Checksum}})
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Version != 2 || pending[1].Version != 3 {
+		t.Fatalf("unexpected pending: %+v", pending)
+	}
+
+	_, err = Pending(ms, []AppliedMigration{{Version: 1, Name: "a", Checksum: "edited"}})
+	if !errors.Is(err, ErrChecksum) {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	_, err = Pending(ms, []AppliedMigration{{Version: 9, Name: "gone", Checksum: "x"}})
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("expected unknown version error, got %v", err)
+	}
+}
+
+// the embedded set must always load so the server can start
+func TestEmbeddedMigrations(t *testing.T) {
+	ms, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatalf("load embedded: %v", err)
+	}
+	for i, m := range ms {
+		if m.Version != i+1 {
+			t.Fatalf("gap in migration versions at %03d_%s", m.Version, m.Name)
+		}
+		if m.Down == "" {
+			t.Errorf("%03d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package moderation screens user-written text against a configurable list of
+// words and regular expressions.
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Action is what happens to text matching a rule. Later constants are stricter.
+type Action int
+
+const (
+	// Allow publishes the text.
+	Allow Action = iota
+	// Hold queues the text for a moderator.
+	Hold
+	// Reject refuses the text outright.
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	}
+	return "allow"
+}
+
+type rule struct {
+	re     *regexp.Regexp
+	action Action
+	source string
+}
+
+// Filter holds the rules. The zero value and a nil *Filter allow everything.
+type Filter struct {
+	rules []rule
+}
+
+// Verdict is the outcome of Check.
+type Verdict struct {
+	Action Action
+	// Rule is the rule that decided the action, as written in the config.
+	Rule string
+}
+
+// Parse reads one rule per line:
+//
+//	# comment
+//	spoiler            hold text containing the word "spoiler"
+//	reject casino      refuse it instead
+//	hold /fr[e3]{2}/   a case-insensitive regular expression
+//
+// Words match whole words regardless of case. Lines without an action hold.
+func Parse(r io.Reader) (*Filter, error) {
+	f := &Filter{}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := f.add(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// MustParse is Parse for rules written in code.
+func MustParse(rules string) *Filter {
+	f, err := Parse(strings.NewReader(rules))
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func (f *Filter) add(line string) error {
+	action := Hold
+	if verb, rest, ok := strings.Cut(line, " "); ok {
+		switch verb {
+		case "hold":
+			line = strings.TrimSpace(rest)
+		case "reject":
+			action, line = Reject, strings.TrimSpace(rest)
+		}
+	}
+	var expr string
+	if len(line) > 1 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/") {
+		expr = "(?i)" + line[1:len(line)-1]
+	} else {
+		// \b only knows ASCII words, so a Cyrillic word would never match
+		expr = `(?i)(?:^|[^\p{L}\p{N}_])` + regexp.QuoteMeta(line) + `(?:$|[^\p{L}\p{N}_])`
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", line, err)
+	}
+	f.rules = append(f.rules, rule{re: re, action: action, source: line})
+	return nil
+}
+
+// Len returns the number of rules.
+func (f *Filter) Len() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.rules)
+}
+
+// Check returns the strictest action of the rules matching text.
+func (f *Filter) Check(text string) Verdict {
+	v := Verdict{Action: Allow}
+	if f == nil {
+		return v
+	}
+	for _, r := range f.rules {
+		if r.action > v.Action && r.re.MatchString(text) {
+			v = Verdict{Action: r.action, Rule: r.source}
+		}
+	}
+	return v
+}
+
+// FromEnv loads rules from the file named by REVIEW_FILTER_FILE and adds the
+// comma-separated REVIEW_FILTER_WORDS, which hold matching text for review.
+func FromEnv() (*Filter, error) {
+	f := &Filter{}
+	if path := os.Getenv("REVIEW_FILTER_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if f, err = Parse(file); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, w := range strings.Split(os.Getenv("REVIEW_FILTER_WORDS"), ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			if err := f.add("hold " + w); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,63 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilterCheck(t *testing.T) {
+	f := MustParse(`
+# words hold by default
+spoiler
+спойлер
+reject casino
+hold /fr[e3]{2}\s+money/
+`)
+	if f.Len() != 4 {
+		t.Fatalf("expected 4 rules, got %d", f.Len())
+	}
+	cases := []struct {
+		text   string
+		action Action
+		rule   string
+	}{
+		{"A fine book", Allow, ""},
+		{"Big SPOILER ahead", Hold, "spoiler"},
+		{"no spoilers here", Allow, ""},
+		{"get fr33 money", Hold, "/fr[e3]{2}\\s+money/"},
+		{"spoiler: visit my casino", Reject, "casino"},
+		{"Осторожно, СПОЙЛЕР!", Hold, "спойлер"},
+		{"спойлер", Hold, "спойлер"},
+		{"без спойлеров", Allow, ""},
+	}
+	for _, c := range cases {
+		v := f.Check(c.text)
+		if v.Action != c.action || v.Rule != c.rule {
+			t.Errorf("%q: got %v %q, want %v %q", c.text, v.Action, v.Rule, c.action, c.rule)
+		}
+	}
+
+	var none *Filter
+	if v := none.Check("casino"); v.Action != Allow {
+		t.Fatalf("nil filter must allow, got %v", v.Action)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse(strings.NewReader("ok\nreject /(unclosed/\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("REVIEW_FILTER_FILE", "")
+	t.Setenv("REVIEW_FILTER_WORDS", "foo, bar baz")
+	f, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Check("say bar baz").Action != Hold || f.Check("food").Action != Allow {
+		t.Fatalf("unexpected rules from env")
+	}
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Tables a backup holds.
+const (
+	BackupUsers      = "users"
+	BackupAuthors    = "authors"
+	BackupBooks      = "books"
+	BackupShelves    = "shelves"
+	BackupShelfBooks = "shelf_books"
+	BackupReviews    = "reviews"
+)
+
+// BackupTables lists the backed-up tables in the order a restore loads them,
+// each after the tables it refers to.
+var BackupTables = []string{BackupUsers, BackupAuthors, BackupBooks, BackupShelves, BackupShelfBooks, BackupReviews}
+
+// backupQueries read each table in BackupTables order into a new row value.
+var backupQueries = []struct {
+	table string
+	query string
+	row   func() interface{}
+}{
+	{BackupUsers, `SELECT id, email, password_hash, COALESCE(name, '') AS name, COALESCE(role, 'user') AS role
+		FROM users ORDER BY id`, func() interface{} { return new(models.User) }},
+	{BackupAuthors, `SELECT id, name FROM authors ORDER BY id`, func() interface{} { return new(models.Author) }},
+	{BackupBooks, `SELECT ` + bookColumns + ` FROM books b ORDER BY b.id`, func() interface{} { return new(models.Book) }},
+	{BackupShelves, `SELECT id, user_id, name, visibility, share_token FROM shelves ORDER BY id`,
+		func() interface{} { return new(models.Shelf) }},
+	{BackupShelfBooks, `SELECT shelf_id, book_id, position, added_at FROM shelf_books ORDER BY shelf_id, position, book_id`,
+		func() interface{} { return new(models.ShelfBook) }},
+	{BackupReviews, reviewSelect + ` ORDER BY rv.id`, func() interface{} { return new(models.Review) }},
+}
+
+// Backup passes every row of the backed-up tables to fn, table by table. The
+// rows are *models.User (with the password hash), *models.Author,
+// *models.Book, *models.Shelf, *models.ShelfBook and *models.Review, all read
+// from one snapshot so they refer to each other consistently.
+func (r *PostgresRepository) Backup(ctx context.Context, fn func(table string, row interface{}) error) error {
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for _, q := range backupQueries {
+		if err := backupTable(ctx, tx, q.query, q.row, func(row interface{}) error { return fn(q.table, row) }); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func backupTable(ctx context.Context, tx *sqlx.Tx, query string, newRow func() interface{}, fn func(interface{}) error) error {
+	rows, err := tx.QueryxContext(ctx, query)
+	if err != nil {
+		return dbError(err, "backup")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		row := newRow()
+		if err := rows.StructScan(row); err != nil {
+			return dbError(err, "backup")
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return dbError(rows.Err(), "backup")
+}
+
+// Restorer writes the rows of a backup inside Restore's transaction. The
+// inserts keep the given values apart from the ID, which they fill in.
+type Restorer interface {
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	InsertUser(ctx context.Context, u *models.User) error // the password hash is stored as given
+	InsertAuthor(ctx context.Context, a *models.Author) error
+	InsertBook(ctx context.Context, b *models.Book) error // with its cover, owner and creation time
+	InsertShelf(ctx context.Context, s *models.Shelf) error
+	InsertShelfBook(ctx context.Context, sb *models.ShelfBook) error
+	InsertReview(ctx context.Context, rv *models.Review) error // counted in the book's ratings when approved
+}
+
+// Restore runs fn in one transaction against an empty catalog: no authors,
+// books, shelves or reviews apart from the sample books the seed migration
+// adds, which are removed. Users may exist. Nothing is kept if fn fails.
+func (r *PostgresRepository) Restore(ctx context.Context, fn func(Restorer) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	// nothing may add to the catalog between the check and the commit
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE authors, books, shelves, reviews IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return dbError(err, "backup")
+	}
+	var used bool
+	if err := tx.GetContext(ctx, &used, `SELECT EXISTS (SELECT 1 FROM shelves) OR EXISTS (SELECT 1 FROM reviews)`); err != nil {
+		return dbError(err, "backup")
+	}
+	if !used {
+		// as in 002_seed.down.sql
+		_, err = tx.ExecContext(ctx, `DELETE FROM books WHERE title IN ('Crime and Punishment', 'Pride and Prejudice') AND created_by IS NULL`)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM authors a WHERE a.name IN ('Fyodor Dostoevsky', 'Jane Austen')
+				AND NOT EXISTS (SELECT 1 FROM books b WHERE b.author_id = a.id)`)
+		}
+		if err != nil {
+			return dbError(err, "backup")
+		}
+		if err := tx.GetContext(ctx, &used, `SELECT EXISTS (SELECT 1 FROM authors) OR EXISTS (SELECT 1 FROM books)`); err != nil {
+			return dbError(err, "backup")
+		}
+	}
+	if used {
+		return domain.Conflict("a backup can only be restored into an empty catalog")
+	}
+	if err := fn(&restoreTx{tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type restoreTx struct {
+	tx *sqlx.Tx
+}
+
+func (t *restoreTx) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	var u models.User
+	err := t.tx.GetContext(ctx, &u, `SELECT id, email, password_hash, COALESCE(name, '') AS name,
+		COALESCE(role, 'user') AS role FROM users WHERE email=$1`, email)
+	if err != nil {
+		return nil, dbError(err, "user")
+	}
+	return &u, nil
+}
+
+func (t *restoreTx) InsertUser(ctx context.Context, u *models.User) error {
+	row := t.tx.QueryRowxContext(ctx, "INSERT INTO users (email, password_hash, name, role) VALUES ($1,$2,$3,$4) RETURNING id",
+		u.Email, u.PasswordHash, u.Name, u.Role)
+	return dbError(row.Scan(&u.ID), "user")
+}
+
+func (t *restoreTx) InsertAuthor(ctx context.Context, a *models.Author) error {
+	row := t.tx.QueryRowxContext(ctx, "INSERT INTO authors (name) VALUES ($1) RETURNING id", a.Name)
+	return dbError(row.Scan(&a.ID), "author")
+}
+
+func (t *restoreTx) InsertBook(ctx context.Context, b *models.Book) error {
+	row := t.tx.QueryRowxContext(ctx, `INSERT INTO books (title, description, author_id, created_by, created_at, isbn,
+		publisher, published_year, language, page_count, cover)
+		VALUES ($1,$2,NULLIF($3,0),$4,$5,NULLIF($6,''),NULLIF($7,''),NULLIF($8,0),NULLIF($9,''),NULLIF($10,0),NULLIF($11,''))
+		RETURNING id`,
+		b.Title, b.Description, b.AuthorID, b.CreatedBy, b.CreatedAt, b.ISBN, b.Publisher, b.PublishedYear, b.Language,
+		b.PageCount, string(b.Cover))
+	if err := row.Scan(&b.ID); err != nil {
+		return dbError(err, "book")
+	}
+	if err := setBookLabels(ctx, t.tx, b); err != nil {
+		return err
+	}
+	return setBookContributors(ctx, t.tx, b)
+}
+
+func (t *restoreTx) InsertShelf(ctx context.Context, s *models.Shelf) error {
+	row := t.tx.QueryRowxContext(ctx, "INSERT INTO shelves (user_id, name, visibility, share_token) VALUES ($1,$2,$3,$4) RETURNING id",
+		s.UserID, s.Name, s.Visibility, s.ShareToken)
+	return dbError(row.Scan(&s.ID), "shelf")
+}
+
+func (t *restoreTx) InsertShelfBook(ctx context.Context, sb *models.ShelfBook) error {
+	_, err := t.tx.ExecContext(ctx, "INSERT INTO shelf_books (shelf_id, book_id, position, added_at) VALUES ($1,$2,$3,$4)",
+		sb.ShelfID, sb.BookID, sb.Position, sb.AddedAt)
+	return dbError(err, "shelf entry")
+}
+
+// InsertReview restores a review without its helpful votes and reports,
+// which are not backed up, so both counts start at zero.
+func (t *restoreTx) InsertReview(ctx context.Context, rv *models.Review) error {
+	row := t.tx.QueryRowxContext(ctx, `INSERT INTO reviews (user_id, book_id, text, rating, status, moderation_note,
+		moderated_by, moderated_at, created_at, updated_at)
+		VALUES ($1,$2,$3,NULLIF($4,0),$5,NULLIF($6,''),$7,$8,$9,$10) RETURNING id`,
+		rv.UserID, rv.BookID, rv.Text, rv.Rating, rv.Status, rv.ModerationNote, rv.ModeratedBy, rv.ModeratedAt,
+		rv.CreatedAt, rv.UpdatedAt)
+	if err := row.Scan(&rv.ID); err != nil {
+		return dbError(err, "review")
+	}
+	rv.HelpfulCount, rv.ReportCount = 0, 0
+	return replaceRating(ctx, t.tx, rv.BookID, 0, countedRating(rv.Status, rv.Rating))
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/example/books/pkg/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// bookContributors aggregates a book's contributors, in credit order, into a JSON array for models.Contributors.
+const bookContributors = `(SELECT COALESCE(json_agg(json_build_object('author_id', bc.author_id, 'name', ca.name, 'role', bc.role) ORDER BY bc.position), '[]')
+	FROM book_contributors bc JOIN authors ca ON ca.id = bc.author_id WHERE bc.book_id = b.id)`
+
+// contributedBy matches books the author contributed to in any role.
+func contributedBy(args *sqlArgs, authorID int) string {
+	return "EXISTS (SELECT 1 FROM book_contributors bc WHERE bc.book_id = b.id AND bc.author_id = " + args.add(authorID) + ")"
+}
+
+// setBookContributors replaces the contributors of one book and re-indexes it
+// for search, since the search vector includes their names.
+func setBookContributors(ctx context.Context, tx sqlx.ExtContext, b *models.Book) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM book_contributors WHERE book_id=$1", b.ID); err != nil {
+		return dbError(err, "book")
+	}
+	ids := make([]int64, len(b.Contributors))
+	roles := make([]string, len(b.Contributors))
+	for i, c := range b.Contributors {
+		ids[i], roles[i] = int64(c.AuthorID), c.Role
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO book_contributors (book_id, author_id, role, position)
+		SELECT $1, x.author_id, x.role, x.ord - 1 FROM unnest($2::int[], $3::text[]) WITH ORDINALITY AS x(author_id, role, ord)`,
+		b.ID, pq.Array(ids), pq.Array(roles)); err != nil {
+		return dbError(err, "contributor")
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE books SET title = title WHERE id=$1", b.ID)
+	return dbError(err, "book")
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/example/books/internal/domain"
+	"github.com/lib/pq"
+)
+
+// Postgres error codes translated into domain errors.
+const (
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+	pqNotNullViolation    = "23502"
+	pqCheckViolation      = "23514"
+	pqStringTooLong       = "22001"
+)
+
+// dbError turns driver errors into domain errors so callers never see SQL text.
+// entity names the record for the message, e.g. "book". Unrecognised errors are
+// returned unchanged and end up as internal errors.
+func dbError(err error, entity string) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Wrap(domain.ErrNotFound, entity+" not found", err)
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case pqUniqueViolation:
+		return domain.Wrap(domain.ErrConflict, entity+" already exists", err)
+	case pqForeignKeyViolation:
+		// deleting a row others point at vs. pointing at a row that does not exist
+		if strings.Contains(pqErr.Detail, "still referenced") {
+			return domain.Wrap(domain.ErrConflict, entity+" is still in use", err)
+		}
+		return domain.Wrap(domain.ErrValidation, entity+" references a record that does not exist", err)
+	case pqNotNullViolation, pqCheckViolation, pqStringTooLong:
+		return domain.Wrap(domain.ErrValidation, "invalid "+entity, err)
+	}
+	return err
+}
+
+// execOne runs a statement that must touch exactly one row; otherwise the
+// entity is reported as not found.
+func (r *PostgresRepository) execOne(ctx context.Context, entity, query string, args ...interface{}) error {
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return dbError(err, entity)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return domain.NotFound(entity + " not found")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/example/books/internal/domain"
+	"github.com/lib/pq"
+)
+
+func TestDBError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+		msg  string
+	}{
+		{sql.ErrNoRows, domain.ErrNotFound, "book not found"},
+		{fmt.Errorf("scan: %w", sql.ErrNoRows), domain.ErrNotFound, "book not found"},
+		{&pq.Error{Code: pqUniqueViolation, Constraint: "books_title_key"}, domain.ErrConflict, "book already exists"},
+		{&pq.Error{Code: pqForeignKeyViolation, Detail: `Key (author_id)=(9) is not present in table "authors".`}, domain.ErrValidation, "book references a record that does not exist"},
+		{&pq.Error{Code: pqForeignKeyViolation, Detail: `Key (id)=(1) is still referenced from table "reviews".`}, domain.ErrConflict, "book is still in use"},
+		{&pq.Error{Code: pqCheckViolation, Message: `new row violates check constraint "reviews_rating_check"`}, domain.ErrValidation, "invalid book"},
+	}
+	for _, tc := range cases {
+		err := dbError(tc.err, "book")
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("%v: expected kind %v, got %v", tc.err, tc.kind, err)
+		}
+		if err.Error() != tc.msg {
+			t.Errorf("%v: expected message %q, got %q", tc.err, tc.msg, err.Error())
+		}
+		if strings.Contains(err.Error(), "constraint") || strings.Contains(err.Error(), "table") {
+			t.Errorf("message leaks SQL details: %q", err.Error())
+		}
+	}
+
+	plain := errors.New("connection refused")
+	if dbError(plain, "book") != plain {
+		t.Fatalf("unknown errors must pass through unchanged")
+	}
+	if dbError(nil, "book") != nil {
+		t.Fatalf("nil must stay nil")
+	}
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/example/books/pkg/models"
+)
+
+const importJobSelect = `SELECT id, user_id, format, mode, on_error, dry_run, status, total, processed,
+	created, updated, skipped, matched, imported, failed, error, errors, changes, created_at, finished_at FROM import_jobs`
+
+func (r *PostgresRepository) CreateImportJob(ctx context.Context, j *models.ImportJob) error {
+	row := r.db.QueryRowxContext(ctx, `INSERT INTO import_jobs (user_id, format, mode, on_error, dry_run, status)
+		VALUES ($1,$2,$3,$4,$5,$6) RETURNING id, created_at`, j.UserID, j.Format, j.Mode, j.OnError, j.DryRun, j.Status)
+	return dbError(row.Scan(&j.ID, &j.CreatedAt), "import")
+}
+
+func (r *PostgresRepository) GetImportJob(ctx context.Context, id int) (*models.ImportJob, error) {
+	var j models.ImportJob
+	if err := r.db.GetContext(ctx, &j, importJobSelect+" WHERE id=$1", id); err != nil {
+		return nil, dbError(err, "import")
+	}
+	return &j, nil
+}
+
+// UpdateImportJob saves the job's status, counters and reports.
+func (r *PostgresRepository) UpdateImportJob(ctx context.Context, j *models.ImportJob) error {
+	return r.execOne(ctx, "import", `UPDATE import_jobs SET status=$1, total=$2, processed=$3, created=$4, updated=$5,
+		skipped=$6, matched=$7, imported=$8, failed=$9, error=$10, errors=$11, changes=$12, finished_at=$13 WHERE id=$14`,
+		j.Status, j.Total, j.Processed, j.Created, j.Updated, j.Skipped, j.Matched, j.Imported, j.Failed, j.Error,
+		j.Errors, j.Changes, j.FinishedAt, j.ID)
+}
+
+func (r *PostgresRepository) CancelImportJob(ctx context.Context, id int) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE import_jobs SET status='cancelled', finished_at=now()
+		WHERE id=$1 AND status='running'`, id)
+	if err != nil {
+		return false, dbError(err, "import")
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// InterruptImportJobs fails the imports still marked running, which only
+// happens when the process running them stopped.
+func (r *PostgresRepository) InterruptImportJobs(ctx context.Context, reason string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE import_jobs SET status='failed', error=$1, finished_at=now()
+		WHERE status='running'`, reason)
+	return dbError(err, "import")
+}
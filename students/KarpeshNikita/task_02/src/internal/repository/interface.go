@@ -1,23 +1,88 @@
 package repository
 
-import "github.com/example/books/pkg/models"
+import (
+	"context"
+	"time"
+
+	"github.com/example/books/pkg/models"
+)
 
 type Repository interface {
-	CreateUser(u *models.User) error
-	GetUserByEmail(email string) (*models.User, error)
-	CreateAuthor(a *models.Author) error
-	ListBooks() ([]models.Book, error)
-	CreateBook(b *models.Book) error
-	GetBook(id int) (*models.Book, error)
-	UpdateBook(b *models.Book) error
-	DeleteBook(id int) error
-	CreateShelf(s *models.Shelf) error
-	ListShelves() ([]models.Shelf, error)
-	GetShelf(id int) (*models.Shelf, error)
-	ListBooksByShelf(shelfID int) ([]models.Book, error)
-	AddBookToShelf(shelfID int, bookID int) error
-	GetUserByID(id int) (*models.User, error)
-	UpdateUserRole(userID int, role string) error
-	CreateReview(r *models.Review) error
-	ListReviewsByBook(bookID int) ([]models.Review, error)
+	CreateUser(ctx context.Context, u *models.User) error
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	CreateAuthor(ctx context.Context, a *models.Author) error
+	ListAuthors(ctx context.Context) ([]models.Author, error)
+	GetAuthor(ctx context.Context, id int) (*models.Author, error)
+	FindAuthorByName(ctx context.Context, name string) (*models.Author, error)
+	UpdateAuthor(ctx context.Context, a *models.Author) error
+	DeleteAuthor(ctx context.Context, id int) error
+	ListBooksByAuthor(ctx context.Context, authorID int) ([]models.Book, error)
+	ListBooks(ctx context.Context) ([]models.Book, error)
+	QueryBooks(ctx context.Context, q BookQuery) (*BookPage, error)
+	CountBooks(ctx context.Context, q BookQuery) (int, error)
+	StreamBooks(ctx context.Context, q BookQuery, fn func(*models.Book) error) error
+	SearchBooks(ctx context.Context, q SearchQuery) (*SearchPage, error)
+	CreateBook(ctx context.Context, b *models.Book) error
+	GetBook(ctx context.Context, id int) (*models.Book, error)
+	UpdateBook(ctx context.Context, b *models.Book) error
+	DeleteBook(ctx context.Context, id int) error
+	SetBookCover(ctx context.Context, bookID int, cover string) error
+	SaveBooks(ctx context.Context, books []*models.Book) (int, error)
+	FindBookByKey(ctx context.Context, isbn, title string, authorID int) (*models.Book, error)
+	CreateImportJob(ctx context.Context, j *models.ImportJob) error
+	GetImportJob(ctx context.Context, id int) (*models.ImportJob, error)
+	UpdateImportJob(ctx context.Context, j *models.ImportJob) error
+	// CancelImportJob marks a running import cancelled and reports whether it
+	// did; false means the import had already finished.
+	CancelImportJob(ctx context.Context, id int) (bool, error)
+	InterruptImportJobs(ctx context.Context, reason string) error
+	ListGenres(ctx context.Context) ([]models.LabelCount, error)
+	ListTags(ctx context.Context) ([]models.LabelCount, error)
+	CreateShelf(ctx context.Context, s *models.Shelf) error
+	QueryShelves(ctx context.Context, q ShelfQuery) ([]models.Shelf, error)
+	CountShelves(ctx context.Context, q ShelfQuery) (int, error)
+	GetShelf(ctx context.Context, id int) (*models.Shelf, error)
+	ListBooksByShelf(ctx context.Context, shelfID int) ([]models.Book, error)
+	AddBookToShelf(ctx context.Context, shelfID int, bookID int) error
+	UpdateShelf(ctx context.Context, s *models.Shelf) error
+	DeleteShelf(ctx context.Context, id int) error
+	RemoveBookFromShelf(ctx context.Context, shelfID, bookID int) error
+	ReorderShelf(ctx context.Context, shelfID int, bookIDs []int) error
+	MoveBook(ctx context.Context, fromShelfID, toShelfID, bookID int) error
+	GetShelfByShareToken(ctx context.Context, token string) (*models.Shelf, error)
+	ListShelfCollaborators(ctx context.Context, shelfID int) ([]models.ShelfCollaborator, error)
+	GetShelfCollaborator(ctx context.Context, shelfID, userID int) (*models.ShelfCollaborator, error)
+	SaveShelfCollaborator(ctx context.Context, c *models.ShelfCollaborator) error
+	RemoveShelfCollaborator(ctx context.Context, shelfID, userID int) error
+	GetUserByID(ctx context.Context, id int) (*models.User, error)
+	UpdateUserRole(ctx context.Context, userID int, role string) error
+	CreateReview(ctx context.Context, r *models.Review) error
+	TopRatedBooks(ctx context.Context, q TopRatedQuery) ([]models.RatedBook, error)
+	GetReview(ctx context.Context, id int) (*models.Review, error)
+	UpdateReview(ctx context.Context, r *models.Review) error
+	DeleteReview(ctx context.Context, id int) error
+	ListReviewsByBook(ctx context.Context, bookID int, sort string) ([]models.Review, error)
+	AddReviewVote(ctx context.Context, reviewID, userID int) error
+	RemoveReviewVote(ctx context.Context, reviewID, userID int) error
+	SetReviewStatus(ctx context.Context, id int, status string, moderatorID *int, note string) error
+	QueryReviews(ctx context.Context, q ReviewQuery) ([]models.Review, error)
+	CountReviews(ctx context.Context, q ReviewQuery) (int, error)
+	ReportReview(ctx context.Context, r *models.ReviewReport) (int, error)
+	ListReviewReports(ctx context.Context, reviewID int) ([]models.ReviewReport, error)
+	GetReadingStatus(ctx context.Context, userID, bookID int) (*models.ReadingStatus, error)
+	ListReadingStatuses(ctx context.Context, userID int, status string) ([]models.ReadingStatus, error)
+	SaveReadingStatus(ctx context.Context, rs *models.ReadingStatus, statusChanged bool) error
+	DeleteReadingStatus(ctx context.Context, userID, bookID int) error
+	ListReadingHistory(ctx context.Context, userID, bookID int) ([]models.ReadingEvent, error)
+	CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// RevokeRefreshToken reports whether this call revoked the token; false
+	// means it was already revoked, possibly by a concurrent rotation.
+	RevokeRefreshToken(ctx context.Context, id int) (bool, error)
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	SetUserTokenCutoff(ctx context.Context, userID int, notBefore time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error)
+	Backup(ctx context.Context, fn func(table string, row interface{}) error) error
+	Restore(ctx context.Context, fn func(Restorer) error) error
 }
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/example/books/pkg/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// bookGenres and bookTags aggregate a book's labels into JSON arrays for models.Labels.
+const (
+	bookGenres = `(SELECT COALESCE(json_agg(g.name ORDER BY g.name), '[]') FROM book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE bg.book_id = b.id)`
+	bookTags   = `(SELECT COALESCE(json_agg(t.name ORDER BY t.name), '[]') FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE bt.book_id = b.id)`
+)
+
+// labelTable describes one many-to-many label relation.
+type labelTable struct {
+	table, link, column string
+}
+
+var (
+	genreLabels = labelTable{table: "genres", link: "book_genres", column: "genre_id"}
+	tagLabels   = labelTable{table: "tags", link: "book_tags", column: "tag_id"}
+)
+
+// set replaces the labels of one book, creating unknown names.
+func (t labelTable) set(ctx context.Context, tx sqlx.ExtContext, bookID int, names []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+t.link+" WHERE book_id=$1", bookID); err != nil {
+		return dbError(err, "book")
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO "+t.table+" (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING", pq.Array(names)); err != nil {
+		return dbError(err, "book")
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO "+t.link+" (book_id, "+t.column+") SELECT $1, id FROM "+t.table+" WHERE name = ANY($2)", bookID, pq.Array(names))
+	return dbError(err, "book")
+}
+
+func (t labelTable) counts(ctx context.Context, db *sqlx.DB) ([]models.LabelCount, error) {
+	out := []models.LabelCount{}
+	query := "SELECT l.name, count(x.book_id) AS book_count FROM " + t.table + " l JOIN " + t.link + " x ON x." + t.column + " = l.id GROUP BY l.name ORDER BY l.name"
+	if err := db.SelectContext(ctx, &out, query); err != nil {
+		return nil, dbError(err, t.table)
+	}
+	return out, nil
+}
+
+func setBookLabels(ctx context.Context, tx sqlx.ExtContext, b *models.Book) error {
+	if err := genreLabels.set(ctx, tx, b.ID, b.Genres); err != nil {
+		return err
+	}
+	return tagLabels.set(ctx, tx, b.ID, b.Tags)
+}
+
+// ListGenres returns every genre in use with its number of books.
+func (r *PostgresRepository) ListGenres(ctx context.Context) ([]models.LabelCount, error) {
+	return genreLabels.counts(ctx, r.db)
+}
+
+// ListTags returns every tag in use with its number of books.
+func (r *PostgresRepository) ListTags(ctx context.Context) ([]models.LabelCount, error) {
+	return tagLabels.counts(ctx, r.db)
+}
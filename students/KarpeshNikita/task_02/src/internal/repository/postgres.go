@@ -1,6 +1,10 @@
 package repository
 
 import (
+	"context"
+	"time"
+
+	"github.com/example/books/internal/domain"
 	"github.com/example/books/pkg/models"
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/crypto/bcrypt"
@@ -18,123 +22,364 @@ func NewPostgresRepository(db *sqlx.DB) *PostgresRepository {
 }
 
 // Users
-func (r *PostgresRepository) CreateUser(u *models.User) error {
+func (r *PostgresRepository) CreateUser(ctx context.Context, u *models.User) error {
 	// ensure password is hashed; if the provided PasswordHash doesn't look like a bcrypt hash, hash it
 	if u.PasswordHash != "" && !(len(u.PasswordHash) > 3 && (u.PasswordHash[:3] == "$2a" || u.PasswordHash[:3] == "$2b" || u.PasswordHash[:3] == "$2y")) {
 		hash, err := bcrypt.GenerateFromPassword([]byte(u.PasswordHash), bcrypt.DefaultCost)
 		if err != nil {
-			return err
+			return dbError(err, "user")
 		}
 		u.PasswordHash = string(hash)
 	}
-	row := r.db.QueryRowx("INSERT INTO users (email, password_hash, name, role) VALUES ($1,$2,$3,$4) RETURNING id", u.Email, u.PasswordHash, u.Name, u.Role)
-	return row.Scan(&u.ID)
+	row := r.db.QueryRowxContext(ctx, "INSERT INTO users (email, password_hash, name, role) VALUES ($1,$2,$3,$4) RETURNING id", u.Email, u.PasswordHash, u.Name, u.Role)
+	return dbError(row.Scan(&u.ID), "user")
 }
 
-func (r *PostgresRepository) GetUserByEmail(email string) (*models.User, error) {
+func (r *PostgresRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	var u models.User
-	if err := r.db.Get(&u, "SELECT * FROM users WHERE email=$1", email); err != nil {
-		return nil, err
+	if err := r.db.GetContext(ctx, &u, "SELECT * FROM users WHERE email=$1", email); err != nil {
+		return nil, dbError(err, "user")
 	}
 	return &u, nil
 }
 
-func (r *PostgresRepository) CreateAuthor(a *models.Author) error {
-	row := r.db.QueryRowx("INSERT INTO authors (name) VALUES ($1) RETURNING id", a.Name)
-	return row.Scan(&a.ID)
+func (r *PostgresRepository) CreateAuthor(ctx context.Context, a *models.Author) error {
+	row := r.db.QueryRowxContext(ctx, "INSERT INTO authors (name) VALUES ($1) RETURNING id", a.Name)
+	return dbError(row.Scan(&a.ID), "author")
+}
+
+func (r *PostgresRepository) ListAuthors(ctx context.Context) ([]models.Author, error) {
+	var as []models.Author
+	if err := r.db.SelectContext(ctx, &as, "SELECT * FROM authors ORDER BY name"); err != nil {
+		return nil, dbError(err, "author")
+	}
+	return as, nil
+}
+
+func (r *PostgresRepository) GetAuthor(ctx context.Context, id int) (*models.Author, error) {
+	var a models.Author
+	if err := r.db.GetContext(ctx, &a, "SELECT * FROM authors WHERE id=$1", id); err != nil {
+		return nil, dbError(err, "author")
+	}
+	return &a, nil
+}
+
+// FindAuthorByName returns the oldest author with the name, ignoring case.
+func (r *PostgresRepository) FindAuthorByName(ctx context.Context, name string) (*models.Author, error) {
+	var a models.Author
+	if err := r.db.GetContext(ctx, &a, "SELECT * FROM authors WHERE lower(name) = lower($1) ORDER BY id LIMIT 1", name); err != nil {
+		return nil, dbError(err, "author")
+	}
+	return &a, nil
+}
+
+func (r *PostgresRepository) UpdateAuthor(ctx context.Context, a *models.Author) error {
+	return r.execOne(ctx, "author", "UPDATE authors SET name=$1 WHERE id=$2", a.Name, a.ID)
+}
+
+func (r *PostgresRepository) DeleteAuthor(ctx context.Context, id int) error {
+	return r.execOne(ctx, "author", "DELETE FROM authors WHERE id=$1", id)
 }
 
-func (r *PostgresRepository) ListBooks() ([]models.Book, error) {
+func (r *PostgresRepository) ListBooksByAuthor(ctx context.Context, authorID int) ([]models.Book, error) {
 	var books []models.Book
-	if err := r.db.Select(&books, "SELECT * FROM books ORDER BY created_at DESC"); err != nil {
-		return nil, err
+	var args sqlArgs
+	if err := r.db.SelectContext(ctx, &books, bookSelect+" WHERE "+contributedBy(&args, authorID)+" ORDER BY b.created_at DESC", args...); err != nil {
+		return nil, dbError(err, "book")
 	}
 	return books, nil
 }
 
-func (r *PostgresRepository) CreateBook(b *models.Book) error {
-	row := r.db.QueryRowx("INSERT INTO books (title, description, author_id) VALUES ($1,$2,$3) RETURNING id, created_at", b.Title, b.Description, b.AuthorID)
-	if err := row.Scan(&b.ID, &b.CreatedAt); err != nil {
+// bookColumns lists book columns explicitly so internal ones (search_vector) are never scanned
+const bookColumns = `b.id, b.title, COALESCE(b.description, '') AS description, b.author_id, b.created_by, b.created_at,
+	b.rating_avg, b.rating_count, COALESCE(b.isbn, '') AS isbn, COALESCE(b.publisher, '') AS publisher,
+	COALESCE(b.published_year, 0) AS published_year, COALESCE(b.language, '') AS language, COALESCE(b.page_count, 0) AS page_count,
+	COALESCE(b.cover, '') AS cover,
+	` + bookGenres + ` AS genres, ` + bookTags + ` AS tags, ` + bookContributors + ` AS contributors`
+
+// bookFrom joins books with their primary author for the name
+const bookFrom = ` FROM books b LEFT JOIN authors a ON a.id = b.author_id`
+
+// bookSelect loads books together with their primary author's name
+const bookSelect = `SELECT ` + bookColumns + `, COALESCE(a.name, '') AS author_name` + bookFrom
+
+func (r *PostgresRepository) ListBooks(ctx context.Context) ([]models.Book, error) {
+	var books []models.Book
+	if err := r.db.SelectContext(ctx, &books, bookSelect+" ORDER BY b.created_at DESC"); err != nil {
+		return nil, dbError(err, "book")
+	}
+	return books, nil
+}
+
+func (r *PostgresRepository) QueryBooks(ctx context.Context, q BookQuery) (*BookPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
+	}
+	query, args, err := buildBookQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	var books []models.Book
+	if err := r.db.SelectContext(ctx, &books, query, args...); err != nil {
+		return nil, dbError(err, "book")
+	}
+	return pageFromRows(q, books), nil
+}
+
+// StreamBooks calls fn for every book matching the query filters, in the
+// query's sort order. Rows are read from the cursor one at a time, so memory
+// use does not grow with the catalog. An error from fn stops the scan.
+func (r *PostgresRepository) StreamBooks(ctx context.Context, q BookQuery, fn func(*models.Book) error) error {
+	query, args, err := buildBookStream(q)
+	if err != nil {
 		return err
 	}
-	return nil
+	rows, err := r.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return dbError(err, "book")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b models.Book
+		if err := rows.StructScan(&b); err != nil {
+			return dbError(err, "book")
+		}
+		if err := fn(&b); err != nil {
+			return err
+		}
+	}
+	return dbError(rows.Err(), "book")
+}
+
+func (r *PostgresRepository) CountBooks(ctx context.Context, q BookQuery) (int, error) {
+	var args sqlArgs
+	var n int
+	if err := r.db.GetContext(ctx, &n, "SELECT count(*) FROM books b"+whereClause(bookWhere(q, &args)), args...); err != nil {
+		return 0, dbError(err, "book")
+	}
+	return n, nil
+}
+
+// CreateBook inserts the book together with its contributors, genres and tags.
+func (r *PostgresRepository) CreateBook(ctx context.Context, b *models.Book) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := insertBook(ctx, tx, b); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveBooks inserts the books without an ID and updates the others, all in
+// one transaction, so either every book is saved or none is. On error it
+// also returns the index of the book that failed, or len(books) when the
+// commit itself failed.
+func (r *PostgresRepository) SaveBooks(ctx context.Context, books []*models.Book) (int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for i, b := range books {
+		if b.ID == 0 {
+			err = insertBook(ctx, tx, b)
+		} else {
+			err = updateBook(ctx, tx, b)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(books), tx.Commit()
 }
 
-func (r *PostgresRepository) GetBook(id int) (*models.Book, error) {
+// FindBookByKey returns the book an imported record stands for: the book
+// with the ISBN or, when title is set, one with that title (ignoring case)
+// and primary author whose ISBN does not contradict the record's.
+func (r *PostgresRepository) FindBookByKey(ctx context.Context, isbn, title string, authorID int) (*models.Book, error) {
 	var b models.Book
-	if err := r.db.Get(&b, "SELECT * FROM books WHERE id=$1", id); err != nil {
-		return nil, err
+	query := `SELECT ` + bookColumns + `, COALESCE(a.name, '') AS author_name` + bookFrom + `
+		WHERE b.isbn = NULLIF($1, '')
+			OR ($2 <> '' AND lower(b.title) = lower($2) AND b.author_id = $3 AND ($1 = '' OR b.isbn IS NULL))
+		ORDER BY b.isbn = NULLIF($1, '') DESC NULLS LAST, b.id LIMIT 1`
+	if err := r.db.GetContext(ctx, &b, query, isbn, title, authorID); err != nil {
+		return nil, dbError(err, "book")
 	}
 	return &b, nil
 }
 
-func (r *PostgresRepository) UpdateBook(b *models.Book) error {
-	_, err := r.db.Exec("UPDATE books SET title=$1, description=$2, author_id=$3 WHERE id=$4", b.Title, b.Description, b.AuthorID, b.ID)
-	return err
+func insertBook(ctx context.Context, tx *sqlx.Tx, b *models.Book) error {
+	row := tx.QueryRowxContext(ctx, `INSERT INTO books (title, description, author_id, created_by, isbn, publisher, published_year, language, page_count)
+		VALUES ($1,$2,$3,$4,NULLIF($5,''),NULLIF($6,''),NULLIF($7,0),NULLIF($8,''),NULLIF($9,0)) RETURNING id, created_at`,
+		b.Title, b.Description, b.AuthorID, b.CreatedBy, b.ISBN, b.Publisher, b.PublishedYear, b.Language, b.PageCount)
+	if err := row.Scan(&b.ID, &b.CreatedAt); err != nil {
+		return dbError(err, "book")
+	}
+	if err := setBookLabels(ctx, tx, b); err != nil {
+		return err
+	}
+	return setBookContributors(ctx, tx, b)
 }
 
-func (r *PostgresRepository) DeleteBook(id int) error {
-	_, err := r.db.Exec("DELETE FROM books WHERE id=$1", id)
-	return err
+func (r *PostgresRepository) GetBook(ctx context.Context, id int) (*models.Book, error) {
+	var row struct {
+		models.Book
+		R1 int `db:"rating_1"`
+		R2 int `db:"rating_2"`
+		R3 int `db:"rating_3"`
+		R4 int `db:"rating_4"`
+		R5 int `db:"rating_5"`
+	}
+	query := `SELECT ` + bookColumns + `, COALESCE(a.name, '') AS author_name,
+		b.rating_1, b.rating_2, b.rating_3, b.rating_4, b.rating_5` + bookFrom + ` WHERE b.id=$1`
+	if err := r.db.GetContext(ctx, &row, query, id); err != nil {
+		return nil, dbError(err, "book")
+	}
+	b := row.Book
+	b.RatingHistogram = []int{row.R1, row.R2, row.R3, row.R4, row.R5}
+	return &b, nil
 }
 
-func (r *PostgresRepository) CreateShelf(s *models.Shelf) error {
-	row := r.db.QueryRowx("INSERT INTO shelves (user_id, name) VALUES ($1,$2) RETURNING id", s.UserID, s.Name)
-	return row.Scan(&s.ID)
+// UpdateBook replaces the book's fields, contributors, genres and tags.
+func (r *PostgresRepository) UpdateBook(ctx context.Context, b *models.Book) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := updateBook(ctx, tx, b); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *PostgresRepository) ListShelves() ([]models.Shelf, error) {
-	var s []models.Shelf
-	if err := r.db.Select(&s, "SELECT * FROM shelves"); err != nil {
-		return nil, err
+func updateBook(ctx context.Context, tx *sqlx.Tx, b *models.Book) error {
+	res, err := tx.ExecContext(ctx, `UPDATE books SET title=$1, description=$2, author_id=$3, isbn=NULLIF($4,''), publisher=NULLIF($5,''),
+		published_year=NULLIF($6,0), language=NULLIF($7,''), page_count=NULLIF($8,0) WHERE id=$9`,
+		b.Title, b.Description, b.AuthorID, b.ISBN, b.Publisher, b.PublishedYear, b.Language, b.PageCount, b.ID)
+	if err != nil {
+		return dbError(err, "book")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return domain.NotFound("book not found")
+	}
+	if err := setBookLabels(ctx, tx, b); err != nil {
+		return err
+	}
+	return setBookContributors(ctx, tx, b)
+}
+
+func (r *PostgresRepository) DeleteBook(ctx context.Context, id int) error {
+	return r.execOne(ctx, "book", "DELETE FROM books WHERE id=$1", id)
+}
+
+// SetBookCover records the book's cover version; an empty cover removes it.
+func (r *PostgresRepository) SetBookCover(ctx context.Context, bookID int, cover string) error {
+	return r.execOne(ctx, "book", "UPDATE books SET cover=NULLIF($1,'') WHERE id=$2", cover, bookID)
+}
+
+func (r *PostgresRepository) CreateShelf(ctx context.Context, s *models.Shelf) error {
+	if s.Visibility == "" {
+		s.Visibility = ShelfPrivate
+	}
+	row := r.db.QueryRowxContext(ctx, "INSERT INTO shelves (user_id, name, visibility, share_token) VALUES ($1,$2,$3,$4) RETURNING id", s.UserID, s.Name, s.Visibility, s.ShareToken)
+	return dbError(row.Scan(&s.ID), "shelf")
+}
+
+func (r *PostgresRepository) QueryShelves(ctx context.Context, q ShelfQuery) ([]models.Shelf, error) {
+	q.Normalize()
+	var args sqlArgs
+	conds := shelfConds(q, &args)
+	query := "SELECT * FROM shelves" + whereClause(conds) + " ORDER BY id LIMIT " + args.add(q.Limit) + " OFFSET " + args.add(q.Offset)
+	s := []models.Shelf{}
+	if err := r.db.SelectContext(ctx, &s, query, args...); err != nil {
+		return nil, dbError(err, "shelf")
 	}
 	return s, nil
 }
 
-func (r *PostgresRepository) GetShelf(id int) (*models.Shelf, error) {
+func (r *PostgresRepository) CountShelves(ctx context.Context, q ShelfQuery) (int, error) {
+	var args sqlArgs
+	conds := shelfConds(q, &args)
+	var n int
+	if err := r.db.GetContext(ctx, &n, "SELECT count(*) FROM shelves"+whereClause(conds), args...); err != nil {
+		return 0, dbError(err, "shelf")
+	}
+	return n, nil
+}
+
+func (r *PostgresRepository) GetShelf(ctx context.Context, id int) (*models.Shelf, error) {
 	var sh models.Shelf
-	if err := r.db.Get(&sh, "SELECT * FROM shelves WHERE id=$1", id); err != nil {
-		return nil, err
+	if err := r.db.GetContext(ctx, &sh, "SELECT * FROM shelves WHERE id=$1", id); err != nil {
+		return nil, dbError(err, "shelf")
 	}
 	return &sh, nil
 }
 
-func (r *PostgresRepository) ListBooksByShelf(shelfID int) ([]models.Book, error) {
-	var books []models.Book
-	query := `SELECT b.* FROM books b JOIN shelf_books sb ON sb.book_id = b.id WHERE sb.shelf_id=$1 ORDER BY b.created_at DESC`
-	if err := r.db.Select(&books, query, shelfID); err != nil {
-		return nil, err
+func (r *PostgresRepository) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	var u models.User
+	if err := r.db.GetContext(ctx, &u, "SELECT * FROM users WHERE id=$1", id); err != nil {
+		return nil, dbError(err, "user")
 	}
-	return books, nil
+	return &u, nil
 }
 
-func (r *PostgresRepository) AddBookToShelf(shelfID int, bookID int) error {
-	_, err := r.db.Exec("INSERT INTO shelf_books (shelf_id, book_id) VALUES ($1,$2) ON CONFLICT DO NOTHING", shelfID, bookID)
-	return err
+func (r *PostgresRepository) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	return r.execOne(ctx, "user", "UPDATE users SET role=$1 WHERE id=$2", role, userID)
 }
 
-func (r *PostgresRepository) GetUserByID(id int) (*models.User, error) {
-	var u models.User
-	if err := r.db.Get(&u, "SELECT * FROM users WHERE id=$1", id); err != nil {
-		return nil, err
+// Tokens
+func (r *PostgresRepository) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	row := r.db.QueryRowxContext(ctx, "INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1,$2,$3) RETURNING id, created_at", t.UserID, t.TokenHash, t.ExpiresAt)
+	return dbError(row.Scan(&t.ID, &t.CreatedAt), "refresh token")
+}
+
+func (r *PostgresRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	if err := r.db.GetContext(ctx, &t, "SELECT * FROM refresh_tokens WHERE token_hash=$1", hash); err != nil {
+		return nil, dbError(err, "refresh token")
 	}
-	return &u, nil
+	return &t, nil
 }
 
-func (r *PostgresRepository) UpdateUserRole(userID int, role string) error {
-	_, err := r.db.Exec("UPDATE users SET role=$1 WHERE id=$2", role, userID)
-	return err
+func (r *PostgresRepository) RevokeRefreshToken(ctx context.Context, id int) (bool, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return false, dbError(err, "refresh token")
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
 }
 
-func (r *PostgresRepository) CreateReview(rv *models.Review) error {
-	row := r.db.QueryRowx("INSERT INTO reviews (user_id, book_id, text, rating) VALUES ($1,$2,$3,$4) RETURNING id, created_at", rv.UserID, rv.BookID, rv.Text, rv.Rating)
-	return row.Scan(&rv.ID, &rv.CreatedAt)
+func (r *PostgresRepository) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL", userID)
+	return dbError(err, "refresh token")
 }
 
-func (r *PostgresRepository) ListReviewsByBook(bookID int) ([]models.Review, error) {
-	var rs []models.Review
-	if err := r.db.Select(&rs, "SELECT * FROM reviews WHERE book_id=$1 ORDER BY created_at DESC", bookID); err != nil {
-		return nil, err
+// RevokeAccessToken also purges the revocations of tokens that have expired
+// since, which no longer need them.
+func (r *PostgresRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `WITH purged AS (DELETE FROM revoked_tokens WHERE expires_at < now())
+		INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1,$2) ON CONFLICT DO NOTHING`, jti, expiresAt)
+	return dbError(err, "token")
+}
+
+func (r *PostgresRepository) SetUserTokenCutoff(ctx context.Context, userID int, notBefore time.Time) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO user_token_cutoffs (user_id, not_before) VALUES ($1,$2) ON CONFLICT (user_id) DO UPDATE SET not_before=EXCLUDED.not_before", userID, notBefore)
+	return dbError(err, "user")
+}
+
+func (r *PostgresRepository) IsAccessTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti=$1)
+		OR EXISTS (SELECT 1 FROM user_token_cutoffs WHERE user_id=$2 AND not_before > $3)`
+	if err := r.db.GetContext(ctx, &revoked, query, jti, userID, issuedAt); err != nil {
+		return false, dbError(err, "token")
 	}
-	return rs, nil
+	return revoked, nil
 }
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/books/pkg/models"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// ErrInvalidQuery is returned for unknown sort fields or malformed cursors.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// BookQuery filters, sorts and pages book listings.
+type BookQuery struct {
+	Limit  int
+	Offset int
+	// Cursor continues a previous page (keyset paging); Offset is ignored when set.
+	Cursor string
+	// Sort is a field name (created_at, title, id, rating or year), prefixed with "-"
+	// for descending order. Defaults to "-created_at".
+	Sort          string
+	AuthorID      int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Genre, Tag and Language match exactly; ISBN is a normalized ISBN-13.
+	Genre    string
+	Tag      string
+	Language string
+	ISBN     string
+	Year     int
+}
+
+// BookPage is one page of books plus the cursor of the next page, if any.
+type BookPage struct {
+	Books      []models.Book
+	NextCursor string
+}
+
+// ShelfQuery pages shelf listings, optionally restricted to one owner, to
+// the shelves a user owns or collaborates on, or to one visibility.
+type ShelfQuery struct {
+	Limit      int
+	Offset     int
+	UserID     int
+	MemberID   int
+	Visibility string
+}
+
+type sortField struct {
+	column string
+	cast   string
+	value  func(b *models.Book) string
+}
+
+var bookSortFields = map[string]sortField{
+	"created_at": {"b.created_at", "timestamp", func(b *models.Book) string { return b.CreatedAt.Format(time.RFC3339Nano) }},
+	"title":      {"b.title", "text", func(b *models.Book) string { return b.Title }},
+	"id":         {"b.id", "int", func(b *models.Book) string { return strconv.Itoa(b.ID) }},
+	"rating":     {"b.rating_avg", "float8", func(b *models.Book) string { return strconv.FormatFloat(b.RatingAvg, 'g', -1, 64) }},
+	"year":       {"COALESCE(b.published_year, 0)", "int", func(b *models.Book) string { return strconv.Itoa(b.PublishedYear) }},
+}
+
+// sortSpec splits Sort into the field and direction.
+func (q *BookQuery) sortSpec() (string, bool) {
+	return strings.TrimPrefix(q.Sort, "-"), strings.HasPrefix(q.Sort, "-")
+}
+
+// Normalize applies defaults and validates the sort field.
+func (q *BookQuery) Normalize() error {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	if q.Sort == "" {
+		q.Sort = "-created_at"
+	}
+	field, _ := q.sortSpec()
+	if _, ok := bookSortFields[field]; !ok {
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, field)
+	}
+	return nil
+}
+
+// Normalize applies paging defaults.
+func (q *ShelfQuery) Normalize() {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+}
+
+// cursor is the keyset position after the last row of a page. The sort is
+// recorded so a cursor cannot be replayed against a different ordering.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
+
+// sqlArgs collects positional arguments and hands out their placeholders.
+type sqlArgs []interface{}
+
+func (a *sqlArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// bookWhere renders the filter conditions shared by listing and counting.
+func bookWhere(q BookQuery, args *sqlArgs) []string {
+	var conds []string
+	if q.AuthorID != 0 {
+		conds = append(conds, contributedBy(args, q.AuthorID))
+	}
+	if q.CreatedAfter != nil {
+		conds = append(conds, "b.created_at > "+args.add(*q.CreatedAfter))
+	}
+	if q.CreatedBefore != nil {
+		conds = append(conds, "b.created_at < "+args.add(*q.CreatedBefore))
+	}
+	if q.Genre != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE bg.book_id = b.id AND g.name = "+args.add(q.Genre)+")")
+	}
+	if q.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM book_tags bt JOIN tags t ON t.id = bt.tag_id WHERE bt.book_id = b.id AND t.name = "+args.add(q.Tag)+")")
+	}
+	if q.Language != "" {
+		conds = append(conds, "b.language = "+args.add(q.Language))
+	}
+	if q.ISBN != "" {
+		conds = append(conds, "b.isbn = "+args.add(q.ISBN))
+	}
+	if q.Year != 0 {
+		conds = append(conds, "b.published_year = "+args.add(q.Year))
+	}
+	return conds
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// buildBookQuery renders the listing SQL. One extra row is fetched to detect a next page.
+func buildBookQuery(q BookQuery) (string, []interface{}, error) {
+	if err := q.Normalize(); err != nil {
+		return "", nil, err
+	}
+	field, desc := q.sortSpec()
+	sf := bookSortFields[field]
+	var args sqlArgs
+	conds := bookWhere(q, &args)
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		if c.Sort != q.Sort {
+			return "", nil, fmt.Errorf("%w: cursor does not match sort %q", ErrInvalidQuery, q.Sort)
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		conds = append(conds, fmt.Sprintf("(%s, b.id) %s (%s::%s, %s)", sf.column, op, args.add(c.Value), sf.cast, args.add(c.ID)))
+	}
+	query := bookSelect + whereClause(conds) + bookOrder(q) + " LIMIT " + args.add(q.Limit+1)
+	if q.Cursor == "" && q.Offset > 0 {
+		query += " OFFSET " + args.add(q.Offset)
+	}
+	return query, args, nil
+}
+
+// buildBookStream renders the export SQL: the listing filters and sort order
+// without paging.
+func buildBookStream(q BookQuery) (string, []interface{}, error) {
+	if err := q.Normalize(); err != nil {
+		return "", nil, err
+	}
+	var args sqlArgs
+	return bookSelect + whereClause(bookWhere(q, &args)) + bookOrder(q), args, nil
+}
+
+// bookOrder renders the ORDER BY clause; the id breaks ties so the order is total.
+func bookOrder(q BookQuery) string {
+	field, desc := q.sortSpec()
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, b.id %s", bookSortFields[field].column, dir, dir)
+}
+
+// pageFromRows trims the look-ahead row and derives the next cursor.
+func pageFromRows(q BookQuery, rows []models.Book) *BookPage {
+	page := &BookPage{Books: rows}
+	if len(rows) > q.Limit {
+		page.Books = rows[:q.Limit]
+		field, _ := q.sortSpec()
+		last := &page.Books[len(page.Books)-1]
+		page.NextCursor = encodeCursor(cursor{Sort: q.Sort, Value: bookSortFields[field].value(last), ID: last.ID})
+	}
+	if page.Books == nil {
+		page.Books = []models.Book{}
+	}
+	return page
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/books/pkg/models"
+)
+
+func TestBuildBookQueryDefaults(t *testing.T) {
+	query, args, err := buildBookQuery(BookQuery{})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !strings.HasSuffix(query, "ORDER BY b.created_at DESC, b.id DESC LIMIT $1") {
+		t.Fatalf("unexpected query: %s", query)
+	}
+	if len(args) != 1 || args[0] != DefaultLimit+1 {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestBuildBookStream(t *testing.T) {
+	query, args, err := buildBookStream(BookQuery{Limit: 5, Offset: 10, Sort: "-title", Genre: "classic"})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !strings.HasSuffix(query, "ORDER BY b.title DESC, b.id DESC") || strings.Contains(query, "LIMIT") {
+		t.Fatalf("exports are not paged: %s", query)
+	}
+	if len(args) != 1 || args[0] != "classic" {
+		t.Fatalf("unexpected args: %v", args)
+	}
+	if _, _, err := buildBookStream(BookQuery{Sort: "nope"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestBuildBookQueryFiltersAndOffset(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args, err := buildBookQuery(BookQuery{Limit: 500, Offset: 40, Sort: "title", AuthorID: 3, CreatedAfter: &after})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !strings.Contains(query, "WHERE EXISTS (SELECT 1 FROM book_contributors bc WHERE bc.book_id = b.id AND bc.author_id = $1) AND b.created_at > $2") {
+		t.Fatalf("filters missing: %s", query)
+	}
+	if !strings.HasSuffix(query, "ORDER BY b.title ASC, b.id ASC LIMIT $3 OFFSET $4") {
+		t.Fatalf("unexpected order/paging: %s", query)
+	}
+	if args[2] != MaxLimit+1 || args[3] != 40 {
+		t.Fatalf("limit not capped or offset lost: %v", args)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	q := BookQuery{Limit: 2, Sort: "-created_at"}
+	if err := q.Normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	now := time.Now()
+	rows := []models.Book{{ID: 9, CreatedAt: now}, {ID: 8, CreatedAt: now.Add(-time.Minute)}, {ID: 7, CreatedAt: now.Add(-2 * time.Minute)}}
+	page := pageFromRows(q, rows)
+	if len(page.Books) != 2 || page.NextCursor == "" {
+		t.Fatalf("expected trimmed page with cursor, got %d %q", len(page.Books), page.NextCursor)
+	}
+
+	q.Cursor = page.NextCursor
+	query, args, err := buildBookQuery(q)
+	if err != nil {
+		t.Fatalf("build with cursor: %v", err)
+	}
+	if !strings.Contains(query, "(b.created_at, b.id) < ($1::timestamp, $2)") || strings.Contains(query, "OFFSET") {
+		t.Fatalf("unexpected keyset query: %s", query)
+	}
+	if args[1] != 8 {
+		t.Fatalf("cursor should continue after id 8, got %v", args)
+	}
+
+	if pageFromRows(q, rows[:1]).NextCursor != "" {
+		t.Fatalf("last page must not have a cursor")
+	}
+}
+
+func TestInvalidQueries(t *testing.T) {
+	if _, _, err := buildBookQuery(BookQuery{Sort: "password_hash"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for unknown sort, got %v", err)
+	}
+	if _, _, err := buildBookQuery(BookQuery{Cursor: "%%%"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for malformed cursor, got %v", err)
+	}
+	c := encodeCursor(cursor{Sort: "title", Value: "A", ID: 1})
+	if _, _, err := buildBookQuery(BookQuery{Cursor: c, Sort: "-created_at"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for cursor/sort mismatch, got %v", err)
+	}
+}
+
+func TestRatingSortCursor(t *testing.T) {
+	q := BookQuery{Limit: 1, Sort: "-rating"}
+	if err := q.Normalize(); err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	page := pageFromRows(q, []models.Book{{ID: 4, RatingAvg: 4.25}, {ID: 2, RatingAvg: 3}})
+	q.Cursor = page.NextCursor
+	query, args, err := buildBookQuery(q)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if !strings.Contains(query, "(b.rating_avg, b.id) < ($1::float8, $2)") || !strings.Contains(query, "ORDER BY b.rating_avg DESC, b.id DESC") {
+		t.Fatalf("unexpected query: %s", query)
+	}
+	if args[0] != "4.25" || args[1] != 4 {
+		t.Fatalf("unexpected cursor args: %v", args)
+	}
+}
+
+func TestTopRatedQueryNormalize(t *testing.T) {
+	q := TopRatedQuery{Limit: 1000}
+	q.Normalize()
+	if q.Limit != MaxLimit || q.MinCount != 1 || q.Weight != DefaultRatingWeight {
+		t.Fatalf("unexpected defaults: %+v", q)
+	}
+}
+
+func TestBuildBookQueryMetadataFilters(t *testing.T) {
+	query, args, err := buildBookQuery(BookQuery{Genre: "fantasy", Tag: "classic", Language: "ru", ISBN: "9780306406157", Year: 1866, Sort: "-year"})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	for _, want := range []string{"g.name = $1", "t.name = $2", "b.language = $3", "b.isbn = $4", "b.published_year = $5",
+		"ORDER BY COALESCE(b.published_year, 0) DESC"} {
+		if !strings.Contains(query, want) {
+			t.Fatalf("missing %q in %s", want, query)
+		}
+	}
+	if args[0] != "fantasy" || args[4] != 1866 {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestLabelsScan(t *testing.T) {
+	var l models.Labels
+	if err := l.Scan([]byte(`["drama","fantasy"]`)); err != nil || len(l) != 2 || l[1] != "fantasy" {
+		t.Fatalf("scan: %v %v", l, err)
+	}
+	if err := l.Scan(nil); err != nil || l == nil || len(l) != 0 {
+		t.Fatalf("scan nil: %v %v", l, err)
+	}
+}
+
+func TestContributorsScan(t *testing.T) {
+	var c models.Contributors
+	err := c.Scan(`[{"author_id":1,"name":"Leo Tolstoy","role":"author"},{"author_id":7,"name":"Aylmer Maude","role":"translator"}]`)
+	if err != nil || len(c) != 2 || c[1] != (models.Contributor{AuthorID: 7, Name: "Aylmer Maude", Role: "translator"}) {
+		t.Fatalf("scan: %v %v", c, err)
+	}
+	if err := c.Scan(42); err == nil {
+		t.Fatal("expected an error for a non-JSON value")
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/example/books/pkg/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// adjustRating adds (delta 1) or removes (delta -1) one rating from a book's aggregates.
+func adjustRating(ctx context.Context, tx *sqlx.Tx, bookID, rating, delta int) error {
+	_, err := tx.ExecContext(ctx, `UPDATE books SET
+		rating_count = rating_count + $3,
+		rating_sum = rating_sum + $2 * $3,
+		rating_1 = rating_1 + CASE WHEN $2 = 1 THEN $3 ELSE 0 END,
+		rating_2 = rating_2 + CASE WHEN $2 = 2 THEN $3 ELSE 0 END,
+		rating_3 = rating_3 + CASE WHEN $2 = 3 THEN $3 ELSE 0 END,
+		rating_4 = rating_4 + CASE WHEN $2 = 4 THEN $3 ELSE 0 END,
+		rating_5 = rating_5 + CASE WHEN $2 = 5 THEN $3 ELSE 0 END
+		WHERE id = $1`, bookID, rating, delta)
+	return dbError(err, "book")
+}
+
+// replaceRating moves a review's contribution to the aggregates from oldRating
+// to newRating; 0 stands for a review that is not counted.
+func replaceRating(ctx context.Context, tx *sqlx.Tx, bookID, oldRating, newRating int) error {
+	if oldRating == newRating {
+		return nil
+	}
+	if oldRating > 0 {
+		if err := adjustRating(ctx, tx, bookID, oldRating, -1); err != nil {
+			return err
+		}
+	}
+	if newRating > 0 {
+		return adjustRating(ctx, tx, bookID, newRating, 1)
+	}
+	return nil
+}
+
+// TopRatedQuery ranks books by a Bayesian average that pulls ratings of books
+// with few reviews towards the catalog mean.
+type TopRatedQuery struct {
+	Limit int
+	// MinCount skips books with fewer ratings.
+	MinCount int
+	// Weight is the number of mean-valued "virtual" ratings every book starts with.
+	Weight int
+}
+
+// DefaultRatingWeight is used when TopRatedQuery.Weight is not set.
+const DefaultRatingWeight = 10
+
+// Normalize applies defaults.
+func (q *TopRatedQuery) Normalize() {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.MinCount < 1 {
+		q.MinCount = 1
+	}
+	if q.Weight <= 0 {
+		q.Weight = DefaultRatingWeight
+	}
+}
+
+const topRatedSQL = `WITH catalog AS (
+		SELECT COALESCE(sum(rating_sum)::float8 / NULLIF(sum(rating_count), 0), 0) AS mean FROM books
+	)
+	SELECT ` + bookColumns + `, COALESCE(a.name, '') AS author_name,
+		($1 * catalog.mean + b.rating_sum) / ($1 + b.rating_count) AS score` +
+	bookFrom + `, catalog
+	WHERE b.rating_count >= $2
+	ORDER BY score DESC, b.rating_count DESC, b.id
+	LIMIT $3`
+
+func (r *PostgresRepository) TopRatedBooks(ctx context.Context, q TopRatedQuery) ([]models.RatedBook, error) {
+	q.Normalize()
+	out := []models.RatedBook{}
+	if err := r.db.SelectContext(ctx, &out, topRatedSQL, q.Weight, q.MinCount, q.Limit); err != nil {
+		return nil, dbError(err, "book")
+	}
+	return out, nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/example/books/pkg/models"
+)
+
+const readingSelect = `SELECT rs.user_id, rs.book_id, b.title AS book_title, rs.status, rs.current_page, rs.percent,
+	rs.started_at, rs.finished_at, rs.updated_at
+	FROM reading_status rs JOIN books b ON b.id = rs.book_id`
+
+func (r *PostgresRepository) GetReadingStatus(ctx context.Context, userID, bookID int) (*models.ReadingStatus, error) {
+	var rs models.ReadingStatus
+	if err := r.db.GetContext(ctx, &rs, readingSelect+" WHERE rs.user_id=$1 AND rs.book_id=$2", userID, bookID); err != nil {
+		return nil, dbError(err, "reading status")
+	}
+	return &rs, nil
+}
+
+// ListReadingStatuses returns the user's books, optionally only those with the given status.
+func (r *PostgresRepository) ListReadingStatuses(ctx context.Context, userID int, status string) ([]models.ReadingStatus, error) {
+	var args sqlArgs
+	conds := []string{"rs.user_id = " + args.add(userID)}
+	if status != "" {
+		conds = append(conds, "rs.status = "+args.add(status))
+	}
+	out := []models.ReadingStatus{}
+	if err := r.db.SelectContext(ctx, &out, readingSelect+whereClause(conds)+" ORDER BY rs.updated_at DESC", args...); err != nil {
+		return nil, dbError(err, "reading status")
+	}
+	return out, nil
+}
+
+// SaveReadingStatus upserts the status and, when statusChanged, appends a
+// history entry in the same transaction.
+func (r *PostgresRepository) SaveReadingStatus(ctx context.Context, rs *models.ReadingStatus, statusChanged bool) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	row := tx.QueryRowxContext(ctx, `INSERT INTO reading_status (user_id, book_id, status, current_page, percent, started_at, finished_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,now())
+		ON CONFLICT (user_id, book_id) DO UPDATE SET status=EXCLUDED.status, current_page=EXCLUDED.current_page,
+			percent=EXCLUDED.percent, started_at=EXCLUDED.started_at, finished_at=EXCLUDED.finished_at, updated_at=now()
+		RETURNING updated_at`, rs.UserID, rs.BookID, rs.Status, rs.CurrentPage, rs.Percent, rs.StartedAt, rs.FinishedAt)
+	if err := row.Scan(&rs.UpdatedAt); err != nil {
+		return dbError(err, "reading status")
+	}
+	if statusChanged {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO reading_status_history (user_id, book_id, status) VALUES ($1,$2,$3)", rs.UserID, rs.BookID, rs.Status); err != nil {
+			return dbError(err, "reading status")
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) DeleteReadingStatus(ctx context.Context, userID, bookID int) error {
+	return r.execOne(ctx, "reading status", "DELETE FROM reading_status WHERE user_id=$1 AND book_id=$2", userID, bookID)
+}
+
+func (r *PostgresRepository) ListReadingHistory(ctx context.Context, userID, bookID int) ([]models.ReadingEvent, error) {
+	out := []models.ReadingEvent{}
+	if err := r.db.SelectContext(ctx, &out, "SELECT * FROM reading_status_history WHERE user_id=$1 AND book_id=$2 ORDER BY changed_at, id", userID, bookID); err != nil {
+		return nil, dbError(err, "reading status")
+	}
+	return out, nil
+}
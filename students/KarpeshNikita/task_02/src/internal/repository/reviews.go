@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/example/books/pkg/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// Review list orders accepted by ListReviewsByBook.
+const (
+	ReviewSortNewest  = "newest"
+	ReviewSortHelpful = "helpful"
+)
+
+// Moderation states of a review. Only approved reviews are listed and counted
+// in the book's rating aggregates.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+const reviewSelect = `SELECT rv.id, rv.user_id, COALESCE(u.name, '') AS user_name, rv.book_id,
+	COALESCE(b.title, '') AS book_title, COALESCE(rv.text, '') AS text,
+	COALESCE(rv.rating, 0) AS rating, rv.helpful_count, rv.status, rv.report_count,
+	COALESCE(rv.moderation_note, '') AS moderation_note, rv.moderated_by, rv.moderated_at,
+	rv.created_at, rv.updated_at
+	FROM reviews rv LEFT JOIN users u ON u.id = rv.user_id LEFT JOIN books b ON b.id = rv.book_id`
+
+var reviewOrders = map[string]string{
+	ReviewSortNewest:  "rv.created_at DESC, rv.id DESC",
+	ReviewSortHelpful: "rv.helpful_count DESC, rv.created_at DESC, rv.id DESC",
+}
+
+// ReviewQuery pages the moderation queue: reviews in one state, most reported
+// and then oldest first.
+type ReviewQuery struct {
+	Status string
+	Limit  int
+	Offset int
+}
+
+// Normalize applies defaults; an empty Status selects pending reviews.
+func (q *ReviewQuery) Normalize() {
+	if q.Status == "" {
+		q.Status = ReviewPending
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+}
+
+// countedRating is what a review contributes to its book's aggregates.
+func countedRating(status string, rating int) int {
+	if status != ReviewApproved {
+		return 0
+	}
+	return rating
+}
+
+type reviewState struct {
+	BookID int    `db:"book_id"`
+	Rating int    `db:"rating"`
+	Status string `db:"status"`
+}
+
+func lockReview(ctx context.Context, tx *sqlx.Tx, id int) (reviewState, error) {
+	var st reviewState
+	err := tx.GetContext(ctx, &st, "SELECT book_id, COALESCE(rating, 0) AS rating, status FROM reviews WHERE id=$1 FOR UPDATE", id)
+	return st, dbError(err, "review")
+}
+
+// CreateReview stores the review and folds its rating into the book's aggregates.
+// A second review of the same book by the same user is a conflict.
+func (r *PostgresRepository) CreateReview(ctx context.Context, rv *models.Review) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if rv.Status == "" {
+		rv.Status = ReviewApproved
+	}
+	row := tx.QueryRowxContext(ctx, "INSERT INTO reviews (user_id, book_id, text, rating, status) VALUES ($1,$2,$3,$4,$5) RETURNING id, created_at", rv.UserID, rv.BookID, rv.Text, rv.Rating, rv.Status)
+	if err := row.Scan(&rv.ID, &rv.CreatedAt); err != nil {
+		return dbError(err, "review")
+	}
+	if err := replaceRating(ctx, tx, rv.BookID, 0, countedRating(rv.Status, rv.Rating)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PostgresRepository) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	var rv models.Review
+	if err := r.db.GetContext(ctx, &rv, reviewSelect+" WHERE rv.id=$1", id); err != nil {
+		return nil, dbError(err, "review")
+	}
+	return &rv, nil
+}
+
+// UpdateReview rewrites the text, rating and status, moving the book's
+// aggregates from the old rating to the new one. New text clears the
+// moderator's decision, which was about the old text.
+func (r *PostgresRepository) UpdateReview(ctx context.Context, rv *models.Review) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	old, err := lockReview(ctx, tx, rv.ID)
+	if err != nil {
+		return err
+	}
+	if rv.Status == "" {
+		rv.Status = old.Status
+	}
+	row := tx.QueryRowxContext(ctx, `UPDATE reviews SET text=$1, rating=$2, status=$3, updated_at=now(),
+		moderated_by=CASE WHEN text=$1 THEN moderated_by END, moderated_at=CASE WHEN text=$1 THEN moderated_at END,
+		moderation_note=CASE WHEN text=$1 THEN moderation_note END
+		WHERE id=$4 RETURNING book_id, updated_at, moderated_by, moderated_at, COALESCE(moderation_note, '')`,
+		rv.Text, rv.Rating, rv.Status, rv.ID)
+	if err := row.Scan(&rv.BookID, &rv.UpdatedAt, &rv.ModeratedBy, &rv.ModeratedAt, &rv.ModerationNote); err != nil {
+		return dbError(err, "review")
+	}
+	if err := replaceRating(ctx, tx, rv.BookID, countedRating(old.Status, old.Rating), countedRating(rv.Status, rv.Rating)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteReview removes the review, its votes and reports, and its rating from
+// the book's aggregates.
+func (r *PostgresRepository) DeleteReview(ctx context.Context, id int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	var old reviewState
+	if err := tx.GetContext(ctx, &old, "DELETE FROM reviews WHERE id=$1 RETURNING book_id, COALESCE(rating, 0) AS rating, status", id); err != nil {
+		return dbError(err, "review")
+	}
+	if err := replaceRating(ctx, tx, old.BookID, countedRating(old.Status, old.Rating), 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetReviewStatus records a moderation decision. moderatorID is nil when the
+// system changed the state, e.g. after too many reports.
+func (r *PostgresRepository) SetReviewStatus(ctx context.Context, id int, status string, moderatorID *int, note string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	old, err := lockReview(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE reviews SET status=$1, moderated_by=$2, moderation_note=NULLIF($3, ''), moderated_at=now() WHERE id=$4", status, moderatorID, note, id); err != nil {
+		return dbError(err, "review")
+	}
+	if err := replaceRating(ctx, tx, old.BookID, countedRating(old.Status, old.Rating), countedRating(status, old.Rating)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListReviewsByBook returns the book's approved reviews with reviewer names,
+// newest or most helpful first.
+func (r *PostgresRepository) ListReviewsByBook(ctx context.Context, bookID int, sort string) ([]models.Review, error) {
+	if sort == "" {
+		sort = ReviewSortNewest
+	}
+	order, ok := reviewOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown review sort %q", ErrInvalidQuery, sort)
+	}
+	rs := []models.Review{}
+	if err := r.db.SelectContext(ctx, &rs, reviewSelect+" WHERE rv.book_id=$1 AND rv.status=$2 ORDER BY "+order, bookID, ReviewApproved); err != nil {
+		return nil, dbError(err, "review")
+	}
+	return rs, nil
+}
+
+// AddReviewVote marks the review as helpful for the user. Voting twice is a no-op.
+func (r *PostgresRepository) AddReviewVote(ctx context.Context, reviewID, userID int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	res, err := tx.ExecContext(ctx, "INSERT INTO review_votes (review_id, user_id) VALUES ($1,$2) ON CONFLICT DO NOTHING", reviewID, userID)
+	if err != nil {
+		return dbError(err, "review vote")
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE reviews SET helpful_count = helpful_count + 1 WHERE id=$1", reviewID); err != nil {
+			return dbError(err, "review")
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveReviewVote withdraws the user's helpful vote, if any.
+func (r *PostgresRepository) RemoveReviewVote(ctx context.Context, reviewID, userID int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	res, err := tx.ExecContext(ctx, "DELETE FROM review_votes WHERE review_id=$1 AND user_id=$2", reviewID, userID)
+	if err != nil {
+		return dbError(err, "review vote")
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE reviews SET helpful_count = helpful_count - 1 WHERE id=$1", reviewID); err != nil {
+			return dbError(err, "review")
+		}
+	}
+	return tx.Commit()
+}
+
+// QueryReviews returns one page of the moderation queue.
+func (r *PostgresRepository) QueryReviews(ctx context.Context, q ReviewQuery) ([]models.Review, error) {
+	q.Normalize()
+	rs := []models.Review{}
+	err := r.db.SelectContext(ctx, &rs, reviewSelect+` WHERE rv.status=$1
+		ORDER BY rv.report_count DESC, rv.created_at, rv.id LIMIT $2 OFFSET $3`, q.Status, q.Limit, q.Offset)
+	if err != nil {
+		return nil, dbError(err, "review")
+	}
+	return rs, nil
+}
+
+func (r *PostgresRepository) CountReviews(ctx context.Context, q ReviewQuery) (int, error) {
+	q.Normalize()
+	var n int
+	err := r.db.GetContext(ctx, &n, "SELECT count(*) FROM reviews WHERE status=$1", q.Status)
+	return n, dbError(err, "review")
+}
+
+// ReportReview files the user's report and returns how many users have
+// reported the review. Reporting twice keeps the first report.
+func (r *PostgresRepository) ReportReview(ctx context.Context, rep *models.ReviewReport) (int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	res, err := tx.ExecContext(ctx, "INSERT INTO review_reports (review_id, user_id, reason) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING", rep.ReviewID, rep.UserID, rep.Reason)
+	if err != nil {
+		return 0, dbError(err, "review report")
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE reviews SET report_count = report_count + 1 WHERE id=$1", rep.ReviewID); err != nil {
+			return 0, dbError(err, "review")
+		}
+	}
+	var count int
+	if err := tx.GetContext(ctx, &count, "SELECT report_count FROM reviews WHERE id=$1", rep.ReviewID); err != nil {
+		return 0, dbError(err, "review")
+	}
+	return count, tx.Commit()
+}
+
+func (r *PostgresRepository) ListReviewReports(ctx context.Context, reviewID int) ([]models.ReviewReport, error) {
+	out := []models.ReviewReport{}
+	err := r.db.SelectContext(ctx, &out, `SELECT rr.review_id, rr.user_id, COALESCE(u.name, '') AS user_name, rr.reason, rr.created_at
+		FROM review_reports rr LEFT JOIN users u ON u.id = rr.user_id
+		WHERE rr.review_id=$1 ORDER BY rr.created_at`, reviewID)
+	if err != nil {
+		return nil, dbError(err, "review report")
+	}
+	return out, nil
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/example/books/pkg/models"
+)
+
+// SearchQuery is a full-text book search request.
+type SearchQuery struct {
+	Text   string
+	Limit  int
+	Offset int
+}
+
+// SearchPage is one page of search hits; HasMore reports whether another page exists.
+type SearchPage struct {
+	Hits    []models.BookSearchHit
+	HasMore bool
+}
+
+// Normalize applies paging defaults.
+func (q *SearchQuery) Normalize() {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+}
+
+// SearchTerms splits user input into lower-cased words, dropping punctuation
+// and tsquery operators so the input can never alter the query syntax.
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixTSQuery matches every term as a prefix so results update while typing.
+func prefixTSQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = t + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// Private-use code points mark matches inside ts_headline output. They are not
+// touched by HTML escaping, so the markers can be swapped for tags afterwards.
+const (
+	markOpen  = "\ue000"
+	markClose = "\ue001"
+)
+
+// HighlightHTML escapes s and turns match markers into <mark> tags.
+func HighlightHTML(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markOpen, "<mark>")
+	return strings.ReplaceAll(s, markClose, "</mark>")
+}
+
+const headlineOptions = "StartSel=" + markOpen + ", StopSel=" + markClose
+
+const searchSQL = `SELECT ` + bookColumns + `, COALESCE(a.name, '') AS author_name,
+	ts_rank_cd(b.search_vector, q) AS rank,
+	ts_headline('simple', COALESCE(b.description, ''), q, $2::text || ', MaxFragments=2, MaxWords=25, MinWords=8') AS snippet,
+	ts_headline('simple', b.title, q, $2::text || ', HighlightAll=true') AS highlighted_title` +
+	bookFrom + `, to_tsquery('simple', $1) q
+	WHERE b.search_vector @@ q
+	ORDER BY rank DESC, b.id
+	LIMIT $3 OFFSET $4`
+
+func (r *PostgresRepository) SearchBooks(ctx context.Context, q SearchQuery) (*SearchPage, error) {
+	q.Normalize()
+	terms := SearchTerms(q.Text)
+	if len(terms) == 0 {
+		return &SearchPage{Hits: []models.BookSearchHit{}}, nil
+	}
+	var hits []models.BookSearchHit
+	if err := r.db.SelectContext(ctx, &hits, searchSQL, prefixTSQuery(terms), headlineOptions, q.Limit+1, q.Offset); err != nil {
+		return nil, dbError(err, "book")
+	}
+	page := &SearchPage{Hits: hits}
+	if len(hits) > q.Limit {
+		page.Hits = hits[:q.Limit]
+		page.HasMore = true
+	}
+	for i := range page.Hits {
+		page.Hits[i].Snippet = HighlightHTML(page.Hits[i].Snippet)
+		page.Hits[i].HighlightedTitle = HighlightHTML(page.Hits[i].HighlightedTitle)
+	}
+	if page.Hits == nil {
+		page.Hits = []models.BookSearchHit{}
+	}
+	return page, nil
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchTermsStripOperators(t *testing.T) {
+	terms := SearchTerms("War & Peace | !Толстой:* 'x'")
+	want := []string{"war", "peace", "толстой", "x"}
+	if !reflect.DeepEqual(terms, want) {
+		t.Fatalf("got %v, want %v", terms, want)
+	}
+	if q := prefixTSQuery(terms); q != "war:* & peace:* & толстой:* & x:*" {
+		t.Fatalf("unexpected tsquery: %s", q)
+	}
+}
+
+func TestHighlightHTMLEscapes(t *testing.T) {
+	got := HighlightHTML("<b>" + markOpen + "war" + markClose + "</b> & peace")
+	if got != "&lt;b&gt;<mark>war</mark>&lt;/b&gt; &amp; peace" {
+		t.Fatalf("unexpected highlight: %s", got)
+	}
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Shelf visibilities. Unlisted shelves are reachable through their share token.
+const (
+	ShelfPrivate  = "private"
+	ShelfPublic   = "public"
+	ShelfUnlisted = "unlisted"
+)
+
+// Collaborator roles.
+const (
+	CollaboratorRead  = "read"
+	CollaboratorWrite = "write"
+)
+
+func shelfConds(q ShelfQuery, args *sqlArgs) []string {
+	var conds []string
+	if q.UserID != 0 {
+		conds = append(conds, "user_id = "+args.add(q.UserID))
+	}
+	if q.MemberID != 0 {
+		p := args.add(q.MemberID)
+		conds = append(conds, "(user_id = "+p+" OR EXISTS (SELECT 1 FROM shelf_collaborators c WHERE c.shelf_id = shelves.id AND c.user_id = "+p+"))")
+	}
+	if q.Visibility != "" {
+		conds = append(conds, "visibility = "+args.add(q.Visibility))
+	}
+	return conds
+}
+
+// ListBooksByShelf returns the shelf's books in their manual order.
+func (r *PostgresRepository) ListBooksByShelf(ctx context.Context, shelfID int) ([]models.Book, error) {
+	var books []models.Book
+	query := bookSelect + ` JOIN shelf_books sb ON sb.book_id = b.id WHERE sb.shelf_id=$1 ORDER BY sb.position, sb.added_at, b.id`
+	if err := r.db.SelectContext(ctx, &books, query, shelfID); err != nil {
+		return nil, dbError(err, "book")
+	}
+	return books, nil
+}
+
+// appendToShelf puts the book at the end of the shelf; a book already there keeps its place.
+func appendToShelf(ctx context.Context, db sqlx.ExtContext, shelfID, bookID int) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO shelf_books (shelf_id, book_id, position)
+		SELECT $1, $2, COALESCE(max(position), 0) + 1 FROM shelf_books WHERE shelf_id = $1
+		ON CONFLICT DO NOTHING`, shelfID, bookID)
+	return dbError(err, "shelf entry")
+}
+
+func (r *PostgresRepository) AddBookToShelf(ctx context.Context, shelfID int, bookID int) error {
+	return appendToShelf(ctx, r.db, shelfID, bookID)
+}
+
+func (r *PostgresRepository) UpdateShelf(ctx context.Context, s *models.Shelf) error {
+	return r.execOne(ctx, "shelf", "UPDATE shelves SET name=$1, visibility=$2, share_token=$3 WHERE id=$4", s.Name, s.Visibility, s.ShareToken, s.ID)
+}
+
+func (r *PostgresRepository) GetShelfByShareToken(ctx context.Context, token string) (*models.Shelf, error) {
+	var sh models.Shelf
+	if err := r.db.GetContext(ctx, &sh, "SELECT * FROM shelves WHERE share_token=$1", token); err != nil {
+		return nil, dbError(err, "shelf")
+	}
+	return &sh, nil
+}
+
+const collaboratorSelect = `SELECT c.shelf_id, c.user_id, COALESCE(u.name, '') AS user_name, c.role, c.added_at
+	FROM shelf_collaborators c LEFT JOIN users u ON u.id = c.user_id`
+
+func (r *PostgresRepository) ListShelfCollaborators(ctx context.Context, shelfID int) ([]models.ShelfCollaborator, error) {
+	out := []models.ShelfCollaborator{}
+	if err := r.db.SelectContext(ctx, &out, collaboratorSelect+" WHERE c.shelf_id=$1 ORDER BY c.added_at, c.user_id", shelfID); err != nil {
+		return nil, dbError(err, "collaborator")
+	}
+	return out, nil
+}
+
+func (r *PostgresRepository) GetShelfCollaborator(ctx context.Context, shelfID, userID int) (*models.ShelfCollaborator, error) {
+	var c models.ShelfCollaborator
+	if err := r.db.GetContext(ctx, &c, collaboratorSelect+" WHERE c.shelf_id=$1 AND c.user_id=$2", shelfID, userID); err != nil {
+		return nil, dbError(err, "collaborator")
+	}
+	return &c, nil
+}
+
+// SaveShelfCollaborator adds the collaborator or changes their role.
+func (r *PostgresRepository) SaveShelfCollaborator(ctx context.Context, c *models.ShelfCollaborator) error {
+	row := r.db.QueryRowxContext(ctx, `INSERT INTO shelf_collaborators (shelf_id, user_id, role) VALUES ($1,$2,$3)
+		ON CONFLICT (shelf_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		RETURNING added_at`, c.ShelfID, c.UserID, c.Role)
+	return dbError(row.Scan(&c.AddedAt), "collaborator")
+}
+
+func (r *PostgresRepository) RemoveShelfCollaborator(ctx context.Context, shelfID, userID int) error {
+	return r.execOne(ctx, "collaborator", "DELETE FROM shelf_collaborators WHERE shelf_id=$1 AND user_id=$2", shelfID, userID)
+}
+
+func (r *PostgresRepository) DeleteShelf(ctx context.Context, id int) error {
+	return r.execOne(ctx, "shelf", "DELETE FROM shelves WHERE id=$1", id)
+}
+
+func (r *PostgresRepository) RemoveBookFromShelf(ctx context.Context, shelfID, bookID int) error {
+	return r.execOne(ctx, "shelf entry", "DELETE FROM shelf_books WHERE shelf_id=$1 AND book_id=$2", shelfID, bookID)
+}
+
+// ReorderShelf numbers the listed books 1..n in the given order. Books missing
+// from the list keep their position.
+func (r *PostgresRepository) ReorderShelf(ctx context.Context, shelfID int, bookIDs []int) error {
+	ids := make(pq.Int64Array, len(bookIDs))
+	for i, id := range bookIDs {
+		ids[i] = int64(id)
+	}
+	_, err := r.db.ExecContext(ctx, `UPDATE shelf_books sb SET position = o.n
+		FROM unnest($2::int[]) WITH ORDINALITY AS o(book_id, n)
+		WHERE sb.shelf_id = $1 AND sb.book_id = o.book_id`, shelfID, ids)
+	return dbError(err, "shelf entry")
+}
+
+// MoveBook takes the book off one shelf and appends it to another in one
+// transaction. If the target shelf already holds the book it is only removed
+// from the source.
+func (r *PostgresRepository) MoveBook(ctx context.Context, fromShelfID, toShelfID, bookID int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	res, err := tx.ExecContext(ctx, "DELETE FROM shelf_books WHERE shelf_id=$1 AND book_id=$2", fromShelfID, bookID)
+	if err != nil {
+		return dbError(err, "shelf entry")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return domain.NotFound("shelf entry not found")
+	}
+	if err := appendToShelf(ctx, tx, toShelfID, bookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
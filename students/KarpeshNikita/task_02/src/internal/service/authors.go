@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+)
+
+var (
+	// ErrUnknownAuthor is returned when a book references an author that does not exist.
+	ErrUnknownAuthor = domain.Validation("author does not exist")
+	// ErrAuthorHasBooks is returned when deleting an author that still has books.
+	ErrAuthorHasBooks = domain.Conflict("author has books")
+)
+
+type AuthorModel = models.Author
+
+func (s *Service) ListAuthors(ctx context.Context) ([]models.Author, error) {
+	return s.repo.ListAuthors(ctx)
+}
+
+func (s *Service) GetAuthor(ctx context.Context, id int) (*models.Author, error) {
+	return s.repo.GetAuthor(ctx, id)
+}
+
+func (s *Service) CreateAuthor(ctx context.Context, a *models.Author) error {
+	return s.repo.CreateAuthor(ctx, a)
+}
+
+func (s *Service) UpdateAuthor(ctx context.Context, a *models.Author) error {
+	return s.repo.UpdateAuthor(ctx, a)
+}
+
+// DeleteAuthor refuses to remove authors still credited on a book.
+func (s *Service) DeleteAuthor(ctx context.Context, id int) error {
+	if _, err := s.repo.GetAuthor(ctx, id); err != nil {
+		return err
+	}
+	books, err := s.repo.ListBooksByAuthor(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(books) > 0 {
+		return ErrAuthorHasBooks
+	}
+	return s.repo.DeleteAuthor(ctx, id)
+}
+
+func (s *Service) ListBooksByAuthor(ctx context.Context, id int) ([]models.Book, error) {
+	return s.repo.ListBooksByAuthor(ctx, id)
+}
+
+// maxContributors caps the number of credits on one book.
+const maxContributors = 50
+
+var contributorRoles = map[string]bool{
+	models.RoleAuthor: true, models.RoleEditor: true, models.RoleTranslator: true,
+	models.RoleIllustrator: true, models.RoleNarrator: true,
+}
+
+// resolveContributors checks the book's contributors exist, normalizes their
+// roles and fills in their names. A book sent with only the deprecated
+// author_id gets that author as its sole contributor. AuthorID and AuthorName
+// are then set to the primary author: the first one credited as author, or
+// the first contributor when nobody is.
+func (s *Service) resolveContributors(ctx context.Context, b *models.Book) error {
+	in := b.Contributors
+	if len(in) == 0 {
+		in = models.Contributors{{AuthorID: b.AuthorID}}
+	}
+	if len(in) > maxContributors {
+		return domain.Validation(fmt.Sprintf("a book can have at most %d contributors", maxContributors))
+	}
+	out := make(models.Contributors, 0, len(in))
+	seen := make(map[models.Contributor]bool, len(in))
+	credited := false
+	for _, c := range in {
+		c.Role = strings.ToLower(strings.TrimSpace(c.Role))
+		if c.Role == "" {
+			c.Role = models.RoleAuthor
+		}
+		if !contributorRoles[c.Role] {
+			return domain.Validation(fmt.Sprintf("unknown contributor role %q", c.Role))
+		}
+		c.Name = ""
+		if seen[c] {
+			return domain.Validation("contributor is listed twice in the same role")
+		}
+		seen[c] = true
+		a, err := s.repo.GetAuthor(ctx, c.AuthorID)
+		if errors.Is(err, domain.ErrNotFound) {
+			return ErrUnknownAuthor
+		}
+		if err != nil {
+			return err
+		}
+		c.Name = a.Name
+		credited = credited || c.AuthorID == b.AuthorID
+		out = append(out, c)
+	}
+	if b.AuthorID != 0 && !credited {
+		return domain.Validation("author_id is not among the contributors")
+	}
+	primary := out[0]
+	for _, c := range out {
+		if c.Role == models.RoleAuthor {
+			primary = c
+			break
+		}
+	}
+	b.Contributors = out
+	b.AuthorID, b.AuthorName = primary.AuthorID, primary.Name
+	return nil
+}
@@ -0,0 +1,570 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/internal/storage"
+	"github.com/example/books/pkg/models"
+)
+
+const (
+	// BackupVersion is the archive layout Backup writes. Restore reads
+	// archives up to this version.
+	BackupVersion = 1
+	// MaxRestoreBytes caps the size of an uploaded backup.
+	MaxRestoreBytes = 2 << 30
+	// maxBackupEntryBytes caps each table of a backup once decompressed, as
+	// the tables are read into memory before they are checked.
+	maxBackupEntryBytes = 256 << 20
+
+	backupFormat   = "books-backup"
+	backupManifest = "manifest.json"
+)
+
+// ErrBackupTooLarge is returned for restores over MaxRestoreBytes.
+var ErrBackupTooLarge = domain.Validation(fmt.Sprintf("backup must be at most %d GB", MaxRestoreBytes>>30))
+
+// backupInfo is the manifest.json of a backup. A backup is a zip archive
+// holding the manifest, one NDJSON file per table with the rows under their
+// original IDs, and the cover thumbnails under their storage keys.
+type backupInfo struct {
+	Format         string         `json:"format"`
+	Version        int            `json:"version"`
+	CreatedAt      time.Time      `json:"created_at"`
+	PasswordHashes bool           `json:"password_hashes"`
+	Counts         map[string]int `json:"counts"` // rows per table
+	Covers         int            `json:"covers"`
+}
+
+// backupUser is a user as backed up; the hash is only kept when asked for.
+type backupUser struct {
+	ID           int    `json:"id"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// backupBook is a book as backed up: the cover is its version rather than
+// URLs, and derived fields such as ratings are left out.
+type backupBook struct {
+	ID            int                 `json:"id"`
+	Title         string              `json:"title"`
+	Description   string              `json:"description"`
+	AuthorID      int                 `json:"author_id"`
+	Contributors  models.Contributors `json:"contributors"`
+	ISBN          string              `json:"isbn,omitempty"`
+	Publisher     string              `json:"publisher,omitempty"`
+	PublishedYear int                 `json:"published_year,omitempty"`
+	Language      string              `json:"language,omitempty"`
+	PageCount     int                 `json:"page_count,omitempty"`
+	Genres        models.Labels       `json:"genres"`
+	Tags          models.Labels       `json:"tags"`
+	Cover         string              `json:"cover,omitempty"`
+	CreatedBy     *int                `json:"created_by,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+}
+
+// RestoreReport counts what a restore loaded.
+type RestoreReport struct {
+	Version int            `json:"version"`
+	Counts  map[string]int `json:"counts"` // rows restored per table
+	// MatchedUsers counts backed-up users whose email already had an
+	// account; their rows were attached to it and the account left as it was.
+	MatchedUsers int `json:"matched_users"`
+	Covers       int `json:"covers"`
+	// UsersWithoutPassword counts new accounts the backup had no password
+	// hash for. They cannot sign in.
+	UsersWithoutPassword int `json:"users_without_password"`
+}
+
+// noPassword is stored for restored users without a hash; no password matches it.
+const noPassword = "!"
+
+// Backup writes a zip archive of the users, authors, books, shelves, shelf
+// entries, reviews and cover images to w. Password hashes are left out
+// unless passwordHashes is set. Rows are written as they are read.
+func (s *Service) Backup(ctx context.Context, w io.Writer, passwordHashes bool) error {
+	info := backupInfo{Format: backupFormat, Version: BackupVersion, CreatedAt: time.Now().UTC(),
+		PasswordHashes: passwordHashes, Counts: make(map[string]int)}
+	zw := zip.NewWriter(w)
+	var enc *json.Encoder
+	var covers []models.Cover
+	table := ""
+	err := s.repo.Backup(ctx, func(t string, row interface{}) error {
+		if t != table {
+			f, err := zw.Create(t + ".ndjson")
+			if err != nil {
+				return err
+			}
+			enc, table = json.NewEncoder(f), t
+		}
+		info.Counts[t]++
+		switch v := row.(type) {
+		case *models.User:
+			u := backupUser{ID: v.ID, Email: v.Email, Name: v.Name, Role: v.Role}
+			if passwordHashes {
+				u.PasswordHash = v.PasswordHash
+			}
+			return enc.Encode(u)
+		case *models.Book:
+			if v.Cover != "" {
+				covers = append(covers, v.Cover)
+			}
+			return enc.Encode(backupBook{ID: v.ID, Title: v.Title, Description: v.Description, AuthorID: v.AuthorID,
+				Contributors: v.Contributors, ISBN: v.ISBN, Publisher: v.Publisher, PublishedYear: v.PublishedYear,
+				Language: v.Language, PageCount: v.PageCount, Genres: v.Genres, Tags: v.Tags, Cover: string(v.Cover),
+				CreatedBy: v.CreatedBy, CreatedAt: v.CreatedAt})
+		default:
+			return enc.Encode(row)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range repository.BackupTables {
+		if _, ok := info.Counts[t]; !ok {
+			info.Counts[t] = 0
+			if _, err := zw.Create(t + ".ndjson"); err != nil {
+				return err
+			}
+		}
+	}
+	if info.Covers, err = s.backupCovers(ctx, zw, covers); err != nil {
+		return err
+	}
+	f, err := zw.Create(backupManifest)
+	if err != nil {
+		return err
+	}
+	enc = json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// backupCovers copies every size of the covers into the archive and returns
+// how many covers were complete. Missing images are logged and skipped, so
+// their books are restored without a cover.
+func (s *Service) backupCovers(ctx context.Context, zw *zip.Writer, covers []models.Cover) (int, error) {
+	if s.storage == nil {
+		if len(covers) > 0 {
+			log.Printf("backup: cover storage is not configured; %d covers left out", len(covers))
+		}
+		return 0, nil
+	}
+	n := 0
+	for _, cover := range covers {
+		complete := true
+		for _, size := range models.CoverSizes {
+			ok, err := s.backupObject(ctx, zw, coverKey(cover, size))
+			if err != nil {
+				return n, err
+			}
+			complete = complete && ok
+		}
+		if complete {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *Service) backupObject(ctx context.Context, zw *zip.Writer, key string) (bool, error) {
+	rc, _, err := s.storage.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotExist) {
+		log.Printf("backup: %s is missing from storage", key)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	// images are compressed already
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: key, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return false, err
+	}
+	_, err = io.Copy(f, rc)
+	return err == nil, err
+}
+
+// backupData is a backup read into memory, before it is checked and loaded.
+type backupData struct {
+	info       backupInfo
+	users      []backupUser
+	authors    []models.Author
+	books      []backupBook
+	shelves    []models.Shelf
+	shelfBooks []models.ShelfBook
+	reviews    []models.Review
+	covers     map[string]*zip.File
+}
+
+// Restore loads a backup written by Backup into an empty catalog. The
+// archive is checked completely before anything is written, and is loaded
+// in one transaction: rows get new IDs and every reference is remapped to
+// them. Backed-up users whose email already has an account are attached to
+// that account. The archive is read in place, so r must stay open until
+// Restore returns.
+func (s *Service) Restore(ctx context.Context, r io.ReaderAt, size int64) (*RestoreReport, error) {
+	if size > MaxRestoreBytes {
+		return nil, ErrBackupTooLarge
+	}
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, domain.Validation("not a backup: the file is not a zip archive")
+	}
+	data, err := readBackup(zr)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.check(); err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{Version: data.info.Version, Counts: make(map[string]int)}
+	var written []string
+	err = s.repo.Restore(ctx, func(tx repository.Restorer) error {
+		return s.loadBackup(ctx, tx, data, report, &written)
+	})
+	if err != nil {
+		// the rows are gone; so must be the images written for them
+		for _, key := range written {
+			if derr := s.storage.Delete(context.WithoutCancel(ctx), key); derr != nil {
+				log.Printf("restore: remove %s: %v", key, derr)
+			}
+		}
+		return nil, err
+	}
+	return report, nil
+}
+
+// readBackup reads the manifest and every table of the archive.
+func readBackup(zr *zip.Reader) (*backupData, error) {
+	files := make(map[string]*zip.File, len(zr.File))
+	data := &backupData{covers: make(map[string]*zip.File)}
+	for _, f := range zr.File {
+		files[f.Name] = f
+		if strings.HasPrefix(f.Name, "covers/") {
+			data.covers[f.Name] = f
+		}
+	}
+	mf, ok := files[backupManifest]
+	if !ok {
+		return nil, domain.Validation("not a backup: manifest.json is missing")
+	}
+	if err := readBackupFile(mf, func(dec *json.Decoder) error { return dec.Decode(&data.info) }); err != nil {
+		return nil, domain.Validation("not a backup: manifest.json is not valid JSON")
+	}
+	if data.info.Format != backupFormat {
+		return nil, domain.Validation("not a backup: manifest.json does not describe a " + backupFormat + " archive")
+	}
+	if data.info.Version < 1 || data.info.Version > BackupVersion {
+		return nil, domain.Validation(fmt.Sprintf("backup version %d is not supported; this server reads versions 1 to %d",
+			data.info.Version, BackupVersion))
+	}
+	tables := []struct {
+		name string
+		next func(*json.Decoder) error
+	}{
+		{repository.BackupUsers, func(d *json.Decoder) error { return decodeRow(d, &data.users) }},
+		{repository.BackupAuthors, func(d *json.Decoder) error { return decodeRow(d, &data.authors) }},
+		{repository.BackupBooks, func(d *json.Decoder) error { return decodeRow(d, &data.books) }},
+		{repository.BackupShelves, func(d *json.Decoder) error { return decodeRow(d, &data.shelves) }},
+		{repository.BackupShelfBooks, func(d *json.Decoder) error { return decodeRow(d, &data.shelfBooks) }},
+		{repository.BackupReviews, func(d *json.Decoder) error { return decodeRow(d, &data.reviews) }},
+	}
+	for _, t := range tables {
+		name := t.name + ".ndjson"
+		f, ok := files[name]
+		if !ok {
+			return nil, domain.Validation("the backup has no " + name)
+		}
+		n := 0
+		err := readBackupFile(f, func(dec *json.Decoder) error {
+			for dec.More() {
+				n++
+				if err := t.next(dec); err != nil {
+					return domain.Validation(fmt.Sprintf("%s record %d: %v", name, n, err))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if want := data.info.Counts[t.name]; n != want {
+			return nil, domain.Validation(fmt.Sprintf("%s has %d records, but the manifest lists %d", name, n, want))
+		}
+	}
+	return data, nil
+}
+
+func readBackupFile(f *zip.File, fn func(*json.Decoder) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return domain.Validation(fmt.Sprintf("the backup's %s cannot be read: %v", f.Name, err))
+	}
+	defer rc.Close()
+	// a small entry can inflate to far more than the archive's own size
+	lr := &io.LimitedReader{R: rc, N: maxBackupEntryBytes + 1}
+	err = fn(json.NewDecoder(bufio.NewReader(lr)))
+	if lr.N <= 0 {
+		return domain.Validation(fmt.Sprintf("the backup's %s is larger than %d MB uncompressed", f.Name, maxBackupEntryBytes>>20))
+	}
+	return err
+}
+
+func decodeRow[T any](dec *json.Decoder, rows *[]T) error {
+	var row T
+	if err := dec.Decode(&row); err != nil {
+		return err
+	}
+	*rows = append(*rows, row)
+	return nil
+}
+
+// check makes sure every row names a row of the backup it refers to, so a
+// restore fails before it writes anything rather than halfway through.
+func (d *backupData) check() error {
+	ids := func(table string, n int, id func(int) int) (map[int]bool, error) {
+		seen := make(map[int]bool, n)
+		for i := 0; i < n; i++ {
+			if seen[id(i)] {
+				return nil, domain.Validation(fmt.Sprintf("%s.ndjson record %d: id %d is used twice", table, i+1, id(i)))
+			}
+			seen[id(i)] = true
+		}
+		return seen, nil
+	}
+	users, err := ids(repository.BackupUsers, len(d.users), func(i int) int { return d.users[i].ID })
+	if err != nil {
+		return err
+	}
+	authors, err := ids(repository.BackupAuthors, len(d.authors), func(i int) int { return d.authors[i].ID })
+	if err != nil {
+		return err
+	}
+	books, err := ids(repository.BackupBooks, len(d.books), func(i int) int { return d.books[i].ID })
+	if err != nil {
+		return err
+	}
+	shelves, err := ids(repository.BackupShelves, len(d.shelves), func(i int) int { return d.shelves[i].ID })
+	if err != nil {
+		return err
+	}
+	if _, err := ids(repository.BackupReviews, len(d.reviews), func(i int) int { return d.reviews[i].ID }); err != nil {
+		return err
+	}
+
+	missing := func(table string, record int, what string, id int) error {
+		return domain.Validation(fmt.Sprintf("%s.ndjson record %d: %s %d is not in the backup", table, record, what, id))
+	}
+	for i, u := range d.users {
+		if u.Email == "" {
+			return domain.Validation(fmt.Sprintf("users.ndjson record %d: the email is missing", i+1))
+		}
+	}
+	for i, b := range d.books {
+		if b.AuthorID != 0 && !authors[b.AuthorID] {
+			return missing(repository.BackupBooks, i+1, "author", b.AuthorID)
+		}
+		for _, c := range b.Contributors {
+			if !authors[c.AuthorID] {
+				return missing(repository.BackupBooks, i+1, "contributor", c.AuthorID)
+			}
+		}
+		if b.CreatedBy != nil && !users[*b.CreatedBy] {
+			return missing(repository.BackupBooks, i+1, "user", *b.CreatedBy)
+		}
+	}
+	for i, sh := range d.shelves {
+		if !users[sh.UserID] {
+			return missing(repository.BackupShelves, i+1, "user", sh.UserID)
+		}
+	}
+	for i, sb := range d.shelfBooks {
+		if !shelves[sb.ShelfID] {
+			return missing(repository.BackupShelfBooks, i+1, "shelf", sb.ShelfID)
+		}
+		if !books[sb.BookID] {
+			return missing(repository.BackupShelfBooks, i+1, "book", sb.BookID)
+		}
+	}
+	for i, rv := range d.reviews {
+		if !users[rv.UserID] {
+			return missing(repository.BackupReviews, i+1, "user", rv.UserID)
+		}
+		if !books[rv.BookID] {
+			return missing(repository.BackupReviews, i+1, "book", rv.BookID)
+		}
+		if rv.ModeratedBy != nil && !users[*rv.ModeratedBy] {
+			return missing(repository.BackupReviews, i+1, "moderator", *rv.ModeratedBy)
+		}
+	}
+	return nil
+}
+
+// loadBackup writes the backup through tx table by table, mapping each
+// backed-up ID to the ID its row got. Cover images are stored before their
+// book is inserted; written collects their keys so a failed restore can
+// remove them.
+func (s *Service) loadBackup(ctx context.Context, tx repository.Restorer, d *backupData, report *RestoreReport, written *[]string) error {
+	rowError := func(table string, record int, err error) error {
+		if msg := domain.Message(err); msg != "" {
+			return domain.Wrap(domain.ErrValidation, fmt.Sprintf("%s.ndjson record %d: %s", table, record, msg), err)
+		}
+		return err
+	}
+	userIDs := make(map[int]int, len(d.users))
+	for i, bu := range d.users {
+		existing, err := tx.GetUserByEmail(ctx, bu.Email)
+		if err == nil {
+			userIDs[bu.ID] = existing.ID
+			report.MatchedUsers++
+			continue
+		}
+		if !errors.Is(err, domain.ErrNotFound) {
+			return err
+		}
+		u := &models.User{Email: bu.Email, Name: bu.Name, Role: bu.Role, PasswordHash: bu.PasswordHash}
+		if u.Role == "" {
+			u.Role = "user"
+		}
+		if u.PasswordHash == "" {
+			u.PasswordHash = noPassword
+			report.UsersWithoutPassword++
+		}
+		if err := tx.InsertUser(ctx, u); err != nil {
+			return rowError(repository.BackupUsers, i+1, err)
+		}
+		userIDs[bu.ID] = u.ID
+		report.Counts[repository.BackupUsers]++
+	}
+	userRef := func(id *int) *int {
+		if id == nil {
+			return nil
+		}
+		n := userIDs[*id]
+		return &n
+	}
+
+	authorIDs := make(map[int]int, len(d.authors))
+	for i, ba := range d.authors {
+		a := &models.Author{Name: ba.Name}
+		if err := tx.InsertAuthor(ctx, a); err != nil {
+			return rowError(repository.BackupAuthors, i+1, err)
+		}
+		authorIDs[ba.ID] = a.ID
+		report.Counts[repository.BackupAuthors]++
+	}
+
+	bookIDs := make(map[int]int, len(d.books))
+	for i, bb := range d.books {
+		b := &models.Book{Title: bb.Title, Description: bb.Description, AuthorID: authorIDs[bb.AuthorID],
+			ISBN: bb.ISBN, Publisher: bb.Publisher, PublishedYear: bb.PublishedYear, Language: bb.Language,
+			PageCount: bb.PageCount, Genres: bb.Genres, Tags: bb.Tags, CreatedBy: userRef(bb.CreatedBy), CreatedAt: bb.CreatedAt}
+		for _, c := range bb.Contributors {
+			b.Contributors = append(b.Contributors, models.Contributor{AuthorID: authorIDs[c.AuthorID], Role: c.Role})
+		}
+		if bb.Cover != "" {
+			// versions carry a hash of the image, so keeping them cannot clash
+			ok, err := s.restoreCover(ctx, d, models.Cover(bb.Cover), written)
+			if err != nil {
+				return err
+			}
+			if ok {
+				b.Cover = models.Cover(bb.Cover)
+				report.Covers++
+			}
+		}
+		if err := tx.InsertBook(ctx, b); err != nil {
+			return rowError(repository.BackupBooks, i+1, err)
+		}
+		bookIDs[bb.ID] = b.ID
+		report.Counts[repository.BackupBooks]++
+	}
+
+	shelfIDs := make(map[int]int, len(d.shelves))
+	for i, bs := range d.shelves {
+		sh := bs
+		sh.UserID = userIDs[bs.UserID]
+		if err := tx.InsertShelf(ctx, &sh); err != nil {
+			return rowError(repository.BackupShelves, i+1, err)
+		}
+		shelfIDs[bs.ID] = sh.ID
+		report.Counts[repository.BackupShelves]++
+	}
+	for i, bsb := range d.shelfBooks {
+		sb := bsb
+		sb.ShelfID, sb.BookID = shelfIDs[bsb.ShelfID], bookIDs[bsb.BookID]
+		if err := tx.InsertShelfBook(ctx, &sb); err != nil {
+			return rowError(repository.BackupShelfBooks, i+1, err)
+		}
+		report.Counts[repository.BackupShelfBooks]++
+	}
+	for i, brv := range d.reviews {
+		rv := brv
+		rv.UserID, rv.BookID, rv.ModeratedBy = userIDs[brv.UserID], bookIDs[brv.BookID], userRef(brv.ModeratedBy)
+		if err := tx.InsertReview(ctx, &rv); err != nil {
+			return rowError(repository.BackupReviews, i+1, err)
+		}
+		report.Counts[repository.BackupReviews]++
+	}
+	return nil
+}
+
+// restoreCover stores every size of a backed-up cover. It reports false,
+// storing nothing, when the archive lacks a size, the version is malformed
+// or there is no storage; the book is then restored without a cover.
+func (s *Service) restoreCover(ctx context.Context, d *backupData, cover models.Cover, written *[]string) (bool, error) {
+	if s.storage == nil || !coverPattern.MatchString(string(cover)) {
+		return false, nil
+	}
+	for _, size := range models.CoverSizes {
+		if _, ok := d.covers[coverKey(cover, size)]; !ok {
+			return false, nil
+		}
+	}
+	for _, size := range models.CoverSizes {
+		key := coverKey(cover, size)
+		img, err := readCoverFile(d.covers[key])
+		if err != nil {
+			return false, err
+		}
+		if err := s.storage.Put(ctx, key, img, "image/jpeg"); err != nil {
+			return false, err
+		}
+		*written = append(*written, key)
+	}
+	return true, nil
+}
+
+func readCoverFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, domain.Validation(fmt.Sprintf("the backup's %s cannot be read: %v", f.Name, err))
+	}
+	defer rc.Close()
+	img, err := io.ReadAll(io.LimitReader(rc, MaxCoverBytes+1))
+	if err != nil {
+		return nil, domain.Validation(fmt.Sprintf("the backup's %s cannot be read: %v", f.Name, err))
+	}
+	if len(img) > MaxCoverBytes {
+		return nil, domain.Validation(fmt.Sprintf("the backup's %s is larger than a cover may be", f.Name))
+	}
+	return img, nil
+}
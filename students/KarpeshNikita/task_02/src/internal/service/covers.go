@@ -0,0 +1,223 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"regexp"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/storage"
+	"github.com/example/books/pkg/models"
+)
+
+const (
+	// MaxCoverBytes caps the size of an uploaded cover image.
+	MaxCoverBytes = 5 << 20
+	// maxCoverPixels refuses images that would take too much memory to
+	// decode: 16 MP is 64 MB as RGBA, and about twice that while resizing.
+	maxCoverPixels = 16_000_000
+	// coverDecoders is how many uploads are decoded at once; the others wait.
+	coverDecoders = 2
+	coverQuality  = 85
+)
+
+// coverWidths is the largest width of each generated thumbnail; heights are
+// capped at one and a half times the width, the usual book proportions.
+var coverWidths = map[string]int{"small": 120, "medium": 300, "large": 600}
+
+var (
+	// ErrCoverTooLarge is returned for uploads over MaxCoverBytes.
+	ErrCoverTooLarge = domain.Validation(fmt.Sprintf("cover image must be at most %d MB", MaxCoverBytes>>20))
+	// ErrUnsupportedCover is returned for files that are not JPEG, PNG or GIF images.
+	ErrUnsupportedCover = domain.Validation("cover must be a JPEG, PNG or GIF image")
+
+	errNoStorage = errors.New("cover storage is not configured")
+	// coverDecodes holds a slot for each cover being decoded.
+	coverDecodes = make(chan struct{}, coverDecoders)
+	// coverPattern matches the versions UploadCover generates.
+	coverPattern = regexp.MustCompile(`^[0-9]+-[0-9a-f]{12}$`)
+)
+
+// SetStorage sets where cover images are kept.
+func (s *Service) SetStorage(st storage.Storage) {
+	s.storage = st
+}
+
+func coverKey(cover models.Cover, size string) string {
+	return "covers/" + string(cover) + "/" + size + ".jpg"
+}
+
+// UploadCover makes the image the book's cover. Thumbnails are stored under
+// a version derived from the content, so each version can be cached forever.
+// The previous version's files are removed.
+func (s *Service) UploadCover(ctx context.Context, a Actor, bookID int, data []byte) (*models.Book, error) {
+	if err := s.authorizeBook(ctx, a, bookID); err != nil {
+		return nil, err
+	}
+	if s.storage == nil {
+		return nil, errNoStorage
+	}
+	select {
+	case coverDecodes <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	thumbs, err := coverThumbnails(data)
+	<-coverDecodes
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.repo.GetBook(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+	previous := b.Cover
+	sum := sha256.Sum256(data)
+	cover := models.Cover(fmt.Sprintf("%d-%x", bookID, sum[:6]))
+	for _, size := range models.CoverSizes {
+		if err := s.storage.Put(ctx, coverKey(cover, size), thumbs[size], "image/jpeg"); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.SetBookCover(ctx, bookID, string(cover)); err != nil {
+		if cover != previous {
+			s.removeCover(ctx, cover)
+		}
+		return nil, err
+	}
+	if previous != cover {
+		s.removeCover(ctx, previous)
+	}
+	return s.repo.GetBook(ctx, bookID)
+}
+
+// DeleteCover removes the book's cover.
+func (s *Service) DeleteCover(ctx context.Context, a Actor, bookID int) error {
+	if err := s.authorizeBook(ctx, a, bookID); err != nil {
+		return err
+	}
+	b, err := s.repo.GetBook(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	cover := b.Cover
+	if cover == "" {
+		return domain.NotFound("book has no cover")
+	}
+	if err := s.repo.SetBookCover(ctx, bookID, ""); err != nil {
+		return err
+	}
+	s.removeCover(ctx, cover)
+	return nil
+}
+
+// OpenCover reads one size of a cover version. The caller closes the reader.
+func (s *Service) OpenCover(ctx context.Context, cover, size string) (io.ReadCloser, storage.Info, error) {
+	notFound := domain.NotFound("cover not found")
+	if _, ok := coverWidths[size]; !ok || !coverPattern.MatchString(cover) || s.storage == nil {
+		return nil, storage.Info{}, notFound
+	}
+	rc, info, err := s.storage.Get(ctx, coverKey(models.Cover(cover), size))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, storage.Info{}, notFound
+	}
+	return rc, info, err
+}
+
+// removeCover deletes a cover version's files. Failures only leave
+// unreferenced files behind, so they are not reported.
+func (s *Service) removeCover(ctx context.Context, cover models.Cover) {
+	if s.storage == nil || cover == "" {
+		return
+	}
+	for _, size := range models.CoverSizes {
+		_ = s.storage.Delete(ctx, coverKey(cover, size))
+	}
+}
+
+// coverThumbnails validates an uploaded image and renders every cover size as JPEG.
+func coverThumbnails(data []byte) (map[string][]byte, error) {
+	if len(data) > MaxCoverBytes {
+		return nil, ErrCoverTooLarge
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png" && format != "gif") {
+		return nil, ErrUnsupportedCover
+	}
+	if cfg.Width*cfg.Height > maxCoverPixels {
+		return nil, domain.Validation("cover image dimensions are too large")
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedCover
+	}
+	// shrink step by step, largest size first
+	cur := flatten(img)
+	out := make(map[string][]byte, len(models.CoverSizes))
+	for i := len(models.CoverSizes) - 1; i >= 0; i-- {
+		size := models.CoverSizes[i]
+		cur = shrink(cur, coverWidths[size], coverWidths[size]*3/2)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, cur, &jpeg.Options{Quality: coverQuality}); err != nil {
+			return nil, err
+		}
+		out[size] = buf.Bytes()
+	}
+	return out, nil
+}
+
+// flatten draws img onto white at the origin, so transparent areas do not
+// turn black in JPEG.
+func flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// shrink scales src down to fit within maxW×maxH, averaging the source
+// pixels behind each output pixel. Images that already fit are returned as is.
+func shrink(src *image.RGBA, maxW, maxH int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= maxW && h <= maxH {
+		return src
+	}
+	scale := math.Min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	dw := max(1, int(math.Round(float64(w)*scale)))
+	dh := max(1, int(math.Round(float64(h)*scale)))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := y * h / dh
+		y1 := max((y+1)*h/dh, y0+1)
+		for x := 0; x < dw; x++ {
+			x0 := x * w / dw
+			x1 := max((x+1)*w/dw, x0+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride : sy*src.Stride+w*4]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
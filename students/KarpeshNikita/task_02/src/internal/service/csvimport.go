@@ -0,0 +1,307 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// CSV import encodings. An import without one is read as UTF-8 unless the
+// file is not valid UTF-8, in which case it is read as Windows-1251.
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingWindows1251 = "windows-1251"
+)
+
+// csvFields are the book fields a CSV column can hold, under the names a
+// column mapping uses. Header cells match them ignoring case, spaces,
+// underscores and hyphens, so the export's "PublishedYear" needs no mapping.
+// ID and CreatedAt are recognised but not imported.
+var csvFields = []string{"id", "title", "description", "author_id", "created_at", "isbn", "publisher",
+	"published_year", "language", "page_count", "genres", "tags", "contributors"}
+
+// csvDelimiters are the delimiters recognised on the header line when an
+// import does not name one.
+const csvDelimiters = ",;\t|"
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// csvFieldKey folds a header cell or field name for matching.
+func csvFieldKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
+
+// csvField returns the field a header cell or mapping value names.
+func csvField(name string) (string, bool) {
+	key := csvFieldKey(name)
+	for _, f := range csvFields {
+		if csvFieldKey(f) == key {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// normalizeCSV checks the CSV options and puts them in canonical form.
+func (o *ImportOptions) normalizeCSV() error {
+	switch strings.ToLower(strings.TrimSpace(o.Encoding)) {
+	case "", "auto":
+		o.Encoding = ""
+	case "utf-8", "utf8":
+		o.Encoding = EncodingUTF8
+	case "windows-1251", "cp1251":
+		o.Encoding = EncodingWindows1251
+	default:
+		return domain.Validation("encoding must be utf-8 or windows-1251")
+	}
+	if o.Delimiter == `\t` || strings.EqualFold(o.Delimiter, "tab") {
+		o.Delimiter = "\t"
+	}
+	if o.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(o.Delimiter)
+		if size != len(o.Delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return domain.Validation("delimiter must be a single character such as , ; | or tab")
+		}
+	}
+	for column, name := range o.Mapping {
+		field, ok := csvField(name)
+		if !ok {
+			return domain.Validation(fmt.Sprintf("mapping: %q is not a book field; use one of %s", name, strings.Join(csvFields, ", ")))
+		}
+		o.Mapping[column] = field
+	}
+	return nil
+}
+
+// csvLayout says how to read a CSV import. It is worked out from the options
+// and the header line when the import starts, so a file that cannot be read
+// is refused before a job is created.
+type csvLayout struct {
+	encoding  string
+	delimiter rune
+	columns   map[string]int // field to column index
+	width     int            // number of header cells
+}
+
+// readCSVLayout reads the header of a spooled CSV import.
+func readCSVLayout(path string, opts ImportOptions) (csvLayout, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return csvLayout{}, err
+	}
+	defer f.Close()
+	l := csvLayout{encoding: opts.Encoding}
+	if l.encoding == "" {
+		if l.encoding, err = detectEncoding(f); err != nil {
+			return csvLayout{}, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return csvLayout{}, err
+		}
+	}
+	br := bufio.NewReader(csvText(f, l.encoding))
+	if opts.Delimiter != "" {
+		l.delimiter, _ = utf8.DecodeRuneInString(opts.Delimiter)
+	} else {
+		head, _ := br.Peek(br.Size())
+		l.delimiter = sniffDelimiter(head)
+	}
+	cr := csv.NewReader(br)
+	cr.Comma, cr.FieldsPerRecord = l.delimiter, -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return csvLayout{}, domain.Validation("the file is empty")
+	}
+	if err != nil {
+		return csvLayout{}, domain.Validation("malformed CSV: " + err.Error())
+	}
+	l.width, l.columns = len(header), make(map[string]int)
+
+	mapped := make(map[int]bool)
+	names := make([]string, 0, len(opts.Mapping))
+	for column := range opts.Mapping {
+		names = append(names, column)
+	}
+	sort.Strings(names)
+	for _, column := range names {
+		field := opts.Mapping[column]
+		i := headerIndex(header, column)
+		if i < 0 {
+			return csvLayout{}, domain.Validation(fmt.Sprintf("mapping: the header has no %q column", column))
+		}
+		if j, ok := l.columns[field]; ok {
+			return csvLayout{}, domain.Validation(fmt.Sprintf("mapping: columns %q and %q both map to %s", header[j], header[i], field))
+		}
+		l.columns[field], mapped[i] = i, true
+	}
+	// unmapped columns are matched by name; the mapping and earlier columns win
+	for i, name := range header {
+		field, ok := csvField(name)
+		if _, taken := l.columns[field]; !ok || taken || mapped[i] {
+			continue
+		}
+		l.columns[field] = i
+	}
+	if _, ok := l.columns["title"]; !ok {
+		return csvLayout{}, domain.Validation("the header has no Title column; name the title column in the mapping")
+	}
+	return l, nil
+}
+
+// headerIndex finds a mapped column by its header cell, ignoring case.
+func headerIndex(header []string, column string) int {
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(column)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// detectEncoding reads the file as UTF-8 and falls back to Windows-1251 at
+// the first byte that is not valid UTF-8.
+func detectEncoding(r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, size, err := br.ReadRune()
+		if err == io.EOF {
+			return EncodingUTF8, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == utf8.RuneError && size == 1 {
+			return EncodingWindows1251, nil
+		}
+	}
+}
+
+// csvText decodes a CSV import to UTF-8 and drops a byte order mark.
+func csvText(r io.Reader, encoding string) io.Reader {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		br.Discard(len(utf8BOM))
+	}
+	if encoding == EncodingWindows1251 {
+		return charmap.Windows1251.NewDecoder().Reader(br)
+	}
+	return br
+}
+
+// sniffDelimiter picks the delimiter seen most often outside quotes on the
+// first line, or a comma.
+func sniffDelimiter(head []byte) rune {
+	counts := make(map[rune]int)
+	quoted := false
+	for _, c := range string(head) {
+		if c == '"' {
+			quoted = !quoted
+		} else if quoted {
+			continue
+		} else if c == '\n' {
+			break
+		} else if strings.ContainsRune(csvDelimiters, c) {
+			counts[c]++
+		}
+	}
+	best := ','
+	for _, d := range csvDelimiters {
+		if counts[d] > counts[best] {
+			best = d
+		}
+	}
+	return best
+}
+
+// scanCSVImport reads the rows after the header. Rows are numbered by the
+// line they start on, so a report points into the file as an editor shows it.
+func scanCSVImport(r io.Reader, l csvLayout, fn func(importRecord) error) error {
+	cr := csv.NewReader(csvText(r, l.encoding))
+	cr.Comma, cr.FieldsPerRecord = l.delimiter, -1
+	if _, err := cr.Read(); err != nil {
+		return domain.Validation("malformed CSV: " + err.Error())
+	}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return domain.Validation(fmt.Sprintf("malformed CSV on line %d: %v", parseErr.StartLine, parseErr.Err))
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		b, err := l.book(row)
+		if err := fn(importRecord{n: line, book: b, err: err}); err != nil {
+			return err
+		}
+	}
+}
+
+// book reads one row. Errors quote the offending value.
+func (l csvLayout) book(row []string) (*models.Book, error) {
+	cell := func(field string) string {
+		if i, ok := l.columns[field]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	get := func(field string) string { return strings.TrimSpace(cell(field)) }
+	number := func(field, name string) (int, error) {
+		v := get(field)
+		if v == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, domain.Validation(fmt.Sprintf("invalid %s %q", name, v))
+		}
+		return n, nil
+	}
+	labels := func(field string) []string {
+		if get(field) == "" {
+			return nil
+		}
+		return strings.Split(get(field), labelSeparator)
+	}
+
+	b := &models.Book{Title: get("title"), Description: cell("description"), ISBN: get("isbn"),
+		Publisher: get("publisher"), Language: get("language"), Genres: labels("genres"), Tags: labels("tags")}
+	if len(row) != l.width {
+		return b, domain.Validation(fmt.Sprintf("expected %d fields as in the header, found %d", l.width, len(row)))
+	}
+	var err error
+	if b.AuthorID, err = number("author_id", "author id"); err != nil {
+		return b, err
+	}
+	if b.PublishedYear, err = number("published_year", "published year"); err != nil {
+		return b, err
+	}
+	if b.PageCount, err = number("page_count", "page count"); err != nil {
+		return b, err
+	}
+	if b.Contributors, err = parseContributors(labels("contributors")); err != nil {
+		return b, err
+	}
+	return b, requireTitle(b)
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/example/books/pkg/models"
+)
+
+// Export formats. ExportNDJSON writes one book object per line.
+const (
+	ExportJSON   = "json"
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// ExportBooks writes every book matching the query filters to w in the given
+// format and the query's sort order. Paging fields are ignored. Books are
+// written as they are read, so nothing reaches w if the query itself fails.
+func (s *Service) ExportBooks(ctx context.Context, w io.Writer, format string, q BookQuery) error {
+	q.Cursor, q.Offset = "", 0
+	if err := q.Normalize(); err != nil {
+		return err
+	}
+	if err := normalizeBookFilters(&q); err != nil {
+		return err
+	}
+	switch format {
+	case ExportJSON:
+		return s.exportJSON(ctx, w, q)
+	case ExportCSV:
+		return s.exportCSV(ctx, w, q)
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+		return s.repo.StreamBooks(ctx, q, func(b *models.Book) error { return enc.Encode(b) })
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// exportJSON writes an indented array laid out as json.MarshalIndent would.
+func (s *Service) exportJSON(ctx context.Context, w io.Writer, q BookQuery) error {
+	sep := "[\n  "
+	err := s.repo.StreamBooks(ctx, q, func(b *models.Book) error {
+		data, err := json.MarshalIndent(b, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		sep = ",\n  "
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	end := "\n]"
+	if sep == "[\n  " {
+		end = "[]"
+	}
+	_, err = io.WriteString(w, end)
+	return err
+}
+
+func (s *Service) exportCSV(ctx context.Context, w io.Writer, q BookQuery) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	err := s.repo.StreamBooks(ctx, q, func(b *models.Book) error {
+		return cw.Write(csvRow(b))
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvRow renders a book in the csvHeader layout.
+func csvRow(b *models.Book) []string {
+	return []string{
+		strconv.Itoa(b.ID),
+		b.Title,
+		b.Description,
+		strconv.Itoa(b.AuthorID),
+		b.CreatedAt.String(),
+		b.ISBN,
+		b.Publisher,
+		optionalInt(b.PublishedYear),
+		b.Language,
+		optionalInt(b.PageCount),
+		strings.Join(b.Genres, labelSeparator),
+		strings.Join(b.Tags, labelSeparator),
+		formatContributors(b.Contributors),
+	}
+}
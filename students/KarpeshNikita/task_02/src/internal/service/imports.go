@@ -0,0 +1,689 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+)
+
+// Import formats, modes, error handling and job statuses.
+const (
+	ImportCSV  = "csv"
+	ImportJSON = "json"
+	// ImportGoodreads and ImportLibraryThing read a user's library exported
+	// from those sites into their own shelves, reading statuses and reviews.
+	ImportGoodreads    = "goodreads"
+	ImportLibraryThing = "librarything"
+
+	// ImportCreate adds every record as a new book; ImportUpsert updates the
+	// book a record matches; ImportSkipExisting leaves matched books alone.
+	// A record matches the book with its ISBN or, without one, the book with
+	// its title and primary author.
+	ImportCreate       = "create"
+	ImportUpsert       = "upsert"
+	ImportSkipExisting = "skip_existing"
+
+	// ImportRollback imports nothing if any record is refused; ImportSkip
+	// imports the valid records and reports the others.
+	ImportRollback = "rollback"
+	ImportSkip     = "skip"
+
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+	ImportCancelled = "cancelled"
+)
+
+const (
+	// MaxImportBytes caps the size of an uploaded import file.
+	MaxImportBytes = 64 << 20
+	// maxImportErrors and maxImportChanges cap the records kept for the
+	// reports; the counters still count every one.
+	maxImportErrors  = 1000
+	maxImportChanges = 1000
+	// importProgressEvery is how many records pass between progress saves.
+	importProgressEvery = 100
+	importSaveTimeout   = 10 * time.Second
+	// Each import spools its file to disk and runs in its own goroutine, so
+	// a user runs one at a time and the server at most maxRunningImports.
+	maxUserImports    = 1
+	maxRunningImports = 4
+)
+
+var (
+	// ErrImportTooLarge is returned for uploads over MaxImportBytes.
+	ErrImportTooLarge = domain.Validation(fmt.Sprintf("import file must be at most %d MB", MaxImportBytes>>20))
+
+	// ErrImportRunning is returned while the user's previous import runs.
+	ErrImportRunning = domain.Conflict("an import of yours is still running; wait for it or cancel it")
+	// ErrImportsBusy is returned while the server runs maxRunningImports.
+	ErrImportsBusy = domain.Conflict("too many imports are running; try again later")
+
+	errImportFinished = domain.Conflict("import has already finished")
+)
+
+// importRecord is one book read from an import file, or why it could not be read.
+type importRecord struct {
+	n     int // position in the file, as reported to the user
+	book  *models.Book
+	entry *libraryEntry // the user's copy, for library imports
+	err   error
+}
+
+// ImportOptions says how StartImport treats the records of a file.
+type ImportOptions struct {
+	Format  string // csv, json, goodreads or librarything
+	Mode    string // create (the default), upsert or skip_existing; catalog imports only
+	OnError string // rollback (the default) or skip; catalog imports only
+	DryRun  bool   // work out the changes without writing them
+
+	// CSV imports only. Delimiter and Encoding are detected when empty;
+	// Mapping names the book field a header column holds, for columns
+	// whose header does not name a field already.
+	Delimiter string
+	Encoding  string
+	Mapping   map[string]string
+}
+
+func (o *ImportOptions) normalize() error {
+	if o.Format != ImportCSV && (o.Delimiter != "" || o.Encoding != "" || len(o.Mapping) > 0) {
+		return domain.Validation("delimiter, encoding and mapping apply to CSV imports only")
+	}
+	if isLibraryImport(o.Format) {
+		// library rows never update catalog books and are imported one by one
+		if (o.Mode != "" && o.Mode != ImportCreate) || (o.OnError != "" && o.OnError != ImportSkip) {
+			return domain.Validation("mode and on_error do not apply to library imports")
+		}
+		o.Mode, o.OnError = ImportCreate, ImportSkip
+		return nil
+	}
+	if o.Format != ImportCSV && o.Format != ImportJSON {
+		return domain.Validation("format must be csv, json, goodreads or librarything")
+	}
+	if o.Mode == "" {
+		o.Mode = ImportCreate
+	}
+	if o.Mode != ImportCreate && o.Mode != ImportUpsert && o.Mode != ImportSkipExisting {
+		return domain.Validation("mode must be create, upsert or skip_existing")
+	}
+	if o.OnError == "" {
+		o.OnError = ImportRollback
+	}
+	if o.OnError != ImportRollback && o.OnError != ImportSkip {
+		return domain.Validation("on_error must be rollback or skip")
+	}
+	if o.Format == ImportCSV {
+		return o.normalizeCSV()
+	}
+	return nil
+}
+
+// importSource is a spooled upload and how to read it.
+type importSource struct {
+	path   string
+	format string
+	csv    csvLayout // CSV imports only
+}
+
+// StartImport saves the upload to a temporary file and imports it in the
+// background. The returned job's progress is read with GetImport.
+func (s *Service) StartImport(ctx context.Context, a Actor, opts ImportOptions, r io.Reader) (*models.ImportJob, error) {
+	if err := opts.normalize(); err != nil {
+		return nil, err
+	}
+	if err := s.reserveImport(a.UserID); err != nil {
+		return nil, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			s.releaseImport(a.UserID)
+		}
+	}()
+	path, err := spoolImport(r)
+	if err != nil {
+		return nil, err
+	}
+	src := importSource{path: path, format: opts.Format}
+	if opts.Format == ImportCSV {
+		// the header is read now, so a file that cannot be mapped is refused at once
+		if src.csv, err = readCSVLayout(path, opts); err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+	}
+	job := &models.ImportJob{UserID: a.UserID, Format: opts.Format, Mode: opts.Mode, OnError: opts.OnError,
+		DryRun: opts.DryRun, Status: ImportRunning}
+	if err := s.repo.CreateImportJob(ctx, job); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	s.importsMu.Lock()
+	if s.imports == nil {
+		s.imports = make(map[int]context.CancelFunc)
+	}
+	s.imports[job.ID] = cancel
+	s.importsMu.Unlock()
+
+	run := *job
+	started = true
+	go func() {
+		defer os.Remove(path)
+		s.runImport(runCtx, &run, a, src)
+		// the slot is free before the job reads as finished, so the user
+		// can start the next import as soon as they see this one end
+		s.releaseImport(a.UserID)
+		s.saveImport(&run)
+		s.importsMu.Lock()
+		delete(s.imports, run.ID)
+		s.importsMu.Unlock()
+		cancel()
+	}()
+	return job, nil
+}
+
+// reserveImport takes one of the user's import slots and one of the server's,
+// before the upload is spooled.
+func (s *Service) reserveImport(userID int) error {
+	s.importsMu.Lock()
+	defer s.importsMu.Unlock()
+	if s.importSlots[userID] >= maxUserImports {
+		return ErrImportRunning
+	}
+	if s.importCount >= maxRunningImports {
+		return ErrImportsBusy
+	}
+	if s.importSlots == nil {
+		s.importSlots = make(map[int]int)
+	}
+	s.importSlots[userID]++
+	s.importCount++
+	return nil
+}
+
+func (s *Service) releaseImport(userID int) {
+	s.importsMu.Lock()
+	defer s.importsMu.Unlock()
+	if s.importSlots[userID]--; s.importSlots[userID] <= 0 {
+		delete(s.importSlots, userID)
+	}
+	s.importCount--
+}
+
+// GetImport returns an import started by the actor; admins see every import.
+func (s *Service) GetImport(ctx context.Context, a Actor, id int) (*models.ImportJob, error) {
+	job, err := s.repo.GetImportJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != a.UserID && !a.IsAdmin() {
+		return nil, domain.NotFound("import not found")
+	}
+	return job, nil
+}
+
+// CancelImport stops a running import. Records already imported with
+// on_error=skip are kept; a rollback import leaves nothing behind.
+func (s *Service) CancelImport(ctx context.Context, a Actor, id int) (*models.ImportJob, error) {
+	job, err := s.GetImport(ctx, a, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != ImportRunning {
+		return nil, errImportFinished
+	}
+	s.importsMu.Lock()
+	cancel, ok := s.imports[id]
+	s.importsMu.Unlock()
+	if ok {
+		cancel()
+		return job, nil
+	}
+	// Nobody runs it any more, so record the end ourselves. The update only
+	// applies while the job is still running, so an import that finished in
+	// the meantime keeps its real outcome.
+	cancelled, err := s.repo.CancelImportJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job, err = s.repo.GetImportJob(ctx, id); err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, errImportFinished
+	}
+	return job, nil
+}
+
+// ImportErrorReport lists the records an import refused as CSV.
+func (s *Service) ImportErrorReport(ctx context.Context, a Actor, id int) ([]byte, error) {
+	job, err := s.GetImport(ctx, a, id)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	first := "Record"
+	if job.Format == ImportCSV {
+		first = "Line" // CSV rows are numbered by the line they start on
+	}
+	if err := w.Write([]string{first, "Title", "Error"}); err != nil {
+		return nil, err
+	}
+	for _, e := range job.Errors {
+		if err := w.Write([]string{strconv.Itoa(e.Record), e.Title, e.Message}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// InterruptImports fails the imports a previous run of the server left
+// unfinished. Call it once at startup, before serving requests.
+func (s *Service) InterruptImports(ctx context.Context) error {
+	return s.repo.InterruptImportJobs(ctx, "the server stopped during the import; nothing more will be imported")
+}
+
+// spoolImport copies the upload to a temporary file, so the import streams
+// it instead of holding it in memory.
+func spoolImport(r io.Reader) (string, error) {
+	return spool(r, "books-import-*", MaxImportBytes, ErrImportTooLarge)
+}
+
+// spool copies at most limit bytes of r to a new temporary file and returns
+// its name, or tooLarge if r holds more.
+func spool(r io.Reader, pattern string, limit int64, tooLarge error) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = tooLarge
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// runImport reads the file twice: once to count its records, once to import
+// them. The outcome is left on job for the caller to save.
+func (s *Service) runImport(ctx context.Context, job *models.ImportJob, a Actor, src importSource) {
+	err := scanImport(src, func(importRecord) error {
+		job.Total++
+		return ctx.Err()
+	})
+	if err == nil {
+		s.saveImport(job)
+		if isLibraryImport(job.Format) {
+			err = s.importLibrary(ctx, job, a, src)
+		} else if job.OnError == ImportSkip {
+			err = s.importEach(ctx, job, a, src)
+		} else {
+			err = s.importAll(ctx, job, a, src)
+		}
+	}
+	switch {
+	case err == nil:
+		job.Status = ImportCompleted
+	case errors.Is(err, context.Canceled):
+		job.Status = ImportCancelled
+	default:
+		job.Status, job.Error = ImportFailed, domain.Message(err)
+		if job.Error == "" {
+			log.Printf("import %d: %v", job.ID, err)
+			job.Error = "internal error"
+		}
+	}
+	now := time.Now()
+	job.FinishedAt = &now
+}
+
+// importEach saves the books one by one, skipping refused records.
+func (s *Service) importEach(ctx context.Context, job *models.ImportJob, a Actor, src importSource) error {
+	keys := make(map[string]int)
+	return scanImport(src, func(rec importRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b, change, err := s.planRecord(ctx, job, a, rec, keys)
+		if err == nil && b != nil && !job.DryRun {
+			if b.ID == 0 {
+				err = isbnConflict(s.repo.CreateBook(ctx, b), b)
+			} else {
+				err = isbnConflict(s.repo.UpdateBook(ctx, b), b)
+			}
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			refuseRecord(job, rec, err)
+		} else {
+			recordChange(job, change)
+			if b != nil && !job.DryRun {
+				job.Imported++
+			}
+		}
+		s.advanceImport(job)
+		return nil
+	})
+}
+
+// importAll plans every record first and then saves all books in one
+// transaction, or none if any record is refused.
+func (s *Service) importAll(ctx context.Context, job *models.ImportJob, a Actor, src importSource) error {
+	var books []*models.Book
+	var records []int
+	keys := make(map[string]int)
+	err := scanImport(src, func(rec importRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b, change, err := s.planRecord(ctx, job, a, rec, keys)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			refuseRecord(job, rec, err)
+		} else {
+			recordChange(job, change)
+			if b != nil {
+				books, records = append(books, b), append(records, rec.n)
+			}
+		}
+		s.advanceImport(job)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if job.Failed > 0 {
+		return domain.Validation(fmt.Sprintf("%d of %d records were refused; nothing was imported", job.Failed, job.Total))
+	}
+	if job.DryRun {
+		return nil
+	}
+	n, err := s.repo.SaveBooks(ctx, books)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if n < len(books) {
+			refuseRecord(job, importRecord{n: records[n], book: books[n]}, isbnConflict(err, books[n]))
+			return domain.Validation(fmt.Sprintf("record %d was refused; nothing was imported", records[n]))
+		}
+		return err
+	}
+	job.Imported = len(books)
+	return nil
+}
+
+// planRecord works out what importing a record does. It returns the book to
+// create (without an ID) or update, or nil when the record is skipped.
+// keys holds the natural keys of the file's earlier records, so a book the
+// file names twice is refused the second time.
+func (s *Service) planRecord(ctx context.Context, job *models.ImportJob, a Actor, rec importRecord, keys map[string]int) (*models.Book, models.ImportChange, error) {
+	change := models.ImportChange{Record: rec.n}
+	if rec.err != nil {
+		return nil, change, rec.err
+	}
+	b := rec.book
+	listed := len(b.Contributors) > 0
+	if err := s.prepareBook(ctx, b); err != nil {
+		return nil, change, err
+	}
+	change.Title = b.Title
+
+	// create mode only matches by ISBN, to refuse duplicates
+	title := b.Title
+	if job.Mode == ImportCreate {
+		title = ""
+	}
+	if key := importKey(b.ISBN, title, b.AuthorID); key != "" {
+		if first, ok := keys[key]; ok {
+			if b.ISBN != "" {
+				return nil, change, domain.Conflict(fmt.Sprintf("ISBN %s is also used by record %d", b.ISBN, first))
+			}
+			return nil, change, domain.Conflict(fmt.Sprintf("same book as record %d", first))
+		}
+		keys[key] = rec.n
+	}
+
+	stored, err := s.repo.FindBookByKey(ctx, b.ISBN, title, b.AuthorID)
+	if errors.Is(err, domain.ErrNotFound) {
+		b.CreatedBy = &a.UserID
+		change.Action = models.ImportActionCreate
+		return b, change, nil
+	}
+	if err != nil {
+		return nil, change, err
+	}
+	change.BookID = stored.ID
+	switch job.Mode {
+	case ImportCreate:
+		return nil, change, domain.Conflict("a book with ISBN " + b.ISBN + " already exists")
+	case ImportSkipExisting:
+		change.Action, change.Reason = models.ImportActionSkip, "already exists"
+		return nil, change, nil
+	}
+	merged := mergeBook(stored, b, listed)
+	if change.Fields = bookChanges(stored, merged); len(change.Fields) == 0 {
+		change.Action, change.Reason = models.ImportActionSkip, "unchanged"
+		return nil, change, nil
+	}
+	change.Action = models.ImportActionUpdate
+	return merged, change, nil
+}
+
+// importKey is a record's natural key: its ISBN or, when title is set, its
+// title and primary author.
+func importKey(isbn, title string, authorID int) string {
+	switch {
+	case isbn != "":
+		return "isbn:" + isbn
+	case title != "":
+		return fmt.Sprintf("title:%d:%s", authorID, strings.ToLower(title))
+	}
+	return ""
+}
+
+// mergeBook applies an imported record to the stored book it matched.
+// Fields the record leaves empty keep their stored values. Contributors are
+// replaced when the record lists them or names another primary author.
+func mergeBook(stored, rec *models.Book, listed bool) *models.Book {
+	m := *stored
+	m.Title = rec.Title
+	if rec.Description != "" {
+		m.Description = rec.Description
+	}
+	if listed || rec.AuthorID != stored.AuthorID {
+		m.AuthorID, m.AuthorName, m.Contributors = rec.AuthorID, rec.AuthorName, rec.Contributors
+	}
+	if rec.ISBN != "" {
+		m.ISBN = rec.ISBN
+	}
+	if rec.Publisher != "" {
+		m.Publisher = rec.Publisher
+	}
+	if rec.PublishedYear != 0 {
+		m.PublishedYear = rec.PublishedYear
+	}
+	if rec.Language != "" {
+		m.Language = rec.Language
+	}
+	if rec.PageCount != 0 {
+		m.PageCount = rec.PageCount
+	}
+	if len(rec.Genres) > 0 {
+		m.Genres = rec.Genres
+	}
+	if len(rec.Tags) > 0 {
+		m.Tags = rec.Tags
+	}
+	return &m
+}
+
+// bookChanges lists the fields whose values differ between two versions of a book.
+func bookChanges(from, to *models.Book) map[string]models.FieldChange {
+	fields := func(b *models.Book) []string {
+		return []string{b.Title, b.Description, strconv.Itoa(b.AuthorID), formatContributors(b.Contributors),
+			b.ISBN, b.Publisher, optionalInt(b.PublishedYear), b.Language, optionalInt(b.PageCount),
+			strings.Join(b.Genres, labelSeparator), strings.Join(b.Tags, labelSeparator)}
+	}
+	names := []string{"title", "description", "author_id", "contributors", "isbn", "publisher",
+		"published_year", "language", "page_count", "genres", "tags"}
+	old, updated := fields(from), fields(to)
+	changes := make(map[string]models.FieldChange)
+	for i, name := range names {
+		if old[i] != updated[i] {
+			changes[name] = models.FieldChange{From: old[i], To: updated[i]}
+		}
+	}
+	return changes
+}
+
+// recordChange counts what a record does and adds it to the change report.
+func recordChange(job *models.ImportJob, c models.ImportChange) {
+	switch c.Action {
+	case models.ImportActionCreate:
+		job.Created++
+	case models.ImportActionUpdate:
+		job.Updated++
+	case models.ImportActionSkip:
+		job.Skipped++
+	case models.ImportActionMatch:
+		job.Matched++
+	}
+	if len(job.Changes) < maxImportChanges {
+		job.Changes = append(job.Changes, c)
+	}
+}
+
+// advanceImport counts a handled record and saves the progress now and then.
+func (s *Service) advanceImport(job *models.ImportJob) {
+	job.Processed++
+	if job.Processed%importProgressEvery == 0 {
+		s.saveImport(job)
+	}
+}
+
+// saveImport records the job's state. The import goes on when that fails;
+// the next save catches up.
+func (s *Service) saveImport(job *models.ImportJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), importSaveTimeout)
+	defer cancel()
+	if err := s.repo.UpdateImportJob(ctx, job); err != nil {
+		log.Printf("import %d: save progress: %v", job.ID, err)
+	}
+}
+
+// refuseRecord counts a record that was not imported and adds it to the report.
+func refuseRecord(job *models.ImportJob, rec importRecord, err error) {
+	job.Failed++
+	if len(job.Errors) >= maxImportErrors {
+		return
+	}
+	msg := domain.Message(err)
+	if msg == "" {
+		log.Printf("import %d: record %d: %v", job.ID, rec.n, err)
+		msg = "internal error"
+	}
+	e := models.ImportError{Record: rec.n, Message: msg}
+	if rec.book != nil {
+		e.Title = rec.book.Title
+	}
+	job.Errors = append(job.Errors, e)
+}
+
+// scanImport passes each record of the spooled file to fn. A file that
+// cannot be parsed stops the scan; a bad record is only passed on with its error.
+func scanImport(src importSource, fn func(importRecord) error) error {
+	f, err := os.Open(src.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch {
+	case src.format == ImportJSON:
+		return scanJSONImport(f, fn)
+	case isLibraryImport(src.format):
+		return scanLibraryImport(f, src.format, fn)
+	}
+	return scanCSVImport(f, src.csv, fn)
+}
+
+func isLibraryImport(format string) bool {
+	return format == ImportGoodreads || format == ImportLibraryThing
+}
+
+// jsonImportBook is a book as exported. Exported covers point at the
+// exporting server's files, so they are not imported.
+type jsonImportBook struct {
+	models.Book
+	Cover json.RawMessage `json:"cover"`
+}
+
+// scanJSONImport reads an array of books in the export format, numbered from 1.
+func scanJSONImport(r io.Reader, fn func(importRecord) error) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return domain.Validation("JSON import must be an array of books")
+	}
+	for n := 1; dec.More(); n++ {
+		var in jsonImportBook
+		err := dec.Decode(&in)
+		var typeErr *json.UnmarshalTypeError
+		if err != nil && !errors.As(err, &typeErr) {
+			return domain.Validation("malformed JSON: " + err.Error())
+		}
+		m := in.Book
+		b := &models.Book{Title: strings.TrimSpace(m.Title), Description: m.Description, AuthorID: m.AuthorID,
+			Contributors: m.Contributors, ISBN: m.ISBN, Publisher: m.Publisher, PublishedYear: m.PublishedYear,
+			Language: m.Language, PageCount: m.PageCount, Genres: m.Genres, Tags: m.Tags}
+		switch {
+		case typeErr != nil && typeErr.Field == "":
+			err = domain.Validation("expected a book object")
+		case typeErr != nil:
+			err = domain.Validation("invalid " + typeErr.Field)
+		default:
+			err = requireTitle(b)
+		}
+		if err := fn(importRecord{n: n, book: b, err: err}); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return domain.Validation("malformed JSON: " + err.Error())
+	}
+	return nil
+}
+
+func requireTitle(b *models.Book) error {
+	if b.Title == "" {
+		return domain.Validation("title is required")
+	}
+	return nil
+}
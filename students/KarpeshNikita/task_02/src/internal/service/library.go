@@ -0,0 +1,531 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/pkg/models"
+)
+
+// libraryEntry is what a Goodreads or LibraryThing row says about the
+// importing user's copy of a book.
+type libraryEntry struct {
+	authors    []libraryAuthor // primary author first
+	status     string          // reading status, or "" for none
+	startedAt  *time.Time
+	finishedAt *time.Time
+	shelves    []string
+	rating     int // 1 to 5, or 0 when unrated
+	review     string
+}
+
+type libraryAuthor struct {
+	name string
+	role string
+}
+
+// goodreadsStatuses maps Goodreads exclusive shelves to reading statuses.
+// Other exclusive shelves become shelves of the same name.
+var goodreadsStatuses = map[string]string{
+	"read":              StatusFinished,
+	"currently-reading": StatusReading,
+	"to-read":           StatusWantToRead,
+	"did-not-finish":    StatusAbandoned,
+	"dnf":               StatusAbandoned,
+	"abandoned":         StatusAbandoned,
+}
+
+// libraryThingStatuses maps LibraryThing collections to reading statuses.
+// "Your library" holds every owned book and is dropped; other collections
+// become shelves of the same name.
+var libraryThingStatuses = map[string]string{
+	"currently reading": StatusReading,
+	"to read":           StatusWantToRead,
+	"read but unowned":  StatusFinished,
+	"your library":      "",
+}
+
+// libraryRow reads a row by column name.
+type libraryRow struct {
+	cols map[string]int
+	row  []string
+}
+
+func (r libraryRow) get(name string) string {
+	i, ok := r.cols[strings.ToLower(name)]
+	if !ok || i >= len(r.row) {
+		return ""
+	}
+	return strings.TrimSpace(r.row[i])
+}
+
+func (r libraryRow) number(name, what string) (int, error) {
+	v := r.get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, domain.Validation("invalid " + what)
+	}
+	return n, nil
+}
+
+func (r libraryRow) date(name, layout string) (*time.Time, error) {
+	v := r.get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(layout, v)
+	if err != nil {
+		return nil, domain.Validation("invalid " + strings.ToLower(name))
+	}
+	return &t, nil
+}
+
+// scanLibraryImport reads a Goodreads library export (CSV) or a LibraryThing
+// export (tab-separated). Columns are found by their header, and rows are
+// numbered from the header.
+func scanLibraryImport(r io.Reader, format string, fn func(importRecord) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	source, required := "Goodreads library export", []string{"Title", "Author"}
+	parse := goodreadsRow
+	if format == ImportLibraryThing {
+		cr.Comma, cr.LazyQuotes = '\t', true
+		source, required = "LibraryThing export", []string{"Title", "Primary Author"}
+		parse = libraryThingRow
+	}
+	header, err := cr.Read()
+	if err == io.EOF {
+		return domain.Validation("the file is empty")
+	}
+	if err != nil {
+		return domain.Validation("malformed " + source + ": " + err.Error())
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range required {
+		if _, ok := cols[strings.ToLower(name)]; !ok {
+			return domain.Validation("not a " + source + ": the " + name + " column is missing")
+		}
+	}
+	for n := 2; ; n++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return domain.Validation("malformed " + source + ": " + err.Error())
+		}
+		b, e, err := parse(libraryRow{cols: cols, row: row})
+		if err == nil {
+			err = requireTitle(b)
+		}
+		if err := fn(importRecord{n: n, book: b, entry: e, err: err}); err != nil {
+			return err
+		}
+	}
+}
+
+// goodreadsRow reads one row of the Goodreads library export.
+func goodreadsRow(r libraryRow) (*models.Book, *libraryEntry, error) {
+	b := &models.Book{Title: r.get("Title"), Publisher: r.get("Publisher")}
+	e := &libraryEntry{review: goodreadsReview(r.get("My Review"))}
+	// ISBNs are written as ="0441172717" so spreadsheets keep the leading zero
+	for _, col := range []string{"ISBN13", "ISBN"} {
+		if b.ISBN = strings.Trim(strings.TrimPrefix(r.get(col), "="), `"`); b.ISBN != "" {
+			break
+		}
+	}
+	if name := r.get("Author"); name != "" {
+		e.authors = append(e.authors, libraryAuthor{name: name, role: models.RoleAuthor})
+	}
+	for _, name := range strings.Split(r.get("Additional Authors"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			e.authors = append(e.authors, libraryAuthor{name: name, role: models.RoleAuthor})
+		}
+	}
+	var err error
+	if b.PublishedYear, err = r.number("Year Published", "year published"); err != nil {
+		return b, e, err
+	}
+	if b.PublishedYear == 0 {
+		if b.PublishedYear, err = r.number("Original Publication Year", "original publication year"); err != nil {
+			return b, e, err
+		}
+	}
+	if b.PageCount, err = r.number("Number of Pages", "number of pages"); err != nil {
+		return b, e, err
+	}
+	if e.rating, err = r.number("My Rating", "rating"); err != nil {
+		return b, e, err
+	}
+	if e.rating < 0 || e.rating > 5 {
+		return b, e, domain.Validation("rating must be between 0 and 5")
+	}
+	if e.finishedAt, err = r.date("Date Read", "2006/01/02"); err != nil {
+		return b, e, err
+	}
+
+	exclusive := strings.ToLower(r.get("Exclusive Shelf"))
+	e.status = goodreadsStatuses[exclusive]
+	if e.status != StatusFinished {
+		e.finishedAt = nil
+	}
+	shelves := strings.Split(r.get("Bookshelves"), ",")
+	if e.status == "" {
+		shelves = append(shelves, exclusive)
+	}
+	for _, name := range shelves {
+		name = strings.TrimSpace(name)
+		if _, status := goodreadsStatuses[strings.ToLower(name)]; name != "" && !status {
+			e.shelves = appendShelf(e.shelves, name)
+		}
+	}
+	return b, e, nil
+}
+
+// goodreadsReview turns the line breaks of a Goodreads review into text.
+func goodreadsReview(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("<br/>", "\n", "<br />", "\n", "<br>", "\n").Replace(s))
+}
+
+// libraryThingRow reads one row of the LibraryThing tab-separated export.
+func libraryThingRow(r libraryRow) (*models.Book, *libraryEntry, error) {
+	b := &models.Book{Title: r.get("Title"), Publisher: libraryThingPublisher(r.get("Publication"))}
+	e := &libraryEntry{review: r.get("Review")}
+	// ISBN is written as [0441172717]; ISBNs lists every known one
+	b.ISBN = strings.Trim(r.get("ISBN"), "[]")
+	if b.ISBN == "" {
+		b.ISBN, _, _ = strings.Cut(r.get("ISBNs"), ",")
+	}
+	b.ISBN = strings.TrimSpace(b.ISBN)
+	if name := r.get("Primary Author"); name != "" {
+		e.authors = append(e.authors, libraryAuthor{name: invertName(name), role: libraryRole(r.get("Primary Author Role"))})
+	}
+	roles := strings.Split(r.get("Secondary Author Roles"), "|")
+	for i, name := range strings.Split(r.get("Secondary Author"), "|") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		role := models.RoleAuthor
+		if i < len(roles) {
+			role = libraryRole(roles[i])
+		}
+		e.authors = append(e.authors, libraryAuthor{name: invertName(name), role: role})
+	}
+	var err error
+	if year := r.get("Date"); len(year) >= 4 {
+		// a year, a full date or a range such as 1965-1966
+		if b.PublishedYear, err = strconv.Atoi(year[:4]); err != nil {
+			return b, e, domain.Validation("invalid date")
+		}
+	}
+	if b.PageCount, err = r.number("Page Count", "page count"); err != nil {
+		return b, e, err
+	}
+	if v := r.get("Rating"); v != "" {
+		// half stars round up
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 5 {
+			return b, e, domain.Validation("rating must be between 0 and 5")
+		}
+		e.rating = int(math.Round(f))
+	}
+	if e.startedAt, err = r.date("Date Started", "2006-01-02"); err != nil {
+		return b, e, err
+	}
+	if e.finishedAt, err = r.date("Date Read", "2006-01-02"); err != nil {
+		return b, e, err
+	}
+
+	for _, name := range strings.Split(r.get("Collections"), ",") {
+		name = strings.TrimSpace(name)
+		status, ok := libraryThingStatuses[strings.ToLower(name)]
+		switch {
+		case name == "":
+		case !ok:
+			e.shelves = appendShelf(e.shelves, name)
+		case status != "" && e.status == "":
+			e.status = status
+		}
+	}
+	switch {
+	case e.finishedAt != nil:
+		e.status = StatusFinished
+	case e.status == "" && e.startedAt != nil:
+		e.status = StatusReading
+	}
+	return b, e, nil
+}
+
+// libraryThingPublisher takes the publisher from a publication such as
+// "Ace (1990), Mass Market Paperback, 535 pages".
+func libraryThingPublisher(publication string) string {
+	publisher, _, ok := strings.Cut(publication, " (")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(publisher)
+}
+
+// libraryRole maps a LibraryThing author role to a contributor role.
+// Roles without an equivalent, and an empty one, credit an author.
+func libraryRole(role string) string {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if contributorRoles[role] {
+		return role
+	}
+	return models.RoleAuthor
+}
+
+// invertName turns "Herbert, Frank" into "Frank Herbert".
+func invertName(name string) string {
+	last, first, ok := strings.Cut(name, ",")
+	if !ok || strings.Contains(first, ",") {
+		return strings.TrimSpace(name)
+	}
+	return strings.TrimSpace(strings.TrimSpace(first) + " " + strings.TrimSpace(last))
+}
+
+func appendShelf(shelves []string, name string) []string {
+	for _, s := range shelves {
+		if strings.EqualFold(s, name) {
+			return shelves
+		}
+	}
+	return append(shelves, name)
+}
+
+// libraryState caches the authors and shelves a library import looked up or created.
+type libraryState struct {
+	authors map[string]int // by lowercased name
+	shelves map[string]int // the user's shelves by lowercased name; nil until first needed
+}
+
+// importLibrary adds each row to the user's library: the book, found in the
+// catalog or created with any missing authors, then the reading status,
+// shelves, rating and review. Rows are imported one by one, and rows that
+// cannot be matched to a book are reported.
+func (s *Service) importLibrary(ctx context.Context, job *models.ImportJob, a Actor, src importSource) error {
+	lib := &libraryState{authors: make(map[string]int)}
+	return scanImport(src, func(rec importRecord) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		change, err := s.importLibraryRow(ctx, job, a, rec, lib)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			refuseRecord(job, rec, err)
+		} else {
+			recordChange(job, change)
+			if !job.DryRun {
+				job.Imported++
+			}
+		}
+		s.advanceImport(job)
+		return nil
+	})
+}
+
+func (s *Service) importLibraryRow(ctx context.Context, job *models.ImportJob, a Actor, rec importRecord, lib *libraryState) (models.ImportChange, error) {
+	change := models.ImportChange{Record: rec.n}
+	if rec.err != nil {
+		return change, rec.err
+	}
+	b, e := rec.book, rec.entry
+	change.Title = b.Title
+	if err := cleanBookMetadata(b); err != nil {
+		return change, err
+	}
+	contributors, err := s.libraryAuthors(ctx, job.DryRun, e.authors, lib)
+	if err != nil {
+		return change, err
+	}
+	// a dry run leaves new authors without an ID; their books can only match by ISBN
+	title, primary := "", primaryAuthor(contributors)
+	if primary != 0 {
+		title = b.Title
+	}
+	stored, err := s.repo.FindBookByKey(ctx, b.ISBN, title, primary)
+	switch {
+	case err == nil:
+		change.Action, change.BookID = models.ImportActionMatch, stored.ID
+	case errors.Is(err, domain.ErrNotFound):
+		if len(contributors) == 0 {
+			return change, domain.Validation("no book in the catalog matches, and the row names no author to add it with")
+		}
+		change.Action = models.ImportActionCreate
+		if !job.DryRun {
+			b.Contributors, b.CreatedBy = contributors, &a.UserID
+			if err := s.prepareBook(ctx, b); err != nil {
+				return change, err
+			}
+			if err := isbnConflict(s.repo.CreateBook(ctx, b), b); err != nil {
+				return change, err
+			}
+			change.BookID = b.ID
+		}
+	default:
+		return change, err
+	}
+
+	var notes []string
+	if e.review != "" && e.rating == 0 {
+		notes = append(notes, "the review has no rating and was not imported")
+	}
+	if !job.DryRun {
+		more, err := s.applyLibraryEntry(ctx, a, change.BookID, e, lib)
+		if err != nil {
+			return change, err
+		}
+		notes = append(notes, more...)
+	}
+	change.Reason = strings.Join(notes, "; ")
+	return change, nil
+}
+
+// primaryAuthor picks the author resolveContributors would make primary.
+func primaryAuthor(cs models.Contributors) int {
+	for _, c := range cs {
+		if c.Role == models.RoleAuthor {
+			return c.AuthorID
+		}
+	}
+	if len(cs) == 0 {
+		return 0
+	}
+	return cs[0].AuthorID
+}
+
+// libraryAuthors finds the credited authors by name and creates the missing
+// ones, except in a dry run, where they are left with ID 0.
+func (s *Service) libraryAuthors(ctx context.Context, dryRun bool, authors []libraryAuthor, lib *libraryState) (models.Contributors, error) {
+	var out models.Contributors
+	seen := make(map[libraryAuthor]bool, len(authors))
+	for _, la := range authors {
+		key := libraryAuthor{name: strings.ToLower(la.name), role: la.role}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		id, ok := lib.authors[key.name]
+		if !ok {
+			found, err := s.repo.FindAuthorByName(ctx, la.name)
+			switch {
+			case err == nil:
+				id = found.ID
+			case !errors.Is(err, domain.ErrNotFound):
+				return nil, err
+			case !dryRun:
+				created := &models.Author{Name: la.name}
+				if err := s.repo.CreateAuthor(ctx, created); err != nil {
+					return nil, err
+				}
+				id = created.ID
+			}
+			lib.authors[key.name] = id
+		}
+		out = append(out, models.Contributor{AuthorID: id, Role: la.role})
+	}
+	return out, nil
+}
+
+// applyLibraryEntry records the user's status, shelves, rating and review of
+// a book. What the user already has is kept where the two cannot both hold:
+// an existing review stays. It returns notes on what was not imported.
+func (s *Service) applyLibraryEntry(ctx context.Context, a Actor, bookID int, e *libraryEntry, lib *libraryState) ([]string, error) {
+	var notes []string
+	// note refuses a step with a message for the user; other errors stop the row
+	note := func(what string, err error) error {
+		if msg := domain.Message(err); msg != "" {
+			notes = append(notes, what+": "+msg)
+			return nil
+		}
+		return err
+	}
+	if e.status != "" {
+		upd := ReadingUpdate{Status: e.status, StartedAt: e.startedAt, FinishedAt: e.finishedAt}
+		if upd.StartedAt == nil {
+			upd.StartedAt = upd.FinishedAt
+		}
+		if _, err := s.SetReadingStatus(ctx, a.UserID, bookID, upd); err != nil {
+			if err := note("reading status not set", err); err != nil {
+				return notes, err
+			}
+		}
+	}
+	for _, name := range e.shelves {
+		shelfID, err := s.libraryShelf(ctx, a, name, lib)
+		if err == nil {
+			err = s.repo.AddBookToShelf(ctx, shelfID, bookID)
+		}
+		if err != nil {
+			if err := note("not added to shelf "+name, err); err != nil {
+				return notes, err
+			}
+		}
+	}
+	if e.rating > 0 {
+		err := s.CreateReviewFromModel(ctx, &models.Review{UserID: a.UserID, BookID: bookID, Text: e.review, Rating: e.rating})
+		if errors.Is(err, ErrDuplicateReview) {
+			notes = append(notes, "already reviewed; the existing review was kept")
+		} else if err != nil {
+			if err := note("review not imported", err); err != nil {
+				return notes, err
+			}
+		}
+	}
+	return notes, nil
+}
+
+// libraryShelf returns the user's shelf with the name, ignoring case, and
+// creates a private one when there is none.
+func (s *Service) libraryShelf(ctx context.Context, a Actor, name string, lib *libraryState) (int, error) {
+	if lib.shelves == nil {
+		lib.shelves = make(map[string]int)
+		q := ShelfQuery{UserID: a.UserID, Limit: repository.MaxLimit}
+		for {
+			page, err := s.repo.QueryShelves(ctx, q)
+			if err != nil {
+				lib.shelves = nil
+				return 0, err
+			}
+			for _, sh := range page {
+				if _, ok := lib.shelves[strings.ToLower(sh.Name)]; !ok {
+					lib.shelves[strings.ToLower(sh.Name)] = sh.ID
+				}
+			}
+			if len(page) < q.Limit {
+				break
+			}
+			q.Offset += q.Limit
+		}
+	}
+	key := strings.ToLower(strings.TrimSpace(name))
+	if id, ok := lib.shelves[key]; ok {
+		return id, nil
+	}
+	sh := &models.Shelf{UserID: a.UserID, Name: name}
+	if err := s.CreateShelfFromModel(ctx, sh); err != nil {
+		return 0, err
+	}
+	lib.shelves[key] = sh.ID
+	return sh.ID, nil
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+)
+
+// Limits on book metadata.
+const (
+	maxPublisher = 200
+	maxLabel     = 50
+	maxLabels    = 20
+	maxPageCount = 100000
+)
+
+// normalizeISBN validates an ISBN-10 or ISBN-13, ignoring hyphens and spaces,
+// and returns it as ISBN-13 so both forms of one book collide.
+func normalizeISBN(s string) (string, error) {
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	switch len(s) {
+	case 10:
+		sum := 0
+		for i, c := range s {
+			d := int(c - '0')
+			if c == 'X' && i == 9 {
+				d = 10
+			} else if c < '0' || c > '9' {
+				return "", domain.Validation("isbn must contain only digits")
+			}
+			sum += d * (10 - i)
+		}
+		if sum%11 != 0 {
+			return "", domain.Validation("isbn checksum does not match")
+		}
+		return isbn13("978" + s[:9]), nil
+	case 13:
+		for _, c := range s {
+			if c < '0' || c > '9' {
+				return "", domain.Validation("isbn must contain only digits")
+			}
+		}
+		if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+			return "", domain.Validation("isbn-13 must start with 978 or 979")
+		}
+		if isbn13(s[:12]) != s {
+			return "", domain.Validation("isbn checksum does not match")
+		}
+		return s, nil
+	}
+	return "", domain.Validation("isbn must have 10 or 13 digits")
+}
+
+// isbn13 appends the EAN-13 check digit to twelve digits.
+func isbn13(digits string) string {
+	sum := 0
+	for i, c := range digits {
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return digits + string(rune('0'+(10-sum%10)%10))
+}
+
+// normalizeLanguage accepts ISO 639-1/2/3 codes in any case.
+func normalizeLanguage(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "", nil
+	}
+	if len(s) < 2 || len(s) > 3 {
+		return "", domain.Validation("language must be an ISO 639 code such as en or ru")
+	}
+	for _, c := range s {
+		if c < 'a' || c > 'z' {
+			return "", domain.Validation("language must be an ISO 639 code such as en or ru")
+		}
+	}
+	return s, nil
+}
+
+// cleanLabel lower-cases a genre or tag and collapses inner whitespace.
+func cleanLabel(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// cleanLabels normalizes, de-duplicates and sorts genre or tag names.
+func cleanLabels(kind string, names []string) (models.Labels, error) {
+	seen := make(map[string]bool, len(names))
+	out := models.Labels{}
+	for _, n := range names {
+		n = cleanLabel(n)
+		if n == "" || seen[n] {
+			continue
+		}
+		if utf8.RuneCountInString(n) > maxLabel {
+			return nil, domain.Validation(fmt.Sprintf("%s %q is too long", kind, n))
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	if len(out) > maxLabels {
+		return nil, domain.Validation(fmt.Sprintf("a book can have at most %d %ss", maxLabels, kind))
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// cleanBookMetadata validates and normalizes the catalog fields of b in place.
+func cleanBookMetadata(b *models.Book) error {
+	var err error
+	if strings.TrimSpace(b.ISBN) != "" {
+		if b.ISBN, err = normalizeISBN(b.ISBN); err != nil {
+			return err
+		}
+	} else {
+		b.ISBN = ""
+	}
+	b.Publisher = strings.TrimSpace(b.Publisher)
+	if utf8.RuneCountInString(b.Publisher) > maxPublisher {
+		return domain.Validation("publisher is too long")
+	}
+	if b.PublishedYear < 0 || b.PublishedYear > time.Now().Year()+1 {
+		return domain.Validation("published_year is out of range")
+	}
+	if b.PageCount < 0 || b.PageCount > maxPageCount {
+		return domain.Validation("page_count is out of range")
+	}
+	if b.Language, err = normalizeLanguage(b.Language); err != nil {
+		return err
+	}
+	if b.Genres, err = cleanLabels("genre", b.Genres); err != nil {
+		return err
+	}
+	if b.Tags, err = cleanLabels("tag", b.Tags); err != nil {
+		return err
+	}
+	return nil
+}
+
+// isbnConflict gives duplicate ISBNs a clearer message than the generic one.
+func isbnConflict(err error, b *models.Book) error {
+	if b.ISBN != "" && errors.Is(err, domain.ErrConflict) {
+		return domain.Conflict("a book with ISBN " + b.ISBN + " already exists")
+	}
+	return err
+}
+
+// normalizeBookFilters brings metadata filters into the stored form.
+func normalizeBookFilters(q *BookQuery) error {
+	q.Genre = cleanLabel(q.Genre)
+	q.Tag = cleanLabel(q.Tag)
+	var err error
+	if q.Language, err = normalizeLanguage(q.Language); err != nil {
+		return err
+	}
+	if q.ISBN != "" {
+		if q.ISBN, err = normalizeISBN(q.ISBN); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListGenres returns the genres in use with their book counts.
+func (s *Service) ListGenres(ctx context.Context) ([]models.LabelCount, error) {
+	return s.repo.ListGenres(ctx)
+}
+
+// ListTags returns the tags in use with their book counts.
+func (s *Service) ListTags(ctx context.Context) ([]models.LabelCount, error) {
+	return s.repo.ListTags(ctx)
+}
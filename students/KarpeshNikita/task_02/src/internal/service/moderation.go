@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/moderation"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/pkg/models"
+)
+
+// DefaultReportThreshold is the number of reports that hides a review until a
+// moderator looks at it.
+const DefaultReportThreshold = 3
+
+var (
+	// ErrReviewRefused is returned for review text matching a reject rule.
+	ErrReviewRefused = domain.Validation("review contains text that is not allowed")
+	// ErrOwnReviewReport is returned when a user reports their own review.
+	ErrOwnReviewReport = domain.Forbidden("you cannot report your own review")
+)
+
+type ReviewQuery = repository.ReviewQuery
+type ReviewReportModel = models.ReviewReport
+
+// ModerationConfig controls how reviews are screened.
+type ModerationConfig struct {
+	// Filter decides whether review text is published, held or refused.
+	Filter *moderation.Filter
+	// ReportThreshold is the number of reports that sends an approved review
+	// back to the queue; 0 disables it.
+	ReportThreshold int
+}
+
+// SetModeration replaces the review moderation settings.
+func (s *Service) SetModeration(cfg ModerationConfig) {
+	s.moderation = cfg
+}
+
+// screenReview returns the status for review text, given the status it would
+// otherwise get.
+func (s *Service) screenReview(text, status string) (string, error) {
+	switch s.moderation.Filter.Check(text).Action {
+	case moderation.Reject:
+		return "", ErrReviewRefused
+	case moderation.Hold:
+		return ReviewPending, nil
+	}
+	return status, nil
+}
+
+func validReviewStatus(status string) bool {
+	return status == ReviewPending || status == ReviewApproved || status == ReviewRejected
+}
+
+// ReportReview files a user's report against a public review. Once enough users
+// have reported it, the review is hidden until a moderator decides; reviews a
+// moderator already approved stay visible.
+func (s *Service) ReportReview(ctx context.Context, a Actor, id int, reason string) (*models.Review, error) {
+	rv, err := s.repo.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rv.Status != ReviewApproved {
+		return nil, ErrReviewNotFound
+	}
+	if rv.UserID == a.UserID {
+		return nil, ErrOwnReviewReport
+	}
+	count, err := s.repo.ReportReview(ctx, &models.ReviewReport{ReviewID: id, UserID: a.UserID, Reason: reason})
+	if err != nil {
+		return nil, err
+	}
+	if limit := s.moderation.ReportThreshold; limit > 0 && count >= limit && rv.ModeratedBy == nil {
+		note := fmt.Sprintf("hidden after %d reports", count)
+		if err := s.repo.SetReviewStatus(ctx, id, ReviewPending, nil, note); err != nil {
+			return nil, err
+		}
+	}
+	return s.repo.GetReview(ctx, id)
+}
+
+// ReviewQueue returns one page of reviews in a moderation state (pending by
+// default) and the total number of them.
+func (s *Service) ReviewQueue(ctx context.Context, a Actor, q ReviewQuery) ([]models.Review, int, error) {
+	if !a.IsAdmin() {
+		return nil, 0, ErrForbidden
+	}
+	q.Normalize()
+	if !validReviewStatus(q.Status) {
+		return nil, 0, domain.Validation("unknown review status " + q.Status)
+	}
+	reviews, err := s.repo.QueryReviews(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.repo.CountReviews(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reviews, total, nil
+}
+
+func (s *Service) ReviewReports(ctx context.Context, a Actor, id int) ([]models.ReviewReport, error) {
+	if !a.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	if _, err := s.repo.GetReview(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.repo.ListReviewReports(ctx, id)
+}
+
+// ModerateReview approves or rejects a review; the note is kept for the record.
+func (s *Service) ModerateReview(ctx context.Context, a Actor, id int, status, note string) (*models.Review, error) {
+	if !a.IsAdmin() {
+		return nil, ErrForbidden
+	}
+	if status != ReviewApproved && status != ReviewRejected {
+		return nil, domain.Validation("status must be approved or rejected")
+	}
+	if _, err := s.repo.GetReview(ctx, id); err != nil {
+		return nil, err
+	}
+	moderator := a.UserID
+	if err := s.repo.SetReviewStatus(ctx, id, status, &moderator, note); err != nil {
+		return nil, err
+	}
+	return s.repo.GetReview(ctx, id)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+)
+
+// Reading states a user can put a book in.
+const (
+	StatusWantToRead = "want_to_read"
+	StatusReading    = "reading"
+	StatusFinished   = "finished"
+	StatusAbandoned  = "abandoned"
+)
+
+// ReadingStatuses lists the valid states in shelf order.
+var ReadingStatuses = []string{StatusWantToRead, StatusReading, StatusFinished, StatusAbandoned}
+
+type ReadingStatusModel = models.ReadingStatus
+type ReadingEventModel = models.ReadingEvent
+
+// ReadingUpdate changes a reading status. Nil fields keep their current value;
+// an empty Status keeps the current status (or starts with want_to_read).
+type ReadingUpdate struct {
+	Status      string
+	CurrentPage *int
+	Percent     *int
+	StartedAt   *time.Time
+	FinishedAt  *time.Time
+}
+
+func validReadingStatus(s string) bool {
+	for _, v := range ReadingStatuses {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) GetReadingStatus(ctx context.Context, userID, bookID int) (*models.ReadingStatus, error) {
+	return s.repo.GetReadingStatus(ctx, userID, bookID)
+}
+
+// ListReading returns the user's books, optionally filtered by status.
+func (s *Service) ListReading(ctx context.Context, userID int, status string) ([]models.ReadingStatus, error) {
+	if status != "" && !validReadingStatus(status) {
+		return nil, domain.Validation("unknown reading status " + status)
+	}
+	return s.repo.ListReadingStatuses(ctx, userID, status)
+}
+
+func (s *Service) ReadingHistory(ctx context.Context, userID, bookID int) ([]models.ReadingEvent, error) {
+	return s.repo.ListReadingHistory(ctx, userID, bookID)
+}
+
+func (s *Service) ClearReadingStatus(ctx context.Context, userID, bookID int) error {
+	return s.repo.DeleteReadingStatus(ctx, userID, bookID)
+}
+
+// SetReadingStatus applies an update to the user's status of a book. Start and
+// finish dates are filled in automatically when the book moves to reading or
+// finished, and every status change is recorded in the history.
+func (s *Service) SetReadingStatus(ctx context.Context, userID, bookID int, upd ReadingUpdate) (*models.ReadingStatus, error) {
+	if upd.Status != "" && !validReadingStatus(upd.Status) {
+		return nil, domain.Validation("unknown reading status " + upd.Status)
+	}
+	if upd.CurrentPage != nil && *upd.CurrentPage < 0 {
+		return nil, domain.Validation("current_page must not be negative")
+	}
+	if upd.Percent != nil && (*upd.Percent < 0 || *upd.Percent > 100) {
+		return nil, domain.Validation("percent must be between 0 and 100")
+	}
+	book, err := s.repo.GetBook(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := s.repo.GetReadingStatus(ctx, userID, bookID)
+	if errors.Is(err, domain.ErrNotFound) {
+		rs = &models.ReadingStatus{UserID: userID, BookID: bookID}
+	} else if err != nil {
+		return nil, err
+	}
+	previous := rs.Status
+	if upd.Status != "" {
+		rs.Status = upd.Status
+	}
+	if rs.Status == "" {
+		rs.Status = StatusWantToRead
+	}
+	if upd.CurrentPage != nil {
+		rs.CurrentPage = upd.CurrentPage
+	}
+	if upd.Percent != nil {
+		rs.Percent = upd.Percent
+	}
+	if upd.StartedAt != nil {
+		rs.StartedAt = upd.StartedAt
+	}
+	if upd.FinishedAt != nil {
+		rs.FinishedAt = upd.FinishedAt
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	changed := rs.Status != previous
+	if changed {
+		switch rs.Status {
+		case StatusReading:
+			if rs.StartedAt == nil {
+				rs.StartedAt = &now
+			}
+			if previous == StatusFinished && upd.FinishedAt == nil {
+				// re-reading
+				rs.FinishedAt = nil
+			}
+		case StatusFinished:
+			if rs.StartedAt == nil {
+				rs.StartedAt = &now
+			}
+			if rs.FinishedAt == nil {
+				rs.FinishedAt = &now
+			}
+			if upd.Percent == nil {
+				full := 100
+				rs.Percent = &full
+			}
+		}
+	}
+	if rs.StartedAt != nil && rs.FinishedAt != nil && rs.FinishedAt.Before(*rs.StartedAt) {
+		return nil, domain.Validation("finished_at must not be before started_at")
+	}
+
+	if err := s.repo.SaveReadingStatus(ctx, rs, changed); err != nil {
+		return nil, err
+	}
+	rs.BookTitle = book.Title
+	return rs, nil
+}
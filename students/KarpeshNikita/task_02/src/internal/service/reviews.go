@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/pkg/models"
+)
+
+// Review list orders.
+const (
+	ReviewSortNewest  = repository.ReviewSortNewest
+	ReviewSortHelpful = repository.ReviewSortHelpful
+)
+
+// Review moderation states.
+const (
+	ReviewPending  = repository.ReviewPending
+	ReviewApproved = repository.ReviewApproved
+	ReviewRejected = repository.ReviewRejected
+)
+
+var (
+	// ErrDuplicateReview is returned when a user reviews the same book twice.
+	ErrDuplicateReview = domain.Conflict("you have already reviewed this book")
+	// ErrOwnReviewVote is returned when a user marks their own review as helpful.
+	ErrOwnReviewVote = domain.Forbidden("you cannot vote for your own review")
+	// ErrReviewNotFound hides reviews that are not public from other users.
+	ErrReviewNotFound = domain.NotFound("review not found")
+)
+
+func validRating(rating int) error {
+	if rating < 1 || rating > 5 {
+		return domain.Validation("rating must be between 1 and 5")
+	}
+	return nil
+}
+
+func (s *Service) CreateReview(ctx context.Context, rv *models.Review) error {
+	return s.CreateReviewFromModel(ctx, rv)
+}
+
+// CreateReviewFromModel stores a new review; every user gets one review per book.
+// Text caught by the content filter is refused or held for moderation.
+func (s *Service) CreateReviewFromModel(ctx context.Context, m *ReviewModel) error {
+	if err := validRating(m.Rating); err != nil {
+		return err
+	}
+	status, err := s.screenReview(m.Text, ReviewApproved)
+	if err != nil {
+		return err
+	}
+	r := &models.Review{UserID: m.UserID, BookID: m.BookID, Text: m.Text, Rating: m.Rating, Status: status}
+	if err := s.repo.CreateReview(ctx, r); err != nil {
+		if errors.Is(err, domain.ErrConflict) {
+			return ErrDuplicateReview
+		}
+		return err
+	}
+	m.ID = r.ID
+	m.Status = r.Status
+	m.CreatedAt = r.CreatedAt
+	return nil
+}
+
+func (s *Service) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	return s.repo.GetReview(ctx, id)
+}
+
+// ListReviews returns a book's reviews ordered by ReviewSortNewest (the
+// default) or ReviewSortHelpful.
+func (s *Service) ListReviews(ctx context.Context, bookID int, sort string) ([]models.Review, error) {
+	switch sort {
+	case "", ReviewSortNewest, ReviewSortHelpful:
+	default:
+		return nil, fmt.Errorf("%w: unknown review sort %q", ErrInvalidQuery, sort)
+	}
+	return s.repo.ListReviewsByBook(ctx, bookID, sort)
+}
+
+// authorizeReview allows the review's author or an admin to modify it.
+func (s *Service) authorizeReview(ctx context.Context, a Actor, id int) (*models.Review, error) {
+	rv, err := s.repo.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !a.IsAdmin() && rv.UserID != a.UserID {
+		return nil, ErrForbidden
+	}
+	return rv, nil
+}
+
+// UpdateReview changes the text and rating of a review. The new text passes
+// through the content filter again, and an edited rejected review goes back to
+// the moderation queue.
+func (s *Service) UpdateReview(ctx context.Context, a Actor, id int, text string, rating int) (*models.Review, error) {
+	if err := validRating(rating); err != nil {
+		return nil, err
+	}
+	rv, err := s.authorizeReview(ctx, a, id)
+	if err != nil {
+		return nil, err
+	}
+	current := rv.Status
+	if current == ReviewRejected {
+		current = ReviewPending
+	}
+	if rv.Status, err = s.screenReview(text, current); err != nil {
+		return nil, err
+	}
+	rv.Text = text
+	rv.Rating = rating
+	if err := s.repo.UpdateReview(ctx, rv); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+func (s *Service) DeleteReview(ctx context.Context, a Actor, id int) error {
+	if _, err := s.authorizeReview(ctx, a, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteReview(ctx, id)
+}
+
+// VoteHelpful records the actor's helpful vote and returns the updated review.
+// Voting twice has no further effect.
+func (s *Service) VoteHelpful(ctx context.Context, a Actor, id int) (*models.Review, error) {
+	rv, err := s.repo.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rv.Status != ReviewApproved {
+		return nil, ErrReviewNotFound
+	}
+	if rv.UserID == a.UserID {
+		return nil, ErrOwnReviewVote
+	}
+	if err := s.repo.AddReviewVote(ctx, id, a.UserID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetReview(ctx, id)
+}
+
+// UnvoteHelpful withdraws the actor's helpful vote and returns the updated review.
+func (s *Service) UnvoteHelpful(ctx context.Context, a Actor, id int) (*models.Review, error) {
+	if _, err := s.repo.GetReview(ctx, id); err != nil {
+		return nil, err
+	}
+	if err := s.repo.RemoveReviewVote(ctx, id, a.UserID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetReview(ctx, id)
+}
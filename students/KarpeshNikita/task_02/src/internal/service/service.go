@@ -1,55 +1,81 @@
 package service
 
 import (
-	"bytes"
-	"encoding/csv"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/example/books/internal/domain"
 	"github.com/example/books/internal/repository"
+	"github.com/example/books/internal/storage"
 	"github.com/example/books/pkg/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Service struct {
-	repo repository.Repository
+	repo       repository.Repository
+	moderation ModerationConfig
+	storage    storage.Storage
+
+	importsMu   sync.Mutex
+	imports     map[int]context.CancelFunc // running imports by job id
+	importSlots map[int]int                // imports being uploaded or run, by user id
+	importCount int                        // the sum of importSlots
 }
 
 func NewService(r repository.Repository) *Service {
-	return &Service{repo: r}
+	return &Service{repo: r, moderation: ModerationConfig{ReportThreshold: DefaultReportThreshold}}
 }
 
-func (s *Service) RegisterUser(email, password, name string) (*models.User, error) {
+// ErrUserExists is returned when registering an email that is already taken.
+var ErrUserExists = domain.Conflict("email is already registered")
+
+// ErrInvalidCredentials is returned for an unknown email or a wrong password.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+func (s *Service) RegisterUser(ctx context.Context, email, password, name string) (*models.User, error) {
 	// check existing
-	if u, err := s.repo.GetUserByEmail(email); err == nil && u != nil && u.ID != 0 {
-		return nil, errors.New("user exists")
+	_, err := s.repo.GetUserByEmail(ctx, email)
+	if err == nil {
+		return nil, ErrUserExists
+	}
+	if !errors.Is(err, domain.ErrNotFound) {
+		return nil, err
 	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
 	u := &models.User{Email: email, PasswordHash: string(hash), Name: name, Role: "user"}
-	if err := s.repo.CreateUser(u); err != nil {
+	if err := s.repo.CreateUser(ctx, u); err != nil {
+		// lost a race with a concurrent registration
+		if errors.Is(err, domain.ErrConflict) {
+			return nil, ErrUserExists
+		}
 		return nil, err
 	}
 	return u, nil
 }
 
-func (s *Service) Authenticate(email, password string) (*models.User, error) {
-	u, err := s.repo.GetUserByEmail(email)
+func (s *Service) Authenticate(ctx context.Context, email, password string) (*models.User, error) {
+	u, err := s.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
 		return nil, err
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 	return u, nil
 }
 
-func (s *Service) ListBooks() ([]models.Book, error) {
-	return s.repo.ListBooks()
+func (s *Service) ListBooks(ctx context.Context) ([]models.Book, error) {
+	return s.repo.ListBooks(ctx)
 }
 
 // Adapter types used by handlers
@@ -57,182 +83,164 @@ func (s *Service) ListBooks() ([]models.Book, error) {
 type BookModel = models.Book
 type ShelfModel = models.Shelf
 type ReviewModel = models.Review
-
-func (s *Service) CreateBook(b *models.Book) error {
-	return s.repo.CreateBook(b)
-}
-
-func (s *Service) CreateBookFromModel(m *BookModel) error {
-	b := &models.Book{Title: m.Title, Description: m.Description, AuthorID: m.AuthorID}
-	if err := s.repo.CreateBook(b); err != nil {
-		return err
+type BookQuery = repository.BookQuery
+type BookPage = repository.BookPage
+type ShelfQuery = repository.ShelfQuery
+type SearchQuery = repository.SearchQuery
+type SearchPage = repository.SearchPage
+type TopRatedQuery = repository.TopRatedQuery
+
+// MaxPageSize caps the number of rows a single listing returns.
+const MaxPageSize = repository.MaxLimit
+
+// ErrInvalidQuery is returned for unknown sort fields or malformed cursors.
+var ErrInvalidQuery = repository.ErrInvalidQuery
+
+// QueryBooks returns one page of books for the given filters and sort order.
+func (s *Service) QueryBooks(ctx context.Context, q BookQuery) (*BookPage, error) {
+	if err := q.Normalize(); err != nil {
+		return nil, err
 	}
-	// propagate generated fields back to model
-	m.ID = b.ID
-	m.CreatedAt = b.CreatedAt
-	return nil
-}
-
-func (s *Service) GetBook(id int) (*models.Book, error) {
-	return s.repo.GetBook(id)
+	if err := normalizeBookFilters(&q); err != nil {
+		return nil, err
+	}
+	return s.repo.QueryBooks(ctx, q)
 }
 
-func (s *Service) UpdateBook(b *models.Book) error {
-	return s.repo.UpdateBook(b)
+// CountBooks returns the number of books matching the query filters.
+func (s *Service) CountBooks(ctx context.Context, q BookQuery) (int, error) {
+	if err := normalizeBookFilters(&q); err != nil {
+		return 0, err
+	}
+	return s.repo.CountBooks(ctx, q)
 }
 
-func (s *Service) UpdateBookFromModel(m *BookModel) error {
-	b := &models.Book{ID: m.ID, Title: m.Title, Description: m.Description, AuthorID: m.AuthorID}
-	return s.repo.UpdateBook(b)
+// SearchBooks runs a ranked full-text search over title, description and author name.
+func (s *Service) SearchBooks(ctx context.Context, q SearchQuery) (*SearchPage, error) {
+	q.Normalize()
+	return s.repo.SearchBooks(ctx, q)
 }
 
-func (s *Service) DeleteBook(id int) error {
-	return s.repo.DeleteBook(id)
+func (s *Service) CreateBook(ctx context.Context, b *models.Book) error {
+	if err := s.prepareBook(ctx, b); err != nil {
+		return err
+	}
+	return isbnConflict(s.repo.CreateBook(ctx, b), b)
 }
 
-func (s *Service) CreateShelf(sh *models.Shelf) error {
-	return s.repo.CreateShelf(sh)
+// prepareBook normalizes a new book's metadata and resolves its contributors.
+func (s *Service) prepareBook(ctx context.Context, b *models.Book) error {
+	if err := cleanBookMetadata(b); err != nil {
+		return err
+	}
+	return s.resolveContributors(ctx, b)
 }
 
-func (s *Service) CreateShelfFromModel(m *ShelfModel) error {
-	shelf := &models.Shelf{UserID: m.UserID, Name: m.Name}
-	if err := s.repo.CreateShelf(shelf); err != nil {
+func (s *Service) CreateBookFromModel(ctx context.Context, m *BookModel) error {
+	b := &models.Book{Title: m.Title, Description: m.Description, AuthorID: m.AuthorID, Contributors: m.Contributors,
+		CreatedBy: m.CreatedBy, ISBN: m.ISBN, Publisher: m.Publisher, PublishedYear: m.PublishedYear, Language: m.Language,
+		PageCount: m.PageCount, Genres: m.Genres, Tags: m.Tags}
+	if err := s.CreateBook(ctx, b); err != nil {
 		return err
 	}
-	m.ID = shelf.ID
+	// propagate generated and normalized fields back to model
+	*m = *b
 	return nil
 }
 
-func (s *Service) ListShelves() ([]models.Shelf, error) {
-	return s.repo.ListShelves()
-}
-
-func (s *Service) GetShelf(id int) (*models.Shelf, error) {
-	return s.repo.GetShelf(id)
-}
-
-func (s *Service) ListBooksByShelf(shelfID int) ([]models.Book, error) {
-	return s.repo.ListBooksByShelf(shelfID)
-}
-
-func (s *Service) AddBookToShelf(shelfID int, bookID int) error {
-	return s.repo.AddBookToShelf(shelfID, bookID)
+func (s *Service) GetBook(ctx context.Context, id int) (*models.Book, error) {
+	return s.repo.GetBook(ctx, id)
 }
 
-func (s *Service) GetUserByID(id int) (*models.User, error) {
-	return s.repo.GetUserByID(id)
-}
-
-func (s *Service) UpdateUserRole(userID int, role string) error {
-	return s.repo.UpdateUserRole(userID, role)
+func (s *Service) UpdateBook(ctx context.Context, a Actor, b *models.Book) error {
+	if err := cleanBookMetadata(b); err != nil {
+		return err
+	}
+	if err := s.authorizeBook(ctx, a, b.ID); err != nil {
+		return err
+	}
+	if err := s.resolveContributors(ctx, b); err != nil {
+		return err
+	}
+	return isbnConflict(s.repo.UpdateBook(ctx, b), b)
 }
 
-func (s *Service) CreateReview(rv *models.Review) error {
-	return s.repo.CreateReview(rv)
+func (s *Service) UpdateBookFromModel(ctx context.Context, a Actor, m *BookModel) error {
+	b := &models.Book{ID: m.ID, Title: m.Title, Description: m.Description, AuthorID: m.AuthorID, Contributors: m.Contributors,
+		ISBN: m.ISBN, Publisher: m.Publisher, PublishedYear: m.PublishedYear, Language: m.Language, PageCount: m.PageCount,
+		Genres: m.Genres, Tags: m.Tags}
+	if err := s.UpdateBook(ctx, a, b); err != nil {
+		return err
+	}
+	m.ISBN, m.Publisher, m.Language = b.ISBN, b.Publisher, b.Language
+	m.Genres, m.Tags = b.Genres, b.Tags
+	m.AuthorID, m.AuthorName, m.Contributors = b.AuthorID, b.AuthorName, b.Contributors
+	return nil
 }
 
-func (s *Service) CreateReviewFromModel(m *ReviewModel) error {
-	r := &models.Review{UserID: m.UserID, BookID: m.BookID, Text: m.Text, Rating: m.Rating}
-	if err := s.repo.CreateReview(r); err != nil {
+func (s *Service) DeleteBook(ctx context.Context, a Actor, id int) error {
+	if err := s.authorizeBook(ctx, a, id); err != nil {
+		return err
+	}
+	b, err := s.repo.GetBook(ctx, id)
+	if err != nil {
 		return err
 	}
-	m.ID = r.ID
-	m.CreatedAt = r.CreatedAt
+	if err := s.repo.DeleteBook(ctx, id); err != nil {
+		return err
+	}
+	s.removeCover(ctx, b.Cover)
 	return nil
 }
 
-func (s *Service) ListReviews(bookID int) ([]models.Review, error) {
-	return s.repo.ListReviewsByBook(bookID)
+func (s *Service) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	return s.repo.GetUserByID(ctx, id)
 }
-	
-func (s *Service) ExportBooksJSON() ([]byte, error) {
-	books, err := s.repo.ListBooks()
-	if err != nil {
-		return nil, err
-	}
-	return json.MarshalIndent(books, "", "  ")
+
+func (s *Service) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	return s.repo.UpdateUserRole(ctx, userID, role)
 }
 
-func (s *Service) ExportBooksCSV() ([]byte, error) {
-	books, err := s.repo.ListBooks()
-	if err != nil {
-		return nil, err
-	}
+// TopRatedBooks ranks books by their Bayesian-weighted average rating.
+func (s *Service) TopRatedBooks(ctx context.Context, q TopRatedQuery) ([]models.RatedBook, error) {
+	q.Normalize()
+	return s.repo.TopRatedBooks(ctx, q)
+}
 
-	var buf bytes.Buffer
-	w := csv.NewWriter(&buf)
+// csvHeader is the export layout. Imports find their columns by these
+// names, so exported files import without a mapping.
+var csvHeader = []string{"ID", "Title", "Description", "AuthorID", "CreatedAt",
+	"ISBN", "Publisher", "PublishedYear", "Language", "PageCount", "Genres", "Tags", "Contributors"}
 
-	// header
-	if err := w.Write([]string{"ID", "Title", "Description", "AuthorID", "CreatedAt"}); err != nil {
-		return nil, err
-	}
+// labelSeparator joins genres, tags and contributors inside one CSV cell.
+const labelSeparator = "|"
 
-	for _, b := range books {
-		row := []string{
-			strconv.Itoa(b.ID),
-			b.Title,
-			b.Description,
-			strconv.Itoa(b.AuthorID),
-			b.CreatedAt.String(),
-		}
-		if err := w.Write(row); err != nil {
-			return nil, err
-		}
+func optionalInt(n int) string {
+	if n == 0 {
+		return ""
 	}
-	w.Flush()
-	if err := w.Error(); err != nil {
-		return nil, err
-	}
-	return buf.Bytes(), nil
-}
-func (s *Service) ImportBooksJSON(data []byte) error {
-	var books []models.Book
-	if err := json.Unmarshal(data, &books); err != nil {
-		return err
-	}
-	for _, b := range books {
-		// reset ID to let DB generate it if needed, or keep it if we want to preserve IDs?
-		// usually import creates new records.
-		b.ID = 0 
-		if err := s.repo.CreateBook(&b); err != nil {
-			return err
-		}
-	}
-	return nil
+	return strconv.Itoa(n)
 }
 
-func (s *Service) ImportBooksCSV(data []byte) error {
-	r := csv.NewReader(bytes.NewReader(data))
-	rows, err := r.ReadAll()
-	if err != nil {
-		return err
-	}
-	if len(rows) < 2 {
-		return nil
+// formatContributors writes contributors as "author_id:role" items.
+func formatContributors(cs models.Contributors) string {
+	items := make([]string, len(cs))
+	for i, c := range cs {
+		items[i] = strconv.Itoa(c.AuthorID) + ":" + c.Role
 	}
+	return strings.Join(items, labelSeparator)
+}
 
-	for i, row := range rows {
-		if i == 0 {
-			continue // skip header
-		}
-		if len(row) < 4 {
-			continue
-		}
-		title := row[1]
-		desc := row[2]
-		authorID, err := strconv.Atoi(row[3])
+// parseContributors reads "author_id[:role]" items; the role defaults to author.
+func parseContributors(items []string) (models.Contributors, error) {
+	var out models.Contributors
+	for _, item := range items {
+		id, role, _ := strings.Cut(strings.TrimSpace(item), ":")
+		n, err := strconv.Atoi(strings.TrimSpace(id))
 		if err != nil {
-			return fmt.Errorf("invalid author id on row %d: %w", i+1, err)
-		}
-
-		b := &models.Book{
-			Title:       title,
-			Description: desc,
-			AuthorID:    authorID,
-		}
-		if err := s.repo.CreateBook(b); err != nil {
-			return err
+			return nil, domain.Validation(fmt.Sprintf("invalid contributor %q", item))
 		}
+		out = append(out, models.Contributor{AuthorID: n, Role: strings.TrimSpace(role)})
 	}
-	return nil
+	return out, nil
 }
@@ -1,78 +1,615 @@
 package service
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/example/books/internal/auth"
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/moderation"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/internal/storage"
 	"github.com/example/books/pkg/models"
+	"golang.org/x/text/encoding/charmap"
 )
 
 // fakeRepo is a minimal in-memory repo for unit tests
 type fakeRepo struct {
-	users  map[string]*models.User
-	books  map[int]*models.Book
-	nextID int
+	users   map[string]*models.User
+	authors map[int]*models.Author
+	books   map[int]*models.Book
+	refresh map[int]*models.RefreshToken
+	revoked map[string]bool
+	cutoffs map[int]time.Time
+	reading map[[2]int]*models.ReadingStatus
+	history []models.ReadingEvent
+	reviews map[int]*models.Review
+	votes   map[[2]int]bool
+	reports map[[2]int]string
+	shelves map[int]models.Shelf
+	shelved map[[2]int]bool
+	nextID  int
+
+	jobsMu sync.Mutex
+	jobs   map[int]models.ImportJob
 }
 
 func newFakeRepo() *fakeRepo {
-	return &fakeRepo{users: make(map[string]*models.User), books: make(map[int]*models.Book), nextID: 1}
+	return &fakeRepo{
+		users:   make(map[string]*models.User),
+		authors: make(map[int]*models.Author),
+		books:   make(map[int]*models.Book),
+		refresh: make(map[int]*models.RefreshToken),
+		revoked: make(map[string]bool),
+		cutoffs: make(map[int]time.Time),
+		reading: make(map[[2]int]*models.ReadingStatus),
+		reviews: make(map[int]*models.Review),
+		votes:   make(map[[2]int]bool),
+		reports: make(map[[2]int]string),
+		shelves: make(map[int]models.Shelf),
+		shelved: make(map[[2]int]bool),
+		jobs:    make(map[int]models.ImportJob),
+		nextID:  1,
+	}
 }
 
-func (r *fakeRepo) CreateUser(u *models.User) error {
+func (r *fakeRepo) CreateUser(ctx context.Context, u *models.User) error {
 	if _, ok := r.users[u.Email]; ok {
-		return errors.New("exists")
+		return domain.Conflict("user already exists")
 	}
 	u.ID = r.nextID
 	r.nextID++
 	r.users[u.Email] = u
 	return nil
 }
-func (r *fakeRepo) GetUserByEmail(email string) (*models.User, error) {
+func (r *fakeRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	if u, ok := r.users[email]; ok {
 		return u, nil
 	}
-	return nil, errors.New("not found")
+	return nil, domain.NotFound("not found")
+}
+func (r *fakeRepo) CreateAuthor(ctx context.Context, a *models.Author) error {
+	a.ID = r.nextID
+	r.nextID++
+	r.authors[a.ID] = a
+	return nil
+}
+func (r *fakeRepo) FindAuthorByName(ctx context.Context, name string) (*models.Author, error) {
+	var match *models.Author
+	for _, a := range r.authors {
+		if strings.EqualFold(a.Name, name) && (match == nil || a.ID < match.ID) {
+			match = a
+		}
+	}
+	if match == nil {
+		return nil, domain.NotFound("author not found")
+	}
+	return match, nil
+}
+func (r *fakeRepo) ListAuthors(ctx context.Context) ([]models.Author, error) {
+	out := []models.Author{}
+	for _, a := range r.authors {
+		out = append(out, *a)
+	}
+	return out, nil
+}
+func (r *fakeRepo) GetAuthor(ctx context.Context, id int) (*models.Author, error) {
+	if a, ok := r.authors[id]; ok {
+		return a, nil
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *fakeRepo) UpdateAuthor(ctx context.Context, a *models.Author) error {
+	r.authors[a.ID] = a
+	return nil
+}
+func (r *fakeRepo) DeleteAuthor(ctx context.Context, id int) error { delete(r.authors, id); return nil }
+func (r *fakeRepo) ListBooksByAuthor(ctx context.Context, authorID int) ([]models.Book, error) {
+	out := []models.Book{}
+	for _, b := range r.books {
+		for _, c := range b.Contributors {
+			if c.AuthorID == authorID {
+				out = append(out, *b)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+func (r *fakeRepo) ListBooks(ctx context.Context) ([]models.Book, error) {
+	out := []models.Book{}
+	for id := 1; id < r.nextID; id++ {
+		if b, ok := r.books[id]; ok {
+			out = append(out, *b)
+		}
+	}
+	return out, nil
 }
-func (r *fakeRepo) CreateAuthor(a *models.Author) error { a.ID = r.nextID; r.nextID++; return nil }
-func (r *fakeRepo) ListBooks() ([]models.Book, error)   { return []models.Book{}, nil }
-func (r *fakeRepo) CreateBook(b *models.Book) error {
+func (r *fakeRepo) CreateBook(ctx context.Context, b *models.Book) error {
+	for _, other := range r.books {
+		if b.ISBN != "" && other.ISBN == b.ISBN {
+			return domain.Conflict("book already exists")
+		}
+	}
 	b.ID = r.nextID
 	r.nextID++
 	r.books[b.ID] = b
 	return nil
 }
-func (r *fakeRepo) GetBook(id int) (*models.Book, error) {
+func (r *fakeRepo) GetBook(ctx context.Context, id int) (*models.Book, error) {
 	if b, ok := r.books[id]; ok {
 		return b, nil
 	}
-	return nil, errors.New("not found")
+	return nil, domain.NotFound("not found")
 }
-func (r *fakeRepo) UpdateBook(b *models.Book) error {
+func (r *fakeRepo) UpdateBook(ctx context.Context, b *models.Book) error {
 	if _, ok := r.books[b.ID]; !ok {
-		return errors.New("not found")
+		return domain.NotFound("not found")
 	}
 	r.books[b.ID] = b
 	return nil
 }
-func (r *fakeRepo) DeleteBook(id int) error              { delete(r.books, id); return nil }
-func (r *fakeRepo) CreateShelf(s *models.Shelf) error    { s.ID = r.nextID; r.nextID++; return nil }
-func (r *fakeRepo) ListShelves() ([]models.Shelf, error) { return []models.Shelf{}, nil }
-func (r *fakeRepo) CreateReview(rw *models.Review) error { rw.ID = r.nextID; r.nextID++; return nil }
-func (r *fakeRepo) ListReviewsByBook(bookID int) ([]models.Review, error) {
+func (r *fakeRepo) DeleteBook(ctx context.Context, id int) error { delete(r.books, id); return nil }
+func (r *fakeRepo) SetBookCover(ctx context.Context, bookID int, cover string) error {
+	if b, ok := r.books[bookID]; ok {
+		b.Cover = models.Cover(cover)
+	}
+	return nil
+}
+func (r *fakeRepo) SaveBooks(ctx context.Context, books []*models.Book) (int, error) {
+	saved := maps.Clone(r.books)
+	for i, b := range books {
+		var err error
+		if b.ID == 0 {
+			err = r.CreateBook(ctx, b)
+		} else {
+			err = r.UpdateBook(ctx, b)
+		}
+		if err != nil {
+			r.books = saved
+			return i, err
+		}
+	}
+	return len(books), nil
+}
+func (r *fakeRepo) FindBookByKey(ctx context.Context, isbn, title string, authorID int) (*models.Book, error) {
+	var match *models.Book
+	for _, b := range r.books {
+		switch {
+		case isbn != "" && b.ISBN == isbn:
+			return b, nil
+		case title != "" && strings.EqualFold(b.Title, title) && b.AuthorID == authorID && (isbn == "" || b.ISBN == ""):
+			if match == nil || b.ID < match.ID {
+				match = b
+			}
+		}
+	}
+	if match == nil {
+		return nil, domain.NotFound("not found")
+	}
+	return match, nil
+}
+func (r *fakeRepo) CreateImportJob(ctx context.Context, j *models.ImportJob) error {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	j.ID = len(r.jobs) + 1
+	r.jobs[j.ID] = *j
+	return nil
+}
+func (r *fakeRepo) GetImportJob(ctx context.Context, id int) (*models.ImportJob, error) {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok {
+		return nil, domain.NotFound("not found")
+	}
+	j.Errors = append(models.ImportErrors(nil), j.Errors...)
+	return &j, nil
+}
+func (r *fakeRepo) UpdateImportJob(ctx context.Context, j *models.ImportJob) error {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	saved := *j
+	saved.Errors = append(models.ImportErrors(nil), j.Errors...)
+	r.jobs[j.ID] = saved
+	return nil
+}
+func (r *fakeRepo) CancelImportJob(ctx context.Context, id int) (bool, error) {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	j, ok := r.jobs[id]
+	if !ok || j.Status != "running" {
+		return false, nil
+	}
+	now := time.Now()
+	j.Status, j.FinishedAt = "cancelled", &now
+	r.jobs[id] = j
+	return true, nil
+}
+func (r *fakeRepo) InterruptImportJobs(ctx context.Context, reason string) error {
+	r.jobsMu.Lock()
+	defer r.jobsMu.Unlock()
+	for id, j := range r.jobs {
+		if j.Status == "running" {
+			j.Status, j.Error = "failed", reason
+			r.jobs[id] = j
+		}
+	}
+	return nil
+}
+func (r *fakeRepo) ListGenres(ctx context.Context) ([]models.LabelCount, error) {
+	return []models.LabelCount{}, nil
+}
+func (r *fakeRepo) ListTags(ctx context.Context) ([]models.LabelCount, error) {
+	return []models.LabelCount{}, nil
+}
+func (r *fakeRepo) CreateShelf(ctx context.Context, s *models.Shelf) error {
+	s.ID = r.nextID
+	r.nextID++
+	r.shelves[s.ID] = *s
+	return nil
+}
+func (r *fakeRepo) QueryBooks(ctx context.Context, q repository.BookQuery) (*repository.BookPage, error) {
+	return &repository.BookPage{Books: []models.Book{}}, nil
+}
+func (r *fakeRepo) StreamBooks(ctx context.Context, q repository.BookQuery, fn func(*models.Book) error) error {
+	books, _ := r.ListBooks(ctx)
+	for i := range books {
+		if q.AuthorID != 0 && books[i].AuthorID != q.AuthorID {
+			continue
+		}
+		if err := fn(&books[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (r *fakeRepo) CountBooks(ctx context.Context, q repository.BookQuery) (int, error) {
+	return len(r.books), nil
+}
+func (r *fakeRepo) SearchBooks(ctx context.Context, q repository.SearchQuery) (*repository.SearchPage, error) {
+	return &repository.SearchPage{Hits: []models.BookSearchHit{}}, nil
+}
+func (r *fakeRepo) QueryShelves(ctx context.Context, q repository.ShelfQuery) ([]models.Shelf, error) {
+	out := []models.Shelf{}
+	for id := 1; id < r.nextID; id++ {
+		if sh, ok := r.shelves[id]; ok && (q.UserID == 0 || sh.UserID == q.UserID) && (q.Visibility == "" || sh.Visibility == q.Visibility) {
+			out = append(out, sh)
+		}
+	}
+	if q.Offset >= len(out) {
+		return []models.Shelf{}, nil
+	}
+	out = out[q.Offset:]
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+func (r *fakeRepo) CountShelves(ctx context.Context, q repository.ShelfQuery) (int, error) {
+	return 0, nil
+}
+func (r *fakeRepo) CreateReview(ctx context.Context, rw *models.Review) error {
+	for _, other := range r.reviews {
+		if other.UserID == rw.UserID && other.BookID == rw.BookID {
+			return domain.Conflict("review already exists")
+		}
+	}
+	rw.ID = r.nextID
+	r.nextID++
+	if rw.Status == "" {
+		rw.Status = repository.ReviewApproved
+	}
+	cp := *rw
+	r.reviews[rw.ID] = &cp
+	return nil
+}
+func (r *fakeRepo) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	rw, ok := r.reviews[id]
+	if !ok {
+		return nil, domain.NotFound("review not found")
+	}
+	cp := *rw
+	return &cp, nil
+}
+func (r *fakeRepo) UpdateReview(ctx context.Context, rw *models.Review) error {
+	old, ok := r.reviews[rw.ID]
+	if !ok {
+		return domain.NotFound("review not found")
+	}
+	if rw.Text != old.Text {
+		rw.ModeratedBy, rw.ModeratedAt, rw.ModerationNote = nil, nil, ""
+	}
+	cp := *rw
+	r.reviews[rw.ID] = &cp
+	return nil
+}
+func (r *fakeRepo) DeleteReview(ctx context.Context, id int) error {
+	if _, ok := r.reviews[id]; !ok {
+		return domain.NotFound("review not found")
+	}
+	delete(r.reviews, id)
+	return nil
+}
+func (r *fakeRepo) AddReviewVote(ctx context.Context, reviewID, userID int) error {
+	if !r.votes[[2]int{reviewID, userID}] {
+		r.votes[[2]int{reviewID, userID}] = true
+		r.reviews[reviewID].HelpfulCount++
+	}
+	return nil
+}
+func (r *fakeRepo) SetReviewStatus(ctx context.Context, id int, status string, moderatorID *int, note string) error {
+	rw, ok := r.reviews[id]
+	if !ok {
+		return domain.NotFound("review not found")
+	}
+	rw.Status, rw.ModeratedBy, rw.ModerationNote = status, moderatorID, note
+	return nil
+}
+func (r *fakeRepo) QueryReviews(ctx context.Context, q repository.ReviewQuery) ([]models.Review, error) {
+	out := []models.Review{}
+	for _, rw := range r.reviews {
+		if rw.Status == q.Status {
+			out = append(out, *rw)
+		}
+	}
+	return out, nil
+}
+func (r *fakeRepo) CountReviews(ctx context.Context, q repository.ReviewQuery) (int, error) {
+	out, _ := r.QueryReviews(ctx, q)
+	return len(out), nil
+}
+func (r *fakeRepo) ReportReview(ctx context.Context, rep *models.ReviewReport) (int, error) {
+	key := [2]int{rep.ReviewID, rep.UserID}
+	if _, ok := r.reports[key]; !ok {
+		r.reports[key] = rep.Reason
+		r.reviews[rep.ReviewID].ReportCount++
+	}
+	return r.reviews[rep.ReviewID].ReportCount, nil
+}
+func (r *fakeRepo) ListReviewReports(ctx context.Context, reviewID int) ([]models.ReviewReport, error) {
+	out := []models.ReviewReport{}
+	for key, reason := range r.reports {
+		if key[0] == reviewID {
+			out = append(out, models.ReviewReport{ReviewID: key[0], UserID: key[1], Reason: reason})
+		}
+	}
+	return out, nil
+}
+func (r *fakeRepo) RemoveReviewVote(ctx context.Context, reviewID, userID int) error {
+	if r.votes[[2]int{reviewID, userID}] {
+		delete(r.votes, [2]int{reviewID, userID})
+		r.reviews[reviewID].HelpfulCount--
+	}
+	return nil
+}
+func (r *fakeRepo) TopRatedBooks(ctx context.Context, q repository.TopRatedQuery) ([]models.RatedBook, error) {
+	return []models.RatedBook{}, nil
+}
+func (r *fakeRepo) ListReviewsByBook(ctx context.Context, bookID int, sort string) ([]models.Review, error) {
 	return []models.Review{}, nil
 }
 
-func (r *fakeRepo) GetShelf(id int) (*models.Shelf, error) { return nil, nil }
-func (r *fakeRepo) ListBooksByShelf(shelfID int) ([]models.Book, error) { return []models.Book{}, nil }
-func (r *fakeRepo) AddBookToShelf(shelfID int, bookID int) error { return nil }
-func (r *fakeRepo) GetUserByID(id int) (*models.User, error) { return nil, nil }
-func (r *fakeRepo) UpdateUserRole(userID int, role string) error { return nil }
+func (r *fakeRepo) GetShelf(ctx context.Context, id int) (*models.Shelf, error) {
+	return nil, domain.NotFound("shelf not found")
+}
+func (r *fakeRepo) ListBooksByShelf(ctx context.Context, shelfID int) ([]models.Book, error) {
+	return []models.Book{}, nil
+}
+func (r *fakeRepo) AddBookToShelf(ctx context.Context, shelfID int, bookID int) error {
+	r.shelved[[2]int{shelfID, bookID}] = true
+	return nil
+}
+func (r *fakeRepo) UpdateShelf(ctx context.Context, s *models.Shelf) error             { return nil }
+func (r *fakeRepo) DeleteShelf(ctx context.Context, id int) error                      { return nil }
+func (r *fakeRepo) RemoveBookFromShelf(ctx context.Context, shelfID, bookID int) error { return nil }
+func (r *fakeRepo) ReorderShelf(ctx context.Context, shelfID int, bookIDs []int) error { return nil }
+func (r *fakeRepo) MoveBook(ctx context.Context, fromShelfID, toShelfID, bookID int) error {
+	return nil
+}
+func (r *fakeRepo) GetShelfByShareToken(ctx context.Context, token string) (*models.Shelf, error) {
+	return nil, domain.NotFound("shelf not found")
+}
+func (r *fakeRepo) ListShelfCollaborators(ctx context.Context, shelfID int) ([]models.ShelfCollaborator, error) {
+	return []models.ShelfCollaborator{}, nil
+}
+func (r *fakeRepo) GetShelfCollaborator(ctx context.Context, shelfID, userID int) (*models.ShelfCollaborator, error) {
+	return nil, domain.NotFound("collaborator not found")
+}
+func (r *fakeRepo) SaveShelfCollaborator(ctx context.Context, c *models.ShelfCollaborator) error {
+	return nil
+}
+func (r *fakeRepo) RemoveShelfCollaborator(ctx context.Context, shelfID, userID int) error {
+	return nil
+}
+func (r *fakeRepo) GetUserByID(ctx context.Context, id int) (*models.User, error) {
+	for _, u := range r.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *fakeRepo) UpdateUserRole(ctx context.Context, userID int, role string) error { return nil }
+
+func (r *fakeRepo) GetReadingStatus(ctx context.Context, userID, bookID int) (*models.ReadingStatus, error) {
+	if rs, ok := r.reading[[2]int{userID, bookID}]; ok {
+		cp := *rs
+		return &cp, nil
+	}
+	return nil, domain.NotFound("reading status not found")
+}
+func (r *fakeRepo) ListReadingStatuses(ctx context.Context, userID int, status string) ([]models.ReadingStatus, error) {
+	out := []models.ReadingStatus{}
+	for k, rs := range r.reading {
+		if k[0] == userID && (status == "" || rs.Status == status) {
+			out = append(out, *rs)
+		}
+	}
+	return out, nil
+}
+func (r *fakeRepo) SaveReadingStatus(ctx context.Context, rs *models.ReadingStatus, statusChanged bool) error {
+	rs.UpdatedAt = time.Now()
+	cp := *rs
+	r.reading[[2]int{rs.UserID, rs.BookID}] = &cp
+	if statusChanged {
+		r.history = append(r.history, models.ReadingEvent{ID: len(r.history) + 1, UserID: rs.UserID, BookID: rs.BookID, Status: rs.Status, ChangedAt: rs.UpdatedAt})
+	}
+	return nil
+}
+func (r *fakeRepo) DeleteReadingStatus(ctx context.Context, userID, bookID int) error {
+	if _, ok := r.reading[[2]int{userID, bookID}]; !ok {
+		return domain.NotFound("reading status not found")
+	}
+	delete(r.reading, [2]int{userID, bookID})
+	return nil
+}
+func (r *fakeRepo) ListReadingHistory(ctx context.Context, userID, bookID int) ([]models.ReadingEvent, error) {
+	out := []models.ReadingEvent{}
+	for _, e := range r.history {
+		if e.UserID == userID && e.BookID == bookID {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+func (r *fakeRepo) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	t.ID = r.nextID
+	r.nextID++
+	t.CreatedAt = time.Now()
+	r.refresh[t.ID] = t
+	return nil
+}
+func (r *fakeRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	for _, t := range r.refresh {
+		if t.TokenHash == hash {
+			return t, nil
+		}
+	}
+	return nil, domain.NotFound("not found")
+}
+func (r *fakeRepo) RevokeRefreshToken(ctx context.Context, id int) (bool, error) {
+	t, ok := r.refresh[id]
+	if !ok || t.RevokedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	t.RevokedAt = &now
+	return true, nil
+}
+func (r *fakeRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	for _, t := range r.refresh {
+		if t.UserID == userID {
+			_, _ = r.RevokeRefreshToken(ctx, t.ID)
+		}
+	}
+	return nil
+}
+func (r *fakeRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	r.revoked[jti] = true
+	return nil
+}
+func (r *fakeRepo) SetUserTokenCutoff(ctx context.Context, userID int, notBefore time.Time) error {
+	r.cutoffs[userID] = notBefore
+	return nil
+}
+func (r *fakeRepo) IsAccessTokenRevoked(ctx context.Context, jti string, userID int, issuedAt time.Time) (bool, error) {
+	if r.revoked[jti] {
+		return true, nil
+	}
+	cut, ok := r.cutoffs[userID]
+	return ok && cut.After(issuedAt), nil
+}
+
+func (r *fakeRepo) Backup(ctx context.Context, fn func(table string, row interface{}) error) error {
+	var err error
+	emit := func(table string, row interface{}) {
+		if err == nil {
+			err = fn(table, row)
+		}
+	}
+	for _, u := range slices.SortedFunc(maps.Values(r.users), func(a, b *models.User) int { return a.ID - b.ID }) {
+		cp := *u
+		emit(repository.BackupUsers, &cp)
+	}
+	for _, id := range slices.Sorted(maps.Keys(r.authors)) {
+		cp := *r.authors[id]
+		emit(repository.BackupAuthors, &cp)
+	}
+	for _, id := range slices.Sorted(maps.Keys(r.books)) {
+		cp := *r.books[id]
+		emit(repository.BackupBooks, &cp)
+	}
+	for _, id := range slices.Sorted(maps.Keys(r.shelves)) {
+		cp := r.shelves[id]
+		emit(repository.BackupShelves, &cp)
+	}
+	for _, e := range slices.SortedFunc(maps.Keys(r.shelved), func(a, b [2]int) int { return (a[0]-b[0])*1e6 + a[1] - b[1] }) {
+		emit(repository.BackupShelfBooks, &models.ShelfBook{ShelfID: e[0], BookID: e[1]})
+	}
+	for _, id := range slices.Sorted(maps.Keys(r.reviews)) {
+		cp := *r.reviews[id]
+		emit(repository.BackupReviews, &cp)
+	}
+	return err
+}
+
+// Restore keeps copies of the tables so a failed restore leaves nothing behind.
+func (r *fakeRepo) Restore(ctx context.Context, fn func(repository.Restorer) error) error {
+	if len(r.authors) > 0 || len(r.books) > 0 || len(r.shelves) > 0 || len(r.reviews) > 0 {
+		return domain.Conflict("a backup can only be restored into an empty catalog")
+	}
+	users, authors, books := maps.Clone(r.users), maps.Clone(r.authors), maps.Clone(r.books)
+	shelves, shelved, reviews := maps.Clone(r.shelves), maps.Clone(r.shelved), maps.Clone(r.reviews)
+	if err := fn(fakeRestorer{r}); err != nil {
+		r.users, r.authors, r.books = users, authors, books
+		r.shelves, r.shelved, r.reviews = shelves, shelved, reviews
+		return err
+	}
+	return nil
+}
+
+type fakeRestorer struct{ *fakeRepo }
+
+func (r fakeRestorer) InsertUser(ctx context.Context, u *models.User) error {
+	return r.CreateUser(ctx, u)
+}
+func (r fakeRestorer) InsertAuthor(ctx context.Context, a *models.Author) error {
+	return r.CreateAuthor(ctx, a)
+}
+func (r fakeRestorer) InsertBook(ctx context.Context, b *models.Book) error {
+	return r.CreateBook(ctx, b)
+}
+func (r fakeRestorer) InsertShelf(ctx context.Context, s *models.Shelf) error {
+	return r.CreateShelf(ctx, s)
+}
+func (r fakeRestorer) InsertShelfBook(ctx context.Context, sb *models.ShelfBook) error {
+	return r.AddBookToShelf(ctx, sb.ShelfID, sb.BookID)
+}
+func (r fakeRestorer) InsertReview(ctx context.Context, rv *models.Review) error {
+	return r.CreateReview(ctx, rv)
+}
 
 func TestRegisterAndAuth(t *testing.T) {
 	r := newFakeRepo()
 	svc := NewService(r)
 	// register
-	u, err := svc.RegisterUser("a@example.com", "secret", "Alex")
+	u, err := svc.RegisterUser(context.Background(), "a@example.com", "secret", "Alex")
 	if err != nil {
 		t.Fatalf("register failed: %v", err)
 	}
@@ -80,11 +617,11 @@ func TestRegisterAndAuth(t *testing.T) {
 		t.Fatalf("expected id assigned")
 	}
 	// duplicate
-	if _, err := svc.RegisterUser("a@example.com", "secret2", "Alex2"); err == nil {
+	if _, err := svc.RegisterUser(context.Background(), "a@example.com", "secret2", "Alex2"); err == nil {
 		t.Fatalf("expected duplicate error")
 	}
 	// authenticate
-	au, err := svc.Authenticate("a@example.com", "secret")
+	au, err := svc.Authenticate(context.Background(), "a@example.com", "secret")
 	if err != nil {
 		t.Fatalf("authenticate failed: %v", err)
 	}
@@ -92,7 +629,7 @@ func TestRegisterAndAuth(t *testing.T) {
 		t.Fatalf("wrong user authenticated")
 	}
 	// wrong password
-	if _, err := svc.Authenticate("a@example.com", "bad"); err == nil {
+	if _, err := svc.Authenticate(context.Background(), "a@example.com", "bad"); err == nil {
 		t.Fatalf("expected auth failure")
 	}
 }
@@ -100,14 +637,18 @@ func TestRegisterAndAuth(t *testing.T) {
 func TestCreateBookAndReview(t *testing.T) {
 	r := newFakeRepo()
 	svc := NewService(r)
-	bm := &BookModel{Title: "T", Description: "D", AuthorID: 1}
-	if err := svc.CreateBookFromModel(bm); err != nil {
+	author := &AuthorModel{Name: "A"}
+	if err := svc.CreateAuthor(context.Background(), author); err != nil {
+		t.Fatalf("create author: %v", err)
+	}
+	bm := &BookModel{Title: "T", Description: "D", AuthorID: author.ID}
+	if err := svc.CreateBookFromModel(context.Background(), bm); err != nil {
 		t.Fatalf("create book: %v", err)
 	}
 	if bm.ID == 0 {
 		t.Fatalf("book id assigned")
 	}
-	b, err := svc.GetBook(bm.ID)
+	b, err := svc.GetBook(context.Background(), bm.ID)
 	if err != nil {
 		t.Fatalf("get book: %v", err)
 	}
@@ -116,7 +657,1004 @@ func TestCreateBookAndReview(t *testing.T) {
 	}
 	// create review
 	rv := &ReviewModel{UserID: 1, BookID: bm.ID, Text: "nice", Rating: 5}
-	if err := svc.CreateReviewFromModel(rv); err != nil {
+	if err := svc.CreateReviewFromModel(context.Background(), rv); err != nil {
 		t.Fatalf("create review: %v", err)
 	}
 }
+
+func TestRefreshTokenRotation(t *testing.T) {
+	r := newFakeRepo()
+	svc := NewService(r)
+	u, err := svc.RegisterUser(context.Background(), "r@example.com", "secret", "R")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	first, err := svc.IssueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	second, err := svc.RefreshTokens(context.Background(), first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh token was not rotated")
+	}
+	// replaying the rotated token is treated as theft and kills the new session too
+	if _, err := svc.RefreshTokens(context.Background(), first.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken on reuse, got %v", err)
+	}
+	if _, err := svc.RefreshTokens(context.Background(), second.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected all sessions revoked after reuse, got %v", err)
+	}
+	if _, err := svc.RefreshTokens(context.Background(), "garbage"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for unknown token, got %v", err)
+	}
+}
+
+// staleRefreshRepo answers token lookups as they were before any rotation,
+// like a request that read the token just before a concurrent one revoked it.
+type staleRefreshRepo struct{ *fakeRepo }
+
+func (r staleRefreshRepo) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	rt, err := r.fakeRepo.GetRefreshTokenByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	cp := *rt
+	cp.RevokedAt = nil
+	return &cp, nil
+}
+
+func TestRefreshTokenConcurrentRotation(t *testing.T) {
+	r := newFakeRepo()
+	svc := NewService(r)
+	ctx := context.Background()
+	u, err := svc.RegisterUser(ctx, "r@example.com", "secret", "R")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	first, err := svc.IssueTokens(ctx, u)
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	winner, err := svc.RefreshTokens(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	// the losing request saw the token unrevoked but cannot revoke it itself
+	racer := NewService(staleRefreshRepo{r})
+	if _, err := racer.RefreshTokens(ctx, first.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken for the losing rotation, got %v", err)
+	}
+	if _, err := svc.RefreshTokens(ctx, winner.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected the winning session to be revoked as well, got %v", err)
+	}
+}
+
+func TestLogoutAllRejectsTokensIssuedBeforeIt(t *testing.T) {
+	r := newFakeRepo()
+	svc := NewService(r)
+	ctx := context.Background()
+	tok, err := auth.GenerateToken(7, "user", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.ParseToken(tok)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.LogoutAll(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	// the token was most likely issued in the second logout-all ran
+	if revoked, err := svc.IsTokenRevoked(ctx, claims); err != nil || !revoked {
+		t.Fatalf("token issued before logout-all still accepted: %v %v", revoked, err)
+	}
+
+	// signing in again right away, in the same second, works
+	time.Sleep(2 * time.Millisecond)
+	tok, _ = auth.GenerateToken(7, "user", time.Hour)
+	if claims, err = auth.ParseToken(tok); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := svc.IsTokenRevoked(ctx, claims); err != nil || revoked {
+		t.Fatalf("token issued after logout-all rejected: %v %v", revoked, err)
+	}
+}
+
+func TestReadingStatusLifecycle(t *testing.T) {
+	r := newFakeRepo()
+	svc := NewService(r)
+	ctx := context.Background()
+	b := &models.Book{Title: "Dune"}
+	if err := r.CreateBook(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := svc.SetReadingStatus(ctx, 7, b.ID, ReadingUpdate{})
+	if err != nil {
+		t.Fatalf("want to read: %v", err)
+	}
+	if rs.Status != StatusWantToRead || rs.StartedAt != nil || rs.BookTitle != "Dune" {
+		t.Fatalf("unexpected initial status: %+v", rs)
+	}
+
+	page := 40
+	rs, err = svc.SetReadingStatus(ctx, 7, b.ID, ReadingUpdate{Status: StatusReading, CurrentPage: &page})
+	if err != nil {
+		t.Fatalf("start reading: %v", err)
+	}
+	if rs.StartedAt == nil || *rs.CurrentPage != 40 {
+		t.Fatalf("start date or page not set: %+v", rs)
+	}
+
+	// progress updates alone do not add history entries
+	page = 120
+	if _, err := svc.SetReadingStatus(ctx, 7, b.ID, ReadingUpdate{CurrentPage: &page}); err != nil {
+		t.Fatalf("progress: %v", err)
+	}
+
+	rs, err = svc.SetReadingStatus(ctx, 7, b.ID, ReadingUpdate{Status: StatusFinished})
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if rs.FinishedAt == nil || rs.Percent == nil || *rs.Percent != 100 || *rs.CurrentPage != 120 {
+		t.Fatalf("finish did not complete progress: %+v", rs)
+	}
+
+	history, _ := svc.ReadingHistory(ctx, 7, b.ID)
+	var got []string
+	for _, e := range history {
+		got = append(got, e.Status)
+	}
+	if strings.Join(got, ",") != "want_to_read,reading,finished" {
+		t.Fatalf("unexpected history %v", got)
+	}
+
+	bad := 101
+	if _, err := svc.SetReadingStatus(ctx, 7, b.ID, ReadingUpdate{Percent: &bad}); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error for percent, got %v", err)
+	}
+	if _, err := svc.SetReadingStatus(ctx, 7, b.ID, ReadingUpdate{Status: "skimmed"}); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error for status, got %v", err)
+	}
+	early := rs.StartedAt.Add(-48 * time.Hour)
+	if _, err := svc.SetReadingStatus(ctx, 7, b.ID, ReadingUpdate{FinishedAt: &early}); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error for dates, got %v", err)
+	}
+	if _, err := svc.SetReadingStatus(ctx, 7, 999, ReadingUpdate{}); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("expected not found for unknown book, got %v", err)
+	}
+}
+
+func TestReviewOwnership(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newFakeRepo())
+	author, other := Actor{UserID: 1}, Actor{UserID: 2}
+
+	rv := &ReviewModel{UserID: author.UserID, BookID: 7, Rating: 4}
+	if err := svc.CreateReviewFromModel(ctx, rv); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := svc.CreateReviewFromModel(ctx, &ReviewModel{UserID: author.UserID, BookID: 7, Rating: 2}); !errors.Is(err, ErrDuplicateReview) {
+		t.Fatalf("expected ErrDuplicateReview, got %v", err)
+	}
+	if _, err := svc.UpdateReview(ctx, other, rv.ID, "mine now", 1); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if _, err := svc.UpdateReview(ctx, author, rv.ID, "", 6); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	if _, err := svc.VoteHelpful(ctx, author, rv.ID); !errors.Is(err, ErrOwnReviewVote) {
+		t.Fatalf("expected ErrOwnReviewVote, got %v", err)
+	}
+	got, err := svc.VoteHelpful(ctx, other, rv.ID)
+	if err != nil || got.HelpfulCount != 1 {
+		t.Fatalf("vote: %+v %v", got, err)
+	}
+	if _, err := svc.ListReviews(ctx, 7, "loudest"); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery, got %v", err)
+	}
+	if err := svc.DeleteReview(ctx, other, rv.ID); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if err := svc.DeleteReview(ctx, Actor{UserID: 3, Role: RoleAdmin}, rv.ID); err != nil {
+		t.Fatalf("admin delete: %v", err)
+	}
+	if _, err := svc.GetReview(ctx, rv.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestReviewModeration(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newFakeRepo())
+	svc.SetModeration(ModerationConfig{Filter: moderation.MustParse("spoiler\nreject casino"), ReportThreshold: 2})
+	admin := Actor{UserID: 100, Role: RoleAdmin}
+
+	if err := svc.CreateReviewFromModel(ctx, &ReviewModel{UserID: 1, BookID: 7, Rating: 5, Text: "best casino in town"}); !errors.Is(err, ErrReviewRefused) {
+		t.Fatalf("expected ErrReviewRefused, got %v", err)
+	}
+	held := &ReviewModel{UserID: 1, BookID: 7, Rating: 5, Text: "Spoiler: he dies"}
+	if err := svc.CreateReviewFromModel(ctx, held); err != nil || held.Status != ReviewPending {
+		t.Fatalf("expected pending review, got %q %v", held.Status, err)
+	}
+	if _, err := svc.VoteHelpful(ctx, Actor{UserID: 2}, held.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("hidden review must not take votes, got %v", err)
+	}
+
+	if _, _, err := svc.ReviewQueue(ctx, Actor{UserID: 2}, ReviewQuery{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	queue, total, err := svc.ReviewQueue(ctx, admin, ReviewQuery{})
+	if err != nil || total != 1 || queue[0].ID != held.ID {
+		t.Fatalf("queue: %+v %d %v", queue, total, err)
+	}
+	if _, _, err := svc.ReviewQueue(ctx, admin, ReviewQuery{Status: "spam"}); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+
+	rv, err := svc.ModerateReview(ctx, admin, held.ID, ReviewRejected, "spoils the ending")
+	if err != nil || rv.Status != ReviewRejected || rv.ModerationNote != "spoils the ending" {
+		t.Fatalf("reject: %+v %v", rv, err)
+	}
+	// an edited rejected review goes back to the queue even when the text is clean
+	rv, err = svc.UpdateReview(ctx, Actor{UserID: 1}, held.ID, "A gripping ending", 5)
+	if err != nil || rv.Status != ReviewPending {
+		t.Fatalf("edit after rejection: %+v %v", rv, err)
+	}
+	if _, err := svc.ModerateReview(ctx, admin, held.ID, ReviewPending, ""); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+
+	clean := &ReviewModel{UserID: 2, BookID: 7, Rating: 3, Text: "fine"}
+	if err := svc.CreateReviewFromModel(ctx, clean); err != nil || clean.Status != ReviewApproved {
+		t.Fatalf("clean review: %q %v", clean.Status, err)
+	}
+	if _, err := svc.ReportReview(ctx, Actor{UserID: 2}, clean.ID, ""); !errors.Is(err, ErrOwnReviewReport) {
+		t.Fatalf("expected ErrOwnReviewReport, got %v", err)
+	}
+	for i, reporter := range []int{3, 3, 4} {
+		rv, err = svc.ReportReview(ctx, Actor{UserID: reporter}, clean.ID, "rude")
+		if i < 2 && (err != nil || rv.Status != ReviewApproved) {
+			t.Fatalf("report %d: %+v %v", i, rv, err)
+		}
+	}
+	if err != nil || rv.Status != ReviewPending || rv.ReportCount != 2 {
+		t.Fatalf("expected review hidden after two reporters: %+v %v", rv, err)
+	}
+	reports, err := svc.ReviewReports(ctx, admin, clean.ID)
+	if err != nil || len(reports) != 2 {
+		t.Fatalf("reports: %+v %v", reports, err)
+	}
+
+	// reports do not hide a review a moderator has approved
+	if _, err := svc.ModerateReview(ctx, admin, clean.ID, ReviewApproved, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rv, err = svc.ReportReview(ctx, Actor{UserID: 5}, clean.ID, "still rude"); err != nil || rv.Status != ReviewApproved {
+		t.Fatalf("report after approval: %+v %v", rv, err)
+	}
+	// until the author rewrites it: the approval was of the old text
+	if rv, err = svc.UpdateReview(ctx, Actor{UserID: 2}, clean.ID, "rewritten after approval", 3); err != nil || rv.ModeratedBy != nil {
+		t.Fatalf("edit after approval: %+v %v", rv, err)
+	}
+	if rv, err = svc.ReportReview(ctx, Actor{UserID: 6}, clean.ID, "rude again"); err != nil || rv.Status != ReviewPending {
+		t.Fatalf("report after edit: %+v %v", rv, err)
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	cases := map[string]string{
+		"978-0-441-17271-9": "9780441172719",
+		"0-441-17271-7":     "9780441172719",
+		"080442957X":        "9780804429573",
+	}
+	for in, want := range cases {
+		got, err := normalizeISBN(in)
+		if err != nil || got != want {
+			t.Fatalf("normalizeISBN(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"9780441172718", "0441172718", "12345", "97804411727X9"} {
+		if _, err := normalizeISBN(bad); !errors.Is(err, domain.ErrValidation) {
+			t.Fatalf("normalizeISBN(%q): expected validation error, got %v", bad, err)
+		}
+	}
+}
+
+func TestImportBooksCSVMetadata(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(r)
+	herbert, translator := &models.Author{Name: "Frank Herbert"}, &models.Author{Name: "Translator"}
+	_ = r.CreateAuthor(context.Background(), herbert)
+	_ = r.CreateAuthor(context.Background(), translator)
+	data := strings.NewReplacer("$H", strconv.Itoa(herbert.ID), "$T", strconv.Itoa(translator.ID)).Replace(
+		"ID,Title,Description,AuthorID,CreatedAt,ISBN,Publisher,PublishedYear,Language,PageCount,Genres,Tags,Contributors\n" +
+			"1,Dune,,$H,,0-441-17271-7,Chilton,1965,EN,412,Sci-Fi|Classic,desert,\n" +
+			"2,Dune (translated),,,,,,,,,,,$T:translator|$H\n")
+	if job := importFile(t, s, ImportOptions{Format: ImportCSV}, data); job.Status != ImportCompleted || job.Imported != 2 {
+		t.Fatalf("import: %+v", job)
+	}
+	var dune, translated *models.Book
+	for _, b := range r.books {
+		switch b.Title {
+		case "Dune":
+			dune = b
+		case "Dune (translated)":
+			translated = b
+		}
+	}
+	if dune == nil || dune.ISBN != "9780441172719" || dune.PublishedYear != 1965 || dune.PageCount != 412 ||
+		strings.Join(dune.Genres, ",") != "classic,sci-fi" || len(dune.Contributors) != 1 {
+		t.Fatalf("metadata not imported: %+v", dune)
+	}
+	// the primary author is the first one credited as author
+	if translated == nil || translated.AuthorID != herbert.ID || len(translated.Contributors) != 2 ||
+		translated.Contributors[0].Role != models.RoleTranslator || translated.Contributors[1].Role != models.RoleAuthor {
+		t.Fatalf("contributors not imported: %+v", translated)
+	}
+
+	var out strings.Builder
+	err := s.ExportBooks(context.Background(), &out, ExportCSV, BookQuery{})
+	if err != nil || !strings.Contains(out.String(), ","+strconv.Itoa(translator.ID)+":translator|"+strconv.Itoa(herbert.ID)+":author\n") {
+		t.Fatalf("contributors not exported: %v %s", err, out.String())
+	}
+
+	job := importFile(t, s, ImportOptions{Format: ImportCSV}, "ID,Title,Description,AuthorID,CreatedAt,ISBN,Publisher,PublishedYear\n1,Bad,,1,,,,soon\n")
+	if job.Status != ImportFailed || len(job.Errors) != 1 || job.Errors[0] != (models.ImportError{Record: 2, Title: "Bad", Message: `invalid published year "soon"`}) {
+		t.Fatalf("expected row-numbered validation error, got %+v", job)
+	}
+}
+
+func TestExportBooks(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(r)
+	ctx := context.Background()
+	var out strings.Builder
+	if err := s.ExportBooks(ctx, &out, ExportJSON, BookQuery{}); err != nil || out.String() != "[]" {
+		t.Fatalf("empty export: %v %q", err, out.String())
+	}
+	for i, title := range []string{"Dune", "Emma", "Ulysses"} {
+		_ = r.CreateBook(ctx, &models.Book{Title: title, AuthorID: 1 + i%2})
+	}
+
+	// the streamed array is laid out exactly as the buffered export was
+	out.Reset()
+	if err := s.ExportBooks(ctx, &out, ExportJSON, BookQuery{AuthorID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.MarshalIndent([]*models.Book{r.books[1], r.books[3]}, "", "  ")
+	if out.String() != string(want) {
+		t.Fatalf("json export:\n got %s\nwant %s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := s.ExportBooks(ctx, &out, ExportNDJSON, BookQuery{Limit: 1, Offset: 2}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	var b models.Book
+	if len(lines) != 3 || json.Unmarshal([]byte(lines[2]), &b) != nil || b.Title != "Ulysses" {
+		t.Fatalf("ndjson export should ignore paging and hold one book per line: %q", out.String())
+	}
+
+	if err := s.ExportBooks(ctx, &out, ExportCSV, BookQuery{Sort: "pages"}); !errors.Is(err, ErrInvalidQuery) {
+		t.Fatalf("expected ErrInvalidQuery for an unknown sort, got %v", err)
+	}
+}
+
+func TestImportBooksCSVHeaders(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(r)
+	ctx := context.Background()
+	author := &models.Author{Name: "Михаил Булгаков"}
+	_ = r.CreateAuthor(ctx, author)
+	id := strconv.Itoa(author.ID)
+
+	// columns in any order, a BOM and semicolons are handled without options
+	job := importFile(t, s, ImportOptions{Format: ImportCSV},
+		"\ufeffPage Count;author_id;TITLE\n480;"+id+";Белая гвардия\n")
+	if job.Status != ImportCompleted || job.Imported != 1 {
+		t.Fatalf("reordered import: %+v", job)
+	}
+	for _, b := range r.books {
+		if b.Title != "Белая гвардия" || b.PageCount != 480 || b.AuthorID != author.ID {
+			t.Fatalf("columns read by position: %+v", b)
+		}
+	}
+
+	// a Windows-1251 file with its own headers, mapped to book fields
+	data, _ := charmap.Windows1251.NewEncoder().String("Название\tАвтор\tГод\nМастер и Маргарита\t" + id + "\t1967\n")
+	opts := ImportOptions{Format: ImportCSV, Delimiter: "tab", Encoding: "cp1251",
+		Mapping: map[string]string{"название": "Title", "Автор": "author id", "Год": "published_year"}}
+	if job = importFile(t, s, opts, data); job.Status != ImportCompleted || job.Imported != 1 {
+		t.Fatalf("mapped import: %+v", job)
+	}
+	opts.Delimiter, opts.Encoding = "", ""
+	if job = importFile(t, s, opts, data); job.Status != ImportCompleted || job.Imported != 1 {
+		t.Fatalf("detected encoding: %+v", job)
+	}
+	var found bool
+	for _, b := range r.books {
+		found = found || (b.Title == "Мастер и Маргарита" && b.PublishedYear == 1967)
+	}
+	if !found {
+		t.Fatalf("Windows-1251 title not decoded: %+v", r.books)
+	}
+
+	// rows are numbered by line, so a quoted line break moves the next row down
+	job = importFile(t, s, ImportOptions{Format: ImportCSV, OnError: ImportSkip},
+		"Title,Description,AuthorID\nA,\"two\nlines\","+id+"\nShort\nB,,nobody\n")
+	want := []models.ImportError{
+		{Record: 4, Title: "Short", Message: "expected 3 fields as in the header, found 1"},
+		{Record: 5, Title: "B", Message: `invalid author id "nobody"`},
+	}
+	if job.Imported != 1 || fmt.Sprint(job.Errors) != fmt.Sprint(want) {
+		t.Fatalf("line-numbered errors: %+v", job.Errors)
+	}
+
+	// options and headers that cannot work are refused before the import starts
+	for _, bad := range []struct {
+		opts ImportOptions
+		data string
+	}{
+		{ImportOptions{Format: ImportCSV, Encoding: "koi8-r"}, "Title\nA\n"},
+		{ImportOptions{Format: ImportCSV, Delimiter: ";;"}, "Title\nA\n"},
+		{ImportOptions{Format: ImportCSV, Mapping: map[string]string{"Name": "rating"}}, "Name\nA\n"},
+		{ImportOptions{Format: ImportCSV, Mapping: map[string]string{"Name": "title"}}, "Title\nA\n"},
+		{ImportOptions{Format: ImportCSV, Mapping: map[string]string{"Name": "title", "Heading": "title"}}, "Name,Heading\nA,B\n"},
+		{ImportOptions{Format: ImportCSV}, "Name,Author\nA,1\n"},
+		{ImportOptions{Format: ImportCSV}, ""},
+		{ImportOptions{Format: ImportJSON, Delimiter: ";"}, "[]"},
+	} {
+		if _, err := s.StartImport(ctx, Actor{UserID: 1}, bad.opts, strings.NewReader(bad.data)); !errors.Is(err, domain.ErrValidation) {
+			t.Errorf("%+v: expected a validation error, got %v", bad.opts, err)
+		}
+	}
+}
+
+func TestGoodreadsImport(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(r)
+	ctx, reader := context.Background(), Actor{UserID: 2, Role: "user"}
+	herbert := &models.Author{Name: "Frank Herbert"}
+	_ = r.CreateAuthor(ctx, herbert)
+	dune := &models.Book{Title: "Dune", AuthorID: herbert.ID, ISBN: "9780441172719"}
+	_ = r.CreateBook(ctx, dune)
+	favorites := &models.Shelf{UserID: 2, Name: "favorites"}
+	_ = r.CreateShelf(ctx, favorites)
+
+	data := "Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Average Rating,Publisher,Binding,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Bookshelves with positions,Exclusive Shelf,My Review,Spoiler,Private Notes,Read Count,Owned Copies\n" +
+		`234225,Dune,Frank Herbert,"Herbert, Frank",,"=""0441172717""","=""9780441172719""",5,4.27,Ace,Paperback,658,2005,1965,2021/03/14,2020/01/05,"Favorites, read","Favorites (#3), read (#12)",read,Spice<br/>and sand,,,1,0` + "\n" +
+		`1,Emma,Jane Austen,"Austen, Jane",,"=""""","=""""",0,3.9,,,,,1815,,2022/02/02,"to-read, classics",,to-read,,,,0,0` + "\n" +
+		`2,Nameless,,,,"=""""","=""""",0,,,,,,,,,,,to-read,,,,0,0` + "\n" +
+		`3,Bad,Jane Austen,,,,,x,,,,,,,,,,,read,,,,0,0` + "\n"
+
+	job := importFileAs(t, s, reader, ImportOptions{Format: ImportGoodreads}, data)
+	if job.Status != ImportCompleted || job.Matched != 1 || job.Created != 1 || job.Failed != 2 || job.Imported != 2 || job.OnError != ImportSkip {
+		t.Fatalf("import: %+v", job)
+	}
+	if len(job.Errors) != 2 || job.Errors[0].Record != 4 || !strings.Contains(job.Errors[0].Message, "names no author") ||
+		job.Errors[1] != (models.ImportError{Record: 5, Title: "Bad", Message: "invalid rating"}) {
+		t.Fatalf("unmatched rows not reported: %+v", job.Errors)
+	}
+
+	// the matched book gets the status, shelf and review
+	rs, err := r.GetReadingStatus(ctx, 2, dune.ID)
+	if err != nil || rs.Status != StatusFinished || rs.FinishedAt == nil || rs.FinishedAt.Format("2006-01-02") != "2021-03-14" {
+		t.Fatalf("reading status: %+v %v", rs, err)
+	}
+	if !r.shelved[[2]int{favorites.ID, dune.ID}] {
+		t.Fatal("not added to the existing shelf")
+	}
+	var review *models.Review
+	for _, rv := range r.reviews {
+		review = rv
+	}
+	if len(r.reviews) != 1 || review.UserID != 2 || review.BookID != dune.ID || review.Rating != 5 || review.Text != "Spice\nand sand" {
+		t.Fatalf("review: %+v", r.reviews)
+	}
+
+	// the missing book is added with its missing author
+	austen, err := r.FindAuthorByName(ctx, "jane austen")
+	if err != nil {
+		t.Fatal("author not created")
+	}
+	emma, err := r.FindBookByKey(ctx, "", "Emma", austen.ID)
+	if err != nil || emma.PublishedYear != 1815 || emma.CreatedBy == nil || *emma.CreatedBy != 2 {
+		t.Fatalf("book not created: %+v %v", emma, err)
+	}
+	if rs, err := r.GetReadingStatus(ctx, 2, emma.ID); err != nil || rs.Status != StatusWantToRead {
+		t.Fatalf("want to read: %+v %v", rs, err)
+	}
+	var classics *models.Shelf
+	for _, sh := range r.shelves {
+		if sh.Name == "classics" {
+			classics = &sh
+		}
+	}
+	if classics == nil || classics.UserID != 2 || classics.Visibility != "private" || !r.shelved[[2]int{classics.ID, emma.ID}] {
+		t.Fatalf("shelf not created: %+v", r.shelves)
+	}
+
+	// importing again adds nothing new and keeps the review
+	job = importFileAs(t, s, reader, ImportOptions{Format: ImportGoodreads}, data)
+	if job.Matched != 2 || job.Created != 0 || len(r.reviews) != 1 || len(r.shelves) != 2 ||
+		job.Changes[0].Reason != "already reviewed; the existing review was kept" {
+		t.Fatalf("second import: %+v %+v", job, job.Changes)
+	}
+
+	if _, err := s.StartImport(ctx, reader, ImportOptions{Format: ImportGoodreads, Mode: ImportUpsert}, strings.NewReader(data)); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected mode to be refused, got %v", err)
+	}
+	job = importFileAs(t, s, reader, ImportOptions{Format: ImportGoodreads}, "Title,Year\nDune,1965\n")
+	if job.Status != ImportFailed || job.Error != "not a Goodreads library export: the Author column is missing" {
+		t.Fatalf("expected the file to be refused: %+v", job)
+	}
+}
+
+func TestLibraryThingImport(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(r)
+	reader := Actor{UserID: 2, Role: "user"}
+	data := "\ufeffBook Id\tTitle\tPrimary Author\tPrimary Author Role\tSecondary Author\tSecondary Author Roles\tPublication\tDate\tReview\tRating\tISBN\tISBNs\tCollections\tDate Started\tDate Read\tPage Count\n" +
+		"1\tHyperion\tSimmons, Dan\t\tWilliams, Jane\tTranslator\tBantam (1990), Paperback\t1989\tGrand \"Canterbury\" tale\t4.5\t[0553283685]\t0553283685\tYour library, Currently Reading, Sci-fi\t2024-01-02\t\t482\n"
+
+	job := importFileAs(t, s, reader, ImportOptions{Format: ImportLibraryThing, DryRun: true}, data)
+	if job.Status != ImportCompleted || job.Created != 1 || job.Imported != 0 || len(r.books) != 0 || len(r.authors) != 0 {
+		t.Fatalf("dry run: %+v, %d books, %d authors", job, len(r.books), len(r.authors))
+	}
+
+	job = importFileAs(t, s, reader, ImportOptions{Format: ImportLibraryThing}, data)
+	if job.Status != ImportCompleted || job.Created != 1 || job.Imported != 1 {
+		t.Fatalf("import: %+v %+v", job, job.Errors)
+	}
+	var b *models.Book
+	for _, book := range r.books {
+		b = book
+	}
+	if b.Title != "Hyperion" || b.ISBN != "9780553283686" || b.Publisher != "Bantam" || b.PublishedYear != 1989 || b.PageCount != 482 ||
+		len(b.Contributors) != 2 || b.Contributors[0].Name != "Dan Simmons" || b.Contributors[1].Role != models.RoleTranslator {
+		t.Fatalf("book: %+v", b)
+	}
+	rs, err := r.GetReadingStatus(context.Background(), 2, b.ID)
+	if err != nil || rs.Status != StatusReading || rs.StartedAt.Format("2006-01-02") != "2024-01-02" {
+		t.Fatalf("reading status: %+v %v", rs, err)
+	}
+	for _, rv := range r.reviews {
+		if rv.Rating != 5 || rv.Text != `Grand "Canterbury" tale` {
+			t.Fatalf("review: %+v", rv)
+		}
+	}
+	if len(r.reviews) != 1 || len(r.shelves) != 1 {
+		t.Fatalf("expected one review and the Sci-fi shelf: %+v %+v", r.reviews, r.shelves)
+	}
+}
+
+// importFile runs an import as an admin and waits for it to finish.
+func importFile(t *testing.T, s *Service, opts ImportOptions, data string) *models.ImportJob {
+	t.Helper()
+	return importFileAs(t, s, Actor{UserID: 1, Role: RoleAdmin}, opts, data)
+}
+
+// importFileAs runs an import as the actor and waits for it to finish.
+func importFileAs(t *testing.T, s *Service, a Actor, opts ImportOptions, data string) *models.ImportJob {
+	t.Helper()
+	ctx := context.Background()
+	job, err := s.StartImport(ctx, a, opts, strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("start import: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if job, err = s.GetImport(ctx, a, job.ID); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != ImportRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("import %d did not finish", job.ID)
+		}
+	}
+}
+
+func TestImportJobs(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(r)
+	ctx := context.Background()
+	author := &models.Author{Name: "Ursula K. Le Guin"}
+	_ = r.CreateAuthor(ctx, author)
+	id := strconv.Itoa(author.ID)
+	data := "ID,Title,Description,AuthorID,CreatedAt,ISBN\n" +
+		"1,A Wizard of Earthsea,,ID,,9780553383041\n" +
+		"2,,,ID,,\n" +
+		"3,The Dispossessed,,999,,\n" +
+		"4,The Lathe of Heaven,,ID,,9780553383041\n" +
+		"5,The Left Hand of Darkness,,ID,,\n"
+	data = strings.ReplaceAll(data, ",ID,", ","+id+",")
+
+	// rollback refuses the whole file and reports every bad row
+	job := importFile(t, s, ImportOptions{Format: ImportCSV, OnError: ImportRollback}, data)
+	if job.Status != ImportFailed || job.Total != 5 || job.Processed != 5 || job.Imported != 0 || job.Failed != 3 || len(r.books) != 0 {
+		t.Fatalf("rollback import: %+v, %d books", job, len(r.books))
+	}
+	if job.Error != "3 of 5 records were refused; nothing was imported" {
+		t.Fatalf("unexpected job error %q", job.Error)
+	}
+	report, err := s.ImportErrorReport(ctx, Actor{UserID: 1, Role: RoleAdmin}, job.ID)
+	want := "Line,Title,Error\n3,,title is required\n4,The Dispossessed,author does not exist\n" +
+		"5,The Lathe of Heaven,ISBN 9780553383041 is also used by record 2\n"
+	if err != nil || string(report) != want {
+		t.Fatalf("error report: %v\n%s", err, report)
+	}
+	if _, err := s.ImportErrorReport(ctx, Actor{UserID: 2}, job.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("another user read the report: %v", err)
+	}
+
+	// skip imports the rest; the duplicate ISBN now clashes with the stored book
+	job = importFile(t, s, ImportOptions{Format: ImportCSV, OnError: ImportSkip}, data)
+	if job.Status != ImportCompleted || job.Imported != 2 || job.Failed != 3 || len(r.books) != 2 {
+		t.Fatalf("skip import: %+v, %d books", job, len(r.books))
+	}
+	for _, b := range r.books {
+		if b.CreatedBy == nil || *b.CreatedBy != 1 {
+			t.Fatalf("imported book not owned by the importer: %+v", b)
+		}
+	}
+	if _, err := s.CancelImport(ctx, Actor{UserID: 1, Role: RoleAdmin}, job.ID); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("cancelling a finished import: %v", err)
+	}
+
+	// JSON: a badly typed record is refused without stopping the others
+	job = importFile(t, s, ImportOptions{Format: ImportJSON, OnError: ImportSkip}, `[{"title":"Lavinia","author_id":`+id+`,"cover":{"small":"/covers/x/small.jpg"}},
+		{"title":"Powers","author_id":"`+id+`"}, 7]`)
+	if job.Status != ImportCompleted || job.Imported != 1 || len(job.Errors) != 2 ||
+		job.Errors[0].Message != "invalid author_id" || job.Errors[1].Message != "expected a book object" {
+		t.Fatalf("json import: %+v", job)
+	}
+	if job = importFile(t, s, ImportOptions{Format: ImportJSON}, `{"title":"not a list"}`); job.Status != ImportFailed || job.Error != "JSON import must be an array of books" {
+		t.Fatalf("malformed json: %+v", job)
+	}
+	if _, err := s.StartImport(ctx, Actor{UserID: 1}, ImportOptions{Format: ImportCSV, OnError: "sometimes"}, strings.NewReader("")); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error for on_error, got %v", err)
+	}
+
+	// a cancelled import stops and, with rollback, leaves nothing behind
+	before := len(r.books)
+	path, _ := spoolImport(strings.NewReader(strings.ReplaceAll(data, "9780553383041", "")))
+	defer os.Remove(path)
+	cancelled := &models.ImportJob{ID: 99, Format: ImportCSV, Mode: ImportCreate, OnError: ImportRollback}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	layout, _ := readCSVLayout(path, ImportOptions{})
+	s.runImport(cctx, cancelled, Actor{UserID: 1}, importSource{path: path, format: ImportCSV, csv: layout})
+	if cancelled.Status != ImportCancelled || len(r.books) != before {
+		t.Fatalf("cancelled import: %+v", cancelled)
+	}
+
+	// an import nobody runs any more is marked cancelled directly
+	orphan := &models.ImportJob{UserID: 1, Format: ImportCSV, OnError: ImportSkip, Status: ImportRunning}
+	_ = r.CreateImportJob(ctx, orphan)
+	if _, err := s.CancelImport(ctx, Actor{UserID: 1}, orphan.ID); err != nil {
+		t.Fatalf("cancel orphan: %v", err)
+	}
+	if j, _ := r.GetImportJob(ctx, orphan.ID); j.Status != ImportCancelled || j.FinishedAt == nil {
+		t.Fatalf("orphan not cancelled: %+v", j)
+	}
+}
+
+// finishingImportRepo completes every import just before it is cancelled,
+// like a runner that finishes while CancelImport is deciding what to do.
+type finishingImportRepo struct{ *fakeRepo }
+
+func (r finishingImportRepo) CancelImportJob(ctx context.Context, id int) (bool, error) {
+	j, err := r.fakeRepo.GetImportJob(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	j.Status, j.FinishedAt = ImportCompleted, &now
+	_ = r.fakeRepo.UpdateImportJob(ctx, j)
+	return r.fakeRepo.CancelImportJob(ctx, id)
+}
+
+func TestImportLimits(t *testing.T) {
+	s := NewService(newFakeRepo())
+	ctx := context.Background()
+	start := func(user int) (*models.ImportJob, error) {
+		return s.StartImport(ctx, Actor{UserID: user}, ImportOptions{Format: ImportCSV}, strings.NewReader("title\nDune\n"))
+	}
+	// every slot is taken by imports still running
+	for user := 1; user <= maxRunningImports; user++ {
+		if err := s.reserveImport(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := start(1); !errors.Is(err, ErrImportRunning) {
+		t.Fatalf("expected ErrImportRunning, got %v", err)
+	}
+	if _, err := start(maxRunningImports + 1); !errors.Is(err, ErrImportsBusy) {
+		t.Fatalf("expected ErrImportsBusy, got %v", err)
+	}
+
+	// refused imports took no slot, and a finished one gives its slot back
+	s.releaseImport(2)
+	if _, err := start(maxRunningImports + 1); err != nil {
+		t.Fatalf("after a slot was freed: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		_, err := start(maxRunningImports + 2)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("finished import kept its slot: %v", err)
+		}
+	}
+}
+
+func TestCancelImportKeepsAFinishedOutcome(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(finishingImportRepo{r})
+	ctx := context.Background()
+	job := &models.ImportJob{UserID: 1, Format: ImportCSV, OnError: ImportSkip, Status: ImportRunning}
+	_ = r.CreateImportJob(ctx, job)
+	if _, err := s.CancelImport(ctx, Actor{UserID: 1}, job.ID); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("expected a conflict for an import that finished first, got %v", err)
+	}
+	if j, _ := r.GetImportJob(ctx, job.ID); j.Status != ImportCompleted {
+		t.Fatalf("finished import overwritten: %+v", j)
+	}
+}
+
+func TestCoverThumbnails(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1200, 900))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	thumbs, err := coverThumbnails(buf.Bytes())
+	if err != nil {
+		t.Fatalf("thumbnails: %v", err)
+	}
+	want := map[string]image.Point{"small": {120, 90}, "medium": {300, 225}, "large": {600, 450}}
+	for size, dim := range want {
+		img, format, err := image.Decode(bytes.NewReader(thumbs[size]))
+		if err != nil || format != "jpeg" || img.Bounds().Size() != dim {
+			t.Fatalf("%s: %v %s %v, want %v", size, err, format, img.Bounds(), dim)
+		}
+	}
+
+	// small images are never enlarged
+	buf.Reset()
+	_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 80, 100)))
+	thumbs, err = coverThumbnails(buf.Bytes())
+	if err != nil {
+		t.Fatalf("small image: %v", err)
+	}
+	if cfg, _, _ := image.DecodeConfig(bytes.NewReader(thumbs["large"])); cfg.Width != 80 || cfg.Height != 100 {
+		t.Fatalf("small image resized to %dx%d", cfg.Width, cfg.Height)
+	}
+
+	if _, err := coverThumbnails([]byte("%PDF-1.4 not an image")); err != ErrUnsupportedCover {
+		t.Fatalf("expected ErrUnsupportedCover, got %v", err)
+	}
+	if _, err := coverThumbnails(make([]byte, MaxCoverBytes+1)); err != ErrCoverTooLarge {
+		t.Fatalf("expected ErrCoverTooLarge, got %v", err)
+	}
+	// a small file can still unpack to more pixels than we decode
+	buf.Reset()
+	_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4001, 4000)))
+	if _, err := coverThumbnails(buf.Bytes()); !errors.Is(err, domain.ErrValidation) || err == ErrCoverTooLarge {
+		t.Fatalf("expected a dimensions error for %d bytes, got %v", buf.Len(), err)
+	}
+}
+
+func TestImportModes(t *testing.T) {
+	r := newFakeRepo()
+	s := NewService(r)
+	ctx := context.Background()
+	herbert := &models.Author{Name: "Frank Herbert"}
+	_ = r.CreateAuthor(ctx, herbert)
+	owner := 7
+	dune := &models.Book{Title: "Dune", AuthorID: herbert.ID, ISBN: "9780441172719", CreatedBy: &owner,
+		Contributors: models.Contributors{{AuthorID: herbert.ID, Role: models.RoleAuthor}}}
+	children := &models.Book{Title: "Children of Dune", AuthorID: herbert.ID, PageCount: 444,
+		Contributors: models.Contributors{{AuthorID: herbert.ID, Role: models.RoleAuthor}}}
+	_ = r.CreateBook(ctx, dune)
+	_ = r.CreateBook(ctx, children)
+	data := strings.ReplaceAll("ID,Title,Description,AuthorID,CreatedAt,ISBN,Publisher\n"+
+		"1,Dune,,H,,0-441-17271-7,Ace\n"+
+		"2,Children of Dune,,H,,,\n"+
+		"3,Heretics of Dune,,H,,,\n", ",H,", ","+strconv.Itoa(herbert.ID)+",")
+
+	// a dry run reports the plan without writing anything
+	job := importFile(t, s, ImportOptions{Format: ImportCSV, Mode: ImportUpsert, DryRun: true}, data)
+	if job.Status != ImportCompleted || !job.DryRun || job.Created != 1 || job.Updated != 1 || job.Skipped != 1 || job.Imported != 0 {
+		t.Fatalf("dry run: %+v", job)
+	}
+	if len(r.books) != 2 || dune.Publisher != "" {
+		t.Fatalf("dry run wrote to the database")
+	}
+	want := models.ImportChanges{
+		{Record: 2, Action: "update", BookID: dune.ID, Title: "Dune", Fields: map[string]models.FieldChange{"publisher": {From: "", To: "Ace"}}},
+		{Record: 3, Action: "skip", BookID: children.ID, Title: "Children of Dune", Reason: "unchanged"},
+		{Record: 4, Action: "create", Title: "Heretics of Dune"},
+	}
+	if fmt.Sprint(job.Changes) != fmt.Sprint(want) {
+		t.Fatalf("changes:\n got %+v\nwant %+v", job.Changes, want)
+	}
+
+	// upsert updates matches in place and keeps their owner
+	for _, onError := range []string{ImportRollback, ImportSkip} {
+		job = importFile(t, s, ImportOptions{Format: ImportCSV, Mode: ImportUpsert, OnError: onError}, data)
+		if job.Status != ImportCompleted || len(r.books) != 3 {
+			t.Fatalf("upsert (%s): %+v, %d books", onError, job, len(r.books))
+		}
+		if b := r.books[dune.ID]; b.Publisher != "Ace" || b.CreatedBy == nil || *b.CreatedBy != owner || b.PageCount != 0 {
+			t.Fatalf("upsert (%s) did not update in place: %+v", onError, b)
+		}
+		if b := r.books[children.ID]; b.PageCount != 444 {
+			t.Fatalf("empty fields overwrote stored values: %+v", b)
+		}
+	}
+	// the second pass found nothing left to change
+	if job.Created != 0 || job.Updated != 0 || job.Skipped != 3 {
+		t.Fatalf("repeated upsert: %+v", job)
+	}
+
+	job = importFile(t, s, ImportOptions{Format: ImportCSV, Mode: ImportSkipExisting},
+		data+"4,God Emperor of Dune,,"+strconv.Itoa(herbert.ID)+",,,\n")
+	if job.Status != ImportCompleted || job.Created != 1 || job.Skipped != 3 || job.Imported != 1 || len(r.books) != 4 {
+		t.Fatalf("skip_existing: %+v", job)
+	}
+
+	// create refuses books that exist and books the file names twice
+	job = importFile(t, s, ImportOptions{Format: ImportCSV}, data)
+	if job.Status != ImportFailed || job.Failed != 1 || job.Errors[0].Message != "a book with ISBN 9780441172719 already exists" {
+		t.Fatalf("create over existing: %+v", job)
+	}
+	job = importFile(t, s, ImportOptions{Format: ImportCSV, Mode: ImportUpsert}, data+"9,children of dune,,"+strconv.Itoa(herbert.ID)+",,,\n")
+	if job.Status != ImportFailed || job.Failed != 1 || job.Errors[0] != (models.ImportError{Record: 5, Title: "children of dune", Message: "same book as record 3"}) {
+		t.Fatalf("duplicate in file: %+v", job)
+	}
+	if _, err := s.StartImport(ctx, Actor{UserID: 1}, ImportOptions{Format: ImportCSV, Mode: "merge"}, strings.NewReader("")); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("expected validation error for mode, got %v", err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	src := newFakeRepo()
+	s := NewService(src)
+	s.SetStorage(storage.NewLocal(t.TempDir()))
+	admin := &models.User{Email: "admin@example.com", PasswordHash: "$2a$10$admin", Role: RoleAdmin}
+	alice := &models.User{Email: "alice@example.com", PasswordHash: "$2a$10$alice", Name: "Alice", Role: "user"}
+	_ = src.CreateUser(ctx, admin)
+	_ = src.CreateUser(ctx, alice)
+	author := &models.Author{Name: "Ursula K. Le Guin"}
+	translator := &models.Author{Name: "Translator"}
+	_ = src.CreateAuthor(ctx, author)
+	_ = src.CreateAuthor(ctx, translator)
+	book := &models.Book{Title: "The Dispossessed", AuthorID: author.ID, CreatedBy: &alice.ID, Genres: models.Labels{"sf"},
+		Contributors: models.Contributors{{AuthorID: author.ID, Role: models.RoleAuthor}, {AuthorID: translator.ID, Role: models.RoleTranslator}}}
+	_ = src.CreateBook(ctx, book)
+	var img bytes.Buffer
+	_ = png.Encode(&img, image.NewGray(image.Rect(0, 0, 40, 60)))
+	if _, err := s.UploadCover(ctx, Actor{UserID: admin.ID, Role: RoleAdmin}, book.ID, img.Bytes()); err != nil {
+		t.Fatalf("upload cover: %v", err)
+	}
+	shelf := &models.Shelf{UserID: alice.ID, Name: "Favourites", Visibility: "public"}
+	_ = src.CreateShelf(ctx, shelf)
+	_ = src.AddBookToShelf(ctx, shelf.ID, book.ID)
+	_ = src.CreateReview(ctx, &models.Review{UserID: alice.ID, BookID: book.ID, Rating: 5, Text: "Great"})
+
+	var archive bytes.Buffer
+	if err := s.Backup(ctx, &archive, false); err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	if bytes.Contains(archive.Bytes(), []byte("$2a$10$")) {
+		t.Fatal("password hashes were backed up without being asked for")
+	}
+
+	// the target already has the admin, and other IDs, so every reference moves
+	dst := newFakeRepo()
+	dst.nextID = 100
+	_ = dst.CreateUser(ctx, &models.User{Email: "admin@example.com", PasswordHash: "$2a$10$other", Role: RoleAdmin})
+	restored := NewService(dst)
+	store := storage.NewLocal(t.TempDir())
+	restored.SetStorage(store)
+	report, err := restored.Restore(ctx, bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	want := map[string]int{"users": 1, "authors": 2, "books": 1, "shelves": 1, "shelf_books": 1, "reviews": 1}
+	if !maps.Equal(report.Counts, want) || report.MatchedUsers != 1 || report.UsersWithoutPassword != 1 || report.Covers != 1 {
+		t.Fatalf("report: %+v", report)
+	}
+	newAlice, err := dst.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil || newAlice.ID == alice.ID || newAlice.PasswordHash != noPassword || newAlice.Name != "Alice" {
+		t.Fatalf("restored user: %+v %v", newAlice, err)
+	}
+	var got *models.Book
+	for _, b := range dst.books {
+		got = b
+	}
+	newAuthor, _ := dst.FindAuthorByName(ctx, "Ursula K. Le Guin")
+	newTranslator, _ := dst.FindAuthorByName(ctx, "Translator")
+	if got.AuthorID != newAuthor.ID || got.ID == book.ID || *got.CreatedBy != newAlice.ID || got.Cover != book.Cover ||
+		got.Contributors[1] != (models.Contributor{AuthorID: newTranslator.ID, Role: models.RoleTranslator}) {
+		t.Fatalf("restored book: %+v", got)
+	}
+	if rc, _, err := store.Get(ctx, coverKey(got.Cover, "large")); err != nil {
+		t.Fatalf("cover not restored: %v", err)
+	} else {
+		rc.Close()
+	}
+	for _, sh := range dst.shelves {
+		if sh.UserID != newAlice.ID || !dst.shelved[[2]int{sh.ID, got.ID}] {
+			t.Fatalf("restored shelf: %+v %v", sh, dst.shelved)
+		}
+	}
+	for _, rv := range dst.reviews {
+		if rv.UserID != newAlice.ID || rv.BookID != got.ID || rv.Rating != 5 {
+			t.Fatalf("restored review: %+v", rv)
+		}
+	}
+
+	if _, err := restored.Restore(ctx, bytes.NewReader(archive.Bytes()), int64(archive.Len())); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("restore over a catalog: expected a conflict, got %v", err)
+	}
+
+	// archives that cannot be restored are refused before anything is written
+	rewrite := func(name, content string) []byte {
+		zr, _ := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+		var out bytes.Buffer
+		zw := zip.NewWriter(&out)
+		for _, f := range zr.File {
+			w, _ := zw.Create(f.Name)
+			if f.Name == name {
+				io.WriteString(w, content)
+				continue
+			}
+			rc, _ := f.Open()
+			io.Copy(w, rc)
+			rc.Close()
+		}
+		zw.Close()
+		return out.Bytes()
+	}
+	for name, data := range map[string][]byte{
+		"not a zip":      []byte("users,authors\n"),
+		"newer version":  rewrite("manifest.json", `{"format":"books-backup","version":2}`),
+		"dangling shelf": rewrite("shelves.ndjson", fmt.Sprintf(`{"id":%d,"user_id":999,"name":"Orphan","visibility":"private"}`+"\n", shelf.ID)),
+		"short table":    rewrite("reviews.ndjson", ""),
+	} {
+		empty := newFakeRepo()
+		_, err := NewService(empty).Restore(ctx, bytes.NewReader(data), int64(len(data)))
+		if !errors.Is(err, domain.ErrValidation) || len(empty.authors) != 0 || len(empty.users) != 0 {
+			t.Errorf("%s: expected a validation error and nothing restored, got %v", name, err)
+		}
+	}
+
+	// a table that inflates past the limit is refused without reading it all
+	var bomb bytes.Buffer
+	zw := zip.NewWriter(&bomb)
+	w, _ := zw.Create("manifest.json")
+	io.WriteString(w, `{"format":"books-backup","version":1,"counts":{}}`)
+	w, _ = zw.Create("users.ndjson")
+	chunk := bytes.Repeat([]byte(" "), 1<<20)
+	for n := 0; n <= maxBackupEntryBytes; n += len(chunk) {
+		w.Write(chunk)
+	}
+	zw.Close()
+	if _, err := NewService(newFakeRepo()).Restore(ctx, bytes.NewReader(bomb.Bytes()), int64(bomb.Len())); !errors.Is(err, domain.ErrValidation) ||
+		!strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("expected the inflated table to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/example/books/internal/auth"
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/pkg/models"
+)
+
+// ErrInvalidToken is returned for unknown, expired or revoked refresh tokens.
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenPair is returned on login and refresh.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// IssueTokens creates a new access token and a persisted refresh token for the user.
+func (s *Service) IssueTokens(ctx context.Context, u *models.User) (*TokenPair, error) {
+	access, err := auth.GenerateToken(u.ID, u.Role, auth.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	raw, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	rt := &models.RefreshToken{UserID: u.ID, TokenHash: hash, ExpiresAt: time.Now().Add(auth.RefreshTokenTTL)}
+	if err := s.repo.CreateRefreshToken(ctx, rt); err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: raw, ExpiresAt: time.Now().Add(auth.AccessTokenTTL)}, nil
+}
+
+// RefreshTokens rotates a refresh token: the presented token is revoked and a new pair issued.
+// Presenting an already revoked token is treated as theft and ends every session of the user.
+func (s *Service) RefreshTokens(ctx context.Context, raw string) (*TokenPair, error) {
+	rt, err := s.repo.GetRefreshTokenByHash(ctx, auth.HashRefreshToken(raw))
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rt.RevokedAt != nil {
+		if err := s.LogoutAll(ctx, rt.UserID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	u, err := s.repo.GetUserByID(ctx, rt.UserID)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	// only one of several concurrent rotations of the same token wins; the
+	// others are reuse like any replay of a rotated token
+	revoked, err := s.repo.RevokeRefreshToken(ctx, rt.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !revoked {
+		if err := s.LogoutAll(ctx, rt.UserID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidToken
+	}
+	return s.IssueTokens(ctx, u)
+}
+
+// Logout revokes the current access token and, if given, the caller's refresh token.
+func (s *Service) Logout(ctx context.Context, claims *auth.Claims, refresh string) error {
+	if claims.Id != "" {
+		if err := s.repo.RevokeAccessToken(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			return err
+		}
+	}
+	if refresh == "" {
+		return nil
+	}
+	rt, err := s.repo.GetRefreshTokenByHash(ctx, auth.HashRefreshToken(refresh))
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rt.UserID != claims.UserID {
+		return nil
+	}
+	_, err = s.repo.RevokeRefreshToken(ctx, rt.ID)
+	return err
+}
+
+// LogoutAll revokes every refresh token of the user and rejects access tokens issued so far.
+func (s *Service) LogoutAll(ctx context.Context, userID int) error {
+	if err := s.repo.RevokeUserRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+	// tokens carry their issue time in milliseconds, so the cutoff is the
+	// next millisecond: a token issued earlier in this one is rejected too
+	return s.repo.SetUserTokenCutoff(ctx, userID, time.Now().Truncate(time.Millisecond).Add(time.Millisecond))
+}
+
+// IsTokenRevoked reports whether an otherwise valid access token has been revoked.
+func (s *Service) IsTokenRevoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+	return s.repo.IsAccessTokenRevoked(ctx, claims.Id, claims.UserID, claims.Issued())
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/pkg/models"
+)
+
+// Shelf visibilities and collaborator roles.
+const (
+	ShelfPrivate      = repository.ShelfPrivate
+	ShelfPublic       = repository.ShelfPublic
+	ShelfUnlisted     = repository.ShelfUnlisted
+	CollaboratorRead  = repository.CollaboratorRead
+	CollaboratorWrite = repository.CollaboratorWrite
+)
+
+// errNotInvitable is returned for an email with no account behind it.
+var errNotInvitable = domain.Validation("no account with this email can be invited")
+
+func validVisibility(v string) bool {
+	switch v {
+	case repository.ShelfPrivate, repository.ShelfPublic, repository.ShelfUnlisted:
+		return true
+	}
+	return false
+}
+
+// newShareToken returns 128 random bits, URL-safe.
+func newShareToken() (*string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	tok := base64.RawURLEncoding.EncodeToString(b)
+	return &tok, nil
+}
+
+// SharedShelf resolves a share link. Links stop working while the shelf is
+// private and start working again if it is shared once more.
+func (s *Service) SharedShelf(ctx context.Context, token string) (*models.Shelf, []models.Book, error) {
+	if token == "" {
+		return nil, nil, domain.NotFound("shelf not found")
+	}
+	sh, err := s.repo.GetShelfByShareToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sh.Visibility == repository.ShelfPrivate {
+		return nil, nil, domain.NotFound("shelf not found")
+	}
+	books, err := s.repo.ListBooksByShelf(ctx, sh.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sh.ShareToken = nil
+	return sh, books, nil
+}
+
+// RotateShareToken replaces the shelf's share token, invalidating old links.
+func (s *Service) RotateShareToken(ctx context.Context, a Actor, id int) (*models.Shelf, error) {
+	if err := s.authorizeShelfOwner(ctx, a, id); err != nil {
+		return nil, err
+	}
+	sh, err := s.repo.GetShelf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if sh.ShareToken, err = newShareToken(); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateShelf(ctx, sh); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// ListCollaborators is open to everyone who can see the shelf.
+func (s *Service) ListCollaborators(ctx context.Context, a Actor, shelfID int) ([]models.ShelfCollaborator, error) {
+	if _, _, err := s.readableShelf(ctx, a, shelfID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListShelfCollaborators(ctx, shelfID)
+}
+
+// AddCollaborator invites the user with the given email, or changes their
+// role if they already collaborate on the shelf.
+func (s *Service) AddCollaborator(ctx context.Context, a Actor, shelfID int, email, role string) (*models.ShelfCollaborator, error) {
+	if role == "" {
+		role = repository.CollaboratorRead
+	}
+	if role != repository.CollaboratorRead && role != repository.CollaboratorWrite {
+		return nil, domain.Validation("role must be read or write")
+	}
+	if err := s.authorizeShelfOwner(ctx, a, shelfID); err != nil {
+		return nil, err
+	}
+	sh, err := s.repo.GetShelf(ctx, shelfID)
+	if err != nil {
+		return nil, err
+	}
+	// An unknown address is refused like any other that cannot be invited.
+	// This does not hide which emails are registered: an invite that works
+	// says so, as does registration.
+	u, err := s.repo.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, errNotInvitable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.ID == sh.UserID {
+		return nil, domain.Validation("the owner cannot be a collaborator")
+	}
+	c := &models.ShelfCollaborator{ShelfID: shelfID, UserID: u.ID, Role: role}
+	if err := s.repo.SaveShelfCollaborator(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RemoveCollaborator is allowed to the owner, an admin, or the collaborator
+// leaving the shelf.
+func (s *Service) RemoveCollaborator(ctx context.Context, a Actor, shelfID, userID int) error {
+	if a.UserID != userID {
+		if err := s.authorizeShelfOwner(ctx, a, shelfID); err != nil {
+			return err
+		}
+	}
+	return s.repo.RemoveShelfCollaborator(ctx, shelfID, userID)
+}
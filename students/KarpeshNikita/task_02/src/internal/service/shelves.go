@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/example/books/internal/domain"
+	"github.com/example/books/internal/repository"
+	"github.com/example/books/pkg/models"
+)
+
+// maxShelfName bounds shelf names in characters.
+const maxShelfName = 100
+
+func (s *Service) CreateShelf(ctx context.Context, sh *models.Shelf) error {
+	return s.repo.CreateShelf(ctx, sh)
+}
+
+func cleanShelfName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", domain.Validation("shelf name must not be empty")
+	}
+	if len([]rune(name)) > maxShelfName {
+		return "", domain.Validation("shelf name is too long")
+	}
+	return name, nil
+}
+
+// CreateShelfFromModel creates a shelf; it is private unless m.Visibility
+// says otherwise.
+func (s *Service) CreateShelfFromModel(ctx context.Context, m *ShelfModel) error {
+	name, err := cleanShelfName(m.Name)
+	if err != nil {
+		return err
+	}
+	shelf := &models.Shelf{UserID: m.UserID, Name: name, Visibility: m.Visibility}
+	if shelf.Visibility == "" {
+		shelf.Visibility = repository.ShelfPrivate
+	}
+	if !validVisibility(shelf.Visibility) {
+		return domain.Validation("visibility must be private, public or unlisted")
+	}
+	if shelf.Visibility == repository.ShelfUnlisted {
+		if shelf.ShareToken, err = newShareToken(); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.CreateShelf(ctx, shelf); err != nil {
+		return err
+	}
+	*m = *shelf
+	return nil
+}
+
+// QueryShelves returns one page of shelves and the total number of matches.
+// Share tokens are only kept on the shelves owned by q.MemberID.
+func (s *Service) QueryShelves(ctx context.Context, q ShelfQuery) ([]models.Shelf, int, error) {
+	q.Normalize()
+	shelves, err := s.repo.QueryShelves(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range shelves {
+		if q.MemberID == 0 || shelves[i].UserID != q.MemberID {
+			shelves[i].ShareToken = nil
+		}
+	}
+	total, err := s.repo.CountShelves(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	return shelves, total, nil
+}
+
+// GetShelf returns a shelf the actor may see; a zero Actor is an anonymous
+// visitor. The share token is only shown to those who may edit the shelf.
+func (s *Service) GetShelf(ctx context.Context, a Actor, id int) (*models.Shelf, error) {
+	sh, level, err := s.readableShelf(ctx, a, id)
+	if err != nil {
+		return nil, err
+	}
+	if level < shelfOwner {
+		sh.ShareToken = nil
+	}
+	return sh, nil
+}
+
+func (s *Service) ListBooksByShelf(ctx context.Context, a Actor, shelfID int) ([]models.Book, error) {
+	if _, _, err := s.readableShelf(ctx, a, shelfID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListBooksByShelf(ctx, shelfID)
+}
+
+func (s *Service) AddBookToShelf(ctx context.Context, a Actor, shelfID int, bookID int) error {
+	if err := s.authorizeShelf(ctx, a, shelfID); err != nil {
+		return err
+	}
+	return s.repo.AddBookToShelf(ctx, shelfID, bookID)
+}
+
+// ShelfUpdate lists the shelf fields to change; nil fields are kept.
+type ShelfUpdate struct {
+	Name       *string
+	Visibility *string
+}
+
+// UpdateShelf renames a shelf or changes its visibility. Making a shelf
+// unlisted gives it a share token if it has none yet.
+func (s *Service) UpdateShelf(ctx context.Context, a Actor, id int, u ShelfUpdate) (*models.Shelf, error) {
+	if u.Name != nil {
+		name, err := cleanShelfName(*u.Name)
+		if err != nil {
+			return nil, err
+		}
+		u.Name = &name
+	}
+	if u.Visibility != nil && !validVisibility(*u.Visibility) {
+		return nil, domain.Validation("visibility must be private, public or unlisted")
+	}
+	if err := s.authorizeShelfOwner(ctx, a, id); err != nil {
+		return nil, err
+	}
+	sh, err := s.repo.GetShelf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Name != nil {
+		sh.Name = *u.Name
+	}
+	if u.Visibility != nil {
+		sh.Visibility = *u.Visibility
+		if sh.Visibility == repository.ShelfUnlisted && sh.ShareToken == nil {
+			if sh.ShareToken, err = newShareToken(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := s.repo.UpdateShelf(ctx, sh); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+func (s *Service) DeleteShelf(ctx context.Context, a Actor, id int) error {
+	if err := s.authorizeShelfOwner(ctx, a, id); err != nil {
+		return err
+	}
+	return s.repo.DeleteShelf(ctx, id)
+}
+
+func (s *Service) RemoveBookFromShelf(ctx context.Context, a Actor, shelfID, bookID int) error {
+	if err := s.authorizeShelf(ctx, a, shelfID); err != nil {
+		return err
+	}
+	return s.repo.RemoveBookFromShelf(ctx, shelfID, bookID)
+}
+
+// ReorderShelf sets the manual order of a shelf; bookIDs must list every book
+// on the shelf exactly once.
+func (s *Service) ReorderShelf(ctx context.Context, a Actor, shelfID int, bookIDs []int) ([]models.Book, error) {
+	if err := s.authorizeShelf(ctx, a, shelfID); err != nil {
+		return nil, err
+	}
+	books, err := s.repo.ListBooksByShelf(ctx, shelfID)
+	if err != nil {
+		return nil, err
+	}
+	onShelf := make(map[int]bool, len(books))
+	for _, b := range books {
+		onShelf[b.ID] = true
+	}
+	if len(bookIDs) != len(books) {
+		return nil, domain.Validation("book_ids must list every book on the shelf exactly once")
+	}
+	for _, id := range bookIDs {
+		if !onShelf[id] {
+			return nil, domain.Validation("book_ids must list every book on the shelf exactly once")
+		}
+		delete(onShelf, id)
+	}
+	if err := s.repo.ReorderShelf(ctx, shelfID, bookIDs); err != nil {
+		return nil, err
+	}
+	return s.repo.ListBooksByShelf(ctx, shelfID)
+}
+
+// MoveBook moves a book between two shelves the actor may edit.
+func (s *Service) MoveBook(ctx context.Context, a Actor, fromShelfID, toShelfID, bookID int) error {
+	if fromShelfID == toShelfID {
+		return domain.Validation("source and target shelf are the same")
+	}
+	if err := s.authorizeShelf(ctx, a, fromShelfID); err != nil {
+		return err
+	}
+	if err := s.authorizeShelf(ctx, a, toShelfID); err != nil {
+		return err
+	}
+	return s.repo.MoveBook(ctx, fromShelfID, toShelfID, bookID)
+}
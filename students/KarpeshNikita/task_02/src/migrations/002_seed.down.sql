@@ -0,0 +1,2 @@
+DELETE FROM books WHERE title IN ('Crime and Punishment', 'Pride and Prejudice') AND created_by IS NULL;
+DELETE FROM authors a WHERE a.name IN ('Fyodor Dostoevsky', 'Jane Austen') AND NOT EXISTS (SELECT 1 FROM books b WHERE b.author_id = a.id);
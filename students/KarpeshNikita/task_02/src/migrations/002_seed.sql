@@ -1,6 +1,6 @@
--- seed some authors and books
-INSERT INTO authors (name) VALUES ('Fyodor Dostoevsky') ON CONFLICT DO NOTHING;
-INSERT INTO authors (name) VALUES ('Jane Austen') ON CONFLICT DO NOTHING;
+-- seed some authors and books (guarded so re-running never duplicates rows)
+INSERT INTO authors (name) SELECT 'Fyodor Dostoevsky' WHERE NOT EXISTS (SELECT 1 FROM authors WHERE name='Fyodor Dostoevsky');
+INSERT INTO authors (name) SELECT 'Jane Austen' WHERE NOT EXISTS (SELECT 1 FROM authors WHERE name='Jane Austen');
 
-INSERT INTO books (title, description, author_id) VALUES ('Crime and Punishment', 'A psychological drama', (SELECT id FROM authors WHERE name='Fyodor Dostoevsky')) ON CONFLICT DO NOTHING;
-INSERT INTO books (title, description, author_id) VALUES ('Pride and Prejudice', 'Classic romance', (SELECT id FROM authors WHERE name='Jane Austen')) ON CONFLICT DO NOTHING;
+INSERT INTO books (title, description, author_id) SELECT 'Crime and Punishment', 'A psychological drama', (SELECT id FROM authors WHERE name='Fyodor Dostoevsky' ORDER BY id LIMIT 1) WHERE NOT EXISTS (SELECT 1 FROM books WHERE title='Crime and Punishment');
+INSERT INTO books (title, description, author_id) SELECT 'Pride and Prejudice', 'Classic romance', (SELECT id FROM authors WHERE name='Jane Austen' ORDER BY id LIMIT 1) WHERE NOT EXISTS (SELECT 1 FROM books WHERE title='Pride and Prejudice');
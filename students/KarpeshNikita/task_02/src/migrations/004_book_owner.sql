@@ -0,0 +1,4 @@
+-- track which user created a book so ownership can be enforced
+ALTER TABLE books ADD COLUMN IF NOT EXISTS created_by INT REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_books_created_by ON books(created_by);
@@ -0,0 +1,24 @@
+-- refresh tokens (hashed) and access token revocation
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(user_id);
+
+-- revoked access tokens by jti; rows can be purged once expires_at has passed
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- access tokens issued before not_before are rejected ("log out all sessions")
+CREATE TABLE IF NOT EXISTS user_token_cutoffs (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    not_before TIMESTAMP NOT NULL
+);
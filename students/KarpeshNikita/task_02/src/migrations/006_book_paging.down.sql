@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_shelves_user;
+DROP INDEX IF EXISTS idx_books_title_id;
+DROP INDEX IF EXISTS idx_books_created_id;
@@ -0,0 +1,4 @@
+-- indexes backing keyset pagination of book listings
+CREATE INDEX IF NOT EXISTS idx_books_created_id ON books(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_books_title_id ON books(title, id);
+CREATE INDEX IF NOT EXISTS idx_shelves_user ON shelves(user_id);
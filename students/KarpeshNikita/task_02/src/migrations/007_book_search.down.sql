@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS authors_search_vector_trigger ON authors;
+DROP FUNCTION IF EXISTS authors_search_vector_update();
+DROP TRIGGER IF EXISTS books_search_vector_trigger ON books;
+DROP FUNCTION IF EXISTS books_search_vector_update();
+DROP INDEX IF EXISTS idx_books_search;
+ALTER TABLE books DROP COLUMN IF EXISTS search_vector;
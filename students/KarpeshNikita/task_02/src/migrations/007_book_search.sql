@@ -0,0 +1,38 @@
+-- full-text search over title, description and author name
+-- the 'simple' configuration avoids language-specific stemming since the catalog mixes Russian and English
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce((SELECT name FROM authors WHERE id = NEW.author_id), '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(NEW.description, '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS books_search_vector_trigger ON books;
+CREATE TRIGGER books_search_vector_trigger
+    BEFORE INSERT OR UPDATE OF title, description, author_id ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_vector_update();
+
+-- renaming an author re-indexes their books
+CREATE OR REPLACE FUNCTION authors_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    UPDATE books SET title = title WHERE author_id = NEW.id;
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS authors_search_vector_trigger ON authors;
+CREATE TRIGGER authors_search_vector_trigger
+    AFTER UPDATE OF name ON authors
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION authors_search_vector_update();
+
+-- backfill existing rows
+UPDATE books SET title = title WHERE search_vector IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_books_search ON books USING GIN (search_vector);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS reading_status_history;
+DROP TABLE IF EXISTS reading_status;
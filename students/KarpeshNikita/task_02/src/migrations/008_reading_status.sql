@@ -0,0 +1,25 @@
+-- per-user reading state of a book and the history of status changes
+
+CREATE TABLE IF NOT EXISTS reading_status (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('want_to_read', 'reading', 'finished', 'abandoned')),
+    current_page INT CHECK (current_page >= 0),
+    percent INT CHECK (percent >= 0 AND percent <= 100),
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, book_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reading_status_user ON reading_status(user_id, status);
+
+CREATE TABLE IF NOT EXISTS reading_status_history (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_reading_history_user_book ON reading_status_history(user_id, book_id, changed_at);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_books_rating_id;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_avg;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_5;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_4;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_3;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_2;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_1;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_sum;
+ALTER TABLE books DROP COLUMN IF EXISTS rating_count;
@@ -0,0 +1,27 @@
+-- per-book rating aggregates, maintained incrementally when reviews are written
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_count INT NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_sum INT NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_1 INT NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_2 INT NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_3 INT NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_4 INT NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_5 INT NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_avg DOUBLE PRECISION
+    GENERATED ALWAYS AS (CASE WHEN rating_count > 0 THEN rating_sum::float8 / rating_count ELSE 0 END) STORED;
+
+-- backfill from existing reviews
+UPDATE books b SET
+    rating_count = s.n, rating_sum = s.total,
+    rating_1 = s.r1, rating_2 = s.r2, rating_3 = s.r3, rating_4 = s.r4, rating_5 = s.r5
+FROM (
+    SELECT book_id, count(*) AS n, sum(rating) AS total,
+        count(*) FILTER (WHERE rating = 1) AS r1, count(*) FILTER (WHERE rating = 2) AS r2,
+        count(*) FILTER (WHERE rating = 3) AS r3, count(*) FILTER (WHERE rating = 4) AS r4,
+        count(*) FILTER (WHERE rating = 5) AS r5
+    FROM reviews WHERE rating IS NOT NULL GROUP BY book_id
+) s
+WHERE s.book_id = b.id;
+
+-- keyset pagination for sort=rating
+CREATE INDEX IF NOT EXISTS idx_books_rating_id ON books(rating_avg, id);
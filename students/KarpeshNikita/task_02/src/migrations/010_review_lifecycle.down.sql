@@ -0,0 +1,25 @@
+DROP INDEX IF EXISTS idx_reviews_book_helpful;
+DROP TABLE IF EXISTS review_votes;
+ALTER TABLE reviews DROP COLUMN IF EXISTS helpful_count;
+ALTER TABLE reviews DROP COLUMN IF EXISTS updated_at;
+DROP INDEX IF EXISTS uq_reviews_user_book;
+
+-- restore the duplicates archived on the way up; the rating aggregates
+-- count every review, as they did before
+INSERT INTO reviews (id, user_id, book_id, text, rating, created_at)
+SELECT id, user_id, book_id, text, rating, created_at FROM archived_duplicate_reviews
+ON CONFLICT (id) DO NOTHING;
+DROP TABLE IF EXISTS archived_duplicate_reviews;
+UPDATE books b SET
+    rating_count = COALESCE(s.n, 0), rating_sum = COALESCE(s.total, 0),
+    rating_1 = COALESCE(s.r1, 0), rating_2 = COALESCE(s.r2, 0), rating_3 = COALESCE(s.r3, 0),
+    rating_4 = COALESCE(s.r4, 0), rating_5 = COALESCE(s.r5, 0)
+FROM books b2
+LEFT JOIN (
+    SELECT book_id, count(*) AS n, sum(rating) AS total,
+        count(*) FILTER (WHERE rating = 1) AS r1, count(*) FILTER (WHERE rating = 2) AS r2,
+        count(*) FILTER (WHERE rating = 3) AS r3, count(*) FILTER (WHERE rating = 4) AS r4,
+        count(*) FILTER (WHERE rating = 5) AS r5
+    FROM reviews WHERE rating IS NOT NULL GROUP BY book_id
+) s ON s.book_id = b2.id
+WHERE b2.id = b.id AND b.rating_count <> COALESCE(s.n, 0);
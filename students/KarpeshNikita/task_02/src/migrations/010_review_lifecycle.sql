@@ -0,0 +1,43 @@
+-- one review per user and book, edit timestamps and "helpful" votes
+
+-- keep only the latest review when a user reviewed the same book more than
+-- once; the older ones move to archived_duplicate_reviews for the operator
+-- to look through, and the down migration puts them back
+CREATE TABLE IF NOT EXISTS archived_duplicate_reviews AS
+SELECT r.*, now() AS archived_at FROM reviews r
+WHERE EXISTS (SELECT 1 FROM reviews newer
+    WHERE newer.user_id = r.user_id AND newer.book_id = r.book_id
+        AND (newer.created_at, newer.id) > (r.created_at, r.id));
+
+DELETE FROM reviews r
+USING archived_duplicate_reviews a
+WHERE a.id = r.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_reviews_user_book ON reviews(user_id, book_id);
+
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS helpful_count INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS review_votes (
+    review_id INT NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (review_id, user_id)
+);
+
+-- the archived duplicates were counted in the rating aggregates
+UPDATE books b SET
+    rating_count = COALESCE(s.n, 0), rating_sum = COALESCE(s.total, 0),
+    rating_1 = COALESCE(s.r1, 0), rating_2 = COALESCE(s.r2, 0), rating_3 = COALESCE(s.r3, 0),
+    rating_4 = COALESCE(s.r4, 0), rating_5 = COALESCE(s.r5, 0)
+FROM books b2
+LEFT JOIN (
+    SELECT book_id, count(*) AS n, sum(rating) AS total,
+        count(*) FILTER (WHERE rating = 1) AS r1, count(*) FILTER (WHERE rating = 2) AS r2,
+        count(*) FILTER (WHERE rating = 3) AS r3, count(*) FILTER (WHERE rating = 4) AS r4,
+        count(*) FILTER (WHERE rating = 5) AS r5
+    FROM reviews WHERE rating IS NOT NULL GROUP BY book_id
+) s ON s.book_id = b2.id
+WHERE b2.id = b.id AND b.rating_count <> COALESCE(s.n, 0);
+
+CREATE INDEX IF NOT EXISTS idx_reviews_book_helpful ON reviews(book_id, helpful_count DESC, id DESC);
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_reviews_status;
+DROP TABLE IF EXISTS review_reports;
+-- hidden reviews were not counted in the aggregates; they move to
+-- archived_hidden_reviews, which the up migration puts back, so the
+-- aggregates stay correct without losing them
+CREATE TABLE IF NOT EXISTS archived_hidden_reviews AS
+SELECT * FROM reviews WHERE status <> 'approved';
+DELETE FROM reviews r
+USING archived_hidden_reviews a
+WHERE a.id = r.id;
+ALTER TABLE reviews DROP COLUMN IF EXISTS moderated_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS moderated_by;
+ALTER TABLE reviews DROP COLUMN IF EXISTS moderation_note;
+ALTER TABLE reviews DROP COLUMN IF EXISTS report_count;
+ALTER TABLE reviews DROP COLUMN IF EXISTS status;
@@ -0,0 +1,32 @@
+-- moderation state for reviews and user reports; only approved reviews are
+-- shown and counted in the book rating aggregates
+
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'approved'
+    CHECK (status IN ('pending', 'approved', 'rejected'));
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS report_count INT NOT NULL DEFAULT 0;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS moderation_note TEXT;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS moderated_by INT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS review_reports (
+    review_id INT NOT NULL REFERENCES reviews(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (review_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reviews_status ON reviews(status, created_at) WHERE status <> 'approved';
+
+-- restore the hidden reviews archived by a rollback of this migration,
+-- unless their author or book is gone or the author reviewed the book again
+CREATE TABLE IF NOT EXISTS archived_hidden_reviews (LIKE reviews);
+INSERT INTO reviews (id, user_id, book_id, text, rating, created_at, updated_at, helpful_count,
+    status, report_count, moderation_note, moderated_by, moderated_at)
+SELECT a.id, a.user_id, a.book_id, a.text, a.rating, a.created_at, a.updated_at, a.helpful_count,
+    a.status, 0, a.moderation_note, (SELECT u.id FROM users u WHERE u.id = a.moderated_by), a.moderated_at
+FROM archived_hidden_reviews a
+WHERE EXISTS (SELECT 1 FROM users u WHERE u.id = a.user_id)
+    AND EXISTS (SELECT 1 FROM books b WHERE b.id = a.book_id)
+ON CONFLICT DO NOTHING;
+DROP TABLE IF EXISTS archived_hidden_reviews;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_shelf_books_position;
+ALTER TABLE shelf_books DROP COLUMN IF EXISTS added_at;
+ALTER TABLE shelf_books DROP COLUMN IF EXISTS position;
@@ -0,0 +1,15 @@
+-- manual ordering of books on a shelf
+
+ALTER TABLE shelf_books ADD COLUMN IF NOT EXISTS position INT;
+ALTER TABLE shelf_books ADD COLUMN IF NOT EXISTS added_at TIMESTAMP NOT NULL DEFAULT now();
+
+UPDATE shelf_books sb SET position = o.n
+FROM (
+    SELECT shelf_id, book_id, row_number() OVER (PARTITION BY shelf_id ORDER BY book_id) AS n
+    FROM shelf_books
+) o
+WHERE o.shelf_id = sb.shelf_id AND o.book_id = sb.book_id AND sb.position IS NULL;
+
+ALTER TABLE shelf_books ALTER COLUMN position SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_shelf_books_position ON shelf_books(shelf_id, position);
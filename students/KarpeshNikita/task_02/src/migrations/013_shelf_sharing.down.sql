@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_shelves_public;
+DROP TABLE IF EXISTS shelf_collaborators;
+ALTER TABLE shelves DROP COLUMN IF EXISTS share_token;
+ALTER TABLE shelves DROP COLUMN IF EXISTS visibility;
@@ -0,0 +1,18 @@
+-- shelf visibility, share links for unlisted shelves and collaborators
+
+-- shelves created so far were visible to everyone; new ones start private
+ALTER TABLE shelves ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'public'
+    CHECK (visibility IN ('private', 'public', 'unlisted'));
+ALTER TABLE shelves ALTER COLUMN visibility SET DEFAULT 'private';
+ALTER TABLE shelves ADD COLUMN IF NOT EXISTS share_token TEXT UNIQUE;
+
+CREATE TABLE IF NOT EXISTS shelf_collaborators (
+    shelf_id INT NOT NULL REFERENCES shelves(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('read', 'write')),
+    added_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (shelf_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_shelf_collaborators_user ON shelf_collaborators(user_id);
+CREATE INDEX IF NOT EXISTS idx_shelves_public ON shelves(id) WHERE visibility = 'public';
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS book_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS book_genres;
+DROP TABLE IF EXISTS genres;
+
+DROP INDEX IF EXISTS idx_books_language;
+DROP INDEX IF EXISTS uq_books_isbn;
+
+ALTER TABLE books DROP COLUMN IF EXISTS page_count;
+ALTER TABLE books DROP COLUMN IF EXISTS language;
+ALTER TABLE books DROP COLUMN IF EXISTS published_year;
+ALTER TABLE books DROP COLUMN IF EXISTS publisher;
+ALTER TABLE books DROP COLUMN IF EXISTS isbn;
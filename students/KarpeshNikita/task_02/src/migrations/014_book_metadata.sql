@@ -0,0 +1,36 @@
+-- catalog metadata: ISBN (stored as ISBN-13), publisher, year, language, page count, genres and tags
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS publisher TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS published_year INT CHECK (published_year BETWEEN 1 AND 9999);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS language TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS page_count INT CHECK (page_count > 0);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_books_isbn ON books(isbn) WHERE isbn IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_books_language ON books(language);
+
+CREATE TABLE IF NOT EXISTS genres (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS book_genres (
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    genre_id INT NOT NULL REFERENCES genres(id) ON DELETE CASCADE,
+    PRIMARY KEY (book_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_genres_genre ON book_genres(genre_id);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS book_tags (
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    tag_id INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (book_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_book_tags_tag ON book_tags(tag_id);
@@ -0,0 +1,36 @@
+-- books may have several contributors with roles; books.author_id stays as the primary author for old clients
+
+CREATE TABLE IF NOT EXISTS book_contributors (
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    author_id INT NOT NULL REFERENCES authors(id),
+    role TEXT NOT NULL DEFAULT 'author' CHECK (role IN ('author', 'editor', 'translator', 'illustrator', 'narrator')),
+    position INT NOT NULL CHECK (position >= 0),
+    PRIMARY KEY (book_id, author_id, role),
+    UNIQUE (book_id, position)
+);
+CREATE INDEX IF NOT EXISTS idx_book_contributors_author ON book_contributors(author_id);
+
+INSERT INTO book_contributors (book_id, author_id, role, position)
+SELECT id, author_id, 'author', 0 FROM books WHERE author_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- search covers every contributor's name; a new book has none yet, so fall back to author_id
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(
+            (SELECT string_agg(a.name, ' ') FROM book_contributors c JOIN authors a ON a.id = c.author_id WHERE c.book_id = NEW.id),
+            (SELECT name FROM authors WHERE id = NEW.author_id), '')), 'B') ||
+        setweight(to_tsvector('simple', coalesce(NEW.description, '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION authors_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    UPDATE books SET title = title
+    WHERE author_id = NEW.id OR id IN (SELECT book_id FROM book_contributors WHERE author_id = NEW.id);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,2 @@
+-- cover image version; the images themselves live in object storage under covers/<cover>/<size>.jpg
+ALTER TABLE books ADD COLUMN IF NOT EXISTS cover TEXT;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_books_title_author;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS changes;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS skipped;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS updated;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS created;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS dry_run;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS mode;
@@ -0,0 +1,11 @@
+-- how imports match existing books, dry runs, and the per-record changes they made or would make
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS mode TEXT NOT NULL DEFAULT 'create'
+    CHECK (mode IN ('create', 'upsert', 'skip_existing'));
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS dry_run BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS created INT NOT NULL DEFAULT 0;
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS updated INT NOT NULL DEFAULT 0;
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS skipped INT NOT NULL DEFAULT 0;
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS changes JSONB NOT NULL DEFAULT '[]';
+
+-- natural key lookups for title + author matches
+CREATE INDEX IF NOT EXISTS idx_books_title_author ON books (lower(title), author_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_authors_name;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS matched;
+DELETE FROM import_jobs WHERE format IN ('goodreads', 'librarything');
+ALTER TABLE import_jobs DROP CONSTRAINT IF EXISTS import_jobs_format_check;
+ALTER TABLE import_jobs ADD CONSTRAINT import_jobs_format_check CHECK (format IN ('csv', 'json'));
@@ -0,0 +1,8 @@
+-- Goodreads and LibraryThing library imports, and the rows they matched to books already in the catalog
+ALTER TABLE import_jobs DROP CONSTRAINT IF EXISTS import_jobs_format_check;
+ALTER TABLE import_jobs ADD CONSTRAINT import_jobs_format_check
+    CHECK (format IN ('csv', 'json', 'goodreads', 'librarything'));
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS matched INT NOT NULL DEFAULT 0;
+
+-- authors are looked up by name when a library import credits them
+CREATE INDEX IF NOT EXISTS idx_authors_name ON authors (lower(name));
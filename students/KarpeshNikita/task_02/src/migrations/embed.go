@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL schema migrations into the binary.
+//
+// Files are named NNN_description.sql (or NNN_description.up.sql) with an
+// optional NNN_description.down.sql that reverts them.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
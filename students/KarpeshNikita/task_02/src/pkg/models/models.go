@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 type User struct {
 	ID           int    `db:"id" json:"id"`
@@ -16,24 +21,322 @@ type Author struct {
 }
 
 type Book struct {
-	ID          int       `db:"id" json:"id"`
-	Title       string    `db:"title" json:"title"`
-	Description string    `db:"description" json:"description"`
-	AuthorID    int       `db:"author_id" json:"author_id"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	ID          int    `db:"id" json:"id"`
+	Title       string `db:"title" json:"title"`
+	Description string `db:"description" json:"description"`
+	// AuthorID and AuthorName name the primary author.
+	// Deprecated: read Contributors, which lists every author, editor and translator.
+	AuthorID      int          `db:"author_id" json:"author_id"`
+	AuthorName    string       `db:"author_name" json:"author_name,omitempty"`
+	Contributors  Contributors `db:"contributors" json:"contributors"`
+	ISBN          string       `db:"isbn" json:"isbn,omitempty"` // ISBN-13, digits only
+	Publisher     string       `db:"publisher" json:"publisher,omitempty"`
+	PublishedYear int          `db:"published_year" json:"published_year,omitempty"`
+	Language      string       `db:"language" json:"language,omitempty"` // ISO 639 code
+	PageCount     int          `db:"page_count" json:"page_count,omitempty"`
+	Genres        Labels       `db:"genres" json:"genres"`
+	Tags          Labels       `db:"tags" json:"tags"`
+	Cover         Cover        `db:"cover" json:"cover,omitempty"`
+	CreatedBy     *int         `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt     time.Time    `db:"created_at" json:"created_at"`
+	RatingAvg     float64      `db:"rating_avg" json:"rating_avg"`
+	RatingCount   int          `db:"rating_count" json:"rating_count"`
+	// RatingHistogram counts ratings 1 to 5; only filled for single-book lookups.
+	RatingHistogram []int `db:"-" json:"rating_histogram,omitempty"`
+}
+
+// Labels is a list of genre or tag names. It scans from a JSON array column.
+type Labels []string
+
+func (l *Labels) Scan(src interface{}) error {
+	out := Labels{}
+	if err := scanJSON(src, &out, "labels"); err != nil {
+		return err
+	}
+	*l = out
+	return nil
+}
+
+// MarshalJSON writes nil labels as an empty array.
+func (l Labels) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(l))
+}
+
+// CoverSizes names the thumbnails generated for every cover, smallest first.
+var CoverSizes = []string{"small", "medium", "large"}
+
+// Cover identifies the current version of a book's cover image; it is empty
+// when the book has none. It is written to JSON as the URL of each size.
+type Cover string
+
+// URL is the address the cover is served from in the given size.
+func (c Cover) URL(size string) string {
+	return "/covers/" + string(c) + "/" + size + ".jpg"
+}
+
+func (c Cover) MarshalJSON() ([]byte, error) {
+	if c == "" {
+		return []byte("null"), nil
+	}
+	urls := make(map[string]string, len(CoverSizes))
+	for _, size := range CoverSizes {
+		urls[size] = c.URL(size)
+	}
+	return json.Marshal(urls)
+}
+
+// Contributor roles.
+const (
+	RoleAuthor      = "author"
+	RoleEditor      = "editor"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+	RoleNarrator    = "narrator"
+)
+
+// Contributor is an author credited on a book in some role.
+type Contributor struct {
+	AuthorID int    `json:"author_id"`
+	Name     string `json:"name,omitempty"`
+	Role     string `json:"role"`
+}
+
+// Contributors lists a book's contributors in credit order. It scans from a JSON array column.
+type Contributors []Contributor
+
+func (c *Contributors) Scan(src interface{}) error {
+	out := Contributors{}
+	if err := scanJSON(src, &out, "contributors"); err != nil {
+		return err
+	}
+	*c = out
+	return nil
+}
+
+// MarshalJSON writes nil contributors as an empty array.
+func (c Contributors) MarshalJSON() ([]byte, error) {
+	if c == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Contributor(c))
+}
+
+// scanJSON decodes a JSON column into dst, leaving dst untouched for NULL.
+func scanJSON(src, dst interface{}, what string) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	default:
+		return fmt.Errorf("%s: cannot scan %T", what, src)
+	}
+}
+
+// jsonValue encodes a list for a JSON column, writing an empty list as [].
+func jsonValue(list interface{}, n int) (driver.Value, error) {
+	if n == 0 {
+		return "[]", nil
+	}
+	b, err := json.Marshal(list)
+	return string(b), err
+}
+
+// LabelCount is a genre or tag with the number of books carrying it.
+type LabelCount struct {
+	Name      string `db:"name" json:"name"`
+	BookCount int    `db:"book_count" json:"book_count"`
+}
+
+// RatedBook is a book ranked by its Bayesian-weighted rating.
+type RatedBook struct {
+	Book
+	Score float64 `db:"score" json:"score"`
+}
+
+// BookSearchHit is a book matched by full-text search. Snippet and
+// HighlightedTitle are HTML-escaped with matches wrapped in <mark>.
+type BookSearchHit struct {
+	Book
+	Rank             float64 `db:"rank" json:"rank"`
+	Snippet          string  `db:"snippet" json:"snippet"`
+	HighlightedTitle string  `db:"highlighted_title" json:"highlighted_title"`
 }
 
 type Shelf struct {
-	ID     int    `db:"id" json:"id"`
-	UserID int    `db:"user_id" json:"user_id"`
-	Name   string `db:"name" json:"name"`
+	ID         int     `db:"id" json:"id"`
+	UserID     int     `db:"user_id" json:"user_id"`
+	Name       string  `db:"name" json:"name"`
+	Visibility string  `db:"visibility" json:"visibility"`             // private, public or unlisted
+	ShareToken *string `db:"share_token" json:"share_token,omitempty"` // only shown to those who may edit the shelf
+}
+
+// ShelfBook is a book's place on a shelf.
+type ShelfBook struct {
+	ShelfID  int       `db:"shelf_id" json:"shelf_id"`
+	BookID   int       `db:"book_id" json:"book_id"`
+	Position int       `db:"position" json:"position"`
+	AddedAt  time.Time `db:"added_at" json:"added_at"`
+}
+
+// ShelfCollaborator grants a user read or write access to someone else's shelf.
+type ShelfCollaborator struct {
+	ShelfID  int       `db:"shelf_id" json:"shelf_id"`
+	UserID   int       `db:"user_id" json:"user_id"`
+	UserName string    `db:"user_name" json:"user_name,omitempty"`
+	Role     string    `db:"role" json:"role"`
+	AddedAt  time.Time `db:"added_at" json:"added_at"`
 }
 
 type Review struct {
+	ID             int        `db:"id" json:"id"`
+	UserID         int        `db:"user_id" json:"user_id"`
+	UserName       string     `db:"user_name" json:"user_name,omitempty"`
+	BookID         int        `db:"book_id" json:"book_id"`
+	BookTitle      string     `db:"book_title" json:"book_title,omitempty"`
+	Text           string     `db:"text" json:"text"`
+	Rating         int        `db:"rating" json:"rating"`
+	HelpfulCount   int        `db:"helpful_count" json:"helpful_count"`
+	Status         string     `db:"status" json:"status"` // pending, approved or rejected; only approved reviews are public
+	ReportCount    int        `db:"report_count" json:"report_count"`
+	ModerationNote string     `db:"moderation_note" json:"moderation_note,omitempty"`
+	ModeratedBy    *int       `db:"moderated_by" json:"moderated_by,omitempty"`
+	ModeratedAt    *time.Time `db:"moderated_at" json:"moderated_at,omitempty"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt      *time.Time `db:"updated_at" json:"updated_at,omitempty"`
+}
+
+// ReviewReport is a user's complaint about a review.
+type ReviewReport struct {
+	ReviewID  int       `db:"review_id" json:"review_id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	UserName  string    `db:"user_name" json:"user_name,omitempty"`
+	Reason    string    `db:"reason" json:"reason"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type RefreshToken struct {
+	ID        int        `db:"id" json:"id"`
+	UserID    int        `db:"user_id" json:"user_id"`
+	TokenHash string     `db:"token_hash" json:"-"`
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ReadingStatus is a user's reading state of one book.
+type ReadingStatus struct {
+	UserID      int        `db:"user_id" json:"user_id"`
+	BookID      int        `db:"book_id" json:"book_id"`
+	BookTitle   string     `db:"book_title" json:"book_title,omitempty"`
+	Status      string     `db:"status" json:"status"`
+	CurrentPage *int       `db:"current_page" json:"current_page,omitempty"`
+	Percent     *int       `db:"percent" json:"percent,omitempty"`
+	StartedAt   *time.Time `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt  *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// ReadingEvent records a change of reading status.
+type ReadingEvent struct {
 	ID        int       `db:"id" json:"id"`
 	UserID    int       `db:"user_id" json:"user_id"`
 	BookID    int       `db:"book_id" json:"book_id"`
-	Text      string    `db:"text" json:"text"`
-	Rating    int       `db:"rating" json:"rating"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	Status    string    `db:"status" json:"status"`
+	ChangedAt time.Time `db:"changed_at" json:"changed_at"`
+}
+
+// ImportJob tracks a book import running in the background.
+type ImportJob struct {
+	ID     int    `db:"id" json:"id"`
+	UserID int    `db:"user_id" json:"user_id"`
+	Format string `db:"format" json:"format"` // csv, json, goodreads or librarything
+	// Mode is create, adding every record as a new book, upsert, updating
+	// the book a record matches, or skip_existing, leaving matches alone.
+	Mode string `db:"mode" json:"mode"`
+	// OnError is rollback, importing nothing if any record is refused, or
+	// skip, importing the valid records.
+	OnError string `db:"on_error" json:"on_error"`
+	DryRun  bool   `db:"dry_run" json:"dry_run"` // report the changes without writing them
+	Status  string `db:"status" json:"status"`   // running, completed, failed or cancelled
+	// Total is the number of records in the file, known shortly after the start.
+	Total     int `db:"total" json:"total"`
+	Processed int `db:"processed" json:"processed"`
+	// Created, Updated and Skipped count what the records were found to do;
+	// Imported counts the books actually written. Library imports count the
+	// books they add as Created and the ones already in the catalog as
+	// Matched; their Imported counts the rows added to the user's library.
+	Created  int    `db:"created" json:"created"`
+	Updated  int    `db:"updated" json:"updated"`
+	Skipped  int    `db:"skipped" json:"skipped"`
+	Matched  int    `db:"matched" json:"matched"`
+	Imported int    `db:"imported" json:"imported"`
+	Failed   int    `db:"failed" json:"failed"`
+	Error    string `db:"error" json:"error,omitempty"` // why the import as a whole failed
+	// Errors and Changes are served as separate reports.
+	Errors     ImportErrors  `db:"errors" json:"-"`
+	Changes    ImportChanges `db:"changes" json:"-"`
+	CreatedAt  time.Time     `db:"created_at" json:"created_at"`
+	FinishedAt *time.Time    `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+// ImportError explains why one record of an import was refused.
+type ImportError struct {
+	Record  int    `json:"record"` // 1-based; CSV rows count the header line
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportErrors scans from a JSON array column.
+type ImportErrors []ImportError
+
+func (e *ImportErrors) Scan(src interface{}) error {
+	return scanJSON(src, e, "import errors")
+}
+
+// Value stores the errors as a JSON array.
+func (e ImportErrors) Value() (driver.Value, error) {
+	return jsonValue(e, len(e))
+}
+
+// Import actions reported for each record.
+const (
+	ImportActionCreate = "create"
+	ImportActionUpdate = "update"
+	ImportActionSkip   = "skip"
+	ImportActionMatch  = "match" // a library row names a book already in the catalog
+)
+
+// ImportChange is what one import record did, or would do in a dry run.
+type ImportChange struct {
+	Record int    `json:"record"`
+	Action string `json:"action"`
+	BookID int    `json:"book_id,omitempty"` // the matched book, for updates and skips
+	Title  string `json:"title"`
+	Reason string `json:"reason,omitempty"` // why a record was skipped
+	// Fields holds the old and new value of each field an update changes.
+	Fields map[string]FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a field's value before and after an update.
+type FieldChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ImportChanges scans from a JSON array column.
+type ImportChanges []ImportChange
+
+func (c *ImportChanges) Scan(src interface{}) error {
+	return scanJSON(src, c, "import changes")
+}
+
+// Value stores the changes as a JSON array.
+func (c ImportChanges) Value() (driver.Value, error) {
+	return jsonValue(c, len(c))
 }
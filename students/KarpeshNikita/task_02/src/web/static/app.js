@@ -1,5 +1,35 @@
 (function(){
   const getToken = ()=> localStorage.getItem('token') || sessionStorage.getItem('token');
+  // refresh token lives in the same storage the login form chose
+  const tokenStore = ()=> localStorage.getItem('token') ? localStorage : sessionStorage;
+
+  function clearTokens(){
+    ['token','refresh_token'].forEach(k=>{ localStorage.removeItem(k); sessionStorage.removeItem(k); });
+  }
+
+  function tokenExpiry(tok){
+    try{ return JSON.parse(atob(tok.split('.')[1].replace(/-/g,'+').replace(/_/g,'/'))).exp * 1000; }
+    catch(err){ return 0; }
+  }
+
+  // rotate the access token shortly before it expires
+  async function refreshIfNeeded(){
+    const token = getToken();
+    const store = tokenStore();
+    const refresh = store.getItem('refresh_token');
+    if(!token || !refresh) return;
+    if(tokenExpiry(token) - Date.now() > 60000) return;
+    try{
+      const res = await fetch('/api/token/refresh', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ refresh_token: refresh }) });
+      if(res.ok){
+        const j = await res.json();
+        store.setItem('token', j.token);
+        store.setItem('refresh_token', j.refresh_token);
+      } else if(res.status === 401){
+        clearTokens();
+      }
+    }catch(err){ /* keep current tokens on network errors */ }
+  }
 
   function updateNav(){
     const token = getToken();
@@ -13,10 +43,22 @@
     if(createShelfArea){ createShelfArea.classList.toggle('d-none', !token); }
   }
 
-  document.addEventListener('DOMContentLoaded', ()=>{
+  document.addEventListener('DOMContentLoaded', async ()=>{
+    await refreshIfNeeded();
+    setInterval(refreshIfNeeded, 30000);
     updateNav();
     const logout = document.getElementById('logout-link');
-    if(logout) logout.addEventListener('click', (e)=>{ e.preventDefault(); localStorage.removeItem('token'); sessionStorage.removeItem('token'); updateNav(); location.reload(); });
+    if(logout) logout.addEventListener('click', async (e)=>{
+      e.preventDefault();
+      const token = getToken();
+      const refresh = tokenStore().getItem('refresh_token');
+      if(token){
+        try{
+          await fetch('/api/logout', { method: 'POST', headers: { 'Content-Type': 'application/json', 'Authorization': 'Bearer ' + token }, body: JSON.stringify({ refresh_token: refresh || '' }) });
+        }catch(err){ /* local logout still proceeds */ }
+      }
+      clearTokens(); updateNav(); location.reload();
+    });
 
     const createForm = document.getElementById('create-shelf-form');
     if(createForm){
@@ -25,16 +67,18 @@
         const nameEl = document.getElementById('shelf-name');
         const name = nameEl && nameEl.value.trim();
         if(!name){ alert('Enter shelf name'); return; }
+        const visEl = document.getElementById('shelf-visibility');
+        const visibility = visEl ? visEl.value : undefined;
         const token = getToken();
         if(!token){ alert('You must be logged in to create a shelf.'); return; }
         try{
           const res = await fetch('/api/shelves', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json', 'Authorization': 'Bearer ' + token },
-            body: JSON.stringify({ name })
+            body: JSON.stringify({ name, visibility })
           });
-          if(res.ok){ location.reload(); }
-          else { const d = await res.json().catch(()=>({})); alert(d.error || 'Create shelf failed'); }
+          if(res.ok){ const sh = await res.json(); location.href = '/shelves/' + sh.id; }
+          else { const d = await res.json().catch(()=>({})); alert(d.detail || 'Create shelf failed'); }
         } catch(err){ alert('Network error'); }
       });
     }
@@ -45,25 +89,105 @@
     if(importToggle && importArea){
       importToggle.addEventListener('click', ()=>{ importArea.classList.toggle('d-none'); });
     }
+    // exports need the token, so they are fetched rather than followed; the home page filters apply
+    document.querySelectorAll('a[data-export]').forEach(link=>{
+      link.addEventListener('click', async (e)=>{
+        e.preventDefault();
+        const params = new URLSearchParams(location.search);
+        ['page', 'size', 'q'].forEach(k=>params.delete(k));
+        const token = getToken();
+        const res = await fetch(link.getAttribute('href') + (params.toString() ? '?' + params : ''),
+          { headers: token ? { 'Authorization': 'Bearer ' + token } : {} });
+        if(!res.ok){ alert(res.status === 401 ? 'Log in to export books' : 'Export failed'); return; }
+        const a = document.createElement('a');
+        a.href = URL.createObjectURL(await res.blob());
+        a.download = link.dataset.export;
+        a.click();
+        URL.revokeObjectURL(a.href);
+      });
+    });
     if(importForm){
+      const statusEl = document.getElementById('import-status');
+      const progressEl = document.getElementById('import-progress');
+      const messageEl = document.getElementById('import-message');
+      const cancelBtn = document.getElementById('import-cancel');
+      const errorsBtn = document.getElementById('import-errors');
+      const changesBtn = document.getElementById('import-changes');
+      const formatSel = document.getElementById('import-format');
+      const csvOptions = document.getElementById('import-csv-options');
+      if(formatSel && csvOptions){
+        formatSel.addEventListener('change', ()=>{ csvOptions.classList.toggle('d-none', formatSel.value !== 'csv'); });
+      }
+      const authHeaders = ()=>{ const token = getToken(); return token ? { 'Authorization': 'Bearer ' + token } : {}; };
+      let job = null;
+      const showJob = ()=>{
+        const pct = job.total ? Math.round(100 * job.processed / job.total) : 0;
+        progressEl.style.width = (job.status === 'running' ? pct : 100) + '%';
+        progressEl.classList.toggle('bg-danger', job.status === 'failed');
+        progressEl.classList.toggle('bg-secondary', job.status === 'cancelled');
+        let msg = job.status === 'running'
+          ? (job.dry_run ? 'Checking… ' : 'Importing… ') + job.processed + ' of ' + (job.total || '?') + ' records'
+          : (job.dry_run ? 'Dry run ' : 'Import ') + job.status + ': ' + job.created + ' new, ' + job.updated + ' updated, ' +
+            job.skipped + ' skipped, ' + job.failed + ' refused' + (job.dry_run ? '; nothing was written' : '; ' + job.imported + ' books saved');
+        if(job.error){ msg += '. ' + job.error; }
+        messageEl.textContent = msg;
+        cancelBtn.classList.toggle('d-none', job.status !== 'running');
+        errorsBtn.classList.toggle('d-none', job.status === 'running' || !job.failed);
+        changesBtn.classList.toggle('d-none', job.status === 'running' || !(job.created || job.updated || job.skipped));
+      };
+      const download = async (path, name)=>{
+        const res = await fetch(path, { headers: authHeaders() });
+        if(!res.ok){ alert('Could not download the report'); return; }
+        const a = document.createElement('a');
+        a.href = URL.createObjectURL(await res.blob());
+        a.download = name;
+        a.click();
+        URL.revokeObjectURL(a.href);
+      };
+      const poll = async ()=>{
+        try{
+          const res = await fetch('/api/imports/' + job.id, { headers: authHeaders() });
+          if(res.ok){ job = await res.json(); showJob(); }
+        }catch(err){}
+        if(job.status === 'running'){ setTimeout(poll, 1000); }
+        else if(job.imported){ setTimeout(()=>location.reload(), 1500); }
+      };
+      cancelBtn.addEventListener('click', async ()=>{
+        if(!job) return;
+        const res = await fetch('/api/imports/' + job.id + '/cancel', { method: 'POST', headers: authHeaders() });
+        if(!res.ok){ const d = await res.json().catch(()=>({})); alert(d.detail || 'Cancel failed'); }
+      });
+      errorsBtn.addEventListener('click', ()=>{ if(job) download('/api/imports/' + job.id + '/errors', 'import-' + job.id + '-errors.csv'); });
+      changesBtn.addEventListener('click', ()=>{ if(job) download('/api/imports/' + job.id + '/changes', 'import-' + job.id + '-changes.json'); });
       importForm.addEventListener('submit', async (e)=>{
         e.preventDefault();
         const fileEl = document.getElementById('import-file');
         const formatEl = document.getElementById('import-format');
+        const onErrorEl = document.getElementById('import-on-error');
+        const modeEl = document.getElementById('import-mode');
+        const dryRunEl = document.getElementById('import-dry-run');
         if(!fileEl || !fileEl.files || fileEl.files.length === 0){ alert('Select a file to import'); return; }
         const file = fileEl.files[0];
         const format = formatEl && formatEl.value === 'csv' ? 'csv' : 'json';
         const fd = new FormData();
         fd.append('file', file, file.name);
-        const token = getToken();
+        if(onErrorEl){ fd.append('on_error', onErrorEl.value); }
+        if(modeEl){ fd.append('mode', modeEl.value); }
+        if(dryRunEl && dryRunEl.checked){ fd.append('dry_run', 'true'); }
+        if(format === 'csv'){
+          ['delimiter', 'encoding', 'mapping'].forEach(k=>{
+            const el = document.getElementById('import-' + k);
+            if(el && el.value.trim()){ fd.append(k, k === 'mapping' ? el.value.trim() : el.value); }
+          });
+        }
         try{
           const res = await fetch('/api/books/import/' + format, {
             method: 'POST',
-            headers: token ? { 'Authorization': 'Bearer ' + token } : {},
+            headers: authHeaders(),
             body: fd
           });
-          if(res.ok){ alert('Imported successfully'); location.reload(); }
-          else { const d = await res.json().catch(()=>({})); alert(d.error || 'Import failed'); }
+          if(res.ok){ job = await res.json(); statusEl.classList.remove('d-none'); showJob(); setTimeout(poll, 500); }
+          else { const d = await res.json().catch(()=>({})); alert(d.detail || 'Import failed'); }
         }catch(err){ alert('Network error'); }
       });
     }